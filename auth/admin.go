@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// AdminOnly allows the request through only for the users listed in registry.admins. It must run after JWTRest, which
+// sets the user in the context
+func (a *auth) AdminOnly() echo.MiddlewareFunc {
+	return func(handler echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set(types.HandlerStartTime, time.Now())
+
+			user, ok := ctx.Get(string(types.UserContextKey)).(*types.User)
+			if !ok {
+				err := fmt.Errorf("missing authentication information")
+				echoErr := ctx.JSON(http.StatusUnauthorized, echo.Map{
+					"error": err.Error(),
+				})
+				a.logger.Log(ctx, err).Send()
+				return echoErr
+			}
+
+			if !slices.Contains(a.c.Registry.Admins, user.Username) {
+				err := fmt.Errorf("access not allowed")
+				echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+					"error":   err.Error(),
+					"message": "only registry admins can use this endpoint",
+				})
+				a.logger.Log(ctx, err).Send()
+				return echoErr
+			}
+
+			return handler(ctx)
+		}
+	}
+}
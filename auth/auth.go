@@ -38,6 +38,7 @@ type Authentication interface {
 	RepositoryPermissionsMiddleware() echo.MiddlewareFunc
 	JWT() echo.MiddlewareFunc
 	JWTRest() echo.MiddlewareFunc
+	AdminOnly() echo.MiddlewareFunc
 }
 
 // New is the constructor function returns an Authentication implementation
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// validateIPFSPublisher checks requests to the ipfs namespace. Anyone can pull from it, pushes are only allowed for
+// the publishers in dfs.ipfs.publishers. The repositories in the namespace belong to the ipfs system user, so pushes
+// continue as that user
+func (a *auth) validateIPFSPublisher(ctx echo.Context, user *types.User, readOp bool) error {
+	if readOp {
+		return nil
+	}
+
+	if !a.canPublishToIPFS(ctx.Request().Context(), user) {
+		return fmt.Errorf("ERR_IPFS_PUBLISH_NOT_ALLOWED: %s isn't allowed to publish to IPFS", user.Username)
+	}
+
+	// the token endpoint checks these permissions before it issues a push token
+	ctx.Set(string(types.UserPermissionsContextKey), &types.Permissions{Push: true, Pull: true})
+	if ctx.Request().URL.Path == "/token" {
+		return nil
+	}
+
+	ipfsUser, err := a.userStore.GetIPFSUser(ctx.Request().Context())
+	if err != nil {
+		return err
+	}
+	ctx.Set(string(types.UserContextKey), ipfsUser)
+
+	return nil
+}
+
+// canPublishToIPFS reports whether the user, or an organization the user can push for, is in dfs.ipfs.publishers
+func (a *auth) canPublishToIPFS(ctx context.Context, user *types.User) bool {
+	for _, publisher := range a.c.DFS.Ipfs.Publishers {
+		if publisher == user.Username {
+			return true
+		}
+
+		org, err := a.userStore.GetUserByUsername(ctx, publisher)
+		if err != nil || org.UserType != types.UserTypeOrganization.String() {
+			continue
+		}
+
+		permissions, err := a.permissionsStore.GetUserPermissionsForOrg(ctx, org.ID, user.ID)
+		if err == nil && (permissions.IsAdmin || permissions.Push) {
+			return true
+		}
+	}
+
+	return false
+}
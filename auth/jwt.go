@@ -128,7 +128,7 @@ func (a *auth) newOCIToken(userID uuid.UUID, scopes types.OCITokenPermissonClaim
 		Audience:  a.c.Registry.FQDN,
 		Issuer:    OpenRegistryIssuer,
 		Id:        userID.String(),
-		TokenType: "oci_token",
+		TokenType: OCITokenType,
 		Acl:       scopes,
 	}
 	claims := CreateOCIClaims(opts)
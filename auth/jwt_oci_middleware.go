@@ -31,10 +31,9 @@ func (a *auth) JWT() echo.MiddlewareFunc {
 				return false
 			}
 
-			repoName := ctx.Param("imagename")
-
-			skip := (readOp && repo.Visibility == types.RepositoryVisibilityPublic) ||
-				repoName == types.SystemUsernameIPFS
+			// pulls from the ipfs namespace are public, pushes need a token like any other namespace
+			isIPFSRepo := ctx.Param("username") == types.SystemUsernameIPFS
+			skip := readOp && (repo.Visibility == types.RepositoryVisibilityPublic || isIPFSRepo)
 			if skip {
 				a.logger.DebugWithContext(ctx).Bool("skip_jwt_middleware", true).Send()
 			}
@@ -61,18 +60,10 @@ func (a *auth) JWT() echo.MiddlewareFunc {
 		SuccessHandler: func(ctx echo.Context) {
 			if token, tokenOk := ctx.Get("user").(*jwt.Token); tokenOk {
 				if claims, claimsOk := token.Claims.(*OCIClaims); claimsOk {
-					var (
-						user *types.User
-						err  error
-					)
+					// pushes to the ipfs namespace switch to the system user once the user is allowed to publish,
+					// see validateIPFSPublisher
 					userId := uuid.MustParse(claims.ID)
-					usernameFromReq := ctx.Param("username")
-					if usernameFromReq == types.SystemUsernameIPFS {
-						user, err = a.userStore.GetIPFSUser(ctx.Request().Context())
-					} else {
-						user, err = a.userStore.GetUserByID(ctx.Request().Context(), userId)
-					}
-
+					user, err := a.userStore.GetUserByID(ctx.Request().Context(), userId)
 					if err == nil {
 						ctx.Set(string(types.UserContextKey), user)
 					}
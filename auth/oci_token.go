@@ -21,6 +21,9 @@ const (
 	OCITokenQueryParamScope        = "scope"
 	OCITokenQueryParamAccount      = "account"
 
+	// OCITokenType is the type of the scoped tokens issued by the token endpoint
+	OCITokenType = "oci_token"
+
 	// Token lifetimes
 	DefaultOCITokenLifetime = time.Minute * 10
 )
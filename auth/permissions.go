@@ -85,7 +85,7 @@ func (a *auth) RepositoryPermissionsMiddleware() echo.MiddlewareFunc {
 			}
 
 			repository, err := a.registryStore.GetRepositoryByNamespace(ctx.Request().Context(), namespace)
-			if err == nil {
+			if err == nil && !a.isPushRequest(ctx) {
 				if repository.Visibility == types.RepositoryVisibilityPublic {
 					a.logger.DebugWithContext(ctx).Send()
 					return handler(ctx)
@@ -158,12 +158,24 @@ func (a *auth) validateUserPermissions(ctx echo.Context, ns string, user *types.
 		)
 
 	usernameFromReq := strings.Split(ns, "/")[0]
+	readOp := !a.isPushRequest(ctx)
+	if !readOp {
+		// registry tokens are scoped, a token for one repository can't push to another
+		claims, ok := ctx.Get(string(types.UserClaimsContextKey)).(*OCIClaims)
+		if ok && claims.Type == OCITokenType && !claims.Access.HasPushAccess(ns) {
+			return fmt.Errorf("ERR_MISSING_PUSH_SCOPE: the token doesn't allow pushes to %s", ns)
+		}
+	}
+
+	if usernameFromReq == types.SystemUsernameIPFS {
+		return a.validateIPFSPublisher(ctx, user, readOp)
+	}
+
 	ctx.Set(string(types.UserPermissionsContextKey), permissions)
-	readOp := ctx.Request().Method == http.MethodGet || ctx.Request().Method == http.MethodHead
 	permissonAllowed := permissions.IsAdmin || (readOp && permissions.Pull) || (!readOp && permissions.Push)
 	isTokenRequest := ctx.Request().URL.Path == "/token"
 
-	if permissonAllowed || user.Username == usernameFromReq || usernameFromReq == types.SystemUsernameIPFS {
+	if permissonAllowed || user.Username == usernameFromReq {
 		// if someone else is making the request on behalf of the org, then we set org as the underyling user
 		if !isTokenRequest && user.Username != usernameFromReq {
 			orgOwner, err := a.userStore.GetUserByID(ctx.Request().Context(), permissions.OrganizationID)
@@ -179,3 +191,24 @@ func (a *auth) validateUserPermissions(ctx echo.Context, ns string, user *types.
 
 	return fmt.Errorf("authentication details are missing")
 }
+
+// isPushRequest reports whether the request writes to the repository. Token requests are always GETs, they push when
+// one of the requested scopes has the push action
+func (a *auth) isPushRequest(ctx echo.Context) bool {
+	if ctx.Request().URL.Path == "/token" {
+		scopes, err := ParseOCITokenPermissionRequest(ctx.Request().URL)
+		if err != nil {
+			return false
+		}
+
+		for _, scope := range scopes {
+			if scope.HasPushAccess() {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return ctx.Request().Method != http.MethodGet && ctx.Request().Method != http.MethodHead
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
-	"strings"
 	"time"
 
 	"github.com/containerish/OpenRegistry/config"
@@ -83,7 +82,7 @@ func (a *auth) SignUp(ctx echo.Context) error {
 
 	err = a.userStore.AddUser(ctx.Request().Context(), user, nil)
 	if err != nil {
-		if strings.Contains(err.Error(), store_err.ErrDuplicateConstraintUsername) {
+		if store_err.IsDuplicateConstraintError(err, store_err.ErrDuplicateConstraintUsername) {
 			echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
 				"error":   err.Error(),
 				"message": "username already exists",
@@ -92,7 +91,7 @@ func (a *auth) SignUp(ctx echo.Context) error {
 			return echoErr
 		}
 
-		if strings.Contains(err.Error(), store_err.ErrDuplicateConstraintEmail) {
+		if store_err.IsDuplicateConstraintError(err, store_err.ErrDuplicateConstraintEmail) {
 			echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
 				"error":   err.Error(),
 				"message": "this email already taken, try sign in?",
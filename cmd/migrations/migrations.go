@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/containerish/OpenRegistry/config"
+	store_v1 "github.com/containerish/OpenRegistry/store/v1"
 	"github.com/containerish/OpenRegistry/store/v1/types"
 	"github.com/fatih/color"
 	"github.com/uptrace/bun"
@@ -29,7 +31,8 @@ Examples:
     1. openregistry migrations init --openregistry-db-dsn=<openregistry_db_dsn> --admin-db-dsn=<admin_db_dsn>
     2. openregistry migrations run --openregistry-db-dsn=<openregistry_db_dsn> 
     3. openregistry migrations generate --openregistry-db-dsn=<openregistry_db_dsn> 
-    4. openregistry migrations reset --openregistry-db-dsn=<openregistry_db_dsn>`,
+    4. openregistry migrations reset --openregistry-db-dsn=<openregistry_db_dsn>
+    5. openregistry migrations init --sqlite-path=<path_to_sqlite_db>`,
 		Subcommands: []*cli.Command{
 			newDatabaseInitCommand(),
 			newMigrationsRunCommand(),
@@ -156,6 +159,62 @@ func createOpenRegistryTables(ctx *cli.Context, db *bun.DB) error {
 	}
 	color.Green(`Table "permissions" created ✔︎`)
 
+	_, err = db.NewCreateTable().Model(&types.LayerFileIndex{}).Table().IfNotExists().Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=layer_file_indexes Created=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Table "layer_file_indexes" created ✔︎`)
+
+	_, err = db.NewCreateTable().Model(&types.RepositoryAuditEvent{}).Table().IfNotExists().Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=repository_audit_events Created=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Table "repository_audit_events" created ✔︎`)
+
+	_, err = db.NewCreateTable().Model(&types.TagHistory{}).Table().IfNotExists().Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=tag_history Created=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Table "tag_history" created ✔︎`)
+
+	_, err = db.NewCreateTable().Model(&types.ReplicationTask{}).Table().IfNotExists().Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=replication_tasks Created=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Table "replication_tasks" created ✔︎`)
+
+	_, err = db.NewCreateTable().Model(&types.BlobKey{}).Table().IfNotExists().Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=blob_keys Created=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Table "blob_keys" created ✔︎`)
+
+	_, err = db.NewCreateTable().Model(&types.StorageMigration{}).Table().IfNotExists().Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=storage_migrations Created=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Table "storage_migrations" created ✔︎`)
+
+	_, err = db.NewCreateTable().Model(&types.RepositoryBlob{}).Table().IfNotExists().Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=repository_blobs Created=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Table "repository_blobs" created ✔︎`)
+
 	return nil
 }
 
@@ -197,7 +256,28 @@ func getOpenRegistryDBConnectorFromCtx(opts *databaseOptions) *pgdriver.Connecto
 	)
 }
 
+// getOpenRegistryDBFromOpts connects to the SQLite database when --sqlite-path is set and to Postgres otherwise
+func getOpenRegistryDBFromOpts(opts *databaseOptions) *bun.DB {
+	if opts.sqlitePath != "" {
+		storeConfig := config.Store{
+			Kind:               config.StoreKindSQLite,
+			Path:               opts.sqlitePath,
+			MaxOpenConnections: 1,
+		}
+		return store_v1.New(storeConfig, config.Production)
+	}
+
+	return getOpenRegistryDB(getOpenRegistryDBConnectorFromCtx(opts))
+}
+
 func createOpenRegistryDatabase(ctx *cli.Context, opts *databaseOptions) (*bun.DB, error) {
+	// SQLite creates the database file on connect and has no users or grants
+	if opts.sqlitePath != "" {
+		db := getOpenRegistryDBFromOpts(opts)
+		color.Green(`Action "CreateDatabase" for SQLite at "%s" succeeded ✔︎`, opts.sqlitePath)
+		return db, nil
+	}
+
 	adminConnector := getAdminDBConnectorFromCtx(opts)
 	adminDB := getAdminBunDB(adminConnector)
 
@@ -260,6 +340,7 @@ type databaseOptions struct {
 	adminDB         string
 	adminUsername   string
 	adminPassword   string
+	sqlitePath      string
 	timeout         time.Duration
 	insecure        bool
 }
@@ -277,6 +358,7 @@ func parseDatabaseFlags(ctx *cli.Context) *databaseOptions {
 		adminDB:         ctx.String("admin-db"),
 		adminUsername:   ctx.String("admin-db-username"),
 		adminPassword:   ctx.String("admin-db-password"),
+		sqlitePath:      ctx.String("sqlite-path"),
 	}
 
 	return opts
@@ -324,6 +406,11 @@ func getOpenRegistryDatabaseCmdFlags() []cli.Flag {
 			Value:    false,
 			Required: false,
 		},
+		&cli.StringFlag{
+			Name:     "sqlite-path",
+			Usage:    "path to a SQLite database file, used instead of Postgres when set",
+			Required: false,
+		},
 	}
 }
 
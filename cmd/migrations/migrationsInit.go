@@ -5,7 +5,9 @@ import (
 	"strings"
 
 	"github.com/containerish/OpenRegistry/store/v1/migrations"
+	"github.com/containerish/OpenRegistry/store/v1/types"
 	"github.com/fatih/color"
+	"github.com/uptrace/bun"
 	"github.com/urfave/cli/v2"
 )
 
@@ -39,6 +41,10 @@ func databaseInitCmd(ctx *cli.Context) error {
 		return err
 	}
 
+	if opts.sqlitePath != "" {
+		return addSQLiteConstraints(ctx, openRegistryDB)
+	}
+
 	_, err = openRegistryDB.ExecContext(
 		ctx.Context,
 		"alter table repositories add constraint fk_owner_id foreign key (owner_id) references users(id)",
@@ -63,3 +69,24 @@ func databaseInitCmd(ctx *cli.Context) error {
 
 	return nil
 }
+
+// addSQLiteConstraints adds the constraints that Postgres gets with "alter table". SQLite can't add constraints to an
+// existing table, the unique group becomes a unique index and the owner_id foreign key is skipped
+func addSQLiteConstraints(ctx *cli.Context, db *bun.DB) error {
+	_, err := db.
+		NewCreateIndex().
+		Model(&types.ImageManifest{}).
+		Unique().
+		IfNotExists().
+		Index("image_manifests_reference_repository_id_key").
+		Column("reference", "repository_id").
+		Exec(ctx.Context)
+	if err != nil {
+		return errors.New(
+			color.RedString("Table=image_manifests CreateIndex=❌ Error=%s", err),
+		)
+	}
+	color.Green(`Create unique index on "reference,repository_id" done ✔︎`)
+
+	return nil
+}
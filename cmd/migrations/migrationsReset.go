@@ -17,8 +17,7 @@ func newDatabaseResetCommand() *cli.Command {
 
 func migrationResetCmd(ctx *cli.Context) error {
 	opts := parseDatabaseFlags(ctx)
-	connector := getOpenRegistryDBConnectorFromCtx(opts)
-	db := getOpenRegistryDB(connector)
+	db := getOpenRegistryDBFromOpts(opts)
 
 	return db.ResetModel(
 		ctx.Context,
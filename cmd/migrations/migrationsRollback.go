@@ -15,8 +15,7 @@ func newMigrationsRollbackCommand() *cli.Command {
 		Flags: getOpenRegistryDatabaseCmdFlags(),
 		Action: func(ctx *cli.Context) error {
 			opts := parseDatabaseFlags(ctx)
-			connector := getOpenRegistryDBConnectorFromCtx(opts)
-			db := getOpenRegistryDB(connector)
+			db := getOpenRegistryDBFromOpts(opts)
 
 			migrator := migrations.NewMigrator(db)
 
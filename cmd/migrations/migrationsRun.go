@@ -12,8 +12,7 @@ func newMigrationsRunCommand() *cli.Command {
 		Flags: getOpenRegistryDatabaseCmdFlags(),
 		Action: func(ctx *cli.Context) error {
 			opts := parseDatabaseFlags(ctx)
-			connector := getOpenRegistryDBConnectorFromCtx(opts)
-			db := getOpenRegistryDB(connector)
+			db := getOpenRegistryDBFromOpts(opts)
 			migrations.PerformMigrations(ctx.Context, db)
 			return nil
 		},
@@ -12,7 +12,9 @@ import (
 	"github.com/containerish/OpenRegistry/auth"
 	auth_server "github.com/containerish/OpenRegistry/auth/server"
 	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs/cache"
 	dfs_client "github.com/containerish/OpenRegistry/dfs/client"
+	"github.com/containerish/OpenRegistry/dfs/migration"
 	healthchecks "github.com/containerish/OpenRegistry/health-checks"
 	"github.com/containerish/OpenRegistry/orgmode"
 	"github.com/containerish/OpenRegistry/registry/v2"
@@ -20,9 +22,12 @@ import (
 	"github.com/containerish/OpenRegistry/router"
 	store_v2 "github.com/containerish/OpenRegistry/store/v1"
 	"github.com/containerish/OpenRegistry/store/v1/automation"
+	"github.com/containerish/OpenRegistry/store/v1/dfsmigration"
 	"github.com/containerish/OpenRegistry/store/v1/emails"
+	"github.com/containerish/OpenRegistry/store/v1/encryption"
 	"github.com/containerish/OpenRegistry/store/v1/permissions"
 	registry_store "github.com/containerish/OpenRegistry/store/v1/registry"
+	"github.com/containerish/OpenRegistry/store/v1/replication"
 	"github.com/containerish/OpenRegistry/store/v1/sessions"
 	"github.com/containerish/OpenRegistry/store/v1/users"
 	"github.com/containerish/OpenRegistry/store/v1/webauthn"
@@ -67,10 +72,35 @@ func RunRegistryServer(ctx *cli.Context) error {
 	}
 
 	logger := telemetry.ZeroLogger(cfg.Environment, cfg.Telemetry)
-	dfs := dfs_client.New(cfg.Environment, cfg.Endpoint(), &cfg.DFS, logger)
 	rawDB := store_v2.New(cfg.StoreConfig, cfg.Environment)
 	defer rawDB.Close()
 
+	replicationStore := replication.New(rawDB, logger)
+	keyStore := encryption.New(rawDB, logger)
+	dfs := dfs_client.New(
+		ctx.Context, cfg.Environment, cfg.Endpoint(), &cfg.DFS, logger, replicationStore, keyStore,
+	)
+
+	if cfg.DFS.Migration.Enabled {
+		migrator, migrationErr := migration.New(dfs, dfsmigration.New(rawDB, logger), &cfg.DFS.Migration, logger)
+		if migrationErr != nil {
+			return errors.New(color.RedString("error starting the storage migration: %s", migrationErr))
+		}
+
+		color.Green("Storage migration: %s -> %s", cfg.DFS.Migration.Source, cfg.DFS.Migration.Target)
+		migrator.Start(ctx.Context)
+	}
+
+	// only the registry reads through the cache, the storage commands would fill it with blobs nobody pulls
+	if cfg.DFS.Cache.Enabled {
+		dfs, err = cache.New(dfs, &cfg.DFS.Cache, logger)
+		if err != nil {
+			return errors.New(color.RedString("error creating the storage cache: %s", err))
+		}
+
+		color.Green("Storage cache: %s (max size: %d bytes)", cfg.DFS.Cache.Dir, cfg.DFS.Cache.MaxSize)
+	}
+
 	registryStore := registry_store.New(rawDB, logger)
 	usersStore := users.New(rawDB, logger)
 	sessionsStore := sessions.New(rawDB)
@@ -81,10 +111,10 @@ func RunRegistryServer(ctx *cli.Context) error {
 
 	authApi := auth.New(cfg, usersStore, sessionsStore, emailStore, registryStore, permissionsStore, logger)
 	webauthnApi := auth_server.NewWebauthnServer(cfg, webauthnStore, sessionsStore, usersStore, logger)
-	healthCheckApi := healthchecks.NewHealthChecksAPI(&store_v2.DBPinger{DB: rawDB})
+	healthCheckApi := healthchecks.NewHealthChecksAPI(&store_v2.DBPinger{DB: rawDB}, dfs)
 	usersApi := user_api.NewApi(usersStore, logger)
 	registryApi := registry.NewRegistry(registryStore, dfs, logger, cfg)
-	extensionsApi := extensions.New(registryStore, logger)
+	extensionsApi := extensions.New(registryStore, usersStore, permissionsStore, dfs, logger, registryApi)
 	orgApi := orgmode.New(permissionsStore, usersStore, logger)
 
 	baseRouter := router.Register(
@@ -100,6 +130,7 @@ func RunRegistryServer(ctx *cli.Context) error {
 		registryStore,
 		usersStore,
 		automationStore,
+		dfs,
 	)
 
 	otelShutdownFunc := otel.ConfigureOtel(cfg.Telemetry, "openregistry-api", baseRouter)
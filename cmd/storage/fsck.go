@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"errors"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	dfs_client "github.com/containerish/OpenRegistry/dfs/client"
+	"github.com/containerish/OpenRegistry/registry/v2/fsck"
+	store_v2 "github.com/containerish/OpenRegistry/store/v1"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+	registry_store "github.com/containerish/OpenRegistry/store/v1/registry"
+	replication_store "github.com/containerish/OpenRegistry/store/v1/replication"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+func NewFsckCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "fsck",
+		Usage:     "check that the layers in the database and the blobs in storage are consistent",
+		UsageText: "OpenRegistry fsck --config-file=./config.yaml [--rehash] [--repair]",
+		Description: `Checks every layer in the database against its storage backend and reports layers whose blob is missing,
+has a different size or (with --rehash) a different digest. Blobs in the storage backends that no layer points to are
+reported as orphaned, along with the manifests that reference missing or corrupted layers.
+
+With --repair, layers without a blob are re-linked to an orphaned blob with the same digest, sizes that were recorded
+wrong are fixed and the manifests that are still broken are marked as such. Nothing is ever deleted.`,
+		Flags: []cli.Flag{
+			configFileFlag(),
+			&cli.BoolFlag{
+				Name:  "rehash",
+				Usage: "Download every blob to verify its digest, instead of only checking that it exists",
+			},
+			&cli.BoolFlag{
+				Name:  "repair",
+				Usage: "Re-link layers to orphaned blobs, fix recorded sizes and mark broken manifests",
+			},
+		},
+		Action: runFsck,
+	}
+}
+
+func runFsck(ctx *cli.Context) error {
+	cfg, err := config.ReadYamlConfig(ctx.String("config-file"))
+	if err != nil {
+		return errors.New(color.RedString("error reading cfg file: %s", err.Error()))
+	}
+
+	logger := telemetry.ZeroLogger(cfg.Environment, cfg.Telemetry)
+	db := store_v2.New(cfg.StoreConfig, cfg.Environment)
+	defer db.Close()
+
+	storage := dfs_client.New(
+		ctx.Context,
+		cfg.Environment,
+		cfg.Endpoint(),
+		&cfg.DFS,
+		logger,
+		replication_store.New(db, logger),
+		encryption_store.New(db, logger),
+	)
+
+	backends := []string{dfs.DefaultBackend}
+	for name := range cfg.DFS.Backends {
+		backends = append(backends, name)
+	}
+
+	checker := fsck.New(registry_store.New(db, logger), storage, os.Stdout, fsck.Options{
+		Backends: backends,
+		Rehash:   ctx.Bool("rehash"),
+		Repair:   ctx.Bool("repair"),
+	})
+	report, err := checker.Run(ctx.Context)
+	if err != nil {
+		return errors.New(color.RedString("error checking storage: %s", err))
+	}
+
+	color.Green(
+		"checked %d layers, %d blobs and %d manifests: %d problems, %d repaired",
+		report.Layers, report.Objects, report.Manifests,
+		len(report.Problems), len(report.Problems)-report.Unrepaired(),
+	)
+	if report.Unrepaired() > 0 {
+		return errors.New(color.RedString("%d problems need attention", report.Unrepaired()))
+	}
+
+	return nil
+}
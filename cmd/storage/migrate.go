@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerish/OpenRegistry/config"
+	dfs_client "github.com/containerish/OpenRegistry/dfs/client"
+	"github.com/containerish/OpenRegistry/dfs/migration"
+	store_v2 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/dfsmigration"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+	replication_store "github.com/containerish/OpenRegistry/store/v1/replication"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+func newMigrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "migrate",
+		Usage:     "copy the blobs of one storage backend to another",
+		UsageText: "OpenRegistry storage migrate --config-file=./config.yaml --from=default --to=storj",
+		Description: `Copies the blob of every layer on the source backend to the target backend, verifies its digest and
+points the layer to the copy. The registry can keep running, it serves a blob from the source backend until its layer
+is cut over. The progress is stored in the database, so an interrupted migration resumes where it stopped. The backends
+are "default" or the names in dfs.backends, the defaults are taken from dfs.migration.
+
+The blobs on the source backend aren't deleted.`,
+		Flags: []cli.Flag{
+			configFileFlag(),
+			&cli.StringFlag{
+				Name:  "from",
+				Usage: "The backend to copy the blobs from (default: dfs.migration.source)",
+			},
+			&cli.StringFlag{
+				Name:  "to",
+				Usage: "The backend to copy the blobs to (default: dfs.migration.target)",
+			},
+			&cli.IntFlag{
+				Name:  "max-bytes-per-second",
+				Usage: "Throttle the copies, 0 means no limit (default: dfs.migration.max_bytes_per_second)",
+			},
+		},
+		Action: migrateStorage,
+	}
+}
+
+func migrateStorage(ctx *cli.Context) error {
+	cfg, err := config.ReadYamlConfig(ctx.String("config-file"))
+	if err != nil {
+		return errors.New(color.RedString("error reading cfg file: %s", err.Error()))
+	}
+
+	migrationConfig := cfg.DFS.Migration
+	if ctx.IsSet("from") {
+		migrationConfig.Source = ctx.String("from")
+	}
+	if ctx.IsSet("to") {
+		migrationConfig.Target = ctx.String("to")
+	}
+	if ctx.IsSet("max-bytes-per-second") {
+		migrationConfig.MaxBytesPerSecond = ctx.Int("max-bytes-per-second")
+	}
+	if migrationConfig.BatchSize == 0 {
+		migrationConfig.BatchSize = 100
+	}
+
+	logger := telemetry.ZeroLogger(cfg.Environment, cfg.Telemetry)
+	db := store_v2.New(cfg.StoreConfig, cfg.Environment)
+	defer db.Close()
+
+	storage := dfs_client.New(
+		ctx.Context,
+		cfg.Environment,
+		cfg.Endpoint(),
+		&cfg.DFS,
+		logger,
+		replication_store.New(db, logger),
+		encryption_store.New(db, logger),
+	)
+
+	migrator, err := migration.New(storage, dfsmigration.New(db, logger), &migrationConfig, logger)
+	if err != nil {
+		return errors.New(color.RedString("error starting the migration: %s", err))
+	}
+
+	color.Green("migrating blobs from %s to %s", migrationConfig.Source, migrationConfig.Target)
+	err = migrator.Run(ctx.Context, func(progress *types.StorageMigration) {
+		color.Green("migrated %d blobs (%d bytes), %d failed", progress.Migrated, progress.MigratedBytes, progress.Failed)
+	})
+	if errors.Is(err, migration.ErrIncomplete) {
+		return errors.New(color.RedString("some blobs failed to copy, run the migration again to retry them: %s", err))
+	}
+	if err != nil {
+		return errors.New(color.RedString("error migrating blobs: %s", err))
+	}
+
+	color.Green("migration from %s to %s is complete", migrationConfig.Source, migrationConfig.Target)
+	return nil
+}
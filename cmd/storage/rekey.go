@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerish/OpenRegistry/config"
+	dfs_client "github.com/containerish/OpenRegistry/dfs/client"
+	"github.com/containerish/OpenRegistry/dfs/migration"
+	store_v2 "github.com/containerish/OpenRegistry/store/v1"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+	registry_store "github.com/containerish/OpenRegistry/store/v1/registry"
+	replication_store "github.com/containerish/OpenRegistry/store/v1/replication"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+func newRekeyCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "rekey",
+		Usage:     "move blobs stored under layer IDs to content addressed keys",
+		UsageText: "OpenRegistry storage rekey --config-file=./config.yaml",
+		Description: `Blobs used to be stored under the ID of their layer (layers/<id>), they are now stored under the
+digest of their content (blobs/sha256/ab/abcd...). This copies every blob that's still under a layer ID to its content
+addressed key, within the backend it's on, and points the layer to the copy. The registry can keep running, but don't
+run this alongside "storage migrate".
+
+The old objects aren't deleted, "fsck" reports them as orphaned once the layers point to the copies.`,
+		Flags: []cli.Flag{
+			configFileFlag(),
+		},
+		Action: rekeyStorage,
+	}
+}
+
+func rekeyStorage(ctx *cli.Context) error {
+	cfg, err := config.ReadYamlConfig(ctx.String("config-file"))
+	if err != nil {
+		return errors.New(color.RedString("error reading cfg file: %s", err.Error()))
+	}
+
+	logger := telemetry.ZeroLogger(cfg.Environment, cfg.Telemetry)
+	db := store_v2.New(cfg.StoreConfig, cfg.Environment)
+	defer db.Close()
+
+	storage := dfs_client.New(
+		ctx.Context,
+		cfg.Environment,
+		cfg.Endpoint(),
+		&cfg.DFS,
+		logger,
+		replication_store.New(db, logger),
+		encryption_store.New(db, logger),
+	)
+
+	result, err := migration.Rekey(
+		ctx.Context,
+		storage,
+		registry_store.New(db, logger),
+		logger,
+		func(progress migration.RekeyProgress) {
+			color.Green("moved %d blobs to content addressed keys, %d failed", progress.Rekeyed, progress.Failed)
+		},
+	)
+	if errors.Is(err, migration.ErrIncomplete) {
+		return errors.New(color.RedString("%d blobs failed to move, run the command again to retry them", result.Failed))
+	}
+	if err != nil {
+		return errors.New(color.RedString("error moving blobs: %s", err))
+	}
+
+	color.Green("every blob is stored under a content addressed key now, %d were moved", result.Rekeyed)
+	return nil
+}
@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"errors"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs/encryption"
+	store_v2 "github.com/containerish/OpenRegistry/store/v1"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+func newRewrapKeysCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "rewrap-keys",
+		Usage:     "re-wrap the data keys of encrypted blobs with the current master key",
+		UsageText: "OpenRegistry storage rewrap-keys --config-file=./config.yaml",
+		Description: `Rotates the master key of encrypted storage. Set the new key as dfs.encryption.key (or key_file),
+move the old key to dfs.encryption.previous_keys (or previous_key_files) and run this command. The data keys that are
+wrapped by the old key are unwrapped and wrapped again with the new key, the blobs themselves aren't re-encrypted.
+Once it succeeds, the old key can be removed from the config.`,
+		Flags: []cli.Flag{
+			configFileFlag(),
+		},
+		Action: rewrapKeys,
+	}
+}
+
+func rewrapKeys(ctx *cli.Context) error {
+	cfg, err := config.ReadYamlConfig(ctx.String("config-file"))
+	if err != nil {
+		return errors.New(color.RedString("error reading cfg file: %s", err.Error()))
+	}
+
+	if !cfg.DFS.Encryption.Enabled {
+		return errors.New(color.RedString("storage encryption isn't enabled"))
+	}
+
+	keys, err := encryption.LoadMasterKeys(&cfg.DFS.Encryption)
+	if err != nil {
+		return errors.New(color.RedString("error loading the encryption keys: %s", err))
+	}
+
+	logger := telemetry.ZeroLogger(cfg.Environment, cfg.Telemetry)
+	db := store_v2.New(cfg.StoreConfig, cfg.Environment)
+	defer db.Close()
+
+	count, err := encryption.Rewrap(ctx.Context, encryption_store.New(db, logger), keys)
+	if err != nil {
+		return errors.New(color.RedString("error re-wrapping keys (%d re-wrapped): %s", count, err))
+	}
+
+	color.Green("re-wrapped %d keys with master key %s", count, keys.CurrentID())
+	return nil
+}
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"github.com/urfave/cli/v2"
+)
+
+func NewStorageCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "storage",
+		Aliases:     []string{"st"},
+		Usage:       "Manage the blobs stored by OpenRegistry",
+		Description: "Maintenance tasks for the storage backends, like migrating blobs or rotating the encryption key",
+		Subcommands: []*cli.Command{
+			newRewrapKeysCommand(),
+			newMigrateCommand(),
+			newRekeyCommand(),
+		},
+		Action: nil,
+	}
+}
+
+func configFileFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:      "config-file",
+		Usage:     "Path to the OpenRegistry config file (default: ./config.yaml or $HOME/.openregistry/config.yaml)",
+		TakesFile: true,
+		Aliases:   []string{"c"},
+	}
+}
@@ -0,0 +1,61 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerish/OpenRegistry/registry/v2/layout"
+)
+
+func NewExportCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "export",
+		Usage: "export repositories to an OCI image layout directory or tarball",
+		UsageText: "OpenRegistry export --output=./images.tar --repository=johndoe/alpine:3.18 " +
+			"--repository=johndoe/nginx",
+		Description: `Export one or more repositories from the registry to an OCI image layout. If the output ends with .tar,
+the layout is written as a tarball, otherwise it's written to a directory. A repository without a tag exports all
+of its tags. Referrers (signatures, SBOMs, etc) are exported along with the images they refer to.`,
+		Flags: []cli.Flag{
+			configFileFlag(),
+			&cli.StringFlag{
+				Name:     "output",
+				Aliases:  []string{"o"},
+				Usage:    "Path to the output directory or .tar file",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:     "repository",
+				Aliases:  []string{"r"},
+				Usage:    "One of <username>/<imagename>, <username>/<imagename>:<tag> or <username>/<imagename>@<digest>",
+				Required: true,
+			},
+		},
+		Action: exportRepositories,
+	}
+}
+
+func exportRepositories(ctx *cli.Context) error {
+	c, err := newComponents(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.db.Close()
+
+	w, err := layout.NewWriter(ctx.String("output"))
+	if err != nil {
+		return errors.New(color.RedString("error creating image layout: %s", err))
+	}
+
+	exporter := layout.NewExporter(c.registryStore, c.dfs, os.Stdout)
+	index, err := exporter.Export(ctx.Context, w, ctx.StringSlice("repository"))
+	if err != nil {
+		return errors.New(color.RedString("error exporting repositories: %s", err))
+	}
+
+	color.Green("exported %d manifests to %s", len(index.Manifests), ctx.String("output"))
+	return nil
+}
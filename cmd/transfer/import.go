@@ -0,0 +1,81 @@
+package transfer
+
+import (
+	"errors"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerish/OpenRegistry/registry/v2/layout"
+	"github.com/containerish/OpenRegistry/store/v1/users"
+)
+
+func NewImportCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "import",
+		Usage:     "import images from an OCI image layout directory or tarball",
+		UsageText: "OpenRegistry import --input=./images.tar",
+		Description: `Import all the images listed in the index.json of an OCI image layout. Layouts created with the export
+command carry the repository name for every image. For other layouts, use --namespace to choose the target repository.
+Repositories that don't exist yet are created as private repositories owned by the user in the namespace.
+Images published to IPFS by an OpenRegistry node in P2P mode are imported by their root CID, with --cid.`,
+		Flags: []cli.Flag{
+			configFileFlag(),
+			&cli.StringFlag{
+				Name:    "input",
+				Aliases: []string{"i"},
+				Usage:   "Path to the image layout directory or .tar file",
+			},
+			&cli.StringFlag{
+				Name:  "cid",
+				Usage: "Root CID of an image published to IPFS, requires the IPFS storage backend",
+			},
+			&cli.StringFlag{
+				Name:    "namespace",
+				Aliases: []string{"n"},
+				Usage:   "Import all the images into <username>/<imagename> instead of the namespace in the layout",
+			},
+		},
+		Action: importRepositories,
+	}
+}
+
+func importRepositories(ctx *cli.Context) error {
+	input, cid := ctx.String("input"), ctx.String("cid")
+	if (input == "") == (cid == "") {
+		return errors.New(color.RedString("exactly one of --input or --cid is required"))
+	}
+
+	c, err := newComponents(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.db.Close()
+
+	var r layout.Reader
+	if cid != "" {
+		r, err = layout.NewPublishedImageReader(c.dfs, cid)
+		input = cid
+	} else {
+		r, err = layout.NewReader(input)
+	}
+	if err != nil {
+		return errors.New(color.RedString("error opening image layout: %s", err))
+	}
+	defer r.Close()
+
+	// layouts exported by the operator keep the digests they were exported with, images published by other nodes are
+	// stored with the digest of the manifest we actually got
+	importer := layout.NewImporter(c.registryStore, users.New(c.db, c.logger), c.dfs, os.Stdout)
+	if cid == "" {
+		importer = importer.WithTrustedDigests()
+	}
+	images, err := importer.Import(ctx.Context, r, ctx.String("namespace"))
+	if err != nil {
+		return errors.New(color.RedString("error importing images: %s", err))
+	}
+
+	color.Green("imported %d manifests from %s", len(images), input)
+	return nil
+}
@@ -0,0 +1,63 @@
+package transfer
+
+import (
+	"errors"
+
+	"github.com/fatih/color"
+	"github.com/uptrace/bun"
+	"github.com/urfave/cli/v2"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	dfs_client "github.com/containerish/OpenRegistry/dfs/client"
+	store_v2 "github.com/containerish/OpenRegistry/store/v1"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+	registry_store "github.com/containerish/OpenRegistry/store/v1/registry"
+	replication_store "github.com/containerish/OpenRegistry/store/v1/replication"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+type components struct {
+	cfg           *config.OpenRegistryConfig
+	logger        telemetry.Logger
+	dfs           dfs.DFS
+	db            *bun.DB
+	registryStore registry_store.RegistryStore
+}
+
+func configFileFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:      "config-file",
+		Usage:     "Path to the OpenRegistry config file (default: ./config.yaml or $HOME/.openregistry/config.yaml)",
+		TakesFile: true,
+		Aliases:   []string{"c"},
+	}
+}
+
+// newComponents wires up the same store & DFS the registry server uses, so that exports and imports go through the
+// exact same code paths as pushes and pulls
+func newComponents(ctx *cli.Context) (*components, error) {
+	cfg, err := config.ReadYamlConfig(ctx.String("config-file"))
+	if err != nil {
+		return nil, errors.New(color.RedString("error reading cfg file: %s", err.Error()))
+	}
+
+	logger := telemetry.ZeroLogger(cfg.Environment, cfg.Telemetry)
+	db := store_v2.New(cfg.StoreConfig, cfg.Environment)
+
+	return &components{
+		cfg:    cfg,
+		logger: logger,
+		dfs: dfs_client.New(
+			ctx.Context,
+			cfg.Environment,
+			cfg.Endpoint(),
+			&cfg.DFS,
+			logger,
+			replication_store.New(db, logger),
+			encryption_store.New(db, logger),
+		),
+		db:            db,
+		registryStore: registry_store.New(db, logger),
+	}, nil
+}
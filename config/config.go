@@ -43,6 +43,185 @@ type (
 		Filebase S3CompatibleDFS `yaml:"filebase" mapstructure:"filebase"`
 		Ipfs     IpfsDFS         `yaml:"ipfs" mapstructure:"ipfs"`
 		Mock     S3CompatibleDFS `yaml:"mock" mapstructure:"mock"`
+		Local    LocalDFS        `yaml:"local" mapstructure:"local"`
+		S3       S3DFS           `yaml:"s3" mapstructure:"s3"`
+		GCS      GCSDFS          `yaml:"gcs" mapstructure:"gcs"`
+		Azure    AzureDFS        `yaml:"azure" mapstructure:"azure"`
+		// Replication writes to the primary backend and copies blobs to the secondaries in the background
+		Replication DFSReplication `yaml:"replication" mapstructure:"replication"`
+		// Backends are named storage backends next to the default one, each is configured like the dfs section
+		Backends map[string]DFS `yaml:"backends" mapstructure:"backends"`
+		// Routing picks the backend of a repository by the prefix of its namespace, the first matching rule wins.
+		// Repositories that don't match any rule use the default backend
+		Routing []DFSRoute `yaml:"routing" mapstructure:"routing"`
+		// Encryption encrypts blobs before they are written to any of the backends
+		Encryption DFSEncryption `yaml:"encryption" mapstructure:"encryption"`
+		// Migration copies the blobs of one backend to another in the background
+		Migration DFSMigration `yaml:"migration" mapstructure:"migration"`
+		// Cache keeps recently pulled blobs on the local disk of the registry
+		Cache DFSCache `yaml:"cache" mapstructure:"cache"`
+		// CDN hands out signed CDN URLs for blobs instead of presigned URLs of the bucket
+		CDN DFSCDN `yaml:"cdn" mapstructure:"cdn"`
+		// Resilience adds timeouts, retries and a circuit breaker to the remote backends of the section
+		Resilience DFSResilience `yaml:"resilience" mapstructure:"resilience"`
+	}
+
+	DFSResilience struct {
+		// Timeout bounds the operations that don't transfer blobs (metadata, presigned URLs, etc) and the time to the
+		// first byte of downloads, defaults to 30 seconds
+		Timeout time.Duration `yaml:"timeout" mapstructure:"timeout"`
+		// TransferTimeout bounds uploads of blobs and parts, defaults to 10 minutes
+		TransferTimeout time.Duration `yaml:"transfer_timeout" mapstructure:"transfer_timeout"`
+		// MaxRetries is the number of retries of idempotent operations after a transient error, defaults to 3 and 0
+		// disables retries. The delays between them grow exponentially from RetryBaseDelay up to RetryMaxDelay, with
+		// full jitter
+		MaxRetries     *int          `yaml:"max_retries" mapstructure:"max_retries"`
+		RetryBaseDelay time.Duration `yaml:"retry_base_delay" mapstructure:"retry_base_delay"`
+		RetryMaxDelay  time.Duration `yaml:"retry_max_delay" mapstructure:"retry_max_delay"`
+		// BreakerThreshold is the number of operations in a row that fail with transient errors before the circuit
+		// breaker opens, defaults to 5. An open breaker fails operations right away for BreakerCooldown (defaults to
+		// 30 seconds) and then lets a single operation through to probe the backend
+		BreakerThreshold int           `yaml:"breaker_threshold" mapstructure:"breaker_threshold"`
+		BreakerCooldown  time.Duration `yaml:"breaker_cooldown" mapstructure:"breaker_cooldown"`
+		Enabled          bool          `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	// DFSCDN is a CDN in front of the bucket of a backend. The CDN URL of a blob is the endpoint followed by its DFS
+	// link, so the origin of the CDN must point at the bucket (and the prefix, if the backend has one)
+	DFSCDN struct {
+		Endpoint string `yaml:"endpoint" mapstructure:"endpoint"`
+		// Signing is the URL signing scheme, either "hmac" (the default) or "cloudfront"
+		Signing string `yaml:"signing" mapstructure:"signing"`
+		// Key is the HMAC key for the hmac scheme. KeyFile is a PEM RSA private key for the cloudfront scheme and
+		// KeyPairID the ID of its public key in CloudFront
+		Key       string `yaml:"key" mapstructure:"key"`
+		KeyFile   string `yaml:"key_file" mapstructure:"key_file"`
+		KeyPairID string `yaml:"key_pair_id" mapstructure:"key_pair_id"`
+		// URLExpiry is how long a CDN URL stays valid, defaults to 20 minutes
+		URLExpiry time.Duration `yaml:"url_expiry" mapstructure:"url_expiry"`
+		Enabled   bool          `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	DFSCache struct {
+		Dir string `yaml:"dir" mapstructure:"dir"`
+		// MaxSize is the size of the cache in bytes, the least recently used blobs are evicted when it's full. Blobs
+		// larger than a quarter of it aren't cached
+		MaxSize int64 `yaml:"max_size" mapstructure:"max_size"`
+		// ProxyDownloads makes the registry stream blobs to clients instead of redirecting them to the backend, so
+		// that pulls are served from the cache
+		ProxyDownloads bool `yaml:"proxy_downloads" mapstructure:"proxy_downloads"`
+		Enabled        bool `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	DFSMigration struct {
+		// Source & Target are backend names, "default" or a name in dfs.backends
+		Source string `yaml:"source" mapstructure:"source"`
+		Target string `yaml:"target" mapstructure:"target"`
+		// MaxBytesPerSecond throttles the copies, so that the migration doesn't compete with pushes & pulls. It's not
+		// throttled when it's 0
+		MaxBytesPerSecond int `yaml:"max_bytes_per_second" mapstructure:"max_bytes_per_second"`
+		// BatchSize is the number of layers read from the database at a time
+		BatchSize int `yaml:"batch_size" mapstructure:"batch_size"`
+		// RetryInterval is the delay before the blobs that failed to copy are tried again
+		RetryInterval time.Duration `yaml:"retry_interval" mapstructure:"retry_interval"`
+		Enabled       bool          `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	DFSEncryption struct {
+		// Key is the base64 encoded 256 bit master key, it wraps the data key of every blob. KeyFile is a file with
+		// the same content, only one of them should be set
+		Key     string `yaml:"key" mapstructure:"key"`
+		KeyFile string `yaml:"key_file" mapstructure:"key_file"`
+		// PreviousKeys & PreviousKeyFiles are master keys that were rotated out. Blobs with data keys that are still
+		// wrapped by them can be read until the keys are re-wrapped with the storage rewrap-keys command
+		PreviousKeys     []string `yaml:"previous_keys" mapstructure:"previous_keys"`
+		PreviousKeyFiles []string `yaml:"previous_key_files" mapstructure:"previous_key_files"`
+		Enabled          bool     `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	DFSRoute struct {
+		// Prefix is matched against the namespace (<username>/<repository>), e.g, "acme-eu/" or "ipfs/"
+		Prefix string `yaml:"prefix" mapstructure:"prefix"`
+		// Backend is a name in dfs.backends
+		Backend string `yaml:"backend" mapstructure:"backend"`
+	}
+
+	DFSReplication struct {
+		// Primary & Secondaries are backend names (the keys in the dfs section), the backends must be enabled
+		Primary     string   `yaml:"primary" mapstructure:"primary"`
+		Secondaries []string `yaml:"secondaries" mapstructure:"secondaries"`
+		// PollInterval is how often the queue is checked for blobs to replicate
+		PollInterval time.Duration `yaml:"poll_interval" mapstructure:"poll_interval"`
+		// RetryInterval is the delay before the first retry of a failed copy, it doubles with every attempt
+		RetryInterval time.Duration `yaml:"retry_interval" mapstructure:"retry_interval"`
+		BatchSize     int           `yaml:"batch_size" mapstructure:"batch_size"`
+		Enabled       bool          `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	GCSDFS struct {
+		BucketName string `yaml:"bucket_name" mapstructure:"bucket_name"`
+		Prefix     string `yaml:"prefix" mapstructure:"prefix"`
+		// CredentialsFile is a service account key. Application default credentials are used if it's empty, URLs are
+		// then signed with the IAM signBlob API
+		CredentialsFile string `yaml:"credentials_file" mapstructure:"credentials_file"`
+		// Endpoint is only used for emulators like fake-gcs-server, requests to it aren't authenticated
+		Endpoint     string        `yaml:"endpoint" mapstructure:"endpoint"`
+		URLExpiry    time.Duration `yaml:"url_expiry" mapstructure:"url_expiry"`
+		ChunkSize    int           `yaml:"chunk_size" mapstructure:"chunk_size"`
+		MinChunkSize uint64        `yaml:"min_chunk_size" mapstructure:"min_chunk_size"`
+		Enabled      bool          `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	AzureDFS struct {
+		AccountName   string `yaml:"account_name" mapstructure:"account_name"`
+		AccountKey    string `yaml:"account_key" mapstructure:"account_key"`
+		ContainerName string `yaml:"container_name" mapstructure:"container_name"`
+		Prefix        string `yaml:"prefix" mapstructure:"prefix"`
+		// Endpoint defaults to https://<account_name>.blob.core.windows.net, set it for Azurite or sovereign clouds
+		Endpoint     string        `yaml:"endpoint" mapstructure:"endpoint"`
+		URLExpiry    time.Duration `yaml:"url_expiry" mapstructure:"url_expiry"`
+		ChunkSize    int           `yaml:"chunk_size" mapstructure:"chunk_size"`
+		MinChunkSize uint64        `yaml:"min_chunk_size" mapstructure:"min_chunk_size"`
+		Enabled      bool          `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	// S3DFS works with AWS S3 and any S3 compatible object store (MinIO, Ceph, etc)
+	S3DFS struct {
+		// Endpoint is only required for S3 compatible object stores, AWS S3 endpoints are derived from the region
+		Endpoint   string `yaml:"endpoint" mapstructure:"endpoint"`
+		Region     string `yaml:"region" mapstructure:"region"`
+		BucketName string `yaml:"bucket_name" mapstructure:"bucket_name"`
+		// Prefix is prepended to all the object keys, so that the bucket can be shared with other applications
+		Prefix string `yaml:"prefix" mapstructure:"prefix"`
+		// AccessKey and SecretKey are optional, the default AWS credential chain (env, shared config, instance
+		// profile, etc) is used if they're not set
+		AccessKey string `yaml:"access_key" mapstructure:"access_key"`
+		SecretKey string `yaml:"secret_key" mapstructure:"secret_key"`
+		// RoleARN is assumed on top of the credentials above, with a web identity token if WebIdentityTokenFile is set
+		RoleARN              string `yaml:"role_arn" mapstructure:"role_arn"`
+		WebIdentityTokenFile string `yaml:"web_identity_token_file" mapstructure:"web_identity_token_file"`
+		// CACertFile is a PEM bundle to trust in addition to the system roots, for object stores with private CAs
+		CACertFile string `yaml:"ca_cert_file" mapstructure:"ca_cert_file"`
+		// ServerSideEncryption is either AES256 (SSE-S3) or aws:kms (SSE-KMS), KMSKeyID is only used for the latter
+		ServerSideEncryption string        `yaml:"server_side_encryption" mapstructure:"server_side_encryption"`
+		KMSKeyID             string        `yaml:"kms_key_id" mapstructure:"kms_key_id"`
+		StorageClass         string        `yaml:"storage_class" mapstructure:"storage_class"`
+		URLExpiry            time.Duration `yaml:"url_expiry" mapstructure:"url_expiry"`
+		ChunkSize            int           `yaml:"chunk_size" mapstructure:"chunk_size"`
+		MinChunkSize         uint64        `yaml:"min_chunk_size" mapstructure:"min_chunk_size"`
+		UsePathStyle         bool          `yaml:"use_path_style" mapstructure:"use_path_style"`
+		Enabled              bool          `yaml:"enabled" mapstructure:"enabled"`
+	}
+
+	// LocalDFS stores blobs on the local disk and serves them from the registry itself
+	LocalDFS struct {
+		RootDir string `yaml:"root_dir" mapstructure:"root_dir"`
+		// SigningKey is the HMAC key for blob URLs. A random key is generated on startup if it's empty, which means the
+		// URLs handed out before a restart stop working
+		SigningKey string `yaml:"signing_key" mapstructure:"signing_key"`
+		// URLExpiry is how long a blob URL stays valid, defaults to 20 minutes
+		URLExpiry time.Duration `yaml:"url_expiry" mapstructure:"url_expiry"`
+		Enabled   bool          `yaml:"enabled" mapstructure:"enabled"`
 	}
 
 	Storj struct {
@@ -56,7 +235,9 @@ type (
 		DFSLinkResolver  string `yaml:"dfs_link_resolver" mapstructure:"dfs_link_resolver"`
 		ChunkSize        int    `yaml:"chunk_size" mapstructure:"chunk_size"`
 		MinChunkSize     uint64 `yaml:"min_chunk_size" mapstructure:"min_chunk_size"`
-		Enabled          bool   `yaml:"enabled" mapstructure:"enabled"`
+		// URLExpiry is how long a presigned URL (or a shared link with uplink) stays valid, defaults to 20 minutes
+		URLExpiry time.Duration `yaml:"url_expiry" mapstructure:"url_expiry"`
+		Enabled   bool          `yaml:"enabled" mapstructure:"enabled"`
 	}
 
 	S3CompatibleDFS struct {
@@ -67,7 +248,9 @@ type (
 		DFSLinkResolver string `yaml:"dfs_link_resolver" mapstructure:"dfs_link_resolver"`
 		ChunkSize       int    `yaml:"chunk_size" mapstructure:"chunk_size"`
 		MinChunkSize    uint64 `yaml:"min_chunk_size" mapstructure:"min_chunk_size"`
-		Enabled         bool   `yaml:"enabled" mapstructure:"enabled"`
+		// URLExpiry is how long a presigned URL stays valid, defaults to 20 minutes
+		URLExpiry time.Duration `yaml:"url_expiry" mapstructure:"url_expiry"`
+		Enabled   bool          `yaml:"enabled" mapstructure:"enabled"`
 
 		// this field is only used by the mock storage driver
 		Type MockStorageBackend `yaml:"type" mapstructure:"type"`
@@ -86,6 +269,27 @@ type (
 		Auth       Auth     `yaml:"auth" mapstructure:"auth" validate:"required"`
 		Services   []string `yaml:"services" mapstructure:"services" validate:"-"`
 		Port       uint     `yaml:"port" mapstructure:"port" validate:"required"`
+		// Conversion creates a zstd variant of gzip images in the background
+		Conversion LayerConversion `yaml:"conversion" mapstructure:"conversion" validate:"-"`
+		// MaxManifestSize is the largest manifest (in bytes) accepted on push, defaults to 4 MiB
+		MaxManifestSize int64 `yaml:"max_manifest_size" mapstructure:"max_manifest_size" validate:"-"`
+		// ManifestCache caches tag & digest lookups for manifest pulls
+		ManifestCache ManifestCache `yaml:"manifest_cache" mapstructure:"manifest_cache" validate:"-"`
+		// Admins are the usernames allowed to use the /api/admin endpoints
+		Admins []string `yaml:"admins" mapstructure:"admins" validate:"-"`
+	}
+
+	ManifestCache struct {
+		// TTL is how long a tag lookup is cached, it's also the max-age sent to clients for tags
+		TTL  time.Duration `yaml:"ttl" mapstructure:"ttl"`
+		Size int           `yaml:"size" mapstructure:"size"`
+	}
+
+	LayerConversion struct {
+		// ZstdLevel is the zstd compression level (1-22), mapped to the closest encoder level supported by klauspost/compress
+		ZstdLevel int  `yaml:"zstd_level" mapstructure:"zstd_level"`
+		Workers   int  `yaml:"workers" mapstructure:"workers"`
+		Enabled   bool `yaml:"enabled" mapstructure:"enabled"`
 	}
 
 	TLS struct {
@@ -95,13 +299,16 @@ type (
 	}
 
 	Store struct {
-		Kind               StoreKind `yaml:"kind" mapstructure:"kind" validate:"required"`
-		User               string    `yaml:"username" mapstructure:"username" validate:"required"`
-		Host               string    `yaml:"host" mapstructure:"host" validate:"required"`
-		Password           string    `yaml:"password" mapstructure:"password" validate:"required"`
-		Database           string    `yaml:"name" mapstructure:"name" validate:"required"`
-		MaxOpenConnections int       `yaml:"max_open_connections" mapstructure:"max_open_connections" validate:"-"`
-		Port               int       `yaml:"port" mapstructure:"port" validate:"required"`
+		Kind     StoreKind `yaml:"kind" mapstructure:"kind" validate:"required"`
+		User     string    `yaml:"username" mapstructure:"username" validate:"required_if=Kind postgres"`
+		Host     string    `yaml:"host" mapstructure:"host" validate:"required_if=Kind postgres"`
+		Password string    `yaml:"password" mapstructure:"password" validate:"required_if=Kind postgres"`
+		Database string    `yaml:"name" mapstructure:"name" validate:"required_if=Kind postgres"`
+		// Path is the SQLite database file, it's created if it doesn't exist. Without a path, SQLite runs in memory
+		// and everything is lost when the registry stops
+		Path               string `yaml:"path" mapstructure:"path"`
+		MaxOpenConnections int    `yaml:"max_open_connections" mapstructure:"max_open_connections" validate:"-"`
+		Port               int    `yaml:"port" mapstructure:"port" validate:"required_if=Kind postgres"`
 	}
 
 	StoreKind string
@@ -195,6 +402,9 @@ type (
 		Enabled         bool   `yaml:"enabled" mapstructure:"enabled"`
 		Local           bool   `yaml:"local" mapstructure:"local"`
 		Pinning         bool   `yaml:"pinning" mapstructure:"pinning"`
+		// Publishers are the users and organizations allowed to push to the ipfs namespace, members of an organization
+		// need push permissions for it
+		Publishers []string `yaml:"publishers" mapstructure:"publishers"`
 	}
 )
 
@@ -208,6 +418,41 @@ const (
 	MockStorageBackendFileBased
 )
 
+// defaults of the resilience settings, used by the YAML config and by resilient backends that are created from a config
+// the YAML defaults weren't applied to
+const (
+	// DefaultMaxRetries is the number of retries of a resilient backend when max_retries isn't set
+	DefaultMaxRetries       = 3
+	DefaultTimeout          = time.Second * 30
+	DefaultTransferTimeout  = time.Minute * 10
+	DefaultRetryBaseDelay   = time.Millisecond * 200
+	DefaultRetryMaxDelay    = time.Second * 5
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Second * 30
+)
+
+// Retries returns MaxRetries, or DefaultMaxRetries when it isn't set (or is negative)
+func (r *DFSResilience) Retries() int {
+	if r.MaxRetries == nil || *r.MaxRetries < 0 {
+		return DefaultMaxRetries
+	}
+
+	return *r.MaxRetries
+}
+
+// DefaultURLExpiry is how long presigned URLs stay valid when url_expiry isn't set
+const DefaultURLExpiry = time.Minute * 20
+
+// URLExpiryOrDefault returns DefaultURLExpiry for an expiry that isn't set. The defaults of the YAML config aren't
+// applied to a config read from OPENREGISTRY_CONFIG, so backends can't rely on them
+func URLExpiryOrDefault(expiry time.Duration) time.Duration {
+	if expiry <= 0 {
+		return DefaultURLExpiry
+	}
+
+	return expiry
+}
+
 func (r *Registry) Address() string {
 	return fmt.Sprintf("%s:%d", r.Host, r.Port)
 }
@@ -355,6 +600,7 @@ func (sj *Storj) S3Config() *S3CompatibleDFS {
 		DFSLinkResolver: sj.DFSLinkResolver,
 		ChunkSize:       sj.ChunkSize,
 		MinChunkSize:    sj.MinChunkSize,
+		URLExpiry:       sj.URLExpiry,
 		Enabled:         sj.Enabled,
 	}
 }
@@ -7,6 +7,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/golang-jwt/jwt/v5"
@@ -67,6 +68,8 @@ func ReadYamlConfig(configPath string) (*OpenRegistryConfig, error) {
 
 	setDefaultsForDatabaseStore(&cfg)
 	setDefaultsForStorageBackend(&cfg)
+	setDefaultsForLayerConversion(&cfg)
+	setDefaultsForRegistry(&cfg)
 
 	githubConfig := cfg.Integrations.GetGithubConfig()
 	if githubConfig.Host == "" {
@@ -86,6 +89,7 @@ func ReadYamlConfig(configPath string) (*OpenRegistryConfig, error) {
 	return &cfg, nil
 }
 
+const fourMBInBytes = 1024 * 1024 * 4
 const fiveMBInBytes = 1024 * 1024 * 5
 const twentyMBInBytes = 1024 * 1024 * 20
 
@@ -122,27 +126,177 @@ func getRSAKeyPairFromViperConfig(authConfig map[string]any) (*rsa.PrivateKey, *
 }
 
 func setDefaultsForStorageBackend(cfg *OpenRegistryConfig) {
-	if cfg.DFS.Filebase.Enabled {
-		if cfg.DFS.Filebase.ChunkSize == 0 {
-			cfg.DFS.Filebase.ChunkSize = twentyMBInBytes
-		}
+	setDefaultsForDFS(&cfg.DFS)
 
-		if cfg.DFS.Filebase.MinChunkSize == 0 {
-			cfg.DFS.Filebase.MinChunkSize = fiveMBInBytes
-		}
+	for name, backend := range cfg.DFS.Backends {
+		setDefaultsForDFS(&backend)
+		cfg.DFS.Backends[name] = backend
 	}
+}
 
-	if cfg.DFS.Storj.Enabled {
-		if cfg.DFS.Storj.ChunkSize == 0 {
-			cfg.DFS.Storj.ChunkSize = twentyMBInBytes
-		}
+func setDefaultsForDFS(dfs *DFS) {
+	if dfs.Local.Enabled {
+		setDefaultURLExpiry(&dfs.Local.URLExpiry)
+	}
+
+	if dfs.S3.Enabled {
+		setDefaultsForS3(&dfs.S3)
+	}
+
+	if dfs.Replication.Enabled {
+		setDefaultsForReplication(&dfs.Replication)
+	}
+
+	if dfs.Migration.Enabled {
+		setDefaultsForMigration(&dfs.Migration)
+	}
+
+	if dfs.Cache.Enabled && dfs.Cache.MaxSize == 0 {
+		dfs.Cache.MaxSize = 10 * 1024 * 1024 * 1024
+	}
+
+	if dfs.GCS.Enabled {
+		setDefaultsForObjectStore(&dfs.GCS.URLExpiry, &dfs.GCS.ChunkSize)
+	}
+
+	if dfs.Azure.Enabled {
+		setDefaultsForObjectStore(&dfs.Azure.URLExpiry, &dfs.Azure.ChunkSize)
+	}
+
+	if dfs.Filebase.Enabled {
+		setDefaultURLExpiry(&dfs.Filebase.URLExpiry)
+		setDefaultsForChunkSizes(&dfs.Filebase.ChunkSize, &dfs.Filebase.MinChunkSize)
+	}
+
+	if dfs.Storj.Enabled {
+		setDefaultURLExpiry(&dfs.Storj.URLExpiry)
+		setDefaultsForChunkSizes(&dfs.Storj.ChunkSize, &dfs.Storj.MinChunkSize)
+	}
+
+	if dfs.Resilience.Enabled {
+		setDefaultsForResilience(&dfs.Resilience)
+	}
 
-		if cfg.DFS.Storj.MinChunkSize == 0 {
-			cfg.DFS.Storj.MinChunkSize = fiveMBInBytes
+	if dfs.CDN.Enabled {
+		setDefaultURLExpiry(&dfs.CDN.URLExpiry)
+		if dfs.CDN.Signing == "" {
+			dfs.CDN.Signing = "hmac"
 		}
 	}
 }
 
+func setDefaultsForS3(s3 *S3DFS) {
+	if s3.Region == "" {
+		s3.Region = "us-east-1"
+	}
+
+	setDefaultsForObjectStore(&s3.URLExpiry, &s3.ChunkSize)
+
+	// S3 rejects multipart uploads with parts smaller than 5 MiB (except the last one)
+	setDefaultsForChunkSizes(&s3.ChunkSize, &s3.MinChunkSize)
+}
+
+func setDefaultsForResilience(resilience *DFSResilience) {
+	if resilience.Timeout == 0 {
+		resilience.Timeout = DefaultTimeout
+	}
+
+	if resilience.TransferTimeout == 0 {
+		resilience.TransferTimeout = DefaultTransferTimeout
+	}
+
+	if resilience.RetryBaseDelay == 0 {
+		resilience.RetryBaseDelay = DefaultRetryBaseDelay
+	}
+
+	if resilience.RetryMaxDelay == 0 {
+		resilience.RetryMaxDelay = DefaultRetryMaxDelay
+	}
+
+	if resilience.BreakerThreshold == 0 {
+		resilience.BreakerThreshold = DefaultBreakerThreshold
+	}
+
+	if resilience.BreakerCooldown == 0 {
+		resilience.BreakerCooldown = DefaultBreakerCooldown
+	}
+}
+
+func setDefaultsForReplication(replication *DFSReplication) {
+	if replication.PollInterval == 0 {
+		replication.PollInterval = time.Second * 5
+	}
+
+	if replication.RetryInterval == 0 {
+		replication.RetryInterval = time.Second * 30
+	}
+
+	if replication.BatchSize == 0 {
+		replication.BatchSize = 16
+	}
+}
+
+func setDefaultsForMigration(migration *DFSMigration) {
+	if migration.BatchSize == 0 {
+		migration.BatchSize = 100
+	}
+
+	if migration.RetryInterval == 0 {
+		migration.RetryInterval = time.Minute
+	}
+}
+
+func setDefaultURLExpiry(urlExpiry *time.Duration) {
+	*urlExpiry = URLExpiryOrDefault(*urlExpiry)
+}
+
+func setDefaultsForObjectStore(urlExpiry *time.Duration, chunkSize *int) {
+	setDefaultURLExpiry(urlExpiry)
+
+	if *chunkSize == 0 {
+		*chunkSize = twentyMBInBytes
+	}
+}
+
+func setDefaultsForChunkSizes(chunkSize *int, minChunkSize *uint64) {
+	if *chunkSize == 0 {
+		*chunkSize = twentyMBInBytes
+	}
+
+	if *minChunkSize == 0 {
+		*minChunkSize = fiveMBInBytes
+	}
+}
+
+func setDefaultsForLayerConversion(cfg *OpenRegistryConfig) {
+	if !cfg.Registry.Conversion.Enabled {
+		return
+	}
+
+	if cfg.Registry.Conversion.ZstdLevel == 0 {
+		cfg.Registry.Conversion.ZstdLevel = 3
+	}
+
+	if cfg.Registry.Conversion.Workers == 0 {
+		cfg.Registry.Conversion.Workers = 1
+	}
+}
+
+func setDefaultsForRegistry(cfg *OpenRegistryConfig) {
+	// the distribution spec recommends accepting manifests of at least 4 MiB
+	if cfg.Registry.MaxManifestSize == 0 {
+		cfg.Registry.MaxManifestSize = fourMBInBytes
+	}
+
+	if cfg.Registry.ManifestCache.Size == 0 {
+		cfg.Registry.ManifestCache.Size = 4096
+	}
+
+	if cfg.Registry.ManifestCache.TTL == 0 {
+		cfg.Registry.ManifestCache.TTL = time.Second * 30
+	}
+}
+
 func setDefaultsForDatabaseStore(cfg *OpenRegistryConfig) {
 	if cfg.StoreConfig.MaxOpenConnections == 0 {
 		cfg.StoreConfig.MaxOpenConnections = runtime.NumCPU() * 6
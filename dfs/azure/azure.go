@@ -0,0 +1,386 @@
+// Package azure is a storage backend for Azure Blob Storage. Layers are stored as block blobs, chunked uploads stage
+// a block per part and commit the block list once the upload is complete
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+type azure struct {
+	client   *container.Client
+	config   *config.AzureDFS
+	s3Config *config.S3CompatibleDFS
+	digests  *dfs.UploadDigests
+}
+
+var _ dfs.ObjectLister = (*azure)(nil)
+
+func New(cfg *config.AzureDFS) (dfs.DFS, error) {
+	cred, err := container.NewSharedKeyCredential(cfg.AccountName, cfg.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_AZURE_SHARED_KEY_CREDENTIAL: %w", err)
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", cfg.AccountName)
+	}
+
+	containerURL := strings.TrimSuffix(endpoint, "/") + "/" + cfg.ContainerName
+	client, err := container.NewClientWithSharedKeyCredential(containerURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_AZURE_NEW_CLIENT: %w", err)
+	}
+
+	return &azure{
+		client:  client,
+		config:  cfg,
+		digests: dfs.NewUploadDigests(),
+		s3Config: &config.S3CompatibleDFS{
+			Endpoint:     endpoint,
+			BucketName:   cfg.ContainerName,
+			ChunkSize:    cfg.ChunkSize,
+			MinChunkSize: cfg.MinChunkSize,
+			Enabled:      cfg.Enabled,
+		},
+	}, nil
+}
+
+// blob maps a DFS link to the block blob in the container
+func (a *azure) blob(dfsLink string) *blockblob.Client {
+	if a.config.Prefix == "" {
+		return a.client.NewBlockBlobClient(dfsLink)
+	}
+
+	return a.client.NewBlockBlobClient(path.Join(a.config.Prefix, dfsLink))
+}
+
+// blockID returns the id of the block for a part. All the block ids of a blob must have the same length, the upload
+// id is a uuid and the part number is zero padded
+func blockID(uploadID string, partNumber int32) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s-%05d", uploadID, partNumber)))
+}
+
+// CreateMultipartUpload doesn't call Azure, uncommitted blocks are staged against the blob name. The upload id only
+// namespaces the block ids, so that blocks of another upload to the same blob are never committed with this one
+func (a *azure) CreateMultipartUpload(layerKey string) (string, error) {
+	return uuid.NewString(), nil
+}
+
+func (a *azure) UploadPart(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	digest string,
+	partNumber int32,
+	content io.ReadSeeker,
+	contentLength int64,
+) (s3types.CompletedPart, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	id := blockID(uploadId, partNumber)
+	_, err := a.blob(layerKey).StageBlock(ctx, id, streaming.NopCloser(content), nil)
+	if err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_AZURE_STAGE_BLOCK: %w", err)
+	}
+
+	if err = a.digests.AddPart(uploadId, partNumber, content); err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
+	return s3types.CompletedPart{
+		ETag:       aws.String(id),
+		PartNumber: aws.Int32(partNumber),
+	}, nil
+}
+
+// CompleteMultipartUpload commits the staged blocks in the order of the part numbers and moves the blob to its content
+// addressed key. Like S3, Azure can't verify the digest of the whole layer, so the parts are hashed as they're staged
+// and the committed blob is removed if it doesn't match
+func (a *azure) CompleteMultipartUpload(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	layerDigest string,
+	completedParts []s3types.CompletedPart,
+) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	blobKey, err := dfs.BlobKey(layerDigest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_AZURE_DIGEST_PARSE: %w", err)
+	}
+
+	sort.Slice(completedParts, func(i, j int) bool {
+		return aws.ToInt32(completedParts[i].PartNumber) < aws.ToInt32(completedParts[j].PartNumber)
+	})
+
+	blockIDs := make([]string, 0, len(completedParts))
+	for _, part := range completedParts {
+		blockIDs = append(blockIDs, blockID(uploadId, aws.ToInt32(part.PartNumber)))
+	}
+
+	_, err = a.blob(layerKey).CommitBlockList(ctx, blockIDs, &blockblob.CommitBlockListOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: aws.String("application/octet-stream")},
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERR_AZURE_COMMIT_BLOCK_LIST: %w", err)
+	}
+
+	err = a.digests.Verify(uploadId, layerDigest, func() (io.ReadCloser, error) {
+		resp, downloadErr := a.blob(layerKey).DownloadStream(ctx, nil)
+		if downloadErr != nil {
+			return nil, fmt.Errorf("ERR_AZURE_DOWNLOAD_BLOB: %w", downloadErr)
+		}
+		return resp.Body, nil
+	})
+	if err != nil {
+		_, _ = a.blob(layerKey).Delete(ctx, nil)
+		return "", err
+	}
+
+	if err = a.commit(ctx, layerKey, blobKey); err != nil {
+		return "", err
+	}
+
+	return blobKey, nil
+}
+
+// commit moves the blob that the blocks were committed to, to its content addressed key, once it's verified. When
+// that key exists already, the blob was stored before and the upload is only deleted
+func (a *azure) commit(ctx context.Context, src, dst string) error {
+	exists, err := a.exists(ctx, dst)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err = a.copy(ctx, src, dst); err != nil {
+			return err
+		}
+	}
+
+	if _, err = a.blob(src).Delete(ctx, nil); err != nil {
+		return fmt.Errorf("ERR_AZURE_DELETE_BLOB: %w", err)
+	}
+
+	return nil
+}
+
+func (a *azure) copy(ctx context.Context, src, dst string) error {
+	srcURL, err := a.blob(src).GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(time.Hour), nil)
+	if err != nil {
+		return fmt.Errorf("ERR_AZURE_GENERATE_SAS_URL: %w", err)
+	}
+
+	resp, err := a.blob(dst).StartCopyFromURL(ctx, srcURL, nil)
+	if err != nil {
+		return fmt.Errorf("ERR_AZURE_COPY_BLOB: %w", err)
+	}
+
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("ERR_AZURE_COPY_BLOB: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
+
+		props, propsErr := a.blob(dst).GetProperties(ctx, nil)
+		if propsErr != nil {
+			return fmt.Errorf("ERR_AZURE_COPY_BLOB: %w", propsErr)
+		}
+		status = props.CopyStatus
+	}
+
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return fmt.Errorf("ERR_AZURE_COPY_BLOB: copy of %s ended with status %s", src, *status)
+	}
+
+	return nil
+}
+
+func (a *azure) exists(ctx context.Context, dfsLink string) (bool, error) {
+	_, err := a.blob(dfsLink).GetProperties(ctx, nil)
+	if err == nil {
+		return true, nil
+	}
+
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("ERR_AZURE_METADATA: %w", err)
+}
+
+// Upload stores the blob under its content addressed key, identifier isn't used. The content is verified against the
+// digest first, a blob that's stored already isn't uploaded again
+func (a *azure) Upload(ctx context.Context, identifier, digest string, content []byte) (string, error) {
+	if err := dfs.VerifyContent(digest, content); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	blobKey, err := dfs.BlobKey(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_AZURE_DIGEST_PARSE: %w", err)
+	}
+
+	exists, err := a.exists(ctx, blobKey)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return blobKey, nil
+	}
+
+	_, err = a.blob(blobKey).Upload(ctx, streaming.NopCloser(bytes.NewReader(content)), &blockblob.UploadOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: aws.String("application/octet-stream")},
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERR_AZURE_UPLOAD_BLOB: %w", err)
+	}
+
+	return blobKey, nil
+}
+
+// Download method returns an io.ReadCloser. The end user/consumer is responsible to close the io.ReadCloser
+func (a *azure) Download(ctx context.Context, dfsLink string) (io.ReadCloser, error) {
+	resp, err := a.blob(dfsLink).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_AZURE_DOWNLOAD_BLOB: %w", err)
+	}
+
+	return resp.Body, nil
+}
+
+func (a *azure) DownloadDir(dfsLink, dir string) error {
+	return nil
+}
+
+func (a *azure) List(path string) ([]*types.Metadata, error) {
+	return nil, nil
+}
+
+// ListObjects implements dfs.ObjectLister, it lists everything under the prefix
+func (a *azure) ListObjects(ctx context.Context, fn func(object *types.ObjectMetadata) error) error {
+	prefix := ""
+	if a.config.Prefix != "" {
+		prefix = strings.TrimSuffix(a.config.Prefix, "/") + "/"
+	}
+
+	pager := a.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: aws.String(prefix)})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ERR_AZURE_LIST_OBJECTS: %w", err)
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			object := &types.ObjectMetadata{DFSLink: strings.TrimPrefix(aws.ToString(item.Name), prefix)}
+			if item.Properties != nil {
+				object.ContentLength = int(aws.ToInt64(item.Properties.ContentLength))
+				object.ContentType = aws.ToString(item.Properties.ContentType)
+			}
+
+			if err = fn(object); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *azure) AddImage(ns string, mf, l map[string][]byte) (string, error) {
+	return "", nil
+}
+
+func (a *azure) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	resp, err := a.blob(layer.DFSLink).GetProperties(context.Background(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_AZURE_METADATA: %w", err)
+	}
+
+	var etag string
+	if resp.ETag != nil {
+		etag = string(*resp.ETag)
+	}
+
+	return &types.ObjectMetadata{
+		ContentType:   aws.ToString(resp.ContentType),
+		Etag:          etag,
+		DFSLink:       layer.DFSLink,
+		ContentLength: int(aws.ToInt64(resp.ContentLength)),
+	}, nil
+}
+
+// GetUploadProgress sums up the uncommitted blocks staged by the upload
+func (a *azure) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
+	resp, err := a.blob(identifier).GetBlockList(context.Background(), blockblob.BlockListTypeUncommitted, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_AZURE_UPLOAD_PROGRESS: %w", err)
+	}
+
+	var uploadedSize int64
+	for _, block := range resp.UncommittedBlocks {
+		name, err := base64.StdEncoding.DecodeString(aws.ToString(block.Name))
+		if err != nil || !strings.HasPrefix(string(name), uploadID+"-") {
+			continue
+		}
+
+		uploadedSize += aws.ToInt64(block.Size)
+	}
+
+	return &types.ObjectMetadata{
+		ContentLength: int(uploadedSize),
+	}, nil
+}
+
+// GeneratePresignedURL returns a read only service SAS URL for the blob, signed with the account key
+func (a *azure) GeneratePresignedURL(ctx context.Context, dfsLink string) (string, error) {
+	expiry := time.Now().Add(config.URLExpiryOrDefault(a.config.URLExpiry))
+	sasURL, err := a.blob(dfsLink).GetSASURL(sas.BlobPermissions{Read: true}, expiry, nil)
+	if err != nil {
+		return "", fmt.Errorf("ERR_AZURE_GENERATE_SAS_URL: %w", err)
+	}
+
+	return sasURL, nil
+}
+
+// AbortMultipartUpload is a no-op, Azure has no API to drop uncommitted blocks and garbage collects them after a week
+func (a *azure) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	a.digests.Forget(uploadId)
+	return nil
+}
+
+func (a *azure) Config() *config.S3CompatibleDFS {
+	return a.s3Config
+}
@@ -0,0 +1,136 @@
+// Package cache is a read-through disk cache in front of a storage backend. Blobs are cached by digest and verified
+// before they are added, so one cache is shared by all the backends. The least recently used blobs are evicted once
+// the cache is full
+package cache
+
+import (
+	"context"
+	"io"
+
+	oci_digest "github.com/opencontainers/go-digest"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+// cached embeds the backend, everything except layer downloads & metadata goes straight to it
+type cached struct {
+	dfs.DFS
+	disk   *disk
+	logger telemetry.Logger
+	proxy  bool
+}
+
+var _ dfs.Wrapper = (*cached)(nil)
+var _ dfs.Selector = (*cached)(nil)
+var _ dfs.Proxy = (*cached)(nil)
+var _ dfs.LayerDownloader = (*cached)(nil)
+
+// New returns the backend with the cache in front of it. The blobs that are already in the cache directory are
+// picked up again
+func New(backend dfs.DFS, cfg *config.DFSCache, logger telemetry.Logger) (dfs.DFS, error) {
+	d, err := newDisk(cfg.Dir, cfg.MaxSize, newMetrics(), logger)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cached{DFS: backend, disk: d, logger: logger, proxy: cfg.ProxyDownloads}, nil
+}
+
+// with returns the cache for another backend, the cache itself is shared
+func (c *cached) with(backend dfs.DFS) dfs.DFS {
+	if backend == c.DFS {
+		return c
+	}
+
+	return &cached{DFS: backend, disk: c.disk, logger: c.logger, proxy: c.proxy}
+}
+
+// Unwrap implements dfs.Wrapper
+func (c *cached) Unwrap() []dfs.DFS {
+	return []dfs.DFS{c.DFS}
+}
+
+// ForNamespace implements dfs.Selector, the backends of the wrapped backend are cached too
+func (c *cached) ForNamespace(namespace string) (string, dfs.DFS) {
+	name, backend := dfs.ForNamespace(c.DFS, namespace)
+	return name, c.with(backend)
+}
+
+// Backend implements dfs.Selector
+func (c *cached) Backend(name string) (dfs.DFS, bool) {
+	selector, ok := dfs.As[dfs.Selector](c.DFS)
+	if !ok {
+		return c, name == "" || name == dfs.DefaultBackend
+	}
+
+	backend, found := selector.Backend(name)
+	if !found {
+		return nil, false
+	}
+
+	return c.with(backend), true
+}
+
+// ProxyDownloads implements dfs.Proxy. Blobs are only served from the cache when the registry streams them, backends
+// that have to be proxied anyway (e.g, encrypted ones) stay proxied
+func (c *cached) ProxyDownloads() bool {
+	return c.proxy || dfs.ProxyDownloads(c.DFS)
+}
+
+// Metadata answers from the cache when the blob is in it, which saves a request to the backend for every pull
+func (c *cached) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	if digest, err := oci_digest.Parse(layer.Digest); err == nil {
+		if size, ok := c.disk.stat(digest); ok {
+			return &types.ObjectMetadata{DFSLink: layer.DFSLink, ContentLength: int(size)}, nil
+		}
+	}
+
+	return c.DFS.Metadata(layer)
+}
+
+// DownloadLayer implements dfs.LayerDownloader. On a miss the blob is downloaded into the cache first, concurrent
+// misses for the same blob wait for a single download. Blobs that can't be cached are downloaded from the backend
+func (c *cached) DownloadLayer(ctx context.Context, layer *types.ContainerImageLayer) (io.ReadCloser, error) {
+	digest, err := oci_digest.Parse(layer.Digest)
+	if err != nil {
+		return c.DFS.Download(ctx, layer.DFSLink)
+	}
+
+	if blob, ok := c.disk.open(digest); ok {
+		c.disk.metrics.hits.Inc()
+		return blob, nil
+	}
+	c.disk.metrics.misses.Inc()
+
+	size := layer.Size
+	if size <= 0 {
+		metadata, metadataErr := c.DFS.Metadata(layer)
+		if metadataErr != nil {
+			return nil, metadataErr
+		}
+		size = int64(metadata.ContentLength)
+	}
+
+	if !c.disk.fits(size) {
+		return c.DFS.Download(ctx, layer.DFSLink)
+	}
+
+	err = c.disk.fill(ctx, digest, func(ctx context.Context) (io.ReadCloser, error) {
+		return c.DFS.Download(ctx, layer.DFSLink)
+	})
+	if err != nil {
+		c.disk.metrics.fillErrors.Inc()
+		c.logger.Info().Err(err).Str("digest", layer.Digest).Msg("ERR_DFS_CACHE_FILL")
+		return c.DFS.Download(ctx, layer.DFSLink)
+	}
+
+	// the blob might have been evicted already on a busy cache
+	if blob, ok := c.disk.open(digest); ok {
+		return blob, nil
+	}
+
+	return c.DFS.Download(ctx, layer.DFSLink)
+}
@@ -0,0 +1,282 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	oci_digest "github.com/opencontainers/go-digest"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+// tmpDir is where blobs are downloaded to, they are moved into the cache once they are verified
+const tmpDir = "tmp"
+
+// disk is an LRU index of the blobs in the cache directory, they are stored at <algorithm>/<first 2 chars>/<hex>
+type disk struct {
+	entries map[oci_digest.Digest]*list.Element
+	// lru has the most recently used blob at the front
+	lru     *list.List
+	metrics *metrics
+	logger  telemetry.Logger
+	fills   singleflight.Group
+	dir     string
+	size    int64
+	maxSize int64
+	mu      sync.Mutex
+}
+
+type entry struct {
+	digest oci_digest.Digest
+	size   int64
+}
+
+type cachedFile struct {
+	modTime time.Time
+	entry   entry
+}
+
+func newDisk(dir string, maxSize int64, metrics *metrics, logger telemetry.Logger) (*disk, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("ERR_DFS_CACHE_CONFIG: the cache directory isn't set")
+	}
+
+	// downloads that didn't finish before a restart are of no use
+	if err := os.RemoveAll(filepath.Join(dir, tmpDir)); err != nil {
+		return nil, fmt.Errorf("ERR_DFS_CACHE_INIT: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, tmpDir), 0o750); err != nil {
+		return nil, fmt.Errorf("ERR_DFS_CACHE_INIT: %w", err)
+	}
+
+	d := &disk{
+		entries: make(map[oci_digest.Digest]*list.Element),
+		lru:     list.New(),
+		metrics: metrics,
+		logger:  logger,
+		dir:     dir,
+		maxSize: maxSize,
+	}
+
+	files, err := d.scan()
+	if err != nil {
+		return nil, fmt.Errorf("ERR_DFS_CACHE_INIT: %w", err)
+	}
+
+	// the modification time of a blob is bumped when it's read, so the order survives restarts
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, file := range files {
+		d.entries[file.entry.digest] = d.lru.PushFront(file.entry)
+		d.size += file.entry.size
+	}
+	d.evict()
+
+	return d, nil
+}
+
+// scan returns the blobs in the cache directory, files that aren't blobs are removed
+func (d *disk) scan() ([]cachedFile, error) {
+	var files []cachedFile
+	err := filepath.WalkDir(d.dir, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if dirEntry.IsDir() {
+			if path == filepath.Join(d.dir, tmpDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			return err
+		}
+
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		digest := oci_digest.NewDigestFromEncoded(oci_digest.Algorithm(parts[0]), parts[len(parts)-1])
+		info, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+
+		if len(parts) != 3 || digest.Validate() != nil || d.path(digest) != path {
+			return os.Remove(path)
+		}
+
+		files = append(files, cachedFile{
+			modTime: info.ModTime(),
+			entry:   entry{digest: digest, size: info.Size()},
+		})
+		return nil
+	})
+
+	return files, err
+}
+
+func (d *disk) path(digest oci_digest.Digest) string {
+	encoded := digest.Encoded()
+	return filepath.Join(d.dir, digest.Algorithm().String(), encoded[:2], encoded)
+}
+
+// fits reports whether a blob of the size is cached at all, large blobs would evict too much of the cache
+func (d *disk) fits(size int64) bool {
+	return size <= d.maxSize/4
+}
+
+func (d *disk) stat(digest oci_digest.Digest) (int64, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	element, ok := d.entries[digest]
+	if !ok {
+		return 0, false
+	}
+
+	return element.Value.(entry).size, true
+}
+
+// open returns the cached blob and marks it as the most recently used one
+func (d *disk) open(digest oci_digest.Digest) (*os.File, bool) {
+	d.mu.Lock()
+	element, ok := d.entries[digest]
+	if ok {
+		d.lru.MoveToFront(element)
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	path := d.path(digest)
+	blob, err := os.Open(path)
+	if err != nil {
+		// the blob was removed from the directory behind the cache's back
+		d.remove(digest)
+		return nil, false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return blob, true
+}
+
+// fill downloads the blob into the cache, concurrent fills of the same blob share one download. The download isn't
+// cancelled with ctx, the other callers might still be waiting for it
+func (d *disk) fill(
+	ctx context.Context,
+	digest oci_digest.Digest,
+	download func(ctx context.Context) (io.ReadCloser, error),
+) error {
+	_, err, _ := d.fills.Do(digest.String(), func() (any, error) {
+		if _, ok := d.stat(digest); ok {
+			return nil, nil
+		}
+
+		return nil, d.download(context.WithoutCancel(ctx), digest, download)
+	})
+
+	return err
+}
+
+func (d *disk) download(
+	ctx context.Context,
+	digest oci_digest.Digest,
+	download func(ctx context.Context) (io.ReadCloser, error),
+) error {
+	blob, err := download(ctx)
+	if err != nil {
+		return fmt.Errorf("ERR_DFS_CACHE_DOWNLOAD: %w", err)
+	}
+	defer blob.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(d.dir, tmpDir), "blob-")
+	if err != nil {
+		return fmt.Errorf("ERR_DFS_CACHE_CREATE: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	verifier := digest.Verifier()
+	size, err := io.Copy(io.MultiWriter(tmp, verifier), blob)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("ERR_DFS_CACHE_WRITE: %w", err)
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("ERR_DFS_CACHE_DIGEST_MISMATCH: %s", digest)
+	}
+
+	path := d.path(digest)
+	if err = os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("ERR_DFS_CACHE_CREATE: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("ERR_DFS_CACHE_CREATE: %w", err)
+	}
+
+	d.add(entry{digest: digest, size: size})
+	return nil
+}
+
+func (d *disk) add(e entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.entries[e.digest]; ok {
+		return
+	}
+
+	d.entries[e.digest] = d.lru.PushFront(e)
+	d.size += e.size
+	d.evict()
+}
+
+func (d *disk) remove(digest oci_digest.Digest) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if element, ok := d.entries[digest]; ok {
+		d.lru.Remove(element)
+		delete(d.entries, digest)
+		d.size -= element.Value.(entry).size
+		d.metrics.size.Set(float64(d.size))
+	}
+}
+
+// evict removes the least recently used blobs until the cache fits in its size, d.mu must be held. Blobs that are
+// being read are removed too, readers keep the file open until they are done
+func (d *disk) evict() {
+	for d.size > d.maxSize && d.lru.Len() > 0 {
+		element := d.lru.Back()
+		e := element.Value.(entry)
+		d.lru.Remove(element)
+		delete(d.entries, e.digest)
+		d.size -= e.size
+		d.metrics.evictions.Inc()
+
+		if err := os.Remove(d.path(e.digest)); err != nil && !os.IsNotExist(err) {
+			d.logger.Info().Err(err).Str("digest", e.digest.String()).Msg("ERR_DFS_CACHE_EVICT")
+		}
+	}
+
+	d.metrics.size.Set(float64(d.size))
+}
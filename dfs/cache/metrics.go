@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type metrics struct {
+	hits       prometheus.Counter
+	misses     prometheus.Counter
+	evictions  prometheus.Counter
+	fillErrors prometheus.Counter
+	size       prometheus.Gauge
+}
+
+// newMetrics registers the metrics of the cache with the default registry, which is what the /metrics endpoint of the
+// registry serves
+func newMetrics() *metrics {
+	counter := func(name, help string) prometheus.Counter {
+		return register(prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "OpenRegistry",
+			Subsystem: "dfs_cache",
+			Name:      name,
+			Help:      help,
+		}))
+	}
+
+	return &metrics{
+		hits:       counter("hits_total", "Blob downloads served from the disk cache"),
+		misses:     counter("misses_total", "Blob downloads that weren't in the disk cache"),
+		evictions:  counter("evictions_total", "Blobs evicted from the disk cache"),
+		fillErrors: counter("fill_errors_total", "Blobs that failed to be added to the disk cache"),
+		size: register(prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "OpenRegistry",
+			Subsystem: "dfs_cache",
+			Name:      "size_bytes",
+			Help:      "Size of the blobs in the disk cache",
+		})),
+	}
+}
+
+// register returns the collector that's already registered if there is one, so that New can be called more than once
+func register[T prometheus.Collector](collector T) T {
+	err := prometheus.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing
+		}
+	}
+
+	return collector
+}
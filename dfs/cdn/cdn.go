@@ -0,0 +1,72 @@
+// Package cdn hands out signed CDN URLs for the blobs of a storage backend, instead of presigned URLs of its bucket.
+// Everything else goes to the backend
+package cdn
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+)
+
+const (
+	SigningHMAC       = "hmac"
+	SigningCloudFront = "cloudfront"
+)
+
+// signer returns the signed form of the URL, valid until expires
+type signer interface {
+	sign(blobURL *url.URL, expires time.Time) (string, error)
+}
+
+type cdn struct {
+	dfs.DFS
+	endpoint *url.URL
+	signer   signer
+	expiry   time.Duration
+}
+
+var _ dfs.Wrapper = (*cdn)(nil)
+
+// New returns the backend with its blob URLs pointing at the CDN
+func New(backend dfs.DFS, cfg *config.DFSCDN) (dfs.DFS, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("ERR_CDN_INVALID_ENDPOINT: %q", cfg.Endpoint)
+	}
+
+	var s signer
+	switch cfg.Signing {
+	case SigningHMAC:
+		s, err = newHMACSigner(cfg.Key)
+	case SigningCloudFront:
+		s, err = newCloudFrontSigner(cfg.KeyFile, cfg.KeyPairID)
+	default:
+		err = fmt.Errorf("ERR_CDN_UNKNOWN_SIGNING: %q, expected %s or %s", cfg.Signing, SigningHMAC, SigningCloudFront)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &cdn{DFS: backend, endpoint: endpoint, signer: s, expiry: config.URLExpiryOrDefault(cfg.URLExpiry)}, nil
+}
+
+// Unwrap implements dfs.Wrapper
+func (c *cdn) Unwrap() []dfs.DFS {
+	return []dfs.DFS{c.DFS}
+}
+
+// GeneratePresignedURL returns the signed CDN URL of the blob, the backend isn't asked for a URL at all
+func (c *cdn) GeneratePresignedURL(ctx context.Context, key string) (string, error) {
+	blobURL := c.endpoint.JoinPath(key)
+	signed, err := c.signer.sign(blobURL, time.Now().Add(c.expiry))
+	if err != nil {
+		return "", fmt.Errorf("ERR_CDN_SIGN_URL: %w", err)
+	}
+
+	return signed, nil
+}
@@ -1,46 +1,293 @@
 package client
 
 import (
+	"context"
 	"log"
 
 	"github.com/containerish/OpenRegistry/config"
 	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/dfs/azure"
+	"github.com/containerish/OpenRegistry/dfs/cdn"
+	"github.com/containerish/OpenRegistry/dfs/encryption"
 	"github.com/containerish/OpenRegistry/dfs/filebase"
+	"github.com/containerish/OpenRegistry/dfs/gcs"
 	"github.com/containerish/OpenRegistry/dfs/ipfs/p2p"
+	"github.com/containerish/OpenRegistry/dfs/local"
 	"github.com/containerish/OpenRegistry/dfs/mock"
+	"github.com/containerish/OpenRegistry/dfs/replication"
+	"github.com/containerish/OpenRegistry/dfs/resilience"
+	"github.com/containerish/OpenRegistry/dfs/routing"
+	"github.com/containerish/OpenRegistry/dfs/s3"
 	"github.com/containerish/OpenRegistry/dfs/storj"
 	"github.com/containerish/OpenRegistry/dfs/storj/uplink"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+	replication_store "github.com/containerish/OpenRegistry/store/v1/replication"
 	"github.com/containerish/OpenRegistry/telemetry"
 	"github.com/fatih/color"
 )
 
-// New returns the first enabled DFS for OpenRegistry.
-// It tries for all the possible backends and returns the first one that's enabled.
-func New(env config.Environment, registryEndpoint string, cfg *config.DFS, logger telemetry.Logger) dfs.DFS {
-	if cfg.Filebase.Enabled {
-		color.Green("Storage backend: Filebase")
-		return filebase.New(env, &cfg.Filebase)
+// New returns the storage backend for OpenRegistry.
+// With replication enabled, it's a composite of the primary and the secondary backends. Otherwise it tries for all the
+// possible backends and returns the first one that's enabled. Named backends in dfs.backends are added next to it, and
+// repositories are routed to them by the dfs.routing rules. With encryption enabled, all of them are encrypted.
+func New(
+	ctx context.Context,
+	env config.Environment,
+	registryEndpoint string,
+	cfg *config.DFS,
+	logger telemetry.Logger,
+	replicationStore replication_store.ReplicationStore,
+	keyStore encryption_store.KeyStore,
+) dfs.DFS {
+	storage := newRouted(ctx, env, registryEndpoint, cfg, logger, replicationStore)
+	if !cfg.Encryption.Enabled {
+		return storage
 	}
 
-	if cfg.Storj.Enabled && cfg.Storj.Type == "s3" {
-		color.Green("Storage backend: Storj with S3 Gateway")
-		return storj.New(env, cfg.Storj.S3Config())
+	keys, err := encryption.LoadMasterKeys(&cfg.Encryption)
+	if err != nil {
+		log.Fatalln(color.RedString("error loading the storage encryption keys: %s", err))
 	}
 
-	if cfg.Storj.Enabled && cfg.Storj.Type == "uplink" {
-		color.Green("Storage backend: Storj with Uplink")
-		return uplink.New(env, &cfg.Storj)
+	color.Green("Storage encryption: enabled (master key: %s)", keys.CurrentID())
+	return encryption.New(storage, keys, keyStore)
+}
+
+// newRouted returns the default backend with the named backends next to it, or just the default backend when there
+// are no named backends
+func newRouted(
+	ctx context.Context,
+	env config.Environment,
+	registryEndpoint string,
+	cfg *config.DFS,
+	logger telemetry.Logger,
+	replicationStore replication_store.ReplicationStore,
+) dfs.DFS {
+	defaultBackend := newDefault(ctx, env, registryEndpoint, dfs.DefaultBackend, cfg, logger, replicationStore)
+	if len(cfg.Backends) == 0 {
+		return defaultBackend
 	}
 
-	if cfg.Ipfs.Enabled {
-		color.Green("Storage backend: IPFS in P2P mode")
-		return p2p.New(&cfg.Ipfs)
+	backends := make(map[string]dfs.DFS, len(cfg.Backends))
+	for name, backendConfig := range cfg.Backends {
+		if name == dfs.DefaultBackend {
+			log.Fatalln(color.RedString("%q is reserved for the storage backend in the dfs section", name))
+		}
+
+		// the replication queue is keyed by the names of the backends in a dfs section, which aren't unique across
+		// named backends. Encryption is configured once, for all the backends
+		if backendConfig.Replication.Enabled || len(backendConfig.Backends) != 0 || backendConfig.Encryption.Enabled {
+			log.Fatalln(color.RedString("storage backend %q can't use replication, encryption or named backends", name))
+		}
+
+		color.Green("Named storage backend: %s", name)
+		backends[name] = newDefault(ctx, env, registryEndpoint, name, &backendConfig, logger, replicationStore)
 	}
 
-	if cfg.Mock.Enabled {
-		return mock.NewMockStorage(env, registryEndpoint, &cfg.Mock, logger)
+	for _, route := range cfg.Routing {
+		if _, ok := backends[route.Backend]; !ok {
+			log.Fatalln(color.RedString("storage routing rule %q uses an unknown backend: %s", route.Prefix, route.Backend))
+		}
+		color.Green("Storage routing: %s* -> %s", route.Prefix, route.Backend)
+	}
+
+	storage := routing.New(defaultBackend, backends, cfg.Routing)
+	if blobServers := countBlobServers(storage); blobServers > 1 {
+		log.Fatalln(color.RedString("only one storage backend can serve blobs from the registry, found %d", blobServers))
+	}
+
+	return storage
+}
+
+// newDefault returns the backend of a dfs section without the named backends. With a CDN configured for the section,
+// blob URLs point at the CDN. section is the name of the section, "default" or a name in dfs.backends
+func newDefault(
+	ctx context.Context,
+	env config.Environment,
+	registryEndpoint string,
+	section string,
+	cfg *config.DFS,
+	logger telemetry.Logger,
+	replicationStore replication_store.ReplicationStore,
+) dfs.DFS {
+	storage := newBackends(ctx, env, registryEndpoint, section, cfg, logger, replicationStore)
+	if !cfg.CDN.Enabled {
+		return storage
+	}
+
+	cdnStorage, err := cdn.New(storage, &cfg.CDN)
+	if err != nil {
+		log.Fatalln(color.RedString("error creating the storage CDN: %s", err))
+	}
+
+	color.Green("Storage CDN: %s (signing: %s)", cfg.CDN.Endpoint, cfg.CDN.Signing)
+	return cdnStorage
+}
+
+// newBackends returns the enabled backend of a dfs section, or the replicated backends
+func newBackends(
+	ctx context.Context,
+	env config.Environment,
+	registryEndpoint string,
+	section string,
+	cfg *config.DFS,
+	logger telemetry.Logger,
+	replicationStore replication_store.ReplicationStore,
+) dfs.DFS {
+	if cfg.Replication.Enabled {
+		return newReplicated(ctx, env, registryEndpoint, section, cfg, logger, replicationStore)
+	}
+
+	// the order in which the backends are picked when more than one is enabled
+	names := []string{"filebase", "storj", "s3", "gcs", "azure", "local", "ipfs", "mock"}
+	for _, name := range names {
+		if isEnabled(cfg, name) {
+			return withResilience(newBackend(name, env, registryEndpoint, cfg, logger), section, name, cfg, logger)
+		}
 	}
 
 	log.Fatalln(color.RedString("no supported storage backend is enabled"))
 	return nil
 }
+
+func newReplicated(
+	ctx context.Context,
+	env config.Environment,
+	registryEndpoint string,
+	section string,
+	cfg *config.DFS,
+	logger telemetry.Logger,
+	replicationStore replication_store.ReplicationStore,
+) dfs.DFS {
+	backend := func(name string) replication.Backend {
+		if !isEnabled(cfg, name) {
+			log.Fatalln(color.RedString("storage backend %q is used for replication but it's not enabled", name))
+		}
+
+		storage := withResilience(newBackend(name, env, registryEndpoint, cfg, logger), section, name, cfg, logger)
+		return replication.Backend{Name: name, DFS: storage}
+	}
+
+	color.Green("Storage replication: primary=%s, secondaries=%v", cfg.Replication.Primary, cfg.Replication.Secondaries)
+	primary := backend(cfg.Replication.Primary)
+	secondaries := make([]replication.Backend, 0, len(cfg.Replication.Secondaries))
+	for _, name := range cfg.Replication.Secondaries {
+		if name == cfg.Replication.Primary {
+			log.Fatalln(color.RedString("storage backend %q can't be both the primary and a secondary", name))
+		}
+		secondaries = append(secondaries, backend(name))
+	}
+
+	return replication.New(ctx, primary, secondaries, replicationStore, &cfg.Replication, logger)
+}
+
+// withResilience adds timeouts, retries and a circuit breaker to the remote backends when they're enabled for the
+// section. The local disk, the IPFS node and the mock don't need them
+func withResilience(backend dfs.DFS, section, name string, cfg *config.DFS, logger telemetry.Logger) dfs.DFS {
+	if !cfg.Resilience.Enabled {
+		return backend
+	}
+
+	switch name {
+	case "filebase", "storj", "s3", "gcs", "azure":
+		color.Green("Storage resilience: %s/%s (timeout: %s, retries: %d)",
+			section, name, cfg.Resilience.Timeout, cfg.Resilience.Retries())
+		return resilience.New(backend, section+"/"+name, &cfg.Resilience, logger)
+	default:
+		return backend
+	}
+}
+
+// countBlobServers counts the backends that serve blobs under dfs.BlobServerPath, there can only be one of them
+func countBlobServers(storage dfs.DFS) int {
+	if _, ok := storage.(dfs.BlobServer); ok {
+		return 1
+	}
+
+	count := 0
+	if wrapper, ok := storage.(dfs.Wrapper); ok {
+		for _, wrapped := range wrapper.Unwrap() {
+			count += countBlobServers(wrapped)
+		}
+	}
+
+	return count
+}
+
+func isEnabled(cfg *config.DFS, name string) bool {
+	switch name {
+	case "filebase":
+		return cfg.Filebase.Enabled
+	case "storj":
+		return cfg.Storj.Enabled && (cfg.Storj.Type == "s3" || cfg.Storj.Type == "uplink")
+	case "s3":
+		return cfg.S3.Enabled
+	case "gcs":
+		return cfg.GCS.Enabled
+	case "azure":
+		return cfg.Azure.Enabled
+	case "local":
+		return cfg.Local.Enabled
+	case "ipfs":
+		return cfg.Ipfs.Enabled
+	case "mock":
+		return cfg.Mock.Enabled
+	default:
+		return false
+	}
+}
+
+// newBackend returns the backend for a name, the names are the keys of the dfs section in the config
+func newBackend(
+	name string,
+	env config.Environment,
+	registryEndpoint string,
+	cfg *config.DFS,
+	logger telemetry.Logger,
+) dfs.DFS {
+	switch name {
+	case "filebase":
+		color.Green("Storage backend: Filebase")
+		return filebase.New(env, &cfg.Filebase)
+	case "storj":
+		if cfg.Storj.Type == "uplink" {
+			color.Green("Storage backend: Storj with Uplink")
+			return uplink.New(env, &cfg.Storj)
+		}
+
+		color.Green("Storage backend: Storj with S3 Gateway")
+		return storj.New(env, cfg.Storj.S3Config())
+	case "s3":
+		color.Green("Storage backend: S3 (bucket: %s, region: %s)", cfg.S3.BucketName, cfg.S3.Region)
+		s3Client, err := s3.New(&cfg.S3)
+		if err != nil {
+			log.Fatalln(color.RedString("error creating S3 storage backend: %s", err))
+		}
+		return s3Client
+	case "gcs":
+		color.Green("Storage backend: Google Cloud Storage (bucket: %s)", cfg.GCS.BucketName)
+		gcsClient, err := gcs.New(&cfg.GCS)
+		if err != nil {
+			log.Fatalln(color.RedString("error creating GCS storage backend: %s", err))
+		}
+		return gcsClient
+	case "azure":
+		color.Green("Storage backend: Azure Blob Storage (container: %s)", cfg.Azure.ContainerName)
+		azureClient, err := azure.New(&cfg.Azure)
+		if err != nil {
+			log.Fatalln(color.RedString("error creating Azure storage backend: %s", err))
+		}
+		return azureClient
+	case "local":
+		color.Green("Storage backend: Local filesystem at %s", cfg.Local.RootDir)
+		return local.New(registryEndpoint, &cfg.Local, logger)
+	case "ipfs":
+		color.Green("Storage backend: IPFS in P2P mode")
+		return p2p.New(&cfg.Ipfs)
+	case "mock":
+		return mock.NewMockStorage(env, registryEndpoint, &cfg.Mock, logger)
+	default:
+		log.Fatalln(color.RedString("unknown storage backend: %s", name))
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+package dfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	oci_digest "github.com/opencontainers/go-digest"
+)
+
+// DefaultChunkSize is the part size of copies to backends that don't configure a chunk size
+const DefaultChunkSize = 20 * 1024 * 1024
+
+// ChunkSize returns the part size for multipart uploads to the backend
+func ChunkSize(backend DFS) int {
+	if cfg := backend.Config(); cfg != nil && cfg.ChunkSize > 0 {
+		return cfg.ChunkSize
+	}
+
+	return DefaultChunkSize
+}
+
+// CopyBlob uploads the blob read from source to the target backend and returns where the target stored it. size is
+// the size of the blob on the source, blobs larger than chunkSize are uploaded in parts so that they are never held in
+// memory whole. The upload is only completed if the content matches the digest
+func CopyBlob(
+	ctx context.Context,
+	target DFS,
+	key string,
+	digest string,
+	source io.Reader,
+	size int,
+	chunkSize int,
+) (string, error) {
+	parsed, err := oci_digest.Parse(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_DFS_COPY_DIGEST_PARSE: %w", err)
+	}
+
+	verifier := parsed.Verifier()
+	reader := io.TeeReader(source, verifier)
+
+	if size <= chunkSize {
+		content, readErr := io.ReadAll(reader)
+		if readErr != nil {
+			return "", fmt.Errorf("ERR_DFS_COPY_SOURCE_READ: %w", readErr)
+		}
+
+		if !verifier.Verified() {
+			return "", fmt.Errorf("ERR_DFS_COPY_DIGEST_MISMATCH: %s", digest)
+		}
+
+		link, uploadErr := target.Upload(ctx, key, digest, content)
+		if uploadErr != nil {
+			return "", fmt.Errorf("ERR_DFS_COPY_UPLOAD: %w", uploadErr)
+		}
+
+		return link, nil
+	}
+
+	uploadID, err := target.CreateMultipartUpload(key)
+	if err != nil {
+		return "", fmt.Errorf("ERR_DFS_COPY_CREATE_MULTIPART_UPLOAD: %w", err)
+	}
+
+	link, err := copyParts(ctx, target, key, digest, uploadID, reader, verifier, chunkSize)
+	if err != nil {
+		if abortErr := target.AbortMultipartUpload(ctx, key, uploadID); abortErr != nil {
+			err = errors.Join(err, abortErr)
+		}
+		return "", err
+	}
+
+	return link, nil
+}
+
+// copyParts uploads the source in parts and completes the upload once the whole source is verified against the digest
+func copyParts(
+	ctx context.Context,
+	target DFS,
+	key string,
+	digest string,
+	uploadID string,
+	source io.Reader,
+	verifier oci_digest.Verifier,
+	chunkSize int,
+) (string, error) {
+	var parts []s3types.CompletedPart
+	buf := make([]byte, chunkSize)
+	for partNumber := int32(1); ; partNumber++ {
+		n, err := io.ReadFull(source, buf)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", fmt.Errorf("ERR_DFS_COPY_SOURCE_READ: %w", err)
+		}
+
+		// like the registry does for chunked uploads, every part is sent with the digest of its own content
+		partDigest := oci_digest.FromBytes(buf[:n]).String()
+		part, uploadErr := target.UploadPart(ctx, uploadID, key, partDigest, partNumber, bytes.NewReader(buf[:n]), int64(n))
+		if uploadErr != nil {
+			return "", fmt.Errorf("ERR_DFS_COPY_UPLOAD_PART: %w", uploadErr)
+		}
+		parts = append(parts, part)
+
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+	}
+
+	if !verifier.Verified() {
+		return "", fmt.Errorf("ERR_DFS_COPY_DIGEST_MISMATCH: %s", digest)
+	}
+
+	link, err := target.CompleteMultipartUpload(ctx, uploadID, key, digest, parts)
+	if err != nil {
+		return "", fmt.Errorf("ERR_DFS_COPY_COMPLETE_MULTIPART_UPLOAD: %w", err)
+	}
+
+	return link, nil
+}
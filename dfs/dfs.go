@@ -5,6 +5,7 @@ import (
 	"io"
 
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/labstack/echo/v4"
 
 	"github.com/containerish/OpenRegistry/config"
 	"github.com/containerish/OpenRegistry/store/v1/types"
@@ -43,3 +44,131 @@ type DFS interface {
 	GeneratePresignedURL(ctx context.Context, key string) (string, error)
 	Config() *config.S3CompatibleDFS
 }
+
+// BlobServerPath is the prefix of the URLs the registry serves blobs on, for storage backends that implement BlobServer
+const BlobServerPath = "/dfs"
+
+// BlobServer is implemented by storage backends that can't hand out URLs of their own (e.g, local disk). Their
+// GeneratePresignedURL returns signed URLs under BlobServerPath, which the registry routes to ServeBlob
+type BlobServer interface {
+	ServeBlob(ctx echo.Context) error
+}
+
+// ObjectLister is implemented by storage backends that can enumerate the blobs they store. fn is called with the DFS
+// link and the size of every blob, listing stops at the first error fn returns
+type ObjectLister interface {
+	ListObjects(ctx context.Context, fn func(object *types.ObjectMetadata) error) error
+}
+
+// Proxy is implemented by storage backends whose blobs can't be handed out as URLs (e.g, encrypted blobs). The registry
+// streams their blobs to clients instead of redirecting them to GeneratePresignedURL
+type Proxy interface {
+	ProxyDownloads() bool
+}
+
+// ProxyDownloads reports whether the registry has to stream the blobs of storage itself
+func ProxyDownloads(storage DFS) bool {
+	proxy, ok := As[Proxy](storage)
+	return ok && proxy.ProxyDownloads()
+}
+
+// Encrypter is implemented by storage backends that encrypt blobs before they're written. The DFS links of their blobs
+// point to the encrypted blobs, which are of no use outside the registry
+type Encrypter interface {
+	EncryptsBlobs() bool
+}
+
+// EncryptsBlobs reports whether storage encrypts the blobs it stores
+func EncryptsBlobs(storage DFS) bool {
+	encrypter, ok := As[Encrypter](storage)
+	return ok && encrypter.EncryptsBlobs()
+}
+
+// LayerDownloader is implemented by storage backends that need the layer to download its blob, e.g, to verify the
+// digest of the layer
+type LayerDownloader interface {
+	DownloadLayer(ctx context.Context, layer *types.ContainerImageLayer) (io.ReadCloser, error)
+}
+
+// DownloadLayer downloads the blob of the layer. Unlike the other capabilities, only storage itself is checked for a
+// LayerDownloader, a wrapped one would skip the decorators around it
+func DownloadLayer(ctx context.Context, storage DFS, layer *types.ContainerImageLayer) (io.ReadCloser, error) {
+	if downloader, ok := storage.(LayerDownloader); ok {
+		return downloader.DownloadLayer(ctx, layer)
+	}
+
+	return storage.Download(ctx, layer.DFSLink)
+}
+
+// ImagePublisher is implemented by storage backends that publish every pushed image as a whole, i.e, IPFS publishes an
+// image as a single DAG. Published images are OCI image layouts
+type ImagePublisher interface {
+	// PublishImage publishes the manifest along with the blobs it references and returns the link of the image. blobs
+	// maps the digests of the config and the layers to their DFS links
+	PublishImage(
+		ctx context.Context,
+		namespace string,
+		reference string,
+		manifest []byte,
+		blobs map[string]string,
+	) (string, error)
+	// DownloadDir writes the image published under the link to dir
+	DownloadDir(link, dir string) error
+}
+
+// DefaultBackend is the name of the backend configured directly in the dfs section, next to the named dfs.backends
+const DefaultBackend = "default"
+
+// Wrapper is implemented by storage backends that decorate or combine other backends
+type Wrapper interface {
+	Unwrap() []DFS
+}
+
+// As returns the first backend in storage or the backends it wraps that implements T. It's used to find optional
+// capabilities (e.g, BlobServer) that a decorator doesn't implement itself
+func As[T any](storage DFS) (T, bool) {
+	if found, ok := storage.(T); ok {
+		return found, true
+	}
+
+	if wrapper, ok := storage.(Wrapper); ok {
+		for _, wrapped := range wrapper.Unwrap() {
+			if found, ok := As[T](wrapped); ok {
+				return found, true
+			}
+		}
+	}
+
+	var zero T
+	return zero, false
+}
+
+// Selector is implemented by storage backends made of several named backends, repositories are routed to one of them
+// by their namespace
+type Selector interface {
+	// ForNamespace returns the name of the backend for the namespace and the backend itself
+	ForNamespace(namespace string) (string, DFS)
+	// Backend returns the backend with the name, or false if there's no backend by that name
+	Backend(name string) (DFS, bool)
+}
+
+// ForNamespace returns the backend that new blobs of the namespace are stored in, along with its name
+func ForNamespace(storage DFS, namespace string) (string, DFS) {
+	if selector, ok := As[Selector](storage); ok {
+		return selector.ForNamespace(namespace)
+	}
+
+	return DefaultBackend, storage
+}
+
+// ForLayer returns the backend the layer is stored in. Layers pushed before backends were named don't have one, they
+// are on the default backend
+func ForLayer(storage DFS, layer *types.ContainerImageLayer) DFS {
+	if selector, ok := As[Selector](storage); ok {
+		if backend, found := selector.Backend(layer.Backend); found {
+			return backend
+		}
+	}
+
+	return storage
+}
@@ -0,0 +1,385 @@
+// Package encryption is a storage backend that encrypts blobs before they are written to another backend. Every blob
+// has a data key of its own (AES-256-GCM), the data keys are wrapped by a master key and stored in the database, so
+// the backend never sees either the plaintext or the keys
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	oci_digest "github.com/opencontainers/go-digest"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+type encrypted struct {
+	backend dfs.DFS
+	keys    *MasterKeys
+	store   encryption_store.KeyStore
+	uploads *uploads
+}
+
+// upload is the state of a multipart upload. Parts have to be uploaded in order, the registry does that anyway, so
+// that the digests of the plaintext and of the encrypted blob can be computed as the parts come in
+type upload struct {
+	aead       cipher.AEAD
+	plaintext  oci_digest.Digester
+	sealed     oci_digest.Digester
+	keyID      string
+	wrappedKey []byte
+	size       int64
+	nextPart   int32
+	mu         sync.Mutex
+}
+
+type uploads struct {
+	m  map[string]*upload
+	mu sync.Mutex
+}
+
+var _ dfs.Wrapper = (*encrypted)(nil)
+var _ dfs.Selector = (*encrypted)(nil)
+var _ dfs.Proxy = (*encrypted)(nil)
+var _ dfs.Encrypter = (*encrypted)(nil)
+
+func New(backend dfs.DFS, keys *MasterKeys, store encryption_store.KeyStore) dfs.DFS {
+	return &encrypted{
+		backend: backend,
+		keys:    keys,
+		store:   store,
+		uploads: &uploads{m: make(map[string]*upload)},
+	}
+}
+
+// with returns the decorator for another backend, the state of the uploads is shared
+func (e *encrypted) with(backend dfs.DFS) dfs.DFS {
+	if backend == e.backend {
+		return e
+	}
+
+	return &encrypted{backend: backend, keys: e.keys, store: e.store, uploads: e.uploads}
+}
+
+// Unwrap implements dfs.Wrapper
+func (e *encrypted) Unwrap() []dfs.DFS {
+	return []dfs.DFS{e.backend}
+}
+
+// ForNamespace implements dfs.Selector, the backends of the wrapped backend are encrypted too
+func (e *encrypted) ForNamespace(namespace string) (string, dfs.DFS) {
+	name, backend := dfs.ForNamespace(e.backend, namespace)
+	return name, e.with(backend)
+}
+
+// Backend implements dfs.Selector
+func (e *encrypted) Backend(name string) (dfs.DFS, bool) {
+	selector, ok := dfs.As[dfs.Selector](e.backend)
+	if !ok {
+		return e, name == "" || name == dfs.DefaultBackend
+	}
+
+	backend, found := selector.Backend(name)
+	if !found {
+		return nil, false
+	}
+
+	return e.with(backend), true
+}
+
+// ProxyDownloads implements dfs.Proxy, the backend only has the encrypted blobs so there's nothing to redirect to
+func (e *encrypted) ProxyDownloads() bool {
+	return true
+}
+
+// EncryptsBlobs implements dfs.Encrypter
+func (e *encrypted) EncryptsBlobs() bool {
+	return true
+}
+
+func (e *encrypted) newDataKey() (cipher.AEAD, string, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, "", nil, fmt.Errorf("ERR_ENCRYPTION_DATA_KEY: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	keyID, wrappedKey, err := e.keys.Wrap(dataKey)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return aead, keyID, wrappedKey, nil
+}
+
+// blobKey returns the data key of the blob, or nil if the blob was stored before encryption was enabled
+func (e *encrypted) blobKey(ctx context.Context, link string) (*types.BlobKey, cipher.AEAD, error) {
+	blobKey, err := e.store.GetBlobKey(ctx, link)
+	if err != nil || blobKey == nil {
+		return nil, nil, err
+	}
+
+	dataKey, err := e.keys.Unwrap(blobKey.KeyID, blobKey.WrappedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", link, err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return blobKey, aead, nil
+}
+
+func (e *encrypted) Upload(ctx context.Context, namespace, digest string, content []byte) (string, error) {
+	aead, keyID, wrappedKey, err := e.newDataKey()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := sealPart(aead, 1, content)
+	if err != nil {
+		return "", err
+	}
+
+	// backends verify what they store against the digest, which is the digest of the encrypted blob for them
+	link, err := e.backend.Upload(ctx, namespace, oci_digest.FromBytes(sealed).String(), sealed)
+	if err != nil {
+		return "", err
+	}
+
+	err = e.store.SetBlobKey(ctx, &types.BlobKey{
+		Link:       link,
+		KeyID:      keyID,
+		WrappedKey: wrappedKey,
+		Size:       int64(len(content)),
+		Parts:      1,
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERR_ENCRYPTION_SET_BLOB_KEY: %w", err)
+	}
+
+	return link, nil
+}
+
+func (e *encrypted) CreateMultipartUpload(namespace string) (string, error) {
+	aead, keyID, wrappedKey, err := e.newDataKey()
+	if err != nil {
+		return "", err
+	}
+
+	uploadID, err := e.backend.CreateMultipartUpload(namespace)
+	if err != nil {
+		return "", err
+	}
+
+	e.uploads.mu.Lock()
+	e.uploads.m[uploadID] = &upload{
+		aead:       aead,
+		keyID:      keyID,
+		wrappedKey: wrappedKey,
+		plaintext:  oci_digest.Canonical.Digester(),
+		sealed:     oci_digest.Canonical.Digester(),
+		nextPart:   1,
+	}
+	e.uploads.mu.Unlock()
+
+	return uploadID, nil
+}
+
+func (e *encrypted) getUpload(uploadID string) (*upload, bool) {
+	e.uploads.mu.Lock()
+	defer e.uploads.mu.Unlock()
+
+	u, ok := e.uploads.m[uploadID]
+	return u, ok
+}
+
+func (e *encrypted) deleteUpload(uploadID string) {
+	e.uploads.mu.Lock()
+	delete(e.uploads.m, uploadID)
+	e.uploads.mu.Unlock()
+}
+
+func (e *encrypted) UploadPart(
+	ctx context.Context,
+	uploadId string,
+	key string,
+	digest string,
+	partNumber int32,
+	content io.ReadSeeker,
+	contentLength int64,
+) (s3types.CompletedPart, error) {
+	u, ok := e.getUpload(uploadId)
+	if !ok {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_ENCRYPTION_UNKNOWN_UPLOAD: %s", uploadId)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if partNumber != u.nextPart {
+		return s3types.CompletedPart{}, fmt.Errorf(
+			"ERR_ENCRYPTION_PART_OUT_OF_ORDER: expected part %d, got %d", u.nextPart, partNumber,
+		)
+	}
+
+	plaintext, err := io.ReadAll(io.LimitReader(content, contentLength))
+	if err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_ENCRYPTION_READ_PART: %w", err)
+	}
+
+	sealed, err := sealPart(u.aead, partNumber, plaintext)
+	if err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
+	part, err := e.backend.UploadPart(
+		ctx,
+		uploadId,
+		key,
+		oci_digest.FromBytes(sealed).String(),
+		partNumber,
+		bytes.NewReader(sealed),
+		int64(len(sealed)),
+	)
+	if err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
+	_, _ = u.plaintext.Hash().Write(plaintext)
+	_, _ = u.sealed.Hash().Write(sealed)
+	u.size += int64(len(plaintext))
+	u.nextPart++
+	return part, nil
+}
+
+func (e *encrypted) CompleteMultipartUpload(
+	ctx context.Context,
+	uploadId string,
+	key string,
+	finalDigest string,
+	completedParts []s3types.CompletedPart,
+) (string, error) {
+	u, ok := e.getUpload(uploadId)
+	if !ok {
+		return "", fmt.Errorf("ERR_ENCRYPTION_UNKNOWN_UPLOAD: %s", uploadId)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// the backend can only verify the encrypted blob, so the plaintext is verified here
+	if u.plaintext.Digest().String() != finalDigest {
+		return "", fmt.Errorf("ERR_DIGEST_MISMATCH: expected %s, got %s", finalDigest, u.plaintext.Digest())
+	}
+
+	link, err := e.backend.CompleteMultipartUpload(ctx, uploadId, key, u.sealed.Digest().String(), completedParts)
+	if err != nil {
+		return "", err
+	}
+	e.deleteUpload(uploadId)
+
+	err = e.store.SetBlobKey(ctx, &types.BlobKey{
+		Link:       link,
+		KeyID:      u.keyID,
+		WrappedKey: u.wrappedKey,
+		Size:       u.size,
+		Parts:      u.nextPart - 1,
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERR_ENCRYPTION_SET_BLOB_KEY: %w", err)
+	}
+
+	return link, nil
+}
+
+func (e *encrypted) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	e.deleteUpload(uploadId)
+	return e.backend.AbortMultipartUpload(ctx, layerKey, uploadId)
+}
+
+// GetUploadProgress returns the size of the plaintext uploaded so far
+func (e *encrypted) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
+	metadata, err := e.backend.GetUploadProgress(identifier, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if u, ok := e.getUpload(uploadID); ok {
+		u.mu.Lock()
+		metadata.ContentLength = int(u.size)
+		u.mu.Unlock()
+	}
+
+	return metadata, nil
+}
+
+func (e *encrypted) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	blobKey, aead, err := e.blobKey(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := e.backend.Download(ctx, path)
+	if err != nil || blobKey == nil {
+		return blob, err
+	}
+
+	return newOpenReader(blob, aead, blobKey.Parts), nil
+}
+
+// Metadata returns the size of the plaintext, since that's what clients get
+func (e *encrypted) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	metadata, err := e.backend.Metadata(layer)
+	if err != nil {
+		return nil, err
+	}
+
+	blobKey, err := e.store.GetBlobKey(context.Background(), layer.DFSLink)
+	if err != nil {
+		return nil, err
+	}
+
+	if blobKey != nil {
+		metadata.ContentLength = int(blobKey.Size)
+	}
+
+	return metadata, nil
+}
+
+// GeneratePresignedURL always fails, a URL for the backend would only serve the encrypted blob. The registry proxies
+// the downloads instead, see ProxyDownloads
+func (e *encrypted) GeneratePresignedURL(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("ERR_ENCRYPTION_PRESIGNED_URL: encrypted blobs can't be downloaded from the backend")
+}
+
+func (e *encrypted) DownloadDir(dfsLink, dir string) error {
+	return e.backend.DownloadDir(dfsLink, dir)
+}
+
+func (e *encrypted) List(path string) ([]*types.Metadata, error) {
+	return e.backend.List(path)
+}
+
+// AddImage always fails, publishing an image would hand it out without encryption
+func (e *encrypted) AddImage(ns string, mf, l map[string][]byte) (string, error) {
+	return "", fmt.Errorf("ERR_ENCRYPTION_ADD_IMAGE: images can't be published from encrypted storage")
+}
+
+func (e *encrypted) Config() *config.S3CompatibleDFS {
+	return e.backend.Config()
+}
@@ -0,0 +1,190 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/containerish/OpenRegistry/config"
+	encryption_store "github.com/containerish/OpenRegistry/store/v1/encryption"
+)
+
+const (
+	// dataKeySize is the size of the per blob data keys and of the master keys, both are AES-256 keys
+	dataKeySize = 32
+	// rewrapBatchSize is the number of keys re-wrapped per query
+	rewrapBatchSize = 100
+)
+
+// MasterKeys wraps & unwraps the data keys of blobs. Data keys are always wrapped by the current master key, the
+// previous master keys are kept to unwrap the keys that weren't re-wrapped yet
+type MasterKeys struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// LoadMasterKeys reads the current and the previous master keys from the config. Keys are identified by a hash of the
+// key, so they don't need to be named in the config
+func LoadMasterKeys(cfg *config.DFSEncryption) (*MasterKeys, error) {
+	current, err := readKey(cfg.Key, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_ENCRYPTION_MASTER_KEY: %w", err)
+	}
+
+	mk := &MasterKeys{keys: make(map[string]cipher.AEAD)}
+	if mk.current, err = mk.add(current); err != nil {
+		return nil, err
+	}
+
+	previous := make([][]byte, 0, len(cfg.PreviousKeys)+len(cfg.PreviousKeyFiles))
+	for _, value := range cfg.PreviousKeys {
+		key, readErr := readKey(value, "")
+		if readErr != nil {
+			return nil, fmt.Errorf("ERR_ENCRYPTION_PREVIOUS_KEY: %w", readErr)
+		}
+		previous = append(previous, key)
+	}
+
+	for _, path := range cfg.PreviousKeyFiles {
+		key, readErr := readKey("", path)
+		if readErr != nil {
+			return nil, fmt.Errorf("ERR_ENCRYPTION_PREVIOUS_KEY: %s: %w", path, readErr)
+		}
+		previous = append(previous, key)
+	}
+
+	for _, key := range previous {
+		if _, err = mk.add(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return mk, nil
+}
+
+func readKey(value, path string) ([]byte, error) {
+	if value != "" && path != "" {
+		return nil, fmt.Errorf("only one of key and key_file can be set")
+	}
+
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		value = string(content)
+	}
+
+	if value == "" {
+		return nil, fmt.Errorf("key is empty")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("key must be base64 encoded: %w", err)
+	}
+
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+
+	return key, nil
+}
+
+func (mk *MasterKeys) add(key []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(key)
+	id := hex.EncodeToString(sum[:8])
+	mk.keys[id] = aead
+	return id, nil
+}
+
+// CurrentID is the id of the master key that new data keys are wrapped with
+func (mk *MasterKeys) CurrentID() string {
+	return mk.current
+}
+
+// Wrap encrypts the data key with the current master key
+func (mk *MasterKeys) Wrap(dataKey []byte) (string, []byte, error) {
+	aead := mk.keys[mk.current]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, fmt.Errorf("ERR_ENCRYPTION_NONCE: %w", err)
+	}
+
+	// the key id is authenticated too, so that a wrapped key can't be passed off as wrapped by another master key
+	return mk.current, aead.Seal(nonce, nonce, dataKey, []byte(mk.current)), nil
+}
+
+// Unwrap decrypts a data key that was wrapped by the master key with keyID
+func (mk *MasterKeys) Unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := mk.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("ERR_ENCRYPTION_UNKNOWN_MASTER_KEY: %s", keyID)
+	}
+
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("ERR_ENCRYPTION_WRAPPED_KEY_TOO_SHORT")
+	}
+
+	nonce, sealed := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, sealed, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("ERR_ENCRYPTION_UNWRAP_KEY: %w", err)
+	}
+
+	return dataKey, nil
+}
+
+// Rewrap wraps the data keys that are still wrapped by a previous master key with the current one. Only the keys in
+// the database change, the blobs themselves aren't touched. It returns the number of keys that were re-wrapped
+func Rewrap(ctx context.Context, keyStore encryption_store.KeyStore, mk *MasterKeys) (int, error) {
+	count := 0
+	for {
+		blobKeys, err := keyStore.ListBlobKeysToRewrap(ctx, mk.CurrentID(), rewrapBatchSize)
+		if err != nil {
+			return count, err
+		}
+
+		if len(blobKeys) == 0 {
+			return count, nil
+		}
+
+		for _, blobKey := range blobKeys {
+			dataKey, err := mk.Unwrap(blobKey.KeyID, blobKey.WrappedKey)
+			if err != nil {
+				return count, fmt.Errorf("%s: %w", blobKey.Link, err)
+			}
+
+			keyID, wrapped, err := mk.Wrap(dataKey)
+			if err != nil {
+				return count, err
+			}
+
+			if err = keyStore.UpdateWrappedKey(ctx, blobKey.Link, keyID, wrapped); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_ENCRYPTION_NEW_CIPHER: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,154 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Blobs are encrypted in frames, so that they can be decrypted while they are streamed and so that the parts of a
+// multipart upload can be encrypted on their own. A frame is:
+//
+//	header (4 bytes) | nonce (12 bytes) | sealed segment (up to segmentSize + 16 bytes)
+//
+// The header has the length of the plaintext and a flag for the last frame of a part. The header, the part number and
+// the position of the frame in its part are authenticated, so frames can't be reordered, dropped or moved to another
+// part without failing the decryption
+const (
+	segmentSize = 64 * 1024
+	headerSize  = 4
+	lastFrame   = uint32(1) << 31
+)
+
+var ErrTruncated = errors.New("ERR_ENCRYPTION_BLOB_TRUNCATED")
+
+func frameAAD(partNumber int32, seq uint32, header []byte) []byte {
+	aad := make([]byte, 0, 8+headerSize)
+	aad = binary.BigEndian.AppendUint32(aad, uint32(partNumber))
+	aad = binary.BigEndian.AppendUint32(aad, seq)
+	return append(aad, header...)
+}
+
+// sealedSize is the size of the encrypted part for a plaintext of size n
+func sealedSize(aead cipher.AEAD, n int) int {
+	frames := max((n+segmentSize-1)/segmentSize, 1)
+	return n + frames*(headerSize+aead.NonceSize()+aead.Overhead())
+}
+
+// sealPart encrypts one part of a blob. Blobs uploaded in one go are a single part, with part number 1
+func sealPart(aead cipher.AEAD, partNumber int32, plaintext []byte) ([]byte, error) {
+	sealed := make([]byte, 0, sealedSize(aead, len(plaintext)))
+	for seq := uint32(0); ; seq++ {
+		segment := plaintext[:min(len(plaintext), segmentSize)]
+		plaintext = plaintext[len(segment):]
+
+		header := uint32(len(segment))
+		if len(plaintext) == 0 {
+			header |= lastFrame
+		}
+
+		start := len(sealed)
+		sealed = binary.BigEndian.AppendUint32(sealed, header)
+		nonce := sealed[len(sealed) : len(sealed)+aead.NonceSize()]
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("ERR_ENCRYPTION_NONCE: %w", err)
+		}
+		sealed = sealed[:len(sealed)+aead.NonceSize()]
+		sealed = aead.Seal(sealed, nonce, segment, frameAAD(partNumber, seq, sealed[start:start+headerSize]))
+
+		if len(plaintext) == 0 {
+			return sealed, nil
+		}
+	}
+}
+
+// openReader decrypts a blob while it's read. It fails with ErrTruncated if the blob ends before the last frame of
+// the last part
+type openReader struct {
+	source    io.ReadCloser
+	aead      cipher.AEAD
+	err       error
+	plaintext []byte
+	frame     []byte
+	parts     int32
+	part      int32
+	seq       uint32
+}
+
+func newOpenReader(source io.ReadCloser, aead cipher.AEAD, parts int32) io.ReadCloser {
+	return &openReader{
+		source: source,
+		aead:   aead,
+		parts:  parts,
+		part:   1,
+		frame:  make([]byte, headerSize+aead.NonceSize()+segmentSize+aead.Overhead()),
+	}
+}
+
+func (r *openReader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+	return n, nil
+}
+
+func (r *openReader) Close() error {
+	return r.source.Close()
+}
+
+func (r *openReader) next() error {
+	header := r.frame[:headerSize]
+	if _, err := io.ReadFull(r.source, header); err != nil {
+		if errors.Is(err, io.EOF) && r.part > r.parts {
+			return io.EOF
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	if r.part > r.parts {
+		return fmt.Errorf("ERR_ENCRYPTION_TRAILING_DATA")
+	}
+
+	flags := binary.BigEndian.Uint32(header)
+	length := int(flags &^ lastFrame)
+	if length > segmentSize {
+		return fmt.Errorf("ERR_ENCRYPTION_INVALID_FRAME: length %d", length)
+	}
+
+	frame := r.frame[headerSize : headerSize+r.aead.NonceSize()+length+r.aead.Overhead()]
+	if _, err := io.ReadFull(r.source, frame); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	nonce, sealed := frame[:r.aead.NonceSize()], frame[r.aead.NonceSize():]
+	plaintext, err := r.aead.Open(sealed[:0], nonce, sealed, frameAAD(r.part, r.seq, header))
+	if err != nil {
+		return fmt.Errorf("ERR_ENCRYPTION_DECRYPT: part %d, frame %d: %w", r.part, r.seq, err)
+	}
+
+	if flags&lastFrame != 0 {
+		r.part++
+		r.seq = 0
+	} else {
+		r.seq++
+	}
+
+	r.plaintext = plaintext
+	return nil
+}
@@ -23,6 +23,7 @@ type filebase struct {
 	client    *s3.Client
 	preSigner *s3.PresignClient
 	config    *config.S3CompatibleDFS
+	digests   *dfs.UploadDigests
 	bucket    string
 	env       config.Environment
 }
@@ -34,10 +35,11 @@ func New(env config.Environment, cfg *config.S3CompatibleDFS) dfs.DFS {
 		client: client,
 		bucket: cfg.BucketName,
 		preSigner: s3.NewPresignClient(client, func(po *s3.PresignOptions) {
-			po.Expires = time.Minute * 20
+			po.Expires = config.DefaultURLExpiry
 		}),
-		config: cfg,
-		env:    env,
+		config:  cfg,
+		digests: dfs.NewUploadDigests(),
+		env:     env,
 	}
 }
 
@@ -93,6 +95,10 @@ func (fb *filebase) UploadPart(
 		return s3types.CompletedPart{}, fmt.Errorf("ERR_FILEBASE_UPLOAD_PART: %w", err)
 	}
 
+	if err = fb.digests.AddPart(uploadId, partNumber, content); err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
 	return s3types.CompletedPart{
 		ChecksumSHA256: &digest,
 		ETag:           resp.ETag,
@@ -142,30 +148,73 @@ func (fb *filebase) CompleteMultipartUpload(
 		return "", fmt.Errorf("ERR_FILEBASE_COMPLETE_MULTIPART_UPLOAD: %w", err)
 	}
 
+	err = dfs.S3VerifyUpload(ctx, fb.client, fb.bucket, layerKey, uploadId, layerDigest, fb.digests)
+	if err != nil {
+		return "", err
+	}
+
+	blobKey, err := dfs.BlobKey(layerDigest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_FILEBASE_DIGEST_PARSE: %w", err)
+	}
+
+	err = dfs.S3CommitBlob(ctx, fb.client, fb.bucket, layerKey, blobKey, dfs.S3ObjectOptions{
+		ACL:          s3types.ObjectCannedACLPublicRead,
+		StorageClass: s3types.StorageClassStandard,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fb.cid(ctx, blobKey)
+}
+
+// cid returns the IPFS CID that Filebase computed for the object, it's the DFS link of the blob
+func (fb *filebase) cid(ctx context.Context, key string) (string, error) {
 	resp, err := fb.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket:       &fb.bucket,
-		Key:          &layerKey,
+		Key:          &key,
 		ChecksumMode: s3types.ChecksumModeEnabled,
 	})
 	if err != nil {
-		return "", fmt.Errorf("ERR_FILEBASE_COMPLETE_MULTIPART_UPLOAD_HEAD: %w", err)
+		return "", fmt.Errorf("ERR_FILEBASE_HEAD_OBJECT: %w", err)
 	}
 
 	cid := resp.Metadata["cid"]
 	if cid == "" {
-		return "", fmt.Errorf("ERR_FILEBASE_CID_NOT_FOUND: %w", err)
+		return "", fmt.Errorf("ERR_FILEBASE_CID_NOT_FOUND: %s", key)
 	}
 
 	return cid, nil
 }
 
+// Upload stores the blob under its content addressed key, namespace isn't used. The content is verified against the
+// digest first, a blob that's stored already isn't uploaded again
 func (fb *filebase) Upload(ctx context.Context, namespace, digest string, content []byte) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
 	defer cancel()
 
+	blobKey, err := dfs.BlobKey(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_FILEBASE_DIGEST_PARSE: %w", err)
+	}
+
+	if err = dfs.VerifyContent(digest, content); err != nil {
+		return "", err
+	}
+
+	exists, err := dfs.S3ObjectExists(ctx, fb.client, fb.bucket, blobKey)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return fb.cid(ctx, blobKey)
+	}
+
 	input := &s3.PutObjectInput{
 		Bucket:            &fb.bucket,
-		Key:               &namespace,
+		Key:               &blobKey,
 		ACL:               s3types.ObjectCannedACLPublicRead,
 		Body:              bytes.NewBuffer(content),
 		ChecksumAlgorithm: s3types.ChecksumAlgorithmSha256,
@@ -176,26 +225,12 @@ func (fb *filebase) Upload(ctx context.Context, namespace, digest string, conten
 	if fb.env == config.CI {
 		input.Expires = aws.Time(time.Now().Add(time.Minute * 30))
 	}
-	_, err := fb.client.PutObject(ctx, input)
+	_, err = fb.client.PutObject(ctx, input)
 	if err != nil {
 		return "", fmt.Errorf("ERR_FILEBASE_UPLOAD_OBJECT: %w", err)
 	}
 
-	resp, err := fb.client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket:       &fb.bucket,
-		Key:          &namespace,
-		ChecksumMode: s3types.ChecksumModeEnabled,
-	})
-	if err != nil {
-		return "", fmt.Errorf("ERR_FILEBASE_UPLOAD_OBJECT_HEAD: %w", err)
-	}
-
-	cid := resp.Metadata["cid"]
-	if cid == "" {
-		return "", fmt.Errorf("ERR_FILEBASE_CID_NOT_FOUND: %w", err)
-	}
-
-	return cid, nil
+	return fb.cid(ctx, blobKey)
 }
 
 func (fb *filebase) Download(ctx context.Context, path string) (io.ReadCloser, error) {
@@ -234,7 +269,7 @@ func (fb *filebase) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMet
 	var resp *s3.HeadObjectOutput
 	var err error
 
-	identifier := core_types.GetLayerIdentifier(layer.ID)
+	identifier := fb.metadataKey(layer)
 	for i := 3; i > 0; i-- {
 		resp, err = fb.client.HeadObject(context.Background(), &s3.HeadObjectInput{
 			Bucket:       &fb.bucket,
@@ -266,6 +301,18 @@ func (fb *filebase) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMet
 	}, nil
 }
 
+// metadataKey returns the object key of the layer. The DFS link is a CID, so the key is derived from the digest, or
+// from the layer ID for blobs stored before keys were content addressed
+func (fb *filebase) metadataKey(layer *types.ContainerImageLayer) string {
+	if blobKey, err := dfs.BlobKey(layer.Digest); err == nil {
+		if exists, _ := dfs.S3ObjectExists(context.Background(), fb.client, fb.bucket, blobKey); exists {
+			return blobKey
+		}
+	}
+
+	return core_types.GetLayerIdentifier(layer.ID)
+}
+
 func (fb *filebase) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
 	partsResp, err := fb.client.ListParts(context.Background(), &s3.ListPartsInput{
 		Bucket:   &fb.bucket,
@@ -287,6 +334,7 @@ func (fb *filebase) GetUploadProgress(identifier, uploadID string) (*types.Objec
 }
 
 func (fb *filebase) AbortMultipartUpload(ctx context.Context, layerKey, uploadId string) error {
+	fb.digests.Forget(uploadId)
 	_, err := fb.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
 		Bucket:   &fb.bucket,
 		Key:      &layerKey,
@@ -306,7 +354,7 @@ func (fb *filebase) GeneratePresignedURL(ctx context.Context, key string) (strin
 	}
 
 	duration := func(o *s3.PresignOptions) {
-		o.Expires = time.Minute * 20
+		o.Expires = config.URLExpiryOrDefault(fb.config.URLExpiry)
 	}
 
 	resp, err := fb.preSigner.PresignGetObject(ctx, opts, duration)
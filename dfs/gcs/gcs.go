@@ -0,0 +1,457 @@
+// Package gcs is a storage backend for Google Cloud Storage. Chunked layer uploads are streamed into a GCS resumable
+// upload session, so the parts never have to be stitched together after the upload
+package gcs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+	oci_digest "github.com/opencontainers/go-digest"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+type gcs struct {
+	client   *storage.Client
+	bucket   *storage.BucketHandle
+	config   *config.GCSDFS
+	s3Config *config.S3CompatibleDFS
+	// uploads holds the resumable upload sessions that are in progress, keyed by the upload id
+	uploads map[string]*uploadSession
+	// signer is the service account from the credentials file, nil if the client signs URLs on its own
+	signer *serviceAccount
+	mu     sync.Mutex
+}
+
+var _ dfs.ObjectLister = (*gcs)(nil)
+
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+// uploadSession is a resumable upload, the parts of a layer are written to it in order
+type uploadSession struct {
+	writer   *storage.Writer
+	cancel   context.CancelFunc
+	hash     hash.Hash
+	written  int64
+	nextPart int32
+	mu       sync.Mutex
+}
+
+func New(cfg *config.GCSDFS) (dfs.DFS, error) {
+	var opts []option.ClientOption
+	var signer *serviceAccount
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(cfg.CredentialsFile))
+
+		// the key is read here as well, since the client doesn't keep credentials when talking to an emulator
+		keyFile, err := os.ReadFile(cfg.CredentialsFile)
+		if err != nil {
+			return nil, fmt.Errorf("ERR_GCS_READ_CREDENTIALS: %w", err)
+		}
+
+		signer = &serviceAccount{}
+		if err = json.Unmarshal(keyFile, signer); err != nil {
+			return nil, fmt.Errorf("ERR_GCS_PARSE_CREDENTIALS: %w", err)
+		}
+	}
+
+	// emulators like fake-gcs-server don't authenticate requests
+	if cfg.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(cfg.Endpoint+"/storage/v1/"), option.WithoutAuthentication())
+	}
+
+	client, err := storage.NewClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_GCS_NEW_CLIENT: %w", err)
+	}
+
+	return &gcs{
+		client:  client,
+		bucket:  client.Bucket(cfg.BucketName),
+		config:  cfg,
+		uploads: make(map[string]*uploadSession),
+		signer:  signer,
+		s3Config: &config.S3CompatibleDFS{
+			Endpoint:     cfg.Endpoint,
+			BucketName:   cfg.BucketName,
+			ChunkSize:    cfg.ChunkSize,
+			MinChunkSize: cfg.MinChunkSize,
+			Enabled:      cfg.Enabled,
+		},
+	}, nil
+}
+
+// key maps a DFS link to the object name in the bucket
+func (g *gcs) key(dfsLink string) string {
+	if g.config.Prefix == "" {
+		return dfsLink
+	}
+
+	return path.Join(g.config.Prefix, dfsLink)
+}
+
+// CreateMultipartUpload starts a resumable upload session. The session outlives the request that created it, so it
+// gets a context of its own, which is cancelled when the upload is aborted
+func (g *gcs) CreateMultipartUpload(layerKey string) (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := g.bucket.Object(g.key(layerKey)).NewWriter(ctx)
+	writer.ChunkSize = g.config.ChunkSize
+	writer.ContentType = "application/octet-stream"
+
+	uploadID := uuid.NewString()
+	g.mu.Lock()
+	g.uploads[uploadID] = &uploadSession{
+		writer:   writer,
+		cancel:   cancel,
+		hash:     sha256.New(),
+		nextPart: 1,
+	}
+	g.mu.Unlock()
+
+	return uploadID, nil
+}
+
+func (g *gcs) session(uploadID string) (*uploadSession, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	session, ok := g.uploads[uploadID]
+	if !ok {
+		return nil, fmt.Errorf("ERR_GCS_UPLOAD_NOT_FOUND: %s", uploadID)
+	}
+
+	return session, nil
+}
+
+// UploadPart appends a part to the resumable upload. A resumable upload can only be appended to, so the parts must
+// arrive in order, which OCI chunked uploads guarantee
+func (g *gcs) UploadPart(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	digest string,
+	partNumber int32,
+	content io.ReadSeeker,
+	contentLength int64,
+) (s3types.CompletedPart, error) {
+	session, err := g.session(uploadId)
+	if err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if partNumber != session.nextPart {
+		return s3types.CompletedPart{}, fmt.Errorf(
+			"ERR_GCS_UPLOAD_PART_OUT_OF_ORDER: expected part %d, got %d", session.nextPart, partNumber,
+		)
+	}
+
+	n, err := io.Copy(io.MultiWriter(session.writer, session.hash), io.LimitReader(content, contentLength))
+	if err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_GCS_UPLOAD_PART: %w", err)
+	}
+
+	session.written += n
+	session.nextPart++
+
+	return s3types.CompletedPart{
+		ETag:       aws.String(strconv.Itoa(int(partNumber))),
+		PartNumber: aws.Int32(partNumber),
+	}, nil
+}
+
+// CompleteMultipartUpload finalises the resumable upload and moves the object to its content addressed key. Since all
+// the bytes went through the session, the digest of the whole layer is verified here and the object is removed if it
+// doesn't match
+func (g *gcs) CompleteMultipartUpload(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	layerDigest string,
+	completedParts []s3types.CompletedPart,
+) (string, error) {
+	session, err := g.session(uploadId)
+	if err != nil {
+		return "", err
+	}
+
+	g.mu.Lock()
+	delete(g.uploads, uploadId)
+	g.mu.Unlock()
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	defer session.cancel()
+
+	if err = session.writer.Close(); err != nil {
+		return "", fmt.Errorf("ERR_GCS_COMPLETE_UPLOAD: %w", err)
+	}
+
+	parsed, err := oci_digest.Parse(layerDigest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_GCS_DIGEST_PARSE: %w", err)
+	}
+
+	if err = g.verify(ctx, layerKey, parsed, session.hash); err != nil {
+		_ = g.bucket.Object(g.key(layerKey)).Delete(ctx)
+		return "", err
+	}
+
+	blobKey, err := dfs.BlobKey(layerDigest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_GCS_DIGEST_PARSE: %w", err)
+	}
+
+	if err = g.commit(ctx, layerKey, blobKey); err != nil {
+		return "", err
+	}
+
+	return blobKey, nil
+}
+
+// verify checks the object a resumable upload wrote against the digest. The session hashes sha256, objects with other
+// digests are read back
+func (g *gcs) verify(ctx context.Context, key string, digest oci_digest.Digest, sessionHash hash.Hash) error {
+	if digest.Algorithm() == oci_digest.SHA256 {
+		if computed := oci_digest.NewDigest(oci_digest.SHA256, sessionHash); computed != digest {
+			return fmt.Errorf("ERR_GCS_DIGEST_MISMATCH: expected %s, got %s", digest, computed)
+		}
+		return nil
+	}
+
+	reader, err := g.bucket.Object(g.key(key)).NewReader(ctx)
+	if err != nil {
+		return fmt.Errorf("ERR_GCS_GET_OBJECT: %w", err)
+	}
+	defer reader.Close()
+
+	verifier := digest.Verifier()
+	if _, err = io.Copy(verifier, reader); err != nil {
+		return fmt.Errorf("ERR_GCS_GET_OBJECT: %w", err)
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("ERR_GCS_DIGEST_MISMATCH: %s", digest)
+	}
+
+	return nil
+}
+
+// commit moves the object a resumable upload wrote to its content addressed key, once it's verified. When that key
+// exists already, the blob was stored before and the upload is only deleted
+func (g *gcs) commit(ctx context.Context, src, dst string) error {
+	exists, err := g.exists(ctx, dst)
+	if err != nil {
+		return err
+	}
+
+	srcObject := g.bucket.Object(g.key(src))
+	if !exists {
+		if _, err = g.bucket.Object(g.key(dst)).CopierFrom(srcObject).Run(ctx); err != nil {
+			return fmt.Errorf("ERR_GCS_COPY_OBJECT: %w", err)
+		}
+	}
+
+	if err = srcObject.Delete(ctx); err != nil {
+		return fmt.Errorf("ERR_GCS_DELETE_OBJECT: %w", err)
+	}
+
+	return nil
+}
+
+func (g *gcs) exists(ctx context.Context, dfsLink string) (bool, error) {
+	_, err := g.bucket.Object(g.key(dfsLink)).Attrs(ctx)
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("ERR_GCS_METADATA: %w", err)
+}
+
+// Upload stores the blob under its content addressed key, identifier isn't used. The content is verified against the
+// digest first, a blob that's stored already isn't uploaded again
+func (g *gcs) Upload(ctx context.Context, identifier, digest string, content []byte) (string, error) {
+	if err := dfs.VerifyContent(digest, content); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	blobKey, err := dfs.BlobKey(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_GCS_DIGEST_PARSE: %w", err)
+	}
+
+	exists, err := g.exists(ctx, blobKey)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return blobKey, nil
+	}
+
+	writer := g.bucket.Object(g.key(blobKey)).NewWriter(ctx)
+	writer.ContentType = "application/octet-stream"
+	if _, err = writer.Write(content); err != nil {
+		_ = writer.Close()
+		return "", fmt.Errorf("ERR_GCS_UPLOAD_OBJECT: %w", err)
+	}
+
+	if err = writer.Close(); err != nil {
+		return "", fmt.Errorf("ERR_GCS_UPLOAD_OBJECT: %w", err)
+	}
+
+	return blobKey, nil
+}
+
+// Download method returns an io.ReadCloser. The end user/consumer is responsible to close the io.ReadCloser
+func (g *gcs) Download(ctx context.Context, dfsLink string) (io.ReadCloser, error) {
+	reader, err := g.bucket.Object(g.key(dfsLink)).NewReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_GCS_GET_OBJECT: %w", err)
+	}
+
+	return reader, nil
+}
+
+func (g *gcs) DownloadDir(dfsLink, dir string) error {
+	return nil
+}
+
+func (g *gcs) List(path string) ([]*types.Metadata, error) {
+	return nil, nil
+}
+
+// ListObjects implements dfs.ObjectLister, it lists everything under the prefix
+func (g *gcs) ListObjects(ctx context.Context, fn func(object *types.ObjectMetadata) error) error {
+	prefix := ""
+	if g.config.Prefix != "" {
+		prefix = strings.TrimSuffix(g.config.Prefix, "/") + "/"
+	}
+
+	objects := g.bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := objects.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("ERR_GCS_LIST_OBJECTS: %w", err)
+		}
+
+		err = fn(&types.ObjectMetadata{
+			ContentType:   attrs.ContentType,
+			Etag:          attrs.Etag,
+			DFSLink:       strings.TrimPrefix(attrs.Name, prefix),
+			ContentLength: int(attrs.Size),
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (g *gcs) AddImage(ns string, mf, l map[string][]byte) (string, error) {
+	return "", nil
+}
+
+func (g *gcs) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	attrs, err := g.bucket.Object(g.key(layer.DFSLink)).Attrs(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("ERR_GCS_METADATA: %w", err)
+	}
+
+	return &types.ObjectMetadata{
+		ContentType:   attrs.ContentType,
+		Etag:          attrs.Etag,
+		DFSLink:       layer.DFSLink,
+		ContentLength: int(attrs.Size),
+	}, nil
+}
+
+func (g *gcs) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
+	session, err := g.session(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	return &types.ObjectMetadata{
+		ContentLength: int(session.written),
+	}, nil
+}
+
+// GeneratePresignedURL returns a V4 signed URL. Without a service account key, the client signs it with the IAM
+// signBlob API, which needs the iam.serviceAccounts.signBlob permission
+func (g *gcs) GeneratePresignedURL(ctx context.Context, dfsLink string) (string, error) {
+	opts := &storage.SignedURLOptions{
+		Method:   http.MethodGet,
+		Expires:  time.Now().Add(config.URLExpiryOrDefault(g.config.URLExpiry)),
+		Scheme:   storage.SigningSchemeV4,
+		Insecure: strings.HasPrefix(g.config.Endpoint, "http://"),
+	}
+	if g.signer != nil && g.signer.PrivateKey != "" {
+		opts.GoogleAccessID = g.signer.ClientEmail
+		opts.PrivateKey = []byte(g.signer.PrivateKey)
+	}
+
+	signedURL, err := g.bucket.SignedURL(g.key(dfsLink), opts)
+	if err != nil {
+		return "", fmt.Errorf("ERR_GCS_GENERATE_PRESIGNED_URL: %w", err)
+	}
+
+	return signedURL, nil
+}
+
+// AbortMultipartUpload cancels the resumable upload session, GCS discards the bytes that were written to it
+func (g *gcs) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	session, err := g.session(uploadId)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	delete(g.uploads, uploadId)
+	g.mu.Unlock()
+
+	session.cancel()
+	return nil
+}
+
+func (g *gcs) Config() *config.S3CompatibleDFS {
+	return g.s3Config
+}
@@ -0,0 +1,275 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	boxo_files "github.com/ipfs/boxo/files"
+	boxo_path "github.com/ipfs/boxo/path"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	oci_digest "github.com/opencontainers/go-digest"
+	img_spec "github.com/opencontainers/image-spec/specs-go"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// publishTimeout is how long adding an image DAG to the node may take, the layers are already on the node by then
+const publishTimeout = time.Minute * 5
+
+var _ dfs.ImagePublisher = (*ipfsP2p)(nil)
+
+// PublishImage implements dfs.ImagePublisher. The image is published as an OCI image layout directory: the manifest,
+// the oci-layout and index.json files are added to the node and the config & layers, which are on the node already,
+// are linked into the blobs directory by their CIDs. The CID of the directory is returned, `ipfs get <cid>` fetches
+// a layout that `openregistry import` reads
+func (ipfs *ipfsP2p) PublishImage(
+	ctx context.Context,
+	namespace string,
+	reference string,
+	manifest []byte,
+	blobs map[string]string,
+) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	return ipfs.addLayout(ctx, namespace, map[string][]byte{reference: manifest}, nil, blobs)
+}
+
+// AddImage publishes the manifests and blobs as a single image DAG, like PublishImage does for pushed images. mf maps
+// the references (tags or digests) to the manifests of the namespace and l maps digests to the content of the blobs
+func (ipfs *ipfsP2p) AddImage(ns string, mf, l map[string][]byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	return ipfs.addLayout(ctx, ns, mf, l, nil)
+}
+
+// addLayout adds an OCI image layout directory to the node and returns its CID. The blobs in blobFiles are added with
+// the layout, the ones in blobLinks are linked to by their CIDs
+func (ipfs *ipfsP2p) addLayout(
+	ctx context.Context,
+	namespace string,
+	manifests map[string][]byte,
+	blobFiles map[string][]byte,
+	blobLinks map[string]string,
+) (string, error) {
+	entries := make(map[string]boxo_files.Node)
+	index := &img_spec_v1.Index{
+		Versioned: img_spec.Versioned{SchemaVersion: 2},
+		MediaType: img_spec_v1.MediaTypeImageIndex,
+		Manifests: make([]img_spec_v1.Descriptor, 0, len(manifests)),
+	}
+
+	// sorted, so that the same image always ends up with the same CID
+	references := make([]string, 0, len(manifests))
+	for reference := range manifests {
+		references = append(references, reference)
+	}
+	sort.Strings(references)
+
+	for _, reference := range references {
+		desc, err := manifestDescriptor(namespace, reference, manifests[reference])
+		if err != nil {
+			return "", err
+		}
+
+		index.Manifests = append(index.Manifests, desc)
+		entries[blobPath(desc.Digest)] = boxo_files.NewBytesFile(manifests[reference])
+	}
+
+	for digest, content := range blobFiles {
+		parsed, err := oci_digest.Parse(digest)
+		if err != nil {
+			return "", fmt.Errorf("ERR_IPFS_DIGEST_PARSE: %w", err)
+		}
+		entries[blobPath(parsed)] = boxo_files.NewBytesFile(content)
+	}
+
+	layoutBz, err := json.Marshal(img_spec_v1.ImageLayout{Version: img_spec_v1.ImageLayoutVersion})
+	if err != nil {
+		return "", err
+	}
+	entries[img_spec_v1.ImageLayoutFile] = boxo_files.NewBytesFile(layoutBz)
+
+	indexBz, err := json.Marshal(index)
+	if err != nil {
+		return "", err
+	}
+	entries["index.json"] = boxo_files.NewBytesFile(indexBz)
+
+	root, err := ipfs.node.Unixfs().Add(ctx, mapDirectory(entries))
+	if err != nil {
+		return "", fmt.Errorf("ERR_IPFS_ADD_IMAGE: %w", err)
+	}
+
+	for digest, link := range blobLinks {
+		parsed, parseErr := oci_digest.Parse(digest)
+		if parseErr != nil {
+			return "", fmt.Errorf("ERR_IPFS_DIGEST_PARSE: %w", parseErr)
+		}
+
+		blob, pathErr := ipfsPath(link)
+		if pathErr != nil {
+			return "", pathErr
+		}
+
+		root, err = ipfs.node.Object().AddLink(ctx, root, blobPath(parsed), blob, options.Object.Create(true))
+		if err != nil {
+			return "", fmt.Errorf("ERR_IPFS_LINK_BLOB: %s: %w", digest, err)
+		}
+	}
+
+	if ipfs.config.Pinning {
+		if err = ipfs.node.Pin().Add(ctx, root); err != nil {
+			return "", fmt.Errorf("ERR_IPFS_PIN_IMAGE: %w", err)
+		}
+	}
+
+	return root.RootCid().String(), nil
+}
+
+// manifestDescriptor returns the index.json entry of a manifest. References that aren't digests are tags
+func manifestDescriptor(namespace, reference string, manifest []byte) (img_spec_v1.Descriptor, error) {
+	var mediaType struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(manifest, &mediaType); err != nil {
+		return img_spec_v1.Descriptor{}, fmt.Errorf("ERR_IPFS_PARSE_MANIFEST: %s: %w", reference, err)
+	}
+
+	if mediaType.MediaType == "" {
+		mediaType.MediaType = img_spec_v1.MediaTypeImageManifest
+	}
+
+	desc := img_spec_v1.Descriptor{
+		MediaType:   mediaType.MediaType,
+		Digest:      oci_digest.FromBytes(manifest),
+		Size:        int64(len(manifest)),
+		Annotations: map[string]string{types.AnnotationNamespace: namespace},
+	}
+	if _, err := oci_digest.Parse(reference); err != nil {
+		desc.Annotations[img_spec_v1.AnnotationRefName] = reference
+	}
+
+	return desc, nil
+}
+
+func blobPath(digest oci_digest.Digest) string {
+	return path.Join("blobs", digest.Algorithm().String(), digest.Encoded())
+}
+
+// mapDirectory turns a map of slash separated paths into nested directories
+func mapDirectory(entries map[string]boxo_files.Node) boxo_files.Directory {
+	children := make(map[string]boxo_files.Node)
+	nested := make(map[string]map[string]boxo_files.Node)
+	for name, node := range entries {
+		dir, rest, ok := strings.Cut(name, "/")
+		if !ok {
+			children[name] = node
+			continue
+		}
+
+		if nested[dir] == nil {
+			nested[dir] = make(map[string]boxo_files.Node)
+		}
+		nested[dir][rest] = node
+	}
+
+	for dir, dirEntries := range nested {
+		children[dir] = mapDirectory(dirEntries)
+	}
+
+	return boxo_files.NewMapDirectory(children)
+}
+
+// List returns the images in the image DAG with the CID
+func (ipfs *ipfsP2p) List(link string) ([]*types.Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+
+	indexBz, err := ipfs.readFile(ctx, link, "index.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var index img_spec_v1.Index
+	if err = json.Unmarshal(indexBz, &index); err != nil {
+		return nil, fmt.Errorf("ERR_IPFS_PARSE_INDEX: %w", err)
+	}
+
+	images := make([]*types.Metadata, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		manifestBz, readErr := ipfs.readFile(ctx, link, blobPath(desc.Digest))
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		if err = desc.Digest.Validate(); err != nil || desc.Digest.Algorithm().FromBytes(manifestBz) != desc.Digest {
+			return nil, fmt.Errorf("ERR_IPFS_MANIFEST_DIGEST_MISMATCH: %s", desc.Digest)
+		}
+
+		image := &types.Metadata{Namespace: desc.Annotations[types.AnnotationNamespace]}
+		if err = json.Unmarshal(manifestBz, &image.Manifest); err != nil {
+			return nil, fmt.Errorf("ERR_IPFS_PARSE_MANIFEST: %s: %w", desc.Digest, err)
+		}
+		image.Manifest.Digest = desc.Digest.String()
+		image.Manifest.Reference = desc.Annotations[img_spec_v1.AnnotationRefName]
+
+		images = append(images, image)
+	}
+
+	return images, nil
+}
+
+// DownloadDir writes the directory DAG with the CID to dir, which must not exist yet
+func (ipfs *ipfsP2p) DownloadDir(link, dir string) error {
+	root, err := ipfsPath(link)
+	if err != nil {
+		return err
+	}
+
+	node, err := ipfs.node.Unixfs().Get(context.Background(), root)
+	if err != nil {
+		return fmt.Errorf("ERR_IPFS_GET: %w", err)
+	}
+	defer node.Close()
+
+	if err = boxo_files.WriteTo(node, dir); err != nil {
+		return fmt.Errorf("ERR_IPFS_DOWNLOAD_DIR: %w", err)
+	}
+
+	return nil
+}
+
+func (ipfs *ipfsP2p) readFile(ctx context.Context, link string, name string) ([]byte, error) {
+	root, err := ipfsPath(link)
+	if err != nil {
+		return nil, err
+	}
+
+	filePath, err := boxo_path.Join(root, strings.Split(name, "/")...)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := ipfs.node.Unixfs().Get(ctx, filePath)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_IPFS_GET: %s: %w", name, err)
+	}
+	defer node.Close()
+
+	file, ok := node.(boxo_files.File)
+	if !ok {
+		return nil, fmt.Errorf("ERR_IPFS_GET: %s isn't a file", name)
+	}
+
+	return io.ReadAll(file)
+}
@@ -223,62 +223,59 @@ func (ipfs *ipfsP2p) Upload(ctx context.Context, namespace, digest string, conte
 	return path.RootCid().String(), nil
 }
 
-func (ipfs *ipfsP2p) Download(ctx context.Context, path string) (io.ReadCloser, error) {
-	ipfsPath, err := boxo_path.NewPath(path)
+// ipfsPath maps a DFS link to an IPFS path. Links are CIDs, paths like /ipfs/<cid> are accepted as well
+func ipfsPath(link string) (boxo_path.Path, error) {
+	parts := strings.Split(strings.Trim(link, "/"), "/")
+	ipfsPath, err := boxo_path.NewPath("/ipfs/" + parts[len(parts)-1])
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ERR_IPFS_INVALID_LINK: %w", err)
 	}
 
-	resolvedPath, _, err := ipfs.node.ResolvePath(ctx, ipfsPath)
+	return ipfsPath, nil
+}
+
+// Download returns the content of the UnixFS file with the CID, it's read from the node as the reader is read
+func (ipfs *ipfsP2p) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	ipfsPath, err := ipfsPath(path)
 	if err != nil {
 		return nil, err
 	}
 
-	node, err := ipfs.node.Dag().Get(ctx, resolvedPath.RootCid())
-	// node, err := ipfs.node.Object().Get(ctx, ipfsPath)
+	node, err := ipfs.node.Unixfs().Get(ctx, ipfsPath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ERR_IPFS_GET: %w", err)
 	}
 
-	buf := bytes.NewBuffer(node.RawData())
-	return io.NopCloser(buf), nil
-}
-
-func (ipfs *ipfsP2p) DownloadDir(dfsLink, dir string) error {
-	return nil
-}
-
-func (ipfs *ipfsP2p) List(path string) ([]*types.Metadata, error) {
-	return nil, nil
-}
+	file, ok := node.(boxo_files.File)
+	if !ok {
+		_ = node.Close()
+		return nil, fmt.Errorf("ERR_IPFS_GET: %s isn't a file", path)
+	}
 
-func (ipfs *ipfsP2p) AddImage(ns string, mf, l map[string][]byte) (string, error) {
-	return "", nil
+	return file, nil
 }
 
-// Metadata API returns the HEADERS for an object. This object can be a manifest or a layer.
-// This API is usually a little behind when it comes to fetching the details for an uploaded object.
-// This is why we put it in a retry loop and break it as soon as we get the data
+// Metadata returns the size of the UnixFS file the layer links to
 func (ipfs *ipfsP2p) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
-	identifier := "/ipfs/" + layer.DFSLink
-	idParts := strings.Split(identifier, "/")
-	if len(idParts) > 1 {
-		identifier = "/ipfs/" + idParts[len(idParts)-1]
+	ipfsPath, err := ipfsPath(layer.DFSLink)
+	if err != nil {
+		return nil, err
 	}
 
-	ipfsPath, err := boxo_path.NewPath(identifier)
+	node, err := ipfs.node.Unixfs().Get(context.TODO(), ipfsPath)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ERR_IPFS_GET: %w", err)
 	}
+	defer node.Close()
 
-	stat, err := ipfs.node.Block().Stat(context.TODO(), ipfsPath)
+	size, err := node.Size()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("ERR_IPFS_SIZE: %w", err)
 	}
 
 	return &types.ObjectMetadata{
 		DFSLink:       ipfsPath.String(),
-		ContentLength: stat.Size(),
+		ContentLength: int(size),
 	}, nil
 }
 
@@ -338,6 +335,10 @@ func (ipfs *ipfsP2p) gc() {
 	}
 }
 
+// Config returns the gateway as the endpoint, blobs are downloaded from it with the URLs of GeneratePresignedURL
 func (ipfs *ipfsP2p) Config() *config.S3CompatibleDFS {
-	return &config.S3CompatibleDFS{}
+	return &config.S3CompatibleDFS{
+		Endpoint: ipfs.config.GatewayEndpoint,
+		Enabled:  ipfs.config.Enabled,
+	}
 }
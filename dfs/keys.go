@@ -0,0 +1,33 @@
+package dfs
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	oci_digest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// BlobsPrefix is where backends store blobs under their content addressed keys
+	BlobsPrefix = "blobs"
+	// LegacyLayersPrefix is where blobs were stored before keys were content addressed, under the ID of the layer
+	LegacyLayersPrefix = "layers/"
+)
+
+// BlobKey returns the content addressed key for a digest, e.g, blobs/sha256/ab/abcd... A blob that's pushed again,
+// from any repository, maps to the same key, so backends store it once
+func BlobKey(digest string) (string, error) {
+	parsed, err := oci_digest.Parse(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_DFS_BLOB_KEY: %w", err)
+	}
+
+	encoded := parsed.Encoded()
+	return path.Join(BlobsPrefix, parsed.Algorithm().String(), encoded[:2], encoded), nil
+}
+
+// IsLegacyLink reports whether a DFS link is a key from before keys were content addressed
+func IsLegacyLink(dfsLink string) bool {
+	return strings.HasPrefix(dfsLink, LegacyLayersPrefix)
+}
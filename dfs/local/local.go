@@ -0,0 +1,408 @@
+// Package local is a storage backend for single node deployments that keeps the blobs on the local disk. Blobs are
+// stored by their digest, so identical layers pushed to different repositories take up the space only once
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	oci_digest "github.com/opencontainers/go-digest"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+const (
+	blobsDir   = "blobs"
+	uploadsDir = "uploads"
+	tmpDir     = "tmp"
+)
+
+type localStorage struct {
+	logger           telemetry.Logger
+	config           *config.LocalDFS
+	s3Config         *config.S3CompatibleDFS
+	rootDir          string
+	registryEndpoint string
+	signingKey       []byte
+}
+
+func New(registryEndpoint string, cfg *config.LocalDFS, logger telemetry.Logger) dfs.DFS {
+	if cfg.RootDir == "" {
+		color.Red("dfs.local.root_dir is required for the local storage backend")
+		os.Exit(1)
+	}
+
+	rootDir, err := filepath.Abs(cfg.RootDir)
+	if err != nil {
+		color.Red("error resolving dfs.local.root_dir: %s", err)
+		os.Exit(1)
+	}
+
+	// temp files left behind by a crash are never going to be renamed into place
+	_ = os.RemoveAll(filepath.Join(rootDir, tmpDir))
+	for _, dir := range []string{blobsDir, uploadsDir, tmpDir} {
+		if err = os.MkdirAll(filepath.Join(rootDir, dir), 0o750); err != nil {
+			color.Red("error creating local storage directory: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	signingKey := []byte(cfg.SigningKey)
+	if len(signingKey) == 0 {
+		color.Yellow("dfs.local.signing_key is not set, blob URLs will stop working after a restart")
+		signingKey = make([]byte, 32)
+		if _, err = rand.Read(signingKey); err != nil {
+			color.Red("error generating signing key for local storage: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	return &localStorage{
+		logger:           logger,
+		config:           cfg,
+		s3Config:         &config.S3CompatibleDFS{Enabled: cfg.Enabled},
+		rootDir:          rootDir,
+		registryEndpoint: strings.TrimSuffix(registryEndpoint, "/"),
+		signingKey:       signingKey,
+	}
+}
+
+// blobKey returns the content addressed key for a digest, e.g, blobs/sha256/ab/abcd...
+func blobKey(digest oci_digest.Digest) string {
+	encoded := digest.Encoded()
+	return path.Join(blobsDir, digest.Algorithm().String(), encoded[:2], encoded)
+}
+
+// resolve maps a blob key to a path on disk. Keys come from URLs too, so anything that escapes the blobs directory is
+// rejected
+func (ls *localStorage) resolve(key string) (string, error) {
+	cleanKey := strings.TrimPrefix(path.Clean("/"+key), "/")
+	if !strings.HasPrefix(cleanKey, blobsDir+"/") {
+		return "", fmt.Errorf("ERR_LOCAL_DFS_INVALID_KEY: %s", key)
+	}
+
+	return filepath.Join(ls.rootDir, filepath.FromSlash(cleanKey)), nil
+}
+
+// uploadDir returns the directory with the parts of a multipart upload. Upload ids come from the client, so only the
+// ids generated by CreateMultipartUpload are accepted
+func (ls *localStorage) uploadDir(uploadID string) (string, error) {
+	id, err := uuid.Parse(uploadID)
+	if err != nil {
+		return "", fmt.Errorf("ERR_LOCAL_DFS_INVALID_UPLOAD_ID: %w", err)
+	}
+
+	return filepath.Join(ls.rootDir, uploadsDir, id.String()), nil
+}
+
+func partName(partNumber int32) string {
+	return fmt.Sprintf("%05d", partNumber)
+}
+
+// writeTemp writes the content to a temp file and syncs it to the disk. It returns the path of the temp file along
+// with the sha256 digest and the size of the content
+func (ls *localStorage) writeTemp(content io.Reader) (string, oci_digest.Digest, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(ls.rootDir, tmpDir), "blob-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", "", 0, err
+	}
+
+	return tmp.Name(), oci_digest.NewDigestFromBytes(oci_digest.SHA256, hasher.Sum(nil)), size, nil
+}
+
+// renameInto moves a synced temp file to dst, so that readers either see the complete file or nothing at all
+func renameInto(tmpPath, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o750); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return err
+	}
+
+	// the rename is only durable once the directory entry is synced as well
+	dir, err := os.Open(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// commitBlob writes the content to its content addressed location after verifying the digest
+func (ls *localStorage) commitBlob(content io.Reader, expectedDigest string) (string, error) {
+	tmpPath, digest, _, err := ls.writeTemp(content)
+	if err != nil {
+		return "", fmt.Errorf("ERR_LOCAL_DFS_WRITE: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	if expectedDigest != "" && digest.String() != expectedDigest {
+		return "", fmt.Errorf("ERR_LOCAL_DFS_DIGEST_MISMATCH: expected %s, got %s", expectedDigest, digest)
+	}
+
+	key := blobKey(digest)
+	dst, err := ls.resolve(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err = renameInto(tmpPath, dst); err != nil {
+		return "", fmt.Errorf("ERR_LOCAL_DFS_WRITE: %w", err)
+	}
+
+	return key, nil
+}
+
+func (ls *localStorage) Upload(ctx context.Context, identifier, digest string, content []byte) (string, error) {
+	return ls.commitBlob(bytes.NewReader(content), digest)
+}
+
+func (ls *localStorage) CreateMultipartUpload(layerKey string) (string, error) {
+	uploadID := uuid.NewString()
+	dir, err := ls.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("ERR_LOCAL_DFS_CREATE_UPLOAD: %w", err)
+	}
+
+	return uploadID, nil
+}
+
+func (ls *localStorage) UploadPart(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	digest string,
+	partNumber int32,
+	content io.ReadSeeker,
+	contentLength int64,
+) (s3types.CompletedPart, error) {
+	if partNumber < 1 || partNumber > config.MaxS3UploadParts {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_LOCAL_DFS_INVALID_PART_NUMBER: %d", partNumber)
+	}
+
+	dir, err := ls.uploadDir(uploadId)
+	if err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
+	if _, err = os.Stat(dir); err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_LOCAL_DFS_UPLOAD_UNKNOWN: %w", err)
+	}
+
+	tmpPath, partDigest, size, err := ls.writeTemp(content)
+	if err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_LOCAL_DFS_UPLOAD_PART: %w", err)
+	}
+	defer os.Remove(tmpPath)
+
+	if size != contentLength {
+		return s3types.CompletedPart{}, fmt.Errorf(
+			"ERR_LOCAL_DFS_UPLOAD_PART: expected %d bytes, got %d",
+			contentLength,
+			size,
+		)
+	}
+
+	if err = renameInto(tmpPath, filepath.Join(dir, partName(partNumber))); err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_LOCAL_DFS_UPLOAD_PART: %w", err)
+	}
+
+	return s3types.CompletedPart{
+		ETag:           aws.String(partDigest.String()),
+		ChecksumSHA256: aws.String(partDigest.Encoded()),
+		PartNumber:     aws.Int32(partNumber),
+	}, nil
+}
+
+// CompleteMultipartUpload concatenates the parts in order into the final blob. The digest of the result must match
+// the digest the client sent
+func (ls *localStorage) CompleteMultipartUpload(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	finalDigest string,
+	completedParts []s3types.CompletedPart,
+) (string, error) {
+	dir, err := ls.uploadDir(uploadId)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = oci_digest.Parse(finalDigest); err != nil {
+		return "", fmt.Errorf("ERR_LOCAL_DFS_DIGEST_PARSE: %w", err)
+	}
+
+	parts := make([]int32, 0, len(completedParts))
+	for _, part := range completedParts {
+		parts = append(parts, aws.ToInt32(part.PartNumber))
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i] < parts[j] })
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, partNumber := range parts {
+		fd, openErr := os.Open(filepath.Join(dir, partName(partNumber)))
+		if openErr != nil {
+			return "", fmt.Errorf("ERR_LOCAL_DFS_MISSING_PART: %d: %w", partNumber, openErr)
+		}
+		defer fd.Close()
+		readers = append(readers, fd)
+	}
+
+	key, err := ls.commitBlob(io.MultiReader(readers...), finalDigest)
+	if err != nil {
+		return "", err
+	}
+
+	if err = os.RemoveAll(dir); err != nil {
+		ls.logger.Debug().Str("method", "CompleteMultipartUpload").Err(err).Send()
+	}
+
+	return key, nil
+}
+
+func (ls *localStorage) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	dir, err := ls.uploadDir(uploadId)
+	if err != nil {
+		return err
+	}
+
+	if err = os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("ERR_LOCAL_DFS_ABORT_UPLOAD: %w", err)
+	}
+
+	return nil
+}
+
+func (ls *localStorage) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
+	dir, err := ls.uploadDir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_LOCAL_DFS_UPLOAD_PROGRESS: %w", err)
+	}
+
+	var uploadedSize int64
+	for _, entry := range entries {
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return nil, fmt.Errorf("ERR_LOCAL_DFS_UPLOAD_PROGRESS: %w", infoErr)
+		}
+		uploadedSize += info.Size()
+	}
+
+	return &types.ObjectMetadata{
+		ContentLength: int(uploadedSize),
+	}, nil
+}
+
+func (ls *localStorage) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	blobPath, err := ls.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(blobPath)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_LOCAL_DFS_DOWNLOAD: %w", err)
+	}
+
+	return fd, nil
+}
+
+func (ls *localStorage) DownloadDir(dfsLink, dir string) error {
+	return nil
+}
+
+func (ls *localStorage) List(path string) ([]*types.Metadata, error) {
+	return nil, nil
+}
+
+// ListObjects implements dfs.ObjectLister
+func (ls *localStorage) ListObjects(ctx context.Context, fn func(object *types.ObjectMetadata) error) error {
+	root := filepath.Join(ls.rootDir, blobsDir)
+	return filepath.WalkDir(root, func(blobPath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		key, err := filepath.Rel(ls.rootDir, blobPath)
+		if err != nil {
+			return err
+		}
+
+		return fn(&types.ObjectMetadata{DFSLink: filepath.ToSlash(key), ContentLength: int(info.Size())})
+	})
+}
+
+func (ls *localStorage) AddImage(ns string, mf, l map[string][]byte) (string, error) {
+	return "", nil
+}
+
+func (ls *localStorage) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	blobPath, err := ls.resolve(layer.DFSLink)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(blobPath)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_LOCAL_DFS_METADATA: %w", err)
+	}
+
+	return &types.ObjectMetadata{
+		DFSLink:       layer.DFSLink,
+		ContentLength: int(stat.Size()),
+	}, nil
+}
+
+func (ls *localStorage) Config() *config.S3CompatibleDFS {
+	return ls.s3Config
+}
@@ -0,0 +1,105 @@
+package local
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+)
+
+var _ dfs.BlobServer = (*localStorage)(nil)
+var _ dfs.ObjectLister = (*localStorage)(nil)
+
+func (ls *localStorage) sign(key string, expires int64) string {
+	mac := hmac.New(sha256.New, ls.signingKey)
+	fmt.Fprintf(mac, "%s\n%d", key, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GeneratePresignedURL returns a URL on the registry itself that's valid for the configured expiry. The signature
+// covers the key and the expiry, so neither can be changed by the client
+func (ls *localStorage) GeneratePresignedURL(ctx context.Context, key string) (string, error) {
+	if _, err := ls.resolve(key); err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(config.URLExpiryOrDefault(ls.config.URLExpiry)).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", ls.sign(key, expires))
+
+	return fmt.Sprintf("%s%s/%s?%s", ls.registryEndpoint, dfs.BlobServerPath, key, query.Encode()), nil
+}
+
+// ServeBlob serves a blob for a URL generated by GeneratePresignedURL. Range requests are supported, so that clients
+// can resume interrupted layer downloads
+func (ls *localStorage) ServeBlob(ctx echo.Context) error {
+	key := ctx.Param("*")
+
+	expires, err := strconv.ParseInt(ctx.QueryParam("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+			"error": "URL is invalid or has expired",
+		})
+		ls.logger.Log(ctx, fmt.Errorf("ERR_LOCAL_DFS_URL_EXPIRED: %s", key)).Send()
+		return echoErr
+	}
+
+	signature := ctx.QueryParam("signature")
+	if !hmac.Equal([]byte(signature), []byte(ls.sign(key, expires))) {
+		echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+			"error": "URL signature is invalid",
+		})
+		ls.logger.Log(ctx, fmt.Errorf("ERR_LOCAL_DFS_INVALID_SIGNATURE: %s", key)).Send()
+		return echoErr
+	}
+
+	blobPath, err := ls.resolve(key)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error": err.Error(),
+		})
+		ls.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	fd, err := os.Open(blobPath)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error": err.Error(),
+		})
+		ls.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+	defer fd.Close()
+
+	stat, err := fd.Stat()
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+		ls.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	// blobs/<algorithm>/<prefix>/<encoded>
+	if parts := strings.Split(key, "/"); len(parts) == 4 {
+		ctx.Response().Header().Set("Docker-Content-Digest", parts[1]+":"+parts[3])
+	}
+	ctx.Response().Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	http.ServeContent(ctx.Response(), ctx.Request(), "", stat.ModTime(), fd)
+	ls.logger.Log(ctx, nil).Send()
+	return nil
+}
@@ -0,0 +1,320 @@
+// Package migration copies the blobs of one storage backend to another while the registry keeps serving them. Layers
+// are cut over to the target backend one at a time, until then the registry reads their blob from the source backend
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/dfsmigration"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+// lockLease is how long a migration is locked for, the lock is extended after every layer
+const lockLease = time.Minute * 30
+
+var (
+	// ErrLocked is returned when the migration is run by another registry instance
+	ErrLocked = errors.New("ERR_MIGRATION_LOCKED: the migration is running somewhere else")
+	// ErrIncomplete is returned when the migration went through all the layers but some of them failed to copy, they
+	// are tried again the next time it runs
+	ErrIncomplete = errors.New("ERR_MIGRATION_INCOMPLETE: some blobs failed to copy")
+)
+
+type Migrator struct {
+	source  dfs.DFS
+	target  dfs.DFS
+	store   dfsmigration.MigrationStore
+	logger  telemetry.Logger
+	limiter *rate.Limiter
+	config  *config.DFSMigration
+	// owner identifies this process in the lock of the migration
+	owner string
+}
+
+// New returns a migrator for the source & target backends of the config. The backends are looked up in storage, so
+// that blobs are re-encrypted when storage is encrypted
+func New(
+	storage dfs.DFS,
+	store dfsmigration.MigrationStore,
+	cfg *config.DFSMigration,
+	logger telemetry.Logger,
+) (*Migrator, error) {
+	if cfg.Source == cfg.Target {
+		return nil, fmt.Errorf("ERR_MIGRATION_CONFIG: the source and target backends are the same: %s", cfg.Source)
+	}
+
+	source, err := backend(storage, cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := backend(storage, cfg.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Migrator{
+		source: source,
+		target: target,
+		store:  store,
+		logger: logger,
+		config: cfg,
+		owner:  uuid.NewString(),
+	}
+
+	if cfg.MaxBytesPerSecond > 0 {
+		m.limiter = rate.NewLimiter(rate.Limit(cfg.MaxBytesPerSecond), cfg.MaxBytesPerSecond)
+	}
+
+	return m, nil
+}
+
+func backend(storage dfs.DFS, name string) (dfs.DFS, error) {
+	if selector, ok := dfs.As[dfs.Selector](storage); ok {
+		if backend, found := selector.Backend(name); found {
+			return backend, nil
+		}
+	} else if name == dfs.DefaultBackend {
+		return storage, nil
+	}
+
+	return nil, fmt.Errorf("ERR_MIGRATION_CONFIG: unknown storage backend: %s", name)
+}
+
+// Start runs the migration in the background until it completes or ctx is cancelled. Failed blobs are tried again
+// after the retry interval
+func (m *Migrator) Start(ctx context.Context) {
+	go func() {
+		for {
+			err := m.Run(ctx, nil)
+			if err == nil {
+				m.logger.Info().Str("source", m.config.Source).Str("target", m.config.Target).Msg("DFS_MIGRATION_COMPLETE")
+				return
+			}
+
+			if !errors.Is(err, ErrLocked) {
+				m.logger.Info().Err(err).Str("source", m.config.Source).Str("target", m.config.Target).
+					Msg("ERR_DFS_MIGRATION")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(m.config.RetryInterval):
+			}
+		}
+	}()
+}
+
+// Run migrates all the layers on the source backend and returns once a pass over them finds nothing left to migrate.
+// It resumes from the progress in the database, progress is called after every batch of layers
+func (m *Migrator) Run(ctx context.Context, progress func(migration *types.StorageMigration)) error {
+	migration, err := m.store.GetMigration(ctx, m.config.Source, m.config.Target)
+	if err != nil {
+		return err
+	}
+
+	if err = m.lock(ctx, migration); err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := m.store.Unlock(context.WithoutCancel(ctx), migration); unlockErr != nil {
+			m.logger.Info().Err(unlockErr).Str("source", migration.Source).Msg("ERR_DFS_MIGRATION_UNLOCK")
+		}
+	}()
+
+	// a completed migration is checked again from the start, blobs might have been pushed to the source since
+	if !migration.CompletedAt.IsZero() {
+		migration.CompletedAt = time.Time{}
+		migration.Cursor = ""
+	}
+
+	for {
+		complete, passErr := m.pass(ctx, migration, progress)
+		if passErr != nil {
+			return passErr
+		}
+
+		if complete {
+			migration.CompletedAt = time.Now()
+			migration.LastError = ""
+			return m.store.SaveProgress(ctx, migration)
+		}
+	}
+}
+
+// pass goes through the layers on the source backend from the cursor to the last one. Layers are pushed while the
+// migration runs and some of them get IDs before the cursor, so the migration is only complete once a pass from the
+// first layer finds nothing to migrate
+func (m *Migrator) pass(
+	ctx context.Context,
+	migration *types.StorageMigration,
+	progress func(migration *types.StorageMigration),
+) (bool, error) {
+	fullPass := migration.Cursor == ""
+	migrated := 0
+	for {
+		layers, err := m.store.ListLayersOnBackend(ctx, m.config.Source, migration.Cursor, m.config.BatchSize)
+		if err != nil {
+			return false, err
+		}
+
+		for _, layer := range layers {
+			if err = m.lock(ctx, migration); err != nil {
+				return false, err
+			}
+
+			cutOver, migrateErr := m.migrateLayer(ctx, migration, layer)
+			if migrateErr != nil {
+				if ctx.Err() != nil {
+					return false, ctx.Err()
+				}
+
+				if err = m.recordFailure(ctx, migration, layer, migrateErr); err != nil {
+					return false, err
+				}
+				continue
+			}
+
+			if cutOver {
+				migrated++
+			}
+		}
+
+		if progress != nil {
+			progress(migration)
+		}
+
+		if len(layers) == m.config.BatchSize {
+			continue
+		}
+
+		if fullPass && migrated == 0 && migration.Failed == 0 {
+			return true, nil
+		}
+
+		failed := migration.Failed
+		migration.Cursor = ""
+		migration.Failed = 0
+		if err = m.store.SaveProgress(ctx, migration); err != nil {
+			return false, err
+		}
+
+		if failed > 0 {
+			return false, ErrIncomplete
+		}
+
+		return false, nil
+	}
+}
+
+func (m *Migrator) lock(ctx context.Context, migration *types.StorageMigration) error {
+	locked, err := m.store.Lock(ctx, migration, m.owner, lockLease)
+	if err != nil {
+		return err
+	}
+
+	if !locked {
+		return ErrLocked
+	}
+
+	return nil
+}
+
+func (m *Migrator) recordFailure(
+	ctx context.Context,
+	migration *types.StorageMigration,
+	layer *types.ContainerImageLayer,
+	migrateErr error,
+) error {
+	m.logger.Info().Err(migrateErr).Str("digest", layer.Digest).Str("target", m.config.Target).
+		Msg("ERR_DFS_MIGRATION_LAYER")
+
+	migration.Failed++
+	migration.LastError = fmt.Sprintf("%s: %s", layer.Digest, migrateErr)
+	migration.Cursor = layer.ID
+	return m.store.SaveProgress(ctx, migration)
+}
+
+// migrateLayer copies the blob of the layer to the target backend, verifies the copy and cuts the layer over to it
+func (m *Migrator) migrateLayer(
+	ctx context.Context,
+	migration *types.StorageMigration,
+	layer *types.ContainerImageLayer,
+) (bool, error) {
+	metadata, err := m.source.Metadata(layer)
+	if err != nil {
+		return false, fmt.Errorf("ERR_MIGRATION_SOURCE_METADATA: %w", err)
+	}
+
+	blob, err := m.source.Download(ctx, layer.DFSLink)
+	if err != nil {
+		return false, fmt.Errorf("ERR_MIGRATION_SOURCE_DOWNLOAD: %w", err)
+	}
+	defer blob.Close()
+
+	var source io.Reader = blob
+	if m.limiter != nil {
+		source = &throttledReader{ctx: ctx, reader: blob, limiter: m.limiter}
+	}
+
+	link, err := dfs.CopyBlob(
+		ctx, m.target, layer.DFSLink, layer.Digest, source, metadata.ContentLength, dfs.ChunkSize(m.target),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	copied, err := m.target.Metadata(&types.ContainerImageLayer{Digest: layer.Digest, DFSLink: link})
+	if err != nil {
+		return false, fmt.Errorf("ERR_MIGRATION_TARGET_METADATA: %w", err)
+	}
+
+	if copied.ContentLength != metadata.ContentLength {
+		return false, fmt.Errorf(
+			"ERR_MIGRATION_SIZE_MISMATCH: source=%d target=%d", metadata.ContentLength, copied.ContentLength,
+		)
+	}
+
+	cutOver, err := m.store.CutOver(ctx, migration, layer, link)
+	if err != nil {
+		return false, err
+	}
+
+	logEvent := m.logger.Debug().Str("method", "migrateLayer").Str("digest", layer.Digest).Str("link", link)
+	logEvent.Bool("cut_over", cutOver).Send()
+	return cutOver, nil
+}
+
+// throttledReader limits the rate at which the blobs are read from the source backend
+type throttledReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// a read can't wait for more bytes than the limiter allows at once
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := t.reader.Read(p)
+	if n > 0 {
+		if waitErr := t.limiter.WaitN(t.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
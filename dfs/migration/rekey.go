@@ -0,0 +1,109 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+	core_types "github.com/containerish/OpenRegistry/types"
+)
+
+// rekeyBatchSize is the number of layers that are read from the database at once
+const rekeyBatchSize = 100
+
+// LayerStore is the part of the registry store that Rekey needs
+type LayerStore interface {
+	ListLayers(ctx context.Context, afterID string, limit int) ([]*types.ContainerImageLayer, error)
+	UpdateLayerLink(ctx context.Context, digest string, backend string, dfsLink string) error
+}
+
+// RekeyProgress is passed to the progress callback of Rekey after every batch of layers
+type RekeyProgress struct {
+	Rekeyed int
+	Failed  int
+}
+
+// Rekey copies the blobs that are stored under the IDs of their layers (layers/<id>) to their content addressed keys
+// and points the layers to the copies. Blobs are copied within the backend they are on, through storage, so encrypted
+// blobs are re-encrypted. The old objects aren't deleted, fsck reports them as orphaned once no layer points to them.
+// Layers that fail are skipped and ErrIncomplete is returned, running Rekey again retries them
+func Rekey(
+	ctx context.Context,
+	storage dfs.DFS,
+	store LayerStore,
+	logger telemetry.Logger,
+	progress func(progress RekeyProgress),
+) (RekeyProgress, error) {
+	var result RekeyProgress
+	afterID := ""
+	for {
+		layers, err := store.ListLayers(ctx, afterID, rekeyBatchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for _, layer := range layers {
+			afterID = layer.ID
+			if !dfs.IsLegacyLink(layer.DFSLink) {
+				continue
+			}
+
+			if err = rekeyLayer(ctx, storage, store, layer); err != nil {
+				if ctx.Err() != nil {
+					return result, ctx.Err()
+				}
+
+				logger.Info().Err(err).Str("digest", layer.Digest).Str("dfs_link", layer.DFSLink).
+					Msg("ERR_DFS_REKEY_LAYER")
+				result.Failed++
+				continue
+			}
+
+			result.Rekeyed++
+		}
+
+		if progress != nil {
+			progress(result)
+		}
+
+		if len(layers) < rekeyBatchSize {
+			break
+		}
+	}
+
+	if result.Failed > 0 {
+		return result, ErrIncomplete
+	}
+
+	return result, nil
+}
+
+func rekeyLayer(ctx context.Context, storage dfs.DFS, store LayerStore, layer *types.ContainerImageLayer) error {
+	backend := dfs.ForLayer(storage, layer)
+	metadata, err := backend.Metadata(layer)
+	if err != nil {
+		return fmt.Errorf("ERR_REKEY_METADATA: %w", err)
+	}
+
+	blob, err := dfs.DownloadLayer(ctx, backend, layer)
+	if err != nil {
+		return fmt.Errorf("ERR_REKEY_DOWNLOAD: %w", err)
+	}
+	defer blob.Close()
+
+	// large blobs are uploaded in parts to a staging key first, which must not be the object that's being copied
+	stagingKey := core_types.GetLayerIdentifier(uuid.NewString())
+	link, err := dfs.CopyBlob(
+		ctx, backend, stagingKey, layer.Digest, blob, metadata.ContentLength, dfs.ChunkSize(backend),
+	)
+	if err != nil {
+		return err
+	}
+
+	// an empty backend and "default" are the same backend, the layer keeps the name it has
+	return store.UpdateLayerLink(ctx, layer.Digest, layer.Backend, link)
+}
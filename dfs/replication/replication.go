@@ -0,0 +1,285 @@
+// Package replication is a storage backend that writes to a primary backend and copies every blob to one or more
+// secondaries in the background. Reads fail over to the secondaries when the primary errors or doesn't have the blob
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/labstack/echo/v4"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	replication_store "github.com/containerish/OpenRegistry/store/v1/replication"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+// Backend is a storage backend with the name it's configured with, the name is what the replication queue tracks
+type Backend struct {
+	DFS  dfs.DFS
+	Name string
+}
+
+type replicated struct {
+	primary     Backend
+	secondaries []Backend
+	store       replication_store.ReplicationStore
+	logger      telemetry.Logger
+	config      *config.DFSReplication
+}
+
+// ReplicationStatus is implemented by storage backends that replicate blobs, the router exposes it as an admin
+// endpoint
+type ReplicationStatus interface {
+	ReplicationStatus(ctx echo.Context) error
+}
+
+var _ ReplicationStatus = (*replicated)(nil)
+var _ dfs.Wrapper = (*replicated)(nil)
+var _ dfs.ObjectLister = (*replicated)(nil)
+
+const (
+	defaultPollInterval  = 5 * time.Second
+	defaultRetryInterval = 30 * time.Second
+	defaultBatchSize     = 16
+)
+
+// New returns the composite storage backend and starts a replication worker for every secondary. The workers stop
+// when ctx is cancelled. Unset (or negative) values in cfg get the same defaults as the YAML config, a poll interval
+// of 0 would panic the ticker and a batch size of 0 would keep the worker polling in a hot loop
+func New(
+	ctx context.Context,
+	primary Backend,
+	secondaries []Backend,
+	store replication_store.ReplicationStore,
+	cfg *config.DFSReplication,
+	logger telemetry.Logger,
+) dfs.DFS {
+	replicationConfig := *cfg
+	if replicationConfig.PollInterval <= 0 {
+		replicationConfig.PollInterval = defaultPollInterval
+	}
+	if replicationConfig.RetryInterval <= 0 {
+		replicationConfig.RetryInterval = defaultRetryInterval
+	}
+	if replicationConfig.BatchSize <= 0 {
+		replicationConfig.BatchSize = defaultBatchSize
+	}
+
+	r := &replicated{
+		primary:     primary,
+		secondaries: secondaries,
+		store:       store,
+		logger:      logger,
+		config:      &replicationConfig,
+	}
+
+	for _, secondary := range secondaries {
+		go r.replicate(ctx, secondary)
+	}
+
+	return r
+}
+
+// Unwrap implements dfs.Wrapper, so that capabilities of the backends (e.g, dfs.BlobServer) stay reachable
+func (r *replicated) Unwrap() []dfs.DFS {
+	backends := []dfs.DFS{r.primary.DFS}
+	for _, secondary := range r.secondaries {
+		backends = append(backends, secondary.DFS)
+	}
+
+	return backends
+}
+
+// enqueue records the blob for replication. The blob is already stored on the primary at this point, so a queue error
+// is logged instead of failing the push
+func (r *replicated) enqueue(ctx context.Context, key, digest string) {
+	tasks := make([]*types.ReplicationTask, 0, len(r.secondaries))
+	for _, secondary := range r.secondaries {
+		tasks = append(tasks, &types.ReplicationTask{
+			Key:    key,
+			Digest: digest,
+			Target: secondary.Name,
+		})
+	}
+
+	if err := r.store.Enqueue(context.WithoutCancel(ctx), tasks...); err != nil {
+		r.logger.Info().Err(err).Str("key", key).Str("digest", digest).Msg("ERR_REPLICATION_ENQUEUE")
+	}
+}
+
+func (r *replicated) Upload(ctx context.Context, namespace, digest string, content []byte) (string, error) {
+	link, err := r.primary.DFS.Upload(ctx, namespace, digest, content)
+	if err != nil {
+		return "", err
+	}
+
+	r.enqueue(ctx, link, digest)
+	return link, nil
+}
+
+func (r *replicated) CreateMultipartUpload(namespace string) (string, error) {
+	return r.primary.DFS.CreateMultipartUpload(namespace)
+}
+
+func (r *replicated) UploadPart(
+	ctx context.Context,
+	uploadId string,
+	key string,
+	digest string,
+	partNumber int32,
+	content io.ReadSeeker,
+	contentLength int64,
+) (s3types.CompletedPart, error) {
+	return r.primary.DFS.UploadPart(ctx, uploadId, key, digest, partNumber, content, contentLength)
+}
+
+func (r *replicated) CompleteMultipartUpload(
+	ctx context.Context,
+	uploadId string,
+	key string,
+	finalDigest string,
+	completedParts []s3types.CompletedPart,
+) (string, error) {
+	link, err := r.primary.DFS.CompleteMultipartUpload(ctx, uploadId, key, finalDigest, completedParts)
+	if err != nil {
+		return "", err
+	}
+
+	r.enqueue(ctx, link, finalDigest)
+	return link, nil
+}
+
+func (r *replicated) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	return r.primary.DFS.AbortMultipartUpload(ctx, layerKey, uploadId)
+}
+
+func (r *replicated) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
+	return r.primary.DFS.GetUploadProgress(identifier, uploadID)
+}
+
+// failover runs fn against the primary and, if that fails, against every secondary that has a replica of the blob.
+// The error of the primary is returned when none of the secondaries can serve the blob
+func failover[T any](
+	ctx context.Context,
+	r *replicated,
+	path string,
+	fn func(backend dfs.DFS, link string) (T, error),
+) (T, error) {
+	result, err := fn(r.primary.DFS, path)
+	if err == nil {
+		return result, nil
+	}
+
+	for _, secondary := range r.secondaries {
+		link, linkErr := r.store.GetReplicaLink(ctx, path, secondary.Name)
+		if linkErr != nil || link == "" {
+			continue
+		}
+
+		if secondaryResult, secondaryErr := fn(secondary.DFS, link); secondaryErr == nil {
+			r.logger.Info().Err(err).Str("key", path).Str("backend", secondary.Name).Msg("DFS_READ_FAILOVER")
+			return secondaryResult, nil
+		}
+	}
+
+	return result, err
+}
+
+func (r *replicated) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	return failover(ctx, r, path, func(backend dfs.DFS, link string) (io.ReadCloser, error) {
+		return backend.Download(ctx, link)
+	})
+}
+
+func (r *replicated) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	metadata := func(backend dfs.DFS, link string) (*types.ObjectMetadata, error) {
+		replica := *layer
+		replica.DFSLink = link
+		return backend.Metadata(&replica)
+	}
+
+	return failover(context.Background(), r, layer.DFSLink, metadata)
+}
+
+// GeneratePresignedURL checks that the backend has the blob before signing a URL for it, since signing alone doesn't
+// fail for missing objects on most backends
+func (r *replicated) GeneratePresignedURL(ctx context.Context, key string) (string, error) {
+	return failover(ctx, r, key, func(backend dfs.DFS, link string) (string, error) {
+		if _, err := backend.Metadata(&types.ContainerImageLayer{DFSLink: link}); err != nil {
+			return "", err
+		}
+
+		return backend.GeneratePresignedURL(ctx, link)
+	})
+}
+
+// ListObjects implements dfs.ObjectLister for the primary. The secondaries aren't listed, they store the blobs under
+// links of their own
+func (r *replicated) ListObjects(ctx context.Context, fn func(object *types.ObjectMetadata) error) error {
+	lister, ok := dfs.As[dfs.ObjectLister](r.primary.DFS)
+	if !ok {
+		return fmt.Errorf("ERR_REPLICATION_LIST_OBJECTS: storage backend %s can't list its blobs", r.primary.Name)
+	}
+
+	return lister.ListObjects(ctx, fn)
+}
+
+func (r *replicated) DownloadDir(dfsLink, dir string) error {
+	return r.primary.DFS.DownloadDir(dfsLink, dir)
+}
+
+func (r *replicated) List(path string) ([]*types.Metadata, error) {
+	return r.primary.DFS.List(path)
+}
+
+func (r *replicated) AddImage(ns string, mf, l map[string][]byte) (string, error) {
+	return r.primary.DFS.AddImage(ns, mf, l)
+}
+
+func (r *replicated) Config() *config.S3CompatibleDFS {
+	return r.primary.DFS.Config()
+}
+
+// ReplicationStatus returns the replication lag of every secondary
+// GET /api/admin/storage/replication
+func (r *replicated) ReplicationStatus(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	lag, err := r.store.GetReplicationLag(ctx.Request().Context())
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error fetching replication lag",
+		})
+		r.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	// secondaries that never had anything queued are listed too
+	byTarget := make(map[string]*types.ReplicationLag, len(lag))
+	for _, l := range lag {
+		byTarget[l.Target] = l
+	}
+
+	secondaries := make([]*types.ReplicationLag, 0, len(r.secondaries))
+	for _, secondary := range r.secondaries {
+		l, ok := byTarget[secondary.Name]
+		if !ok {
+			l = &types.ReplicationLag{Target: secondary.Name}
+		}
+		secondaries = append(secondaries, l)
+	}
+
+	echoErr := ctx.JSON(http.StatusOK, echo.Map{
+		"primary":     r.primary.Name,
+		"secondaries": secondaries,
+	})
+	r.logger.Log(ctx, nil).Send()
+	return echoErr
+}
@@ -0,0 +1,97 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+const (
+	// claimLease is how long a claimed task is hidden from other workers, it has to cover the copy of a large layer
+	claimLease = time.Minute * 30
+	// maxRetryInterval caps the exponential backoff of failing copies
+	maxRetryInterval = time.Hour
+)
+
+// replicate copies the queued blobs to the secondary until ctx is cancelled. A full batch means there's probably more
+// work queued, so the next batch is claimed right away instead of waiting for the poll interval
+func (r *replicated) replicate(ctx context.Context, secondary Backend) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		tasks, err := r.store.Claim(ctx, secondary.Name, r.config.BatchSize, claimLease)
+		if err != nil {
+			r.logger.Info().Err(err).Str("backend", secondary.Name).Msg("ERR_REPLICATION_CLAIM")
+		}
+
+		for _, task := range tasks {
+			r.replicateTask(ctx, secondary, task)
+		}
+
+		if len(tasks) == r.config.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *replicated) replicateTask(ctx context.Context, secondary Backend, task *types.ReplicationTask) {
+	logEvent := r.logger.Debug().
+		Str("method", "replicateTask").
+		Str("key", task.Key).
+		Str("digest", task.Digest).
+		Str("backend", secondary.Name)
+
+	link, err := r.copyBlob(ctx, secondary, task)
+	if err != nil {
+		retryAt := time.Now().Add(r.retryInterval(task.Attempts))
+		if markErr := r.store.MarkFailed(context.WithoutCancel(ctx), task, err, retryAt); markErr != nil {
+			err = errors.Join(err, markErr)
+		}
+		logEvent.Err(err).Send()
+		return
+	}
+
+	if err = r.store.MarkReplicated(context.WithoutCancel(ctx), task, link); err != nil {
+		logEvent.Err(err).Send()
+		return
+	}
+
+	logEvent.Bool("success", true).Send()
+}
+
+func (r *replicated) retryInterval(attempts int) time.Duration {
+	interval := r.config.RetryInterval << min(attempts, 16)
+	if interval <= 0 || interval > maxRetryInterval {
+		return maxRetryInterval
+	}
+
+	return interval
+}
+
+// copyBlob streams the blob from the primary to the secondary and returns where the secondary stored it
+func (r *replicated) copyBlob(ctx context.Context, secondary Backend, task *types.ReplicationTask) (string, error) {
+	metadata, err := r.primary.DFS.Metadata(&types.ContainerImageLayer{DFSLink: task.Key})
+	if err != nil {
+		return "", fmt.Errorf("ERR_REPLICATION_SOURCE_METADATA: %w", err)
+	}
+
+	source, err := r.primary.DFS.Download(ctx, task.Key)
+	if err != nil {
+		return "", fmt.Errorf("ERR_REPLICATION_SOURCE_DOWNLOAD: %w", err)
+	}
+	defer source.Close()
+
+	chunkSize := dfs.ChunkSize(secondary.DFS)
+	return dfs.CopyBlob(ctx, secondary.DFS, task.Key, task.Digest, source, metadata.ContentLength, chunkSize)
+}
@@ -0,0 +1,104 @@
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling the backend while its circuit breaker is open
+var ErrCircuitOpen = errors.New("ERR_DFS_CIRCUIT_OPEN: the storage backend is failing, try again later")
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+// Breaker is the circuit breaker of a storage backend. It opens after threshold operations in a row failed with
+// transient errors and lets a single probe through once the cooldown is over. The probe closes it again if it succeeds
+type Breaker struct {
+	openedAt  time.Time
+	name      string
+	state     string
+	mu        sync.Mutex
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	probing   bool
+}
+
+func newBreaker(name string, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{name: name, state: StateClosed, threshold: threshold, cooldown: cooldown}
+}
+
+// Name is the name of the storage backend, e.g, default/s3
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the state of the breaker, one of StateClosed, StateOpen or StateHalfOpen
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.cooldown {
+		return StateHalfOpen
+	}
+
+	return b.state
+}
+
+// allow reports whether an operation can go to the backend
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateClosed:
+		return true
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+
+		b.state = StateHalfOpen
+		b.probing = true
+		return true
+	default:
+		// only one probe at a time, the others fail fast until it's done
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+		return true
+	}
+}
+
+// release ends an operation that allow let through without recording an outcome, e.g, when the caller cancelled it
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// done records the outcome of an operation that allow let through
+func (b *Breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if !failed {
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.threshold {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
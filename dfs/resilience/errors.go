@@ -0,0 +1,55 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"google.golang.org/api/googleapi"
+)
+
+// isTransient reports whether an operation that failed with err may succeed when it's tried again, i.e, the backend
+// timed out, dropped the connection or returned a 5xx or a 429. Errors like a missing object are the answer of a
+// healthy backend, they are neither retried nor counted by the circuit breaker
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	if status, ok := statusCode(err); ok {
+		return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// statusCode returns the HTTP status code of the response that an SDK error was created from
+func statusCode(err error) (int, bool) {
+	// the AWS SDK (S3, Storj & Filebase)
+	var awsErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &awsErr) {
+		return awsErr.HTTPStatusCode(), true
+	}
+
+	var gcsErr *googleapi.Error
+	if errors.As(err, &gcsErr) {
+		return gcsErr.Code, true
+	}
+
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		return azureErr.StatusCode, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,297 @@
+// Package resilience wraps a remote storage backend with timeouts, retries and a circuit breaker. Idempotent
+// operations are retried with jittered exponential backoff after transient errors, the others are only bounded by a
+// timeout. Once the backend keeps failing, the circuit breaker fails operations right away instead of waiting on it
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+// resilient embeds the backend, the operations that aren't overridden (e.g, DownloadDir) go straight to it
+type resilient struct {
+	dfs.DFS
+	breaker *Breaker
+	config  *config.DFSResilience
+	logger  telemetry.Logger
+}
+
+// BreakerReporter is implemented by the storage backends with a circuit breaker, the health checks report their state
+type BreakerReporter interface {
+	CircuitBreaker() *Breaker
+}
+
+var _ dfs.Wrapper = (*resilient)(nil)
+var _ BreakerReporter = (*resilient)(nil)
+
+// New returns the backend with timeouts, retries and a circuit breaker. name identifies the backend in logs and
+// health checks. Unset (or negative) values in cfg get the same defaults as the YAML config, a timeout of 0 would fail
+// every operation and a retry delay of 0 would panic the backoff
+func New(backend dfs.DFS, name string, cfg *config.DFSResilience, logger telemetry.Logger) dfs.DFS {
+	resilienceConfig := *cfg
+	if resilienceConfig.Timeout <= 0 {
+		resilienceConfig.Timeout = config.DefaultTimeout
+	}
+	if resilienceConfig.TransferTimeout <= 0 {
+		resilienceConfig.TransferTimeout = config.DefaultTransferTimeout
+	}
+	if resilienceConfig.RetryBaseDelay <= 0 {
+		resilienceConfig.RetryBaseDelay = config.DefaultRetryBaseDelay
+	}
+	if resilienceConfig.RetryMaxDelay <= 0 {
+		resilienceConfig.RetryMaxDelay = config.DefaultRetryMaxDelay
+	}
+	if resilienceConfig.BreakerThreshold <= 0 {
+		resilienceConfig.BreakerThreshold = config.DefaultBreakerThreshold
+	}
+	if resilienceConfig.BreakerCooldown <= 0 {
+		resilienceConfig.BreakerCooldown = config.DefaultBreakerCooldown
+	}
+
+	return &resilient{
+		DFS:     backend,
+		breaker: newBreaker(name, resilienceConfig.BreakerThreshold, resilienceConfig.BreakerCooldown),
+		config:  &resilienceConfig,
+		logger:  logger,
+	}
+}
+
+// Unwrap implements dfs.Wrapper
+func (r *resilient) Unwrap() []dfs.DFS {
+	return []dfs.DFS{r.DFS}
+}
+
+// CircuitBreaker implements BreakerReporter
+func (r *resilient) CircuitBreaker() *Breaker {
+	return r.breaker
+}
+
+// Breakers returns the circuit breakers of storage and the backends it wraps
+func Breakers(storage dfs.DFS) []*Breaker {
+	if reporter, ok := storage.(BreakerReporter); ok {
+		return []*Breaker{reporter.CircuitBreaker()}
+	}
+
+	var breakers []*Breaker
+	if wrapper, ok := storage.(dfs.Wrapper); ok {
+		for _, wrapped := range wrapper.Unwrap() {
+			breakers = append(breakers, Breakers(wrapped)...)
+		}
+	}
+
+	return breakers
+}
+
+// call runs fn through the circuit breaker, with a timeout for every attempt. Idempotent operations are retried after
+// transient errors
+func call[T any](
+	ctx context.Context,
+	r *resilient,
+	op string,
+	timeout time.Duration,
+	idempotent bool,
+	fn func(ctx context.Context) (T, error),
+) (T, error) {
+	var zero T
+	if !r.breaker.allow() {
+		return zero, fmt.Errorf("%s: %s: %w", op, r.breaker.Name(), ErrCircuitOpen)
+	}
+
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		result, err := fn(attemptCtx)
+		cancel()
+
+		// the caller gave up, that says nothing about the backend
+		if ctx.Err() != nil {
+			r.breaker.release()
+			return result, err
+		}
+
+		transient := isTransient(err)
+		if !transient || !idempotent || attempt >= r.config.Retries() {
+			r.breaker.done(transient)
+			return result, err
+		}
+
+		r.logger.Debug().Err(err).Str("backend", r.breaker.Name()).Str("operation", op).Int("attempt", attempt+1).
+			Msg("DFS_RETRY")
+
+		select {
+		case <-ctx.Done():
+			r.breaker.release()
+			return zero, err
+		case <-time.After(r.backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns a random delay between zero and the exponential backoff for the attempt ("full jitter"), so that
+// clients that failed together don't retry together
+func (r *resilient) backoff(attempt int) time.Duration {
+	delay := r.config.RetryMaxDelay
+	if attempt < 32 {
+		delay = min(r.config.RetryBaseDelay<<attempt, r.config.RetryMaxDelay)
+	}
+
+	return rand.N(delay) + 1
+}
+
+// detached runs an operation of the backend that doesn't take a context, it stops waiting for it when ctx is done
+func detached[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value: value, err: err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Upload is retried, blobs are stored under their digests so writing one twice stores the same object
+func (r *resilient) Upload(ctx context.Context, namespace, digest string, content []byte) (string, error) {
+	return call(ctx, r, "Upload", r.config.TransferTimeout, true, func(ctx context.Context) (string, error) {
+		return r.DFS.Upload(ctx, namespace, digest, content)
+	})
+}
+
+// CreateMultipartUpload isn't retried, a retry after a lost response would start a second upload
+func (r *resilient) CreateMultipartUpload(namespace string) (string, error) {
+	return call(context.Background(), r, "CreateMultipartUpload", r.config.Timeout, false,
+		func(ctx context.Context) (string, error) {
+			return detached(ctx, func() (string, error) {
+				return r.DFS.CreateMultipartUpload(namespace)
+			})
+		},
+	)
+}
+
+// UploadPart is retried, uploading a part again replaces it. The content is rewound before every retry
+func (r *resilient) UploadPart(
+	ctx context.Context,
+	uploadId string,
+	key string,
+	digest string,
+	partNumber int32,
+	content io.ReadSeeker,
+	contentLength int64,
+) (s3types.CompletedPart, error) {
+	start, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return r.DFS.UploadPart(ctx, uploadId, key, digest, partNumber, content, contentLength)
+	}
+
+	return call(ctx, r, "UploadPart", r.config.TransferTimeout, true,
+		func(ctx context.Context) (s3types.CompletedPart, error) {
+			if _, seekErr := content.Seek(start, io.SeekStart); seekErr != nil {
+				return s3types.CompletedPart{}, seekErr
+			}
+
+			return r.DFS.UploadPart(ctx, uploadId, key, digest, partNumber, content, contentLength)
+		},
+	)
+}
+
+// CompleteMultipartUpload isn't retried, the upload is gone once it's completed so a retry after a lost response fails
+func (r *resilient) CompleteMultipartUpload(
+	ctx context.Context,
+	uploadId string,
+	key string,
+	finalDigest string,
+	completedParts []s3types.CompletedPart,
+) (string, error) {
+	return call(ctx, r, "CompleteMultipartUpload", r.config.TransferTimeout, false,
+		func(ctx context.Context) (string, error) {
+			return r.DFS.CompleteMultipartUpload(ctx, uploadId, key, finalDigest, completedParts)
+		},
+	)
+}
+
+// Download is retried until the backend responds. The timeout only covers the time to the response, the body is
+// streamed for as long as it takes
+func (r *resilient) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	return call(ctx, r, "Download", r.config.Timeout, true, func(_ context.Context) (io.ReadCloser, error) {
+		// the context of the attempt is cancelled as soon as it returns, the body needs one that lives until it's closed
+		downloadCtx, cancel := context.WithCancel(ctx)
+		timer := time.AfterFunc(r.config.Timeout, cancel)
+
+		body, err := r.DFS.Download(downloadCtx, path)
+		if !timer.Stop() {
+			// the timer cancelled the download, the backend took too long to respond
+			if err == nil {
+				body.Close()
+			}
+			err = fmt.Errorf("ERR_DFS_DOWNLOAD_TIMEOUT: %w", context.DeadlineExceeded)
+		}
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		return &cancelOnClose{ReadCloser: body, cancel: cancel}, nil
+	})
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+func (r *resilient) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	return call(context.Background(), r, "Metadata", r.config.Timeout, true,
+		func(ctx context.Context) (*types.ObjectMetadata, error) {
+			return detached(ctx, func() (*types.ObjectMetadata, error) {
+				return r.DFS.Metadata(layer)
+			})
+		},
+	)
+}
+
+func (r *resilient) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
+	return call(context.Background(), r, "GetUploadProgress", r.config.Timeout, true,
+		func(ctx context.Context) (*types.ObjectMetadata, error) {
+			return detached(ctx, func() (*types.ObjectMetadata, error) {
+				return r.DFS.GetUploadProgress(identifier, uploadID)
+			})
+		},
+	)
+}
+
+func (r *resilient) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	_, err := call(ctx, r, "AbortMultipartUpload", r.config.Timeout, true, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, r.DFS.AbortMultipartUpload(ctx, layerKey, uploadId)
+	})
+	return err
+}
+
+func (r *resilient) GeneratePresignedURL(ctx context.Context, key string) (string, error) {
+	return call(ctx, r, "GeneratePresignedURL", r.config.Timeout, true, func(ctx context.Context) (string, error) {
+		return r.DFS.GeneratePresignedURL(ctx, key)
+	})
+}
@@ -0,0 +1,69 @@
+// Package routing is a storage backend made of several named backends. Repositories are routed to a backend by the
+// prefix of their namespace, so that some organisations can keep their blobs in a bucket of their own
+package routing
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+)
+
+// routed is the default backend with the named backends next to it. All the dfs.DFS methods go to the default
+// backend, callers that know the namespace or the layer pick a backend with dfs.ForNamespace or dfs.ForLayer
+type routed struct {
+	dfs.DFS
+	backends map[string]dfs.DFS
+	routes   []config.DFSRoute
+}
+
+var _ dfs.Selector = (*routed)(nil)
+var _ dfs.Wrapper = (*routed)(nil)
+
+func New(defaultBackend dfs.DFS, backends map[string]dfs.DFS, routes []config.DFSRoute) dfs.DFS {
+	return &routed{
+		DFS:      defaultBackend,
+		backends: backends,
+		routes:   routes,
+	}
+}
+
+// ForNamespace implements dfs.Selector. Rules are matched in the order they are configured
+func (r *routed) ForNamespace(namespace string) (string, dfs.DFS) {
+	for _, route := range r.routes {
+		if strings.HasPrefix(namespace, route.Prefix) {
+			if backend, ok := r.backends[route.Backend]; ok {
+				return route.Backend, backend
+			}
+		}
+	}
+
+	return dfs.DefaultBackend, r.DFS
+}
+
+// Backend implements dfs.Selector
+func (r *routed) Backend(name string) (dfs.DFS, bool) {
+	if name == "" || name == dfs.DefaultBackend {
+		return r.DFS, true
+	}
+
+	backend, ok := r.backends[name]
+	return backend, ok
+}
+
+// Unwrap implements dfs.Wrapper, the default backend comes first and the named backends are sorted by name
+func (r *routed) Unwrap() []dfs.DFS {
+	names := make([]string, 0, len(r.backends))
+	for name := range r.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	backends := []dfs.DFS{r.DFS}
+	for _, name := range names {
+		backends = append(backends, r.backends[name])
+	}
+
+	return backends
+}
@@ -0,0 +1,419 @@
+// Package s3 is a storage backend for AWS S3 and S3 compatible object stores (MinIO, Ceph, etc). Unlike the Filebase
+// and Storj backends, nothing here is specific to a provider
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	aws_config "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	aws_s3 "github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	oci_digest "github.com/opencontainers/go-digest"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+type s3 struct {
+	client    *aws_s3.Client
+	preSigner *aws_s3.PresignClient
+	config    *config.S3DFS
+	s3Config  *config.S3CompatibleDFS
+	digests   *dfs.UploadDigests
+	bucket    string
+}
+
+var _ dfs.ObjectLister = (*s3)(nil)
+
+func New(cfg *config.S3DFS) (dfs.DFS, error) {
+	client, err := newClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3{
+		client:    client,
+		preSigner: aws_s3.NewPresignClient(client),
+		config:    cfg,
+		digests:   dfs.NewUploadDigests(),
+		bucket:    cfg.BucketName,
+		s3Config: &config.S3CompatibleDFS{
+			Endpoint:     cfg.Endpoint,
+			BucketName:   cfg.BucketName,
+			ChunkSize:    cfg.ChunkSize,
+			MinChunkSize: cfg.MinChunkSize,
+			Enabled:      cfg.Enabled,
+		},
+	}, nil
+}
+
+func newClient(cfg *config.S3DFS) (*aws_s3.Client, error) {
+	opts := []func(*aws_config.LoadOptions) error{
+		aws_config.WithRegion(cfg.Region),
+	}
+
+	if cfg.AccessKey != "" && cfg.SecretKey != "" {
+		opts = append(opts, aws_config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, ""),
+		))
+	}
+
+	if cfg.CACertFile != "" {
+		caBundle, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("ERR_S3_READ_CA_BUNDLE: %w", err)
+		}
+		opts = append(opts, aws_config.WithCustomCABundle(bytes.NewReader(caBundle)))
+	}
+
+	awsConfig, err := aws_config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_S3_LOAD_CONFIG: %w", err)
+	}
+
+	if cfg.RoleARN != "" {
+		stsClient := sts.NewFromConfig(awsConfig)
+		if cfg.WebIdentityTokenFile != "" {
+			awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewWebIdentityRoleProvider(
+				stsClient,
+				cfg.RoleARN,
+				stscreds.IdentityTokenFile(cfg.WebIdentityTokenFile),
+			))
+		} else {
+			awsConfig.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, cfg.RoleARN))
+		}
+	}
+
+	return aws_s3.NewFromConfig(awsConfig, func(o *aws_s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+	}), nil
+}
+
+// key maps a DFS link to the object key in the bucket
+func (s *s3) key(dfsLink string) string {
+	if s.config.Prefix == "" {
+		return dfsLink
+	}
+
+	return path.Join(s.config.Prefix, dfsLink)
+}
+
+// checksum converts an OCI digest to the base64 encoded checksum that S3 expects. S3 only supports sha256 out of the
+// digest algorithms used by OCI, so other digests aren't sent to S3
+func checksum(digest string) *string {
+	parsed, err := oci_digest.Parse(digest)
+	if err != nil || parsed.Algorithm() != oci_digest.SHA256 {
+		return nil
+	}
+
+	raw, err := hex.DecodeString(parsed.Encoded())
+	if err != nil {
+		return nil
+	}
+
+	return aws.String(base64.StdEncoding.EncodeToString(raw))
+}
+
+func (s *s3) serverSideEncryption() (s3types.ServerSideEncryption, *string) {
+	sse := s3types.ServerSideEncryption(s.config.ServerSideEncryption)
+	if sse == s3types.ServerSideEncryptionAwsKms && s.config.KMSKeyID != "" {
+		return sse, aws.String(s.config.KMSKeyID)
+	}
+
+	return sse, nil
+}
+
+func (s *s3) objectOptions() dfs.S3ObjectOptions {
+	sse, kmsKeyID := s.serverSideEncryption()
+	return dfs.S3ObjectOptions{
+		ServerSideEncryption: sse,
+		SSEKMSKeyId:          kmsKeyID,
+		StorageClass:         s3types.StorageClass(s.config.StorageClass),
+	}
+}
+
+func (s *s3) CreateMultipartUpload(layerKey string) (string, error) {
+	sse, kmsKeyID := s.serverSideEncryption()
+	upload, err := s.client.CreateMultipartUpload(context.Background(), &aws_s3.CreateMultipartUploadInput{
+		Bucket:               &s.bucket,
+		Key:                  aws.String(s.key(layerKey)),
+		ChecksumAlgorithm:    s3types.ChecksumAlgorithmSha256,
+		ServerSideEncryption: sse,
+		SSEKMSKeyId:          kmsKeyID,
+		StorageClass:         s3types.StorageClass(s.config.StorageClass),
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERR_S3_CREATE_MULTIPART_UPLOAD: %w", err)
+	}
+
+	return *upload.UploadId, nil
+}
+
+func (s *s3) UploadPart(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	digest string,
+	partNumber int32,
+	content io.ReadSeeker,
+	contentLength int64,
+) (s3types.CompletedPart, error) {
+	if partNumber > config.MaxS3UploadParts {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_TOO_MANY_PARTS")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	resp, err := s.client.UploadPart(ctx, &aws_s3.UploadPartInput{
+		Body:              content,
+		Bucket:            &s.bucket,
+		ChecksumAlgorithm: s3types.ChecksumAlgorithmSha256,
+		ChecksumSHA256:    checksum(digest),
+		ContentLength:     &contentLength,
+		Key:               aws.String(s.key(layerKey)),
+		PartNumber:        aws.Int32(partNumber),
+		UploadId:          &uploadId,
+	})
+	if err != nil {
+		return s3types.CompletedPart{}, fmt.Errorf("ERR_S3_UPLOAD_PART: %w", err)
+	}
+
+	if err = s.digests.AddPart(uploadId, partNumber, content); err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
+	return s3types.CompletedPart{
+		ChecksumSHA256: resp.ChecksumSHA256,
+		ETag:           resp.ETag,
+		PartNumber:     aws.Int32(partNumber),
+	}, nil
+}
+
+// CompleteMultipartUpload assembles the parts and moves the blob to its content addressed key. The checksum of a
+// multipart object is a checksum of the part checksums, so S3 can't verify the digest of the whole layer. The parts
+// are hashed as they're uploaded instead, and the assembled object is removed if it doesn't match
+func (s *s3) CompleteMultipartUpload(
+	ctx context.Context,
+	uploadId string,
+	layerKey string,
+	layerDigest string,
+	completedParts []s3types.CompletedPart,
+) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	blobKey, err := dfs.BlobKey(layerDigest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_S3_DIGEST_PARSE: %w", err)
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &aws_s3.CompleteMultipartUploadInput{
+		Key:             aws.String(s.key(layerKey)),
+		Bucket:          &s.bucket,
+		UploadId:        &uploadId,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: completedParts},
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERR_S3_COMPLETE_MULTIPART_UPLOAD: %w", err)
+	}
+
+	err = dfs.S3VerifyUpload(ctx, s.client, s.bucket, s.key(layerKey), uploadId, layerDigest, s.digests)
+	if err != nil {
+		return "", err
+	}
+
+	err = dfs.S3CommitBlob(ctx, s.client, s.bucket, s.key(layerKey), s.key(blobKey), s.objectOptions())
+	if err != nil {
+		return "", err
+	}
+
+	return blobKey, nil
+}
+
+// Upload stores the blob under its content addressed key, identifier isn't used. The content is verified against
+// the digest first, a blob that's stored already isn't uploaded again
+func (s *s3) Upload(ctx context.Context, identifier, digest string, content []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
+	defer cancel()
+
+	blobKey, err := dfs.BlobKey(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_S3_DIGEST_PARSE: %w", err)
+	}
+
+	if err = dfs.VerifyContent(digest, content); err != nil {
+		return "", err
+	}
+
+	exists, err := dfs.S3ObjectExists(ctx, s.client, s.bucket, s.key(blobKey))
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return blobKey, nil
+	}
+
+	sse, kmsKeyID := s.serverSideEncryption()
+	_, err = s.client.PutObject(ctx, &aws_s3.PutObjectInput{
+		Bucket:               &s.bucket,
+		Key:                  aws.String(s.key(blobKey)),
+		Body:                 bytes.NewReader(content),
+		ChecksumAlgorithm:    s3types.ChecksumAlgorithmSha256,
+		ChecksumSHA256:       checksum(digest),
+		ContentLength:        aws.Int64(int64(len(content))),
+		ServerSideEncryption: sse,
+		SSEKMSKeyId:          kmsKeyID,
+		StorageClass:         s3types.StorageClass(s.config.StorageClass),
+	})
+	if err != nil {
+		return "", fmt.Errorf("ERR_S3_UPLOAD_OBJECT: %w", err)
+	}
+
+	return blobKey, nil
+}
+
+// Download method returns an io.ReadCloser. The end user/consumer is responsible to close the io.ReadCloser
+func (s *s3) Download(ctx context.Context, dfsLink string) (io.ReadCloser, error) {
+	resp, err := s.client.GetObject(ctx, &aws_s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(s.key(dfsLink)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ERR_S3_GET_OBJECT: %w", err)
+	}
+
+	return resp.Body, nil
+}
+
+func (s *s3) DownloadDir(dfsLink, dir string) error {
+	return nil
+}
+
+func (s *s3) List(path string) ([]*types.Metadata, error) {
+	return nil, nil
+}
+
+// ListObjects implements dfs.ObjectLister, it lists everything under the prefix
+func (s *s3) ListObjects(ctx context.Context, fn func(object *types.ObjectMetadata) error) error {
+	prefix := ""
+	if s.config.Prefix != "" {
+		prefix = strings.TrimSuffix(s.config.Prefix, "/") + "/"
+	}
+
+	paginator := aws_s3.NewListObjectsV2Paginator(s.client, &aws_s3.ListObjectsV2Input{
+		Bucket: &s.bucket,
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("ERR_S3_LIST_OBJECTS: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			err = fn(&types.ObjectMetadata{
+				Etag:          aws.ToString(object.ETag),
+				DFSLink:       strings.TrimPrefix(aws.ToString(object.Key), prefix),
+				ContentLength: int(aws.ToInt64(object.Size)),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *s3) AddImage(ns string, mf, l map[string][]byte) (string, error) {
+	return "", nil
+}
+
+func (s *s3) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
+	resp, err := s.client.HeadObject(context.Background(), &aws_s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(s.key(layer.DFSLink)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ERR_S3_METADATA_HEAD: %w", err)
+	}
+
+	return &types.ObjectMetadata{
+		ContentType:   aws.ToString(resp.ContentType),
+		Etag:          aws.ToString(resp.ETag),
+		DFSLink:       layer.DFSLink,
+		ContentLength: int(aws.ToInt64(resp.ContentLength)),
+	}, nil
+}
+
+func (s *s3) GetUploadProgress(identifier, uploadID string) (*types.ObjectMetadata, error) {
+	partsResp, err := s.client.ListParts(context.Background(), &aws_s3.ListPartsInput{
+		Bucket:   &s.bucket,
+		Key:      aws.String(s.key(identifier)),
+		UploadId: &uploadID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ERR_S3_UPLOAD_PROGRESS: %w", err)
+	}
+
+	var uploadedSize int64
+	for _, p := range partsResp.Parts {
+		uploadedSize += aws.ToInt64(p.Size)
+	}
+
+	return &types.ObjectMetadata{
+		ContentLength: int(uploadedSize),
+	}, nil
+}
+
+func (s *s3) GeneratePresignedURL(ctx context.Context, dfsLink string) (string, error) {
+	opts := &aws_s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(s.key(dfsLink)),
+	}
+
+	expiry := config.URLExpiryOrDefault(s.config.URLExpiry)
+	resp, err := s.preSigner.PresignGetObject(ctx, opts, aws_s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", fmt.Errorf("ERR_S3_GENERATE_PRESIGNED_URL: %w", err)
+	}
+
+	return resp.URL, nil
+}
+
+func (s *s3) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	s.digests.Forget(uploadId)
+	_, err := s.client.AbortMultipartUpload(ctx, &aws_s3.AbortMultipartUploadInput{
+		Bucket:   &s.bucket,
+		Key:      aws.String(s.key(layerKey)),
+		UploadId: &uploadId,
+	})
+	if err != nil {
+		return fmt.Errorf("ERR_S3_ABORT_MULTI_PART_UPLOAD: %w", err)
+	}
+
+	return nil
+}
+
+func (s *s3) Config() *config.S3CompatibleDFS {
+	return s.s3Config
+}
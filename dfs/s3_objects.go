@@ -0,0 +1,179 @@
+package dfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// maxS3CopySize is the largest object that CopyObject copies, larger objects are copied in parts
+	maxS3CopySize  = 5 * 1024 * 1024 * 1024
+	s3CopyPartSize = 512 * 1024 * 1024
+)
+
+// S3ObjectOptions are the settings of the objects a backend writes, copies don't carry them over from the source
+type S3ObjectOptions struct {
+	ACL                  s3types.ObjectCannedACL
+	ServerSideEncryption s3types.ServerSideEncryption
+	SSEKMSKeyId          *string
+	StorageClass         s3types.StorageClass
+}
+
+// S3ObjectExists reports whether the bucket has an object with the key
+func S3ObjectExists(ctx context.Context, client *s3.Client, bucket, key string) (bool, error) {
+	_, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &key})
+	if err == nil {
+		return true, nil
+	}
+
+	// not every S3 compatible store returns an error that the SDK maps to NotFound
+	var notFound *s3types.NotFound
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &notFound) || (errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("ERR_S3_HEAD_OBJECT: %w", err)
+}
+
+// S3VerifyUpload checks the object that a multipart upload assembled at key against the digest, the object is deleted
+// if it doesn't match
+func S3VerifyUpload(
+	ctx context.Context,
+	client *s3.Client,
+	bucket string,
+	key string,
+	uploadID string,
+	digest string,
+	digests *UploadDigests,
+) error {
+	err := digests.Verify(uploadID, digest, func() (io.ReadCloser, error) {
+		resp, getErr := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &bucket, Key: &key})
+		if getErr != nil {
+			return nil, fmt.Errorf("ERR_S3_GET_OBJECT: %w", getErr)
+		}
+		return resp.Body, nil
+	})
+	if err != nil {
+		_, _ = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &key})
+		return err
+	}
+
+	return nil
+}
+
+// S3CommitBlob moves a blob that a multipart upload assembled at src to its content addressed key dst. The caller
+// verifies src against the digest first. When dst exists already, the blob was stored before and src is only deleted
+func S3CommitBlob(ctx context.Context, client *s3.Client, bucket, src, dst string, opts S3ObjectOptions) error {
+	exists, err := S3ObjectExists(ctx, client, bucket, dst)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err = s3Copy(ctx, client, bucket, src, dst, opts); err != nil {
+			return err
+		}
+	}
+
+	_, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &bucket, Key: &src})
+	if err != nil {
+		return fmt.Errorf("ERR_S3_DELETE_OBJECT: %w", err)
+	}
+
+	return nil
+}
+
+func s3Copy(ctx context.Context, client *s3.Client, bucket, src, dst string, opts S3ObjectOptions) error {
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &bucket, Key: &src})
+	if err != nil {
+		return fmt.Errorf("ERR_S3_HEAD_OBJECT: %w", err)
+	}
+
+	copySource := url.PathEscape(bucket) + "/" + (&url.URL{Path: src}).EscapedPath()
+	size := aws.ToInt64(head.ContentLength)
+	if size <= maxS3CopySize {
+		_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+			Bucket:               &bucket,
+			Key:                  &dst,
+			CopySource:           &copySource,
+			ACL:                  opts.ACL,
+			ServerSideEncryption: opts.ServerSideEncryption,
+			SSEKMSKeyId:          opts.SSEKMSKeyId,
+			StorageClass:         opts.StorageClass,
+		})
+		if err != nil {
+			return fmt.Errorf("ERR_S3_COPY_OBJECT: %w", err)
+		}
+
+		return nil
+	}
+
+	return s3CopyParts(ctx, client, bucket, copySource, dst, size, opts)
+}
+
+func s3CopyParts(
+	ctx context.Context,
+	client *s3.Client,
+	bucket string,
+	copySource string,
+	dst string,
+	size int64,
+	opts S3ObjectOptions,
+) error {
+	upload, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               &bucket,
+		Key:                  &dst,
+		ACL:                  opts.ACL,
+		ServerSideEncryption: opts.ServerSideEncryption,
+		SSEKMSKeyId:          opts.SSEKMSKeyId,
+		StorageClass:         opts.StorageClass,
+	})
+	if err != nil {
+		return fmt.Errorf("ERR_S3_CREATE_MULTIPART_UPLOAD: %w", err)
+	}
+
+	var parts []s3types.CompletedPart
+	for offset, partNumber := int64(0), int32(1); offset < size; offset, partNumber = offset+s3CopyPartSize, partNumber+1 {
+		end := min(offset+s3CopyPartSize, size) - 1
+		part, partErr := client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:          &bucket,
+			Key:             &dst,
+			CopySource:      &copySource,
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+			PartNumber:      aws.Int32(partNumber),
+			UploadId:        upload.UploadId,
+		})
+		if partErr != nil {
+			_, _ = client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   &bucket,
+				Key:      &dst,
+				UploadId: upload.UploadId,
+			})
+			return fmt.Errorf("ERR_S3_UPLOAD_PART_COPY: %w", partErr)
+		}
+
+		parts = append(parts, s3types.CompletedPart{ETag: part.CopyPartResult.ETag, PartNumber: aws.Int32(partNumber)})
+	}
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &dst,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("ERR_S3_COMPLETE_MULTIPART_UPLOAD: %w", err)
+	}
+
+	return nil
+}
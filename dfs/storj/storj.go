@@ -23,6 +23,7 @@ type storj struct {
 	client    *s3.Client
 	preSigner *s3.PresignClient
 	config    *config.S3CompatibleDFS
+	digests   *dfs.UploadDigests
 	bucket    string
 	env       config.Environment
 }
@@ -35,6 +36,7 @@ func New(env config.Environment, cfg *config.S3CompatibleDFS) dfs.DFS {
 		bucket:    cfg.BucketName,
 		preSigner: s3.NewPresignClient(client),
 		config:    cfg,
+		digests:   dfs.NewUploadDigests(),
 		env:       env,
 	}
 }
@@ -91,6 +93,10 @@ func (sj *storj) UploadPart(
 		return s3types.CompletedPart{}, fmt.Errorf("ERR_STORJ_UPLOAD_PART: %w", err)
 	}
 
+	if err = sj.digests.AddPart(uploadId, partNumber, content); err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
 	return s3types.CompletedPart{
 		ChecksumSHA256: &digest,
 		ETag:           resp.ETag,
@@ -135,16 +141,54 @@ func (sj *storj) CompleteMultipartUpload(
 		return "", fmt.Errorf("ERR_STORJ_COMPLETE_MULTIPART_UPLOAD_HEAD: %w", err)
 	}
 
-	return layerKey, nil
+	err = dfs.S3VerifyUpload(ctx, sj.client, sj.bucket, layerKey, uploadId, layerDigest, sj.digests)
+	if err != nil {
+		return "", err
+	}
+
+	blobKey, err := dfs.BlobKey(layerDigest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_STORJ_DIGEST_PARSE: %w", err)
+	}
+
+	err = dfs.S3CommitBlob(ctx, sj.client, sj.bucket, layerKey, blobKey, dfs.S3ObjectOptions{
+		ACL:          s3types.ObjectCannedACLPublicRead,
+		StorageClass: s3types.StorageClassStandard,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return blobKey, nil
 }
 
+// Upload stores the blob under its content addressed key, identifier isn't used. The content is verified against the
+// digest first, a blob that's stored already isn't uploaded again
 func (sj *storj) Upload(ctx context.Context, identifier, digest string, content []byte) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*10)
 	defer cancel()
 
+	blobKey, err := dfs.BlobKey(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_STORJ_DIGEST_PARSE: %w", err)
+	}
+
+	if err = dfs.VerifyContent(digest, content); err != nil {
+		return "", err
+	}
+
+	exists, err := dfs.S3ObjectExists(ctx, sj.client, sj.bucket, blobKey)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return blobKey, nil
+	}
+
 	input := &s3.PutObjectInput{
 		Bucket:            &sj.bucket,
-		Key:               &identifier,
+		Key:               &blobKey,
 		ACL:               s3types.ObjectCannedACLPublicRead,
 		Body:              bytes.NewBuffer(content),
 		ChecksumAlgorithm: s3types.ChecksumAlgorithmSha256,
@@ -156,21 +200,21 @@ func (sj *storj) Upload(ctx context.Context, identifier, digest string, content
 		input.Expires = aws.Time(time.Now().Add(time.Minute * 30))
 	}
 
-	_, err := sj.client.PutObject(ctx, input)
+	_, err = sj.client.PutObject(ctx, input)
 	if err != nil {
 		return "", fmt.Errorf("ERR_STORJ_UPLOAD_OBJECT: %w", err)
 	}
 
 	_, err = sj.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket:       &sj.bucket,
-		Key:          &identifier,
+		Key:          &blobKey,
 		ChecksumMode: s3types.ChecksumModeEnabled,
 	})
 	if err != nil {
 		return "", fmt.Errorf("ERR_STORJ_UPLOAD_OBJECT_HEAD: %w", err)
 	}
 
-	return identifier, nil
+	return blobKey, nil
 }
 
 // Download method returns an io.ReadCloser. The end user/consumer is responsible to close the io.ReadCloser
@@ -209,7 +253,10 @@ func (sj *storj) AddImage(ns string, mf, l map[string][]byte) (string, error) {
 func (sj *storj) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
 	var resp *s3.HeadObjectOutput
 	var err error
-	id := core_types.GetLayerIdentifier(layer.ID)
+	id := layer.DFSLink
+	if id == "" {
+		id = core_types.GetLayerIdentifier(layer.ID)
+	}
 	for i := 3; i > 0; i-- {
 		resp, err = sj.client.HeadObject(context.Background(), &s3.HeadObjectInput{
 			Bucket:       &sj.bucket,
@@ -263,7 +310,7 @@ func (sj *storj) GeneratePresignedURL(ctx context.Context, key string) (string,
 	}
 
 	duration := func(o *s3.PresignOptions) {
-		o.Expires = time.Minute * 20
+		o.Expires = config.URLExpiryOrDefault(sj.config.URLExpiry)
 	}
 
 	resp, err := sj.preSigner.PresignGetObject(ctx, opts, duration)
@@ -275,6 +322,7 @@ func (sj *storj) GeneratePresignedURL(ctx context.Context, key string) (string,
 }
 
 func (sj *storj) AbortMultipartUpload(ctx context.Context, layerKey string, uploadId string) error {
+	sj.digests.Forget(uploadId)
 	_, err := sj.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
 		Bucket:   &sj.bucket,
 		Key:      &layerKey,
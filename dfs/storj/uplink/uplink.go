@@ -1,6 +1,7 @@
 package uplink
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -21,11 +22,12 @@ import (
 )
 
 type storjUplink struct {
-	client *uplink.Project
-	access *uplink.Access
-	config *config.Storj
-	bucket string
-	env    config.Environment
+	client  *uplink.Project
+	access  *uplink.Access
+	config  *config.Storj
+	digests *dfs.UploadDigests
+	bucket  string
+	env     config.Environment
 }
 
 func New(env config.Environment, cfg *config.Storj) dfs.DFS {
@@ -40,11 +42,12 @@ func New(env config.Environment, cfg *config.Storj) dfs.DFS {
 	}
 
 	return &storjUplink{
-		client: client,
-		bucket: cfg.BucketName,
-		access: access,
-		config: cfg,
-		env:    env,
+		client:  client,
+		bucket:  cfg.BucketName,
+		access:  access,
+		config:  cfg,
+		digests: dfs.NewUploadDigests(),
+		env:     env,
 	}
 }
 
@@ -107,13 +110,18 @@ func (u *storjUplink) UploadPart(
 		return s3types.CompletedPart{}, fmt.Errorf("ERR_STORJ_UPLINK_COMMIT_PART: %w", err)
 	}
 
+	if err = u.digests.AddPart(uploadId, partNumber, bytes.NewReader(bz)); err != nil {
+		return s3types.CompletedPart{}, err
+	}
+
 	return s3types.CompletedPart{
 		ETag:       &digest,
 		PartNumber: aws.Int32(int32(partNumber)),
 	}, nil
 }
 
-// CompleteMultipartUpload implements dfs.DFS
+// CompleteMultipartUpload implements dfs.DFS. The parts are hashed as they're uploaded, the committed object is removed
+// if it doesn't match the digest
 func (u *storjUplink) CompleteMultipartUpload(
 	ctx context.Context,
 	uploadId string,
@@ -133,7 +141,50 @@ func (u *storjUplink) CompleteMultipartUpload(
 		return "", fmt.Errorf("ERR_STORJ_UPLINK_COMMIT_UPLOAD: %w", err)
 	}
 
-	return resp.Key, nil
+	err = u.digests.Verify(uploadId, finalDigest, func() (io.ReadCloser, error) {
+		return u.client.DownloadObject(ctx, u.bucket, resp.Key, nil)
+	})
+	if err != nil {
+		_, _ = u.client.DeleteObject(ctx, u.bucket, resp.Key)
+		return "", err
+	}
+
+	blobKey, err := dfs.BlobKey(finalDigest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_STORJ_UPLINK_DIGEST_PARSE: %w", err)
+	}
+
+	// the verified blob is moved to its content addressed key, unless it's stored there already
+	exists, err := u.exists(ctx, blobKey)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		if _, err = u.client.DeleteObject(ctx, u.bucket, resp.Key); err != nil {
+			return "", fmt.Errorf("ERR_STORJ_UPLINK_DELETE_OBJECT: %w", err)
+		}
+		return blobKey, nil
+	}
+
+	if err = u.client.MoveObject(ctx, u.bucket, resp.Key, u.bucket, blobKey, nil); err != nil {
+		return "", fmt.Errorf("ERR_STORJ_UPLINK_MOVE_OBJECT: %w", err)
+	}
+
+	return blobKey, nil
+}
+
+func (u *storjUplink) exists(ctx context.Context, key string) (bool, error) {
+	_, err := u.client.StatObject(ctx, u.bucket, key)
+	if err == nil {
+		return true, nil
+	}
+
+	if errors.Is(err, uplink.ErrObjectNotFound) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("ERR_STORJ_UPLINK_STAT_OBJECT: %w", err)
 }
 
 // Download implements dfs.DFS
@@ -154,6 +205,7 @@ func (u *storjUplink) AbortMultipartUpload(ctx context.Context, layerKey string,
 	ctx, cancel := context.WithTimeout(ctx, time.Second*30)
 	defer cancel()
 
+	u.digests.Forget(uploadId)
 	if err := u.client.AbortUpload(ctx, u.bucket, layerKey, uploadId); err != nil {
 		return fmt.Errorf("ERR_STORJ_UPLINK_ABORT_UPLOAD: %w", err)
 	}
@@ -178,7 +230,10 @@ func (u *storjUplink) GetUploadProgress(identifier string, uploadID string) (*ty
 
 // Metadata implements dfs.DFS
 func (u *storjUplink) Metadata(layer *types.ContainerImageLayer) (*types.ObjectMetadata, error) {
-	identifier := core_types.GetLayerIdentifier(layer.ID)
+	identifier := layer.DFSLink
+	if identifier == "" {
+		identifier = core_types.GetLayerIdentifier(layer.ID)
+	}
 
 	metadata, err := u.client.StatObject(context.Background(), u.bucket, identifier)
 	if err != nil {
@@ -191,14 +246,34 @@ func (u *storjUplink) Metadata(layer *types.ContainerImageLayer) (*types.ObjectM
 	}, nil
 }
 
-// Upload implements dfs.DFS
+// Upload implements dfs.DFS. The blob is stored under its content addressed key, namespace isn't used. The content is
+// verified against the digest first, a blob that's stored already isn't uploaded again
 func (u *storjUplink) Upload(ctx context.Context, namespace string, digest string, content []byte) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute*20)
 	defer cancel()
+
+	blobKey, err := dfs.BlobKey(digest)
+	if err != nil {
+		return "", fmt.Errorf("ERR_STORJ_UPLINK_DIGEST_PARSE: %w", err)
+	}
+
+	if err = dfs.VerifyContent(digest, content); err != nil {
+		return "", err
+	}
+
+	exists, err := u.exists(ctx, blobKey)
+	if err != nil {
+		return "", err
+	}
+
+	if exists {
+		return blobKey, nil
+	}
+
 	opts := &uplink.UploadOptions{}
 	u.checkAndSetExpiry(opts)
 
-	resp, err := u.client.UploadObject(ctx, u.bucket, namespace, opts)
+	resp, err := u.client.UploadObject(ctx, u.bucket, blobKey, opts)
 	if err != nil {
 		return "", fmt.Errorf("ERR_STORJ_UPLINK_UPLOAD_OBJECT: %w", err)
 	}
@@ -217,7 +292,7 @@ func (u *storjUplink) Upload(ctx context.Context, namespace string, digest strin
 // GeneratePresignedURL generates a public link (something like a presigned url) given the following:
 func (u *storjUplink) GeneratePresignedURL(ctx context.Context, key string) (string, error) {
 	perms := uplink.ReadOnlyPermission()
-	perms.NotAfter = time.Now().Add(time.Minute * 5)
+	perms.NotAfter = time.Now().Add(config.URLExpiryOrDefault(u.config.URLExpiry))
 
 	var shareList []uplink.SharePrefix
 	shareList = append(shareList, uplink.SharePrefix{Bucket: u.config.BucketName, Prefix: key})
@@ -0,0 +1,149 @@
+package dfs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	oci_digest "github.com/opencontainers/go-digest"
+)
+
+// UploadDigests hashes the parts of multipart uploads as they're uploaded. Backends that assemble the parts remotely
+// (e.g, S3, Azure) can't verify the digest of the whole blob on their own, so the digest is computed here and checked
+// before the blob is moved to its content addressed key
+type UploadDigests struct {
+	uploads map[string]*uploadDigest
+	mu      sync.Mutex
+}
+
+type uploadDigest struct {
+	digester oci_digest.Digester
+	nextPart int32
+	// inOrder is false once a part arrived out of order (e.g, a retried part), the digest can't be computed from the
+	// parts after that
+	inOrder bool
+	// expiresAt is pushed back with every part, uploads that are abandoned without an abort are dropped after that
+	expiresAt time.Time
+}
+
+const (
+	// uploadDigestTimeout is how long an upload can go without a part before its digest is dropped. Completing the
+	// upload after that still works, the blob is read back to verify it
+	uploadDigestTimeout = time.Hour
+	uploadDigestGCEvery = time.Minute
+)
+
+func NewUploadDigests() *UploadDigests {
+	u := &UploadDigests{uploads: make(map[string]*uploadDigest)}
+
+	// run garbage collection in background
+	go u.gc()
+	return u
+}
+
+// AddPart hashes a part once the backend stored it, content is read from the start
+func (u *UploadDigests) AddPart(uploadID string, partNumber int32, content io.ReadSeeker) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	upload, ok := u.uploads[uploadID]
+	if !ok {
+		upload = &uploadDigest{digester: oci_digest.SHA256.Digester(), nextPart: 1, inOrder: true}
+		u.uploads[uploadID] = upload
+	}
+	upload.expiresAt = time.Now().Add(uploadDigestTimeout)
+
+	if !upload.inOrder {
+		return nil
+	}
+
+	if partNumber != upload.nextPart {
+		upload.inOrder = false
+		return nil
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("ERR_DFS_HASH_PART: %w", err)
+	}
+
+	if _, err := io.Copy(upload.digester.Hash(), content); err != nil {
+		return fmt.Errorf("ERR_DFS_HASH_PART: %w", err)
+	}
+
+	upload.nextPart++
+	return nil
+}
+
+// Verify checks the assembled blob against the digest and forgets the upload. When the parts couldn't be hashed as
+// they arrived (out of order, a restart in between, a digest that isn't sha256), the blob is read back with read
+func (u *UploadDigests) Verify(uploadID, digest string, read func() (io.ReadCloser, error)) error {
+	parsed, err := oci_digest.Parse(digest)
+	if err != nil {
+		return fmt.Errorf("ERR_DFS_DIGEST_PARSE: %w", err)
+	}
+
+	u.mu.Lock()
+	upload, ok := u.uploads[uploadID]
+	delete(u.uploads, uploadID)
+	u.mu.Unlock()
+
+	if ok && upload.inOrder && parsed.Algorithm() == oci_digest.SHA256 {
+		if computed := upload.digester.Digest(); computed != parsed {
+			return fmt.Errorf("ERR_DFS_DIGEST_MISMATCH: expected %s, got %s", parsed, computed)
+		}
+		return nil
+	}
+
+	blob, err := read()
+	if err != nil {
+		return fmt.Errorf("ERR_DFS_VERIFY_READ: %w", err)
+	}
+	defer blob.Close()
+
+	verifier := parsed.Verifier()
+	if _, err = io.Copy(verifier, blob); err != nil {
+		return fmt.Errorf("ERR_DFS_VERIFY_READ: %w", err)
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("ERR_DFS_DIGEST_MISMATCH: %s", parsed)
+	}
+
+	return nil
+}
+
+// Forget drops the state of an upload that was aborted
+func (u *UploadDigests) Forget(uploadID string) {
+	u.mu.Lock()
+	delete(u.uploads, uploadID)
+	u.mu.Unlock()
+}
+
+// VerifyContent checks content that's uploaded in a single request against the digest
+func VerifyContent(digest string, content []byte) error {
+	parsed, err := oci_digest.Parse(digest)
+	if err != nil {
+		return fmt.Errorf("ERR_DFS_DIGEST_PARSE: %w", err)
+	}
+
+	if computed := parsed.Algorithm().FromBytes(content); computed != parsed {
+		return fmt.Errorf("ERR_DFS_DIGEST_MISMATCH: expected %s, got %s", parsed, computed)
+	}
+
+	return nil
+}
+
+// gc drops the digests of uploads that didn't get a part for uploadDigestTimeout
+func (u *UploadDigests) gc() {
+	ticker := time.NewTicker(uploadDigestGCEvery)
+	for now := range ticker.C {
+		u.mu.Lock()
+		for uploadID, upload := range u.uploads {
+			if now.After(upload.expiresAt) {
+				delete(u.uploads, uploadID)
+			}
+		}
+		u.mu.Unlock()
+	}
+}
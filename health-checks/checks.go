@@ -1,14 +1,18 @@
 package healthchecks
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/alexliesenfeld/health"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/dfs/resilience"
 	store_v2 "github.com/containerish/OpenRegistry/store/v1"
 )
 
-func NewHealthChecksAPI(pgPing store_v2.PostgresPing) http.HandlerFunc {
+func NewHealthChecksAPI(pgPing store_v2.PostgresPing, storage dfs.DFS) http.HandlerFunc {
 	cacheOpt := health.WithCacheDuration(time.Second * 30)
 	timeoutOpt := health.WithTimeout(time.Second * 10)
 	dbHealthOpt := health.WithCheck(health.Check{
@@ -18,11 +22,21 @@ func NewHealthChecksAPI(pgPing store_v2.PostgresPing) http.HandlerFunc {
 		MaxContiguousFails: 3,
 	})
 
-	checker := health.NewChecker(
-		cacheOpt,
-		timeoutOpt,
-		dbHealthOpt,
-	)
+	opts := []health.CheckerOption{cacheOpt, timeoutOpt, dbHealthOpt}
+	// a storage backend is down while its circuit breaker is open, a half-open breaker is probing it again
+	for _, breaker := range resilience.Breakers(storage) {
+		opts = append(opts, health.WithCheck(health.Check{
+			Name: "storage:" + breaker.Name(),
+			Check: func(ctx context.Context) error {
+				if state := breaker.State(); state == resilience.StateOpen {
+					return fmt.Errorf("circuit breaker is %s", state)
+				}
+				return nil
+			},
+		}))
+	}
+
+	checker := health.NewChecker(opts...)
 
 	return health.NewHandler(checker)
 }
@@ -12,6 +12,8 @@ import (
 	"github.com/containerish/OpenRegistry/cmd/extras"
 	"github.com/containerish/OpenRegistry/cmd/migrations"
 	"github.com/containerish/OpenRegistry/cmd/registry"
+	"github.com/containerish/OpenRegistry/cmd/storage"
+	"github.com/containerish/OpenRegistry/cmd/transfer"
 )
 
 var (
@@ -38,6 +40,10 @@ func main() {
 			migrations.NewMigrationsCommand(),
 			registry.NewRegistryCommand(),
 			extras.NewExtrasCommand(),
+			transfer.NewExportCommand(),
+			transfer.NewImportCommand(),
+			storage.NewStorageCommand(),
+			storage.NewFsckCommand(),
 		}
 	)
 
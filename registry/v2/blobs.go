@@ -13,6 +13,7 @@ import (
 	"github.com/labstack/echo/v4"
 	oci_digest "github.com/opencontainers/go-digest"
 
+	dfsImpl "github.com/containerish/OpenRegistry/dfs"
 	"github.com/containerish/OpenRegistry/types"
 )
 
@@ -37,8 +38,9 @@ func (b *blobs) errorResponse(code, msg string, detail map[string]interface{}) [
 func (b *blobs) HEAD(ctx echo.Context) error {
 	ctx.Set(types.HandlerStartTime, time.Now())
 	digest := ctx.Param("digest")
+	namespace := ctx.Get(string(RegistryNamespace)).(string)
 
-	layerRef, err := b.registry.store.GetLayer(ctx.Request().Context(), digest)
+	layerRef, err := getRepositoryLayer(ctx.Request().Context(), b.registry.store, namespace, digest)
 	if err != nil {
 		details := echo.Map{
 			"error":   err.Error(),
@@ -50,7 +52,7 @@ func (b *blobs) HEAD(ctx echo.Context) error {
 		return echoErr
 	}
 
-	metadata, err := b.registry.dfs.Metadata(layerRef)
+	metadata, err := dfsImpl.ForLayer(b.registry.dfs, layerRef).Metadata(layerRef)
 	if err != nil {
 		details := echo.Map{
 			"error":   err.Error(),
@@ -106,8 +108,9 @@ func (b *blobs) UploadBlob(ctx echo.Context) error {
 
 		digest := oci_digest.FromBytes(buf.Bytes())
 
+		_, storage := b.registry.storageFor(namespace)
 		b.blobCounter[uploadID]++
-		part, err := b.registry.dfs.UploadPart(
+		part, err := storage.UploadPart(
 			ctx.Request().Context(),
 			uploadID,
 			types.GetLayerIdentifier(layerKey),
@@ -173,8 +176,9 @@ func (b *blobs) UploadBlob(ctx echo.Context) error {
 	defer ctx.Request().Body.Close()
 
 	digest := oci_digest.FromBytes(buf.Bytes())
+	_, storage := b.registry.storageFor(namespace)
 	b.blobCounter[uploadID]++
-	part, err := b.registry.dfs.UploadPart(
+	part, err := storage.UploadPart(
 		ctx.Request().Context(),
 		uploadID,
 		types.GetLayerIdentifier(layerKey),
@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"context"
+
+	oci_digest "github.com/opencontainers/go-digest"
+	"github.com/uptrace/bun"
+
+	types_v2 "github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// validateLayerCompression rejects image layers with a compression format that the registry doesn't understand
+func validateLayerCompression(manifest *types_v2.ImageManifest) error {
+	for _, layer := range manifest.Layers {
+		if _, err := types_v2.LayerCompression(layer); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setLayerCompression records the compression (and the TOC digest for lazily pullable layers) of each layer in the
+// manifest. The layers are uploaded before the manifest, so this is the first time we get to see their media type
+func (r *registry) setLayerCompression(ctx context.Context, txn *bun.Tx, manifest *types_v2.ImageManifest) error {
+	for _, layer := range manifest.Layers {
+		compression, err := types_v2.LayerCompression(layer)
+		if err != nil || compression == "" {
+			continue
+		}
+
+		tocDigest := types_v2.LayerTOCDigest(layer)
+		if err = r.store.SetLayerCompression(ctx, txn, layer.Digest.String(), compression, tocDigest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// enqueueLayerConversion schedules a zstd conversion for tags that point to gzip images, if conversion is enabled
+func (r *registry) enqueueLayerConversion(namespace, ref string, manifest *types_v2.ImageManifest) {
+	if r.converter == nil || manifest.LayerCompression() != types_v2.LayerCompressionGzip {
+		return
+	}
+
+	if _, err := oci_digest.Parse(ref); err == nil {
+		return
+	}
+
+	r.converter.Enqueue(namespace, ref)
+}
@@ -0,0 +1,397 @@
+// Package conversion creates zstd variants of gzip images. The zstd variant is pushed by digest and the tag is moved
+// to an image index with both the original and the zstd manifest, so that clients that support zstd can pick it while
+// older clients keep pulling the gzip layers
+package conversion
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	oci_digest "github.com/opencontainers/go-digest"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/registry"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+	core_types "github.com/containerish/OpenRegistry/types"
+)
+
+const (
+	// AnnotationZstdVariant is set on the index entry that points to the zstd variant of the image
+	AnnotationZstdVariant = "io.github.containers.compression.zstd"
+
+	// conversionUserAgent is recorded in the tag history when the tag is moved to the index
+	conversionUserAgent = "openregistry-zstd-conversion"
+
+	jobQueueSize = 128
+)
+
+type (
+	Converter interface {
+		// Enqueue schedules a background conversion of the tag. It never blocks, jobs are dropped if the queue is full
+		Enqueue(namespace, reference string)
+		// ConvertToZstd converts the tag in the foreground and returns the new image index the tag points to
+		ConvertToZstd(ctx context.Context, namespace, reference string) (*types.ImageManifest, error)
+	}
+
+	converter struct {
+		store  registry.RegistryStore
+		dfs    dfs.DFS
+		logger telemetry.Logger
+		config *config.LayerConversion
+		jobs   chan job
+		// onTagMoved is called with the namespace after a tag is moved to the image index
+		onTagMoved func(namespace string)
+	}
+
+	job struct {
+		namespace string
+		reference string
+	}
+)
+
+func New(
+	store registry.RegistryStore,
+	dfs dfs.DFS,
+	logger telemetry.Logger,
+	cfg *config.LayerConversion,
+	onTagMoved func(namespace string),
+) Converter {
+	c := &converter{
+		store:      store,
+		dfs:        dfs,
+		logger:     logger,
+		config:     cfg,
+		jobs:       make(chan job, jobQueueSize),
+		onTagMoved: onTagMoved,
+	}
+
+	workers := cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go c.worker()
+	}
+
+	return c
+}
+
+func (c *converter) Enqueue(namespace, reference string) {
+	select {
+	case c.jobs <- job{namespace: namespace, reference: reference}:
+	default:
+		c.logger.Debug().
+			Str("namespace", namespace).
+			Str("reference", reference).
+			Msg("layer conversion queue is full, dropping job")
+	}
+}
+
+func (c *converter) worker() {
+	for j := range c.jobs {
+		logEvent := c.logger.Debug().
+			Str("method", "ConvertToZstd").
+			Str("namespace", j.namespace).
+			Str("reference", j.reference)
+
+		if _, err := c.ConvertToZstd(context.Background(), j.namespace, j.reference); err != nil {
+			logEvent.Err(err).Send()
+			continue
+		}
+
+		logEvent.Bool("success", true).Send()
+	}
+}
+
+func (c *converter) ConvertToZstd(ctx context.Context, namespace, reference string) (*types.ImageManifest, error) {
+	if _, err := oci_digest.Parse(reference); err == nil {
+		return nil, fmt.Errorf("ERR_CONVERT_DIGEST_REFERENCE: only tags can be converted")
+	}
+
+	manifest, err := c.store.GetManifestByReference(ctx, namespace, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.MediaType == img_spec_v1.MediaTypeImageIndex || manifest.MediaType == types.MediaTypeDockerManifestList {
+		return nil, fmt.Errorf("ERR_ALREADY_AN_INDEX: %s:%s", namespace, reference)
+	}
+
+	if compression := manifest.LayerCompression(); compression != types.LayerCompressionGzip {
+		return nil, fmt.Errorf("ERR_UNSUPPORTED_COMPRESSION: expected gzip layers, got %q", compression)
+	}
+
+	platform, err := c.getPlatform(ctx, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	zstdLayers := make(types.ImageManifestLayers, 0, len(manifest.Layers))
+	var zstdSize int64
+	for _, layer := range manifest.Layers {
+		zstdLayer, err := c.convertLayer(ctx, namespace, layer)
+		if err != nil {
+			return nil, err
+		}
+		zstdLayers = append(zstdLayers, zstdLayer)
+		zstdSize += zstdLayer.Size
+	}
+
+	config := *manifest.Config
+	// docker manifests can't have zstd layers, so the zstd variant is always an OCI manifest
+	if config.MediaType == types.MediaTypeDockerImageConfig {
+		config.MediaType = img_spec_v1.MediaTypeImageConfig
+	}
+
+	zstdManifest := &types.ImageManifest{
+		RepositoryID:  manifest.RepositoryID,
+		OwnerID:       manifest.OwnerID,
+		MediaType:     img_spec_v1.MediaTypeImageManifest,
+		SchemaVersion: 2,
+		Config:        &config,
+		Layers:        zstdLayers,
+		Annotations:   manifest.Annotations,
+		Size:          zstdSize,
+	}
+
+	// the tag is moved to the index, so the original manifest is kept around by its digest
+	original := *manifest
+	original.Repository = nil
+	original.User = nil
+
+	originalDesc, err := setManifestIdentity(&original, "")
+	if err != nil {
+		return nil, err
+	}
+	originalDesc.Platform = platform
+
+	zstdDesc, err := setManifestIdentity(zstdManifest, "")
+	if err != nil {
+		return nil, err
+	}
+	zstdDesc.Platform = platform
+	zstdDesc.Annotations = map[string]string{AnnotationZstdVariant: "true"}
+
+	index := &types.ImageManifest{
+		RepositoryID:  manifest.RepositoryID,
+		OwnerID:       manifest.OwnerID,
+		MediaType:     img_spec_v1.MediaTypeImageIndex,
+		SchemaVersion: 2,
+		// the original manifest comes first so that clients without zstd support keep pulling the gzip layers
+		Manifests: []img_spec_v1.Descriptor{*originalDesc, *zstdDesc},
+		Size:      manifest.Size + zstdSize,
+	}
+	if _, err = setManifestIdentity(index, reference); err != nil {
+		return nil, err
+	}
+
+	txn, err := c.store.NewTxn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.store.AddTagHistory(ctx, txn, index, uuid.Nil, conversionUserAgent); err != nil {
+		_ = c.store.Abort(ctx, txn)
+		return nil, err
+	}
+
+	for _, m := range []*types.ImageManifest{&original, zstdManifest, index} {
+		if err = c.store.SetManifest(ctx, txn, m); err != nil {
+			_ = c.store.Abort(ctx, txn)
+			return nil, err
+		}
+	}
+
+	if err = c.store.Commit(ctx, txn); err != nil {
+		return nil, err
+	}
+
+	if c.onTagMoved != nil {
+		c.onTagMoved(namespace)
+	}
+
+	return index, nil
+}
+
+// convertLayer recompresses a gzip layer with zstd. The uncompressed content (and hence the diff ids in the image
+// config) stays the same, so the config can be shared between both the variants. The zstd layer is stored on the
+// backend of the namespace, which isn't necessarily where the gzip layer is
+func (c *converter) convertLayer(
+	ctx context.Context,
+	namespace string,
+	desc *img_spec_v1.Descriptor,
+) (*img_spec_v1.Descriptor, error) {
+	layer, err := c.store.GetLayer(ctx, desc.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := dfs.ForLayer(c.dfs, layer).Download(ctx, layer.DFSLink)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_DFS_DOWNLOAD: %s: %w", desc.Digest, err)
+	}
+	defer blob.Close()
+
+	gz, err := gzip.NewReader(blob)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_GZIP_READER: %s: %w", desc.Digest, err)
+	}
+	defer gz.Close()
+
+	// the zstd output is spooled to a temp file so that large layers are never held in memory whole
+	tmp, err := os.CreateTemp("", "openregistry-zstd-")
+	if err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_TEMP_FILE: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	digester := oci_digest.Canonical.Digester()
+	enc, err := zstd.NewWriter(
+		io.MultiWriter(tmp, digester.Hash()),
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.zstdLevel())),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_ZSTD_WRITER: %w", err)
+	}
+
+	if _, err = io.Copy(enc, gz); err != nil {
+		enc.Close()
+		return nil, fmt.Errorf("ERR_ZSTD_COMPRESS: %s: %w", desc.Digest, err)
+	}
+
+	if err = enc.Close(); err != nil {
+		return nil, fmt.Errorf("ERR_ZSTD_COMPRESS: %s: %w", desc.Digest, err)
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_ZSTD_COMPRESS: %s: %w", desc.Digest, err)
+	}
+
+	digest := digester.Digest()
+	zstdDesc := &img_spec_v1.Descriptor{
+		MediaType: img_spec_v1.MediaTypeImageLayerZstd,
+		Digest:    digest,
+		Size:      size,
+	}
+
+	// zstd output is deterministic for the same input & level, so the layer might have been converted already
+	if _, err = c.store.GetLayer(ctx, digest.String()); err == nil {
+		return zstdDesc, nil
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("ERR_ZSTD_COMPRESS: %s: %w", desc.Digest, err)
+	}
+
+	id, err := core_types.CreateIdentifier()
+	if err != nil {
+		return nil, err
+	}
+
+	backend, storage := dfs.ForNamespace(c.dfs, namespace)
+	key := core_types.GetLayerIdentifier(id)
+	dfsLink, err := dfs.CopyBlob(ctx, storage, key, digest.String(), tmp, int(size), dfs.ChunkSize(storage))
+	if err != nil {
+		return nil, fmt.Errorf("ERR_DFS_UPLOAD: %s: %w", digest, err)
+	}
+
+	txn, err := c.store.NewTxn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.store.SetLayer(ctx, txn, &types.ContainerImageLayer{
+		ID:          id,
+		Digest:      digest.String(),
+		MediaType:   img_spec_v1.MediaTypeImageLayerZstd,
+		DFSLink:     dfsLink,
+		Backend:     backend,
+		Size:        size,
+		Compression: types.LayerCompressionZstd,
+	})
+	if err != nil {
+		_ = c.store.Abort(ctx, txn)
+		return nil, err
+	}
+
+	if err = c.store.Commit(ctx, txn); err != nil {
+		return nil, err
+	}
+
+	return zstdDesc, nil
+}
+
+// getPlatform reads the platform from the image config, so that clients can pick the right manifest from the index
+func (c *converter) getPlatform(ctx context.Context, manifest *types.ImageManifest) (*img_spec_v1.Platform, error) {
+	if manifest.Config == nil || manifest.Config.Digest == "" {
+		return nil, fmt.Errorf("ERR_MISSING_IMAGE_CONFIG")
+	}
+
+	layer, err := c.store.GetLayer(ctx, manifest.Config.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := dfs.ForLayer(c.dfs, layer).Download(ctx, layer.DFSLink)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_DFS_DOWNLOAD_CONFIG: %w", err)
+	}
+	defer blob.Close()
+
+	var imageConfig img_spec_v1.Image
+	if err = json.NewDecoder(blob).Decode(&imageConfig); err != nil {
+		return nil, fmt.Errorf("ERR_DECODE_IMAGE_CONFIG: %w", err)
+	}
+
+	return &img_spec_v1.Platform{
+		Architecture: imageConfig.Architecture,
+		OS:           imageConfig.OS,
+		OSVersion:    imageConfig.OSVersion,
+		OSFeatures:   imageConfig.OSFeatures,
+		Variant:      imageConfig.Variant,
+	}, nil
+}
+
+func (c *converter) zstdLevel() int {
+	if c.config.ZstdLevel < 1 {
+		return 3
+	}
+
+	return c.config.ZstdLevel
+}
+
+// setManifestIdentity assigns a new id and the digest of the serialised manifest (the same bytes the registry serves
+// on pull). The manifest is referenced by its digest unless a tag is given
+func setManifestIdentity(manifest *types.ImageManifest, tag string) (*img_spec_v1.Descriptor, error) {
+	id, err := core_types.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	bz := manifest.ToOCISubject()
+	digest := oci_digest.FromBytes(bz)
+
+	manifest.ID = id
+	manifest.Digest = digest.String()
+	manifest.Reference = digest.String()
+	if tag != "" {
+		manifest.Reference = tag
+	}
+
+	return &img_spec_v1.Descriptor{
+		MediaType: manifest.MediaType,
+		Digest:    digest,
+		Size:      int64(len(bz)),
+	}, nil
+}
@@ -6,8 +6,12 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/containerish/OpenRegistry/dfs"
+	registry_v2 "github.com/containerish/OpenRegistry/registry/v2"
+	"github.com/containerish/OpenRegistry/store/v1/permissions"
 	"github.com/containerish/OpenRegistry/store/v1/registry"
 	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/store/v1/users"
 	"github.com/containerish/OpenRegistry/telemetry"
 	"github.com/labstack/echo/v4"
 )
@@ -20,17 +24,42 @@ type Extenion interface {
 	GetUserCatalog(ctx echo.Context) error
 	AddRepositoryToFavorites(ctx echo.Context) error
 	RemoveRepositoryFromFavorites(ctx echo.Context) error
+	ListLayerFiles(ctx echo.Context) error
+	GetLayerFile(ctx echo.Context) error
+	ImageDiff(ctx echo.Context) error
+	ImportImageArchive(ctx echo.Context) error
+	ImportPublishedImage(ctx echo.Context) error
+	PromoteImage(ctx echo.Context) error
+	ListAuditEvents(ctx echo.Context) error
+	ListTagHistory(ctx echo.Context) error
+	RollbackTag(ctx echo.Context) error
 }
 
 type extension struct {
-	store  registry.RegistryStore
-	logger telemetry.Logger
+	store            registry.RegistryStore
+	usersStore       users.UserStore
+	permissionsStore permissions.PermissionsStore
+	dfs              dfs.DFS
+	logger           telemetry.Logger
+	// manifestCache is told about the tags moved by promotion, rollback and imports
+	manifestCache registry_v2.ManifestCacheInvalidator
 }
 
-func New(store registry.RegistryStore, logger telemetry.Logger) Extenion {
+func New(
+	store registry.RegistryStore,
+	usersStore users.UserStore,
+	permissionsStore permissions.PermissionsStore,
+	dfs dfs.DFS,
+	logger telemetry.Logger,
+	manifestCache registry_v2.ManifestCacheInvalidator,
+) Extenion {
 	return &extension{
-		store:  store,
-		logger: logger,
+		store:            store,
+		usersStore:       usersStore,
+		permissionsStore: permissionsStore,
+		dfs:              dfs,
+		logger:           logger,
+		manifestCache:    manifestCache,
 	}
 }
 
@@ -160,6 +189,7 @@ func (ext *extension) RepositoryDetail(ctx echo.Context) error {
 		return echoErr
 	}
 
+	types.SetManifestsCompression(repository.ImageManifests)
 	echoErr := ctx.JSON(http.StatusOK, repository)
 	ext.logger.Log(ctx, echoErr).Send()
 	return echoErr
@@ -0,0 +1,405 @@
+package extensions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/registry/v2"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+const opaqueWhiteout = whiteoutPrefix + whiteoutPrefix + ".opq"
+
+type (
+	ImageDiff struct {
+		From   *ImageDiffTarget `json:"from"`
+		To     *ImageDiffTarget `json:"to"`
+		Layers *LayerDiff       `json:"layers"`
+		Config *ConfigDiff      `json:"config"`
+		// Files is left out when the file level diff is skipped with files=false
+		Files *FileDiff `json:"files,omitempty"`
+	}
+
+	ImageDiffTarget struct {
+		Reference string `json:"reference"`
+		Digest    string `json:"digest"`
+		Size      int64  `json:"size"`
+	}
+
+	LayerDiff struct {
+		Added       []*img_spec_v1.Descriptor `json:"added"`
+		Removed     []*img_spec_v1.Descriptor `json:"removed"`
+		Shared      []*img_spec_v1.Descriptor `json:"shared"`
+		AddedSize   int64                     `json:"added_size"`
+		RemovedSize int64                     `json:"removed_size"`
+		SharedSize  int64                     `json:"shared_size"`
+	}
+
+	ConfigDiff struct {
+		Env        *MapDiff        `json:"env"`
+		Labels     *MapDiff        `json:"labels"`
+		Entrypoint *StringListDiff `json:"entrypoint,omitempty"`
+		Cmd        *StringListDiff `json:"cmd,omitempty"`
+		WorkingDir *StringDiff     `json:"working_dir,omitempty"`
+		User       *StringDiff     `json:"user,omitempty"`
+	}
+
+	MapDiff struct {
+		Added   map[string]string    `json:"added"`
+		Removed map[string]string    `json:"removed"`
+		Changed map[string][2]string `json:"changed"`
+	}
+
+	StringListDiff struct {
+		From []string `json:"from"`
+		To   []string `json:"to"`
+	}
+
+	StringDiff struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+
+	FileDiff struct {
+		Added    []*types.LayerFileEntry `json:"added"`
+		Removed  []*types.LayerFileEntry `json:"removed"`
+		Modified []*types.LayerFileEntry `json:"modified"`
+	}
+)
+
+// ImageDiff compares two tags or digests of the same repository
+// GET /v2/ext/repository/<name>/diff?from=v1&to=v2&files=false
+func (ext *extension) ImageDiff(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	fromRef := ctx.QueryParam("from")
+	toRef := ctx.QueryParam("to")
+	// the file diff reads the index of every layer in both images, which can mean downloading all of them, so
+	// callers that only need the layers and the config can skip it
+	withFiles := ctx.QueryParam("files") != "false"
+
+	if fromRef == "" || toRef == "" {
+		err := fmt.Errorf("from and to query params are required")
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	fromManifest, err := ext.store.GetManifestByReference(ctx.Request().Context(), namespace, fromRef)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":     err.Error(),
+			"reference": fromRef,
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	toManifest, err := ext.store.GetManifestByReference(ctx.Request().Context(), namespace, toRef)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":     err.Error(),
+			"reference": toRef,
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	if registry.IsImageIndex(fromManifest.MediaType) || registry.IsImageIndex(toManifest.MediaType) {
+		err = fmt.Errorf("diff is not supported for image indexes, use the digest of a platform specific manifest")
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	diff := &ImageDiff{
+		From: &ImageDiffTarget{
+			Reference: fromRef,
+			Digest:    fromManifest.Digest,
+			Size:      fromManifest.Size,
+		},
+		To: &ImageDiffTarget{
+			Reference: toRef,
+			Digest:    toManifest.Digest,
+			Size:      toManifest.Size,
+		},
+		Layers: diffLayers(fromManifest.Layers, toManifest.Layers),
+	}
+
+	diff.Config, err = ext.diffImageConfig(ctx.Request().Context(), fromManifest, toManifest)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error reading image config",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	if withFiles {
+		diff.Files, err = ext.diffImageFiles(ctx.Request().Context(), namespace, fromManifest, toManifest)
+		if err != nil {
+			echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+				"error":   err.Error(),
+				"message": "error reading layer contents",
+			})
+			ext.logger.Log(ctx, err).Send()
+			return echoErr
+		}
+	}
+
+	echoErr := ctx.JSON(http.StatusOK, diff)
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+func diffLayers(from, to types.ImageManifestLayers) *LayerDiff {
+	diff := &LayerDiff{
+		Added:   make([]*img_spec_v1.Descriptor, 0),
+		Removed: make([]*img_spec_v1.Descriptor, 0),
+		Shared:  make([]*img_spec_v1.Descriptor, 0),
+	}
+
+	fromDigests := make(map[string]struct{}, len(from))
+	for _, l := range from {
+		fromDigests[l.Digest.String()] = struct{}{}
+	}
+	toDigests := make(map[string]struct{}, len(to))
+	for _, l := range to {
+		toDigests[l.Digest.String()] = struct{}{}
+	}
+
+	for _, l := range to {
+		if _, ok := fromDigests[l.Digest.String()]; ok {
+			diff.Shared = append(diff.Shared, l)
+			diff.SharedSize += l.Size
+			continue
+		}
+		diff.Added = append(diff.Added, l)
+		diff.AddedSize += l.Size
+	}
+
+	for _, l := range from {
+		if _, ok := toDigests[l.Digest.String()]; !ok {
+			diff.Removed = append(diff.Removed, l)
+			diff.RemovedSize += l.Size
+		}
+	}
+
+	return diff
+}
+
+func (ext *extension) diffImageConfig(ctx context.Context, from, to *types.ImageManifest) (*ConfigDiff, error) {
+	fromConfig, err := ext.getImageConfig(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	toConfig, err := ext.getImageConfig(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &ConfigDiff{
+		Env:    diffMaps(envToMap(fromConfig.Config.Env), envToMap(toConfig.Config.Env)),
+		Labels: diffMaps(fromConfig.Config.Labels, toConfig.Config.Labels),
+	}
+
+	if !slices.Equal(fromConfig.Config.Entrypoint, toConfig.Config.Entrypoint) {
+		diff.Entrypoint = &StringListDiff{From: fromConfig.Config.Entrypoint, To: toConfig.Config.Entrypoint}
+	}
+	if !slices.Equal(fromConfig.Config.Cmd, toConfig.Config.Cmd) {
+		diff.Cmd = &StringListDiff{From: fromConfig.Config.Cmd, To: toConfig.Config.Cmd}
+	}
+	if fromConfig.Config.WorkingDir != toConfig.Config.WorkingDir {
+		diff.WorkingDir = &StringDiff{From: fromConfig.Config.WorkingDir, To: toConfig.Config.WorkingDir}
+	}
+	if fromConfig.Config.User != toConfig.Config.User {
+		diff.User = &StringDiff{From: fromConfig.Config.User, To: toConfig.Config.User}
+	}
+
+	return diff, nil
+}
+
+// getImageConfig fetches the config blob for the manifest. Config blobs are pushed like any other blob, so they are
+// stored alongside the layers
+func (ext *extension) getImageConfig(ctx context.Context, manifest *types.ImageManifest) (*img_spec_v1.Image, error) {
+	if manifest.Config == nil || manifest.Config.Digest == "" {
+		return &img_spec_v1.Image{}, nil
+	}
+
+	blob, err := ext.store.GetLayer(ctx, manifest.Config.Digest.String())
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := dfs.ForLayer(ext.dfs, blob).Download(ctx, blob.DFSLink)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_DFS_DOWNLOAD_CONFIG: %w", err)
+	}
+	defer rc.Close()
+
+	var config img_spec_v1.Image
+	if err = json.NewDecoder(rc).Decode(&config); err != nil {
+		return nil, fmt.Errorf("ERR_DECODE_IMAGE_CONFIG: %w", err)
+	}
+
+	return &config, nil
+}
+
+func (ext *extension) diffImageFiles(
+	ctx context.Context,
+	namespace string,
+	from, to *types.ImageManifest,
+) (*FileDiff, error) {
+	fromFS, err := ext.flattenImageFiles(ctx, namespace, from.Layers)
+	if err != nil {
+		return nil, err
+	}
+
+	toFS, err := ext.flattenImageFiles(ctx, namespace, to.Layers)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &FileDiff{
+		Added:    make([]*types.LayerFileEntry, 0),
+		Removed:  make([]*types.LayerFileEntry, 0),
+		Modified: make([]*types.LayerFileEntry, 0),
+	}
+
+	for p, entry := range toFS {
+		old, ok := fromFS[p]
+		if !ok {
+			diff.Added = append(diff.Added, entry)
+			continue
+		}
+
+		if old.Type != entry.Type || old.Size != entry.Size || old.Mode != entry.Mode ||
+			old.LinkTarget != entry.LinkTarget {
+			diff.Modified = append(diff.Modified, entry)
+		}
+	}
+
+	for p, entry := range fromFS {
+		if _, ok := toFS[p]; !ok {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+
+	sortLayerFileEntries(diff.Added)
+	sortLayerFileEntries(diff.Removed)
+	sortLayerFileEntries(diff.Modified)
+	return diff, nil
+}
+
+// flattenImageFiles applies the layers on top of each other, the same way a container runtime would, and returns
+// the final set of files (directories are left out since they're mostly noise in a diff)
+func (ext *extension) flattenImageFiles(
+	ctx context.Context,
+	namespace string,
+	layers types.ImageManifestLayers,
+) (map[string]*types.LayerFileEntry, error) {
+	fs := make(map[string]*types.LayerFileEntry)
+
+	for _, layer := range layers {
+		index, err := ext.getOrCreateLayerFileIndex(ctx, namespace, layer.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("ERR_LAYER_FILE_INDEX: %s: %w", layer.Digest, err)
+		}
+
+		// whiteouts only apply to the lower layers, so we process them before adding this layer's files
+		for _, entry := range index.Entries {
+			if !entry.Whiteout {
+				continue
+			}
+
+			dir, base := path.Split(entry.Path)
+			if base == opaqueWhiteout {
+				removeFilesUnder(fs, path.Clean(dir))
+				continue
+			}
+
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			delete(fs, target)
+			removeFilesUnder(fs, target)
+		}
+
+		for _, entry := range index.Entries {
+			if entry.Whiteout || entry.Type == types.LayerFileTypeDir {
+				continue
+			}
+			fs[entry.Path] = entry
+		}
+	}
+
+	return fs, nil
+}
+
+func removeFilesUnder(fs map[string]*types.LayerFileEntry, dir string) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for p := range fs {
+		if strings.HasPrefix(p, prefix) {
+			delete(fs, p)
+		}
+	}
+}
+
+func sortLayerFileEntries(entries []*types.LayerFileEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+}
+
+func envToMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, e := range env {
+		key, value, _ := strings.Cut(e, "=")
+		m[key] = value
+	}
+
+	return m
+}
+
+func diffMaps(from, to map[string]string) *MapDiff {
+	diff := &MapDiff{
+		Added:   make(map[string]string),
+		Removed: make(map[string]string),
+		Changed: make(map[string][2]string),
+	}
+
+	for k, v := range to {
+		old, ok := from[k]
+		if !ok {
+			diff.Added[k] = v
+			continue
+		}
+		if old != v {
+			diff.Changed[k] = [2]string{old, v}
+		}
+	}
+
+	for k, v := range from {
+		if _, ok := to[k]; !ok {
+			diff.Removed[k] = v
+		}
+	}
+
+	return diff
+}
@@ -0,0 +1,185 @@
+package extensions
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/registry/v2"
+	"github.com/containerish/OpenRegistry/registry/v2/layout"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// MaxImageArchiveSize is the largest archive accepted by ImportImageArchive, both for the request body and for the
+// files extracted from it
+const MaxImageArchiveSize int64 = 10 << 30
+
+// ImportImageArchive accepts a `docker save` or an OCI image layout tarball and pushes all the images in it to the
+// repository. The archive can either be the raw request body or a multipart form file named "file"
+// POST /v2/ext/repository/<name>/import
+func (ext *extension) ImportImageArchive(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	if err := ext.canPushToNamespace(ctx, namespace); err != nil {
+		echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+			"error":   err.Error(),
+			"message": "missing push permissions for the repository",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	ctx.Request().Body = http.MaxBytesReader(ctx.Response(), ctx.Request().Body, MaxImageArchiveSize)
+	archive, err := ext.getArchiveFromRequest(ctx)
+	if err != nil {
+		echoErr := ctx.JSON(archiveErrorStatus(err), echo.Map{
+			"error":   err.Error(),
+			"message": "error reading image archive from the request",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+	defer archive.Close()
+
+	reader, err := layout.NewArchiveReader(archive, MaxImageArchiveSize)
+	if err != nil {
+		echoErr := ctx.JSON(archiveErrorStatus(err), echo.Map{
+			"error":   err.Error(),
+			"message": "invalid image archive, expected a docker save or an OCI image layout tarball",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+	defer reader.Close()
+
+	importer := layout.
+		NewImporter(ext.store, ext.usersStore, ext.dfs, io.Discard).
+		WithActor(registry.GetActorID(ctx), ctx.Request().UserAgent())
+	images, err := importer.Import(ctx.Request().Context(), reader, namespace)
+	// tags imported before a failure have been moved as well
+	ext.manifestCache.InvalidateManifestCache(namespace)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error importing images from the archive",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	echoErr := ctx.JSON(http.StatusCreated, echo.Map{
+		"images": images,
+	})
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+// ImportPublishedImage pulls an image that another OpenRegistry node published to IPFS, straight from IPFS, and pushes
+// it to the repository. The image keeps its tag, the namespace it was published from is ignored
+// POST /v2/ext/repository/<name>/import/ipfs/<cid>
+func (ext *extension) ImportPublishedImage(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	if err := ext.canPushToNamespace(ctx, namespace); err != nil {
+		echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+			"error":   err.Error(),
+			"message": "missing push permissions for the repository",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	if _, ok := dfs.As[dfs.ImagePublisher](ext.dfs); !ok {
+		err := fmt.Errorf("ERR_IPFS_NOT_ENABLED: the registry doesn't store blobs on IPFS")
+		echoErr := ctx.JSON(http.StatusNotImplemented, echo.Map{
+			"error":   err.Error(),
+			"message": "importing images from IPFS needs the IPFS storage backend",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	reader, err := layout.NewPublishedImageReader(ext.dfs, ctx.Param("cid"))
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "error fetching the image from IPFS",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+	defer reader.Close()
+
+	importer := layout.
+		NewImporter(ext.store, ext.usersStore, ext.dfs, io.Discard).
+		WithActor(registry.GetActorID(ctx), ctx.Request().UserAgent())
+	images, err := importer.Import(ctx.Request().Context(), reader, namespace)
+	// tags imported before a failure have been moved as well
+	ext.manifestCache.InvalidateManifestCache(namespace)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error importing the image from IPFS",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	echoErr := ctx.JSON(http.StatusCreated, echo.Map{
+		"images": images,
+	})
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+// archiveErrorStatus returns 413 for archives over MaxImageArchiveSize and 400 for everything else
+func archiveErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) || errors.Is(err, layout.ErrArchiveTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	return http.StatusBadRequest
+}
+
+func (ext *extension) getArchiveFromRequest(ctx echo.Context) (io.ReadCloser, error) {
+	if !strings.HasPrefix(ctx.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		return ctx.Request().Body, nil
+	}
+
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+
+	return fileHeader.Open()
+}
+
+// canPushToNamespace makes sure that the user is either the owner of the namespace or has push permissions for it.
+// The permissions middleware lets all requests for public repositories through, which is fine for pulls but not for
+// endpoints that write to the repository
+func (ext *extension) canPushToNamespace(ctx echo.Context, namespace string) error {
+	user, ok := ctx.Get(string(types.UserContextKey)).(*types.User)
+	if !ok {
+		return fmt.Errorf("authentication details are missing")
+	}
+
+	if username, _, _ := strings.Cut(namespace, "/"); user.Username == username {
+		return nil
+	}
+
+	permissions, ok := ctx.Get(string(types.UserPermissionsContextKey)).(*types.Permissions)
+	if ok && (permissions.IsAdmin || permissions.Push) {
+		return nil
+	}
+
+	return fmt.Errorf("user %s is not allowed to push to %s", user.Username, namespace)
+}
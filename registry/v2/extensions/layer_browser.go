@@ -0,0 +1,259 @@
+package extensions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	oci_digest "github.com/opencontainers/go-digest"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/registry/v2"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// ListLayerFiles returns a paginated listing of the files inside a layer, without the need to pull it
+// GET /v2/ext/repository/<name>/layers/<digest>/files?n=100&last=0&prefix=/etc
+func (ext *extension) ListLayerFiles(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	digest := ctx.Param("digest")
+	prefix := ctx.QueryParam("prefix")
+	pageSize, offset, err := parsePaginationParams(ctx)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	if _, err = oci_digest.Parse(digest); err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "invalid layer digest",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	index, err := ext.getOrCreateLayerFileIndex(ctx.Request().Context(), namespace, digest)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":   err.Error(),
+			"message": "error reading layer contents",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	entries := index.Entries
+	if prefix != "" {
+		prefix = normaliseLayerPath(prefix)
+		filtered := make([]*types.LayerFileEntry, 0)
+		for _, e := range entries {
+			if e.Path == prefix || strings.HasPrefix(e.Path, strings.TrimSuffix(prefix, "/")+"/") {
+				filtered = append(filtered, e)
+			}
+		}
+		entries = filtered
+	}
+
+	total := len(entries)
+	if offset > total {
+		offset = total
+	}
+	entries = entries[offset:]
+	if pageSize > 0 && pageSize < len(entries) {
+		entries = entries[:pageSize]
+	}
+
+	echoErr := ctx.JSON(http.StatusOK, echo.Map{
+		"digest":      index.Digest,
+		"compression": index.Compression,
+		"files":       entries,
+		"total":       total,
+	})
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+// GetLayerFile returns the contents of a single file from a layer, as long as it's under MaxLayerFileContentSize
+// GET /v2/ext/repository/<name>/layers/<digest>/file?path=/etc/os-release
+func (ext *extension) GetLayerFile(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	digest := ctx.Param("digest")
+	filePath := ctx.QueryParam("path")
+	if filePath == "" {
+		err := fmt.Errorf("path query param must not be empty")
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	index, err := ext.getOrCreateLayerFileIndex(ctx.Request().Context(), namespace, digest)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":   err.Error(),
+			"message": "error reading layer contents",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	// the index answers for missing files, directories, links and files over the limit, so the layer is only
+	// downloaded to read the contents of a regular file
+	content, entry, err := ext.readLayerFile(ctx.Request().Context(), index, filePath)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ErrLayerFileNotFound) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, ErrLayerFileTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+
+		echoErr := ctx.JSON(status, echo.Map{
+			"error": err.Error(),
+			"path":  filePath,
+			"limit": MaxLayerFileContentSize,
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	// directories, symlinks, etc don't have any content, so we return the entry metadata instead
+	if entry.Type != types.LayerFileTypeRegular {
+		echoErr := ctx.JSON(http.StatusOK, entry)
+		ext.logger.Log(ctx, nil).Send()
+		return echoErr
+	}
+
+	ctx.Response().Header().Set("X-Layer-File-Mode", entry.Mode)
+	ctx.Response().Header().Set("X-Layer-File-Path", entry.Path)
+	echoErr := ctx.Blob(http.StatusOK, echo.MIMEOctetStream, content)
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+// readLayerFile looks the file up in the layer's index and only downloads the layer for the contents of regular files
+// that are under MaxLayerFileContentSize
+func (ext *extension) readLayerFile(
+	ctx context.Context,
+	index *types.LayerFileIndex,
+	filePath string,
+) ([]byte, *types.LayerFileEntry, error) {
+	filePath = normaliseLayerPath(filePath)
+	var entry *types.LayerFileEntry
+	for _, e := range index.Entries {
+		if e.Path == filePath {
+			entry = e
+		}
+	}
+
+	if entry == nil {
+		return nil, nil, ErrLayerFileNotFound
+	}
+
+	if entry.Type != types.LayerFileTypeRegular {
+		return nil, entry, nil
+	}
+
+	if entry.Size > MaxLayerFileContentSize {
+		return nil, entry, ErrLayerFileTooLarge
+	}
+
+	layer, err := ext.store.GetLayer(ctx, index.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blob, err := dfs.ForLayer(ext.dfs, layer).Download(ctx, layer.DFSLink)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ERR_DFS_DOWNLOAD_LAYER: %w", err)
+	}
+	defer blob.Close()
+
+	return ReadFileFromLayer(blob, filePath, MaxLayerFileContentSize)
+}
+
+// getOrCreateLayerFileIndex returns the cached file index for the layer if it exists, otherwise it streams the layer
+// from DFS, builds the index and caches it. Layers are stored once for the whole registry, so the digest must be
+// referenced by a manifest in the namespace, which is the only repository the request was authorized for
+func (ext *extension) getOrCreateLayerFileIndex(
+	ctx context.Context,
+	namespace string,
+	digest string,
+) (*types.LayerFileIndex, error) {
+	referenced, err := ext.store.IsBlobReferenced(ctx, namespace, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if !referenced {
+		return nil, fmt.Errorf("%w: %s is not a layer of %s", ErrLayerNotInRepository, digest, namespace)
+	}
+
+	if index, err := ext.store.GetLayerFileIndex(ctx, digest); err == nil {
+		return index, nil
+	}
+
+	layer, err := ext.store.GetLayer(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := dfs.ForLayer(ext.dfs, layer).Download(ctx, layer.DFSLink)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_DFS_DOWNLOAD_LAYER: %w", err)
+	}
+	defer blob.Close()
+
+	index, err := BuildLayerFileIndex(layer.Digest, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	// failing to cache the index isn't fatal, we'll just compute it again on the next request
+	if err = ext.store.SetLayerFileIndex(ctx, index); err != nil {
+		ext.logger.Debug().Err(err).Str("digest", digest).Msg("error caching layer file index")
+	}
+
+	return index, nil
+}
+
+func parsePaginationParams(ctx echo.Context) (int, int, error) {
+	var pageSize, offset int
+
+	if n := ctx.QueryParam("n"); n != "" {
+		ps, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, 0, err
+		}
+		pageSize = ps
+	}
+
+	if last := ctx.QueryParam("last"); last != "" {
+		o, err := strconv.Atoi(last)
+		if err != nil {
+			return 0, 0, err
+		}
+		offset = o
+	}
+
+	if pageSize < 0 || offset < 0 {
+		return 0, 0, fmt.Errorf("page size and offset must not be negative")
+	}
+
+	return pageSize, offset, nil
+}
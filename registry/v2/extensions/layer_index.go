@@ -0,0 +1,177 @@
+package extensions
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+const (
+	LayerCompressionGzip = "gzip"
+	LayerCompressionZstd = "zstd"
+	LayerCompressionNone = "none"
+
+	// MaxLayerFileContentSize is the size of the largest file that can be read via the layer browsing API
+	MaxLayerFileContentSize = 1024 * 1024 * 2
+
+	whiteoutPrefix = ".wh."
+)
+
+var (
+	ErrLayerFileNotFound = errors.New("ERR_LAYER_FILE_NOT_FOUND")
+	ErrLayerFileTooLarge = errors.New("ERR_LAYER_FILE_TOO_LARGE")
+	// ErrLayerNotInRepository is returned for layers that no manifest of the repository references
+	ErrLayerNotInRepository = errors.New("ERR_LAYER_NOT_IN_REPOSITORY")
+)
+
+// DecompressLayer sniffs the compression from the magic bytes of the blob instead of trusting the media type, since
+// plenty of clients push gzip layers with the uncompressed tar media type (and vice versa)
+func DecompressLayer(r io.Reader) (io.ReadCloser, string, error) {
+	gzipMagic := []byte{0x1f, 0x8b}
+	zstdMagic := []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, "", fmt.Errorf("ERR_READ_LAYER_HEADER: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("ERR_GZIP_READER: %w", err)
+		}
+		return gz, LayerCompressionGzip, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, "", fmt.Errorf("ERR_ZSTD_READER: %w", err)
+		}
+		return zr.IOReadCloser(), LayerCompressionZstd, nil
+	default:
+		return io.NopCloser(br), LayerCompressionNone, nil
+	}
+}
+
+// BuildLayerFileIndex walks the (possibly compressed) layer tarball and returns a listing of all the entries in it
+func BuildLayerFileIndex(digest string, r io.Reader) (*types.LayerFileIndex, error) {
+	rc, compression, err := DecompressLayer(r)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	index := &types.LayerFileIndex{
+		Digest:      digest,
+		Compression: compression,
+		Entries:     make([]*types.LayerFileEntry, 0),
+	}
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ERR_READ_LAYER_TAR: %w", err)
+		}
+
+		entry := newLayerFileEntry(hdr)
+		index.Entries = append(index.Entries, entry)
+		index.TotalSize += entry.Size
+		if entry.Type == types.LayerFileTypeRegular {
+			index.TotalFiles++
+		}
+	}
+
+	return index, nil
+}
+
+// ReadFileFromLayer returns the contents of the file at filePath from the layer. Files larger than limit
+// are rejected with ErrLayerFileTooLarge
+func ReadFileFromLayer(r io.Reader, filePath string, limit int64) ([]byte, *types.LayerFileEntry, error) {
+	rc, _, err := DecompressLayer(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+
+	filePath = normaliseLayerPath(filePath)
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, nil, ErrLayerFileNotFound
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("ERR_READ_LAYER_TAR: %w", err)
+		}
+
+		if normaliseLayerPath(hdr.Name) != filePath {
+			continue
+		}
+
+		entry := newLayerFileEntry(hdr)
+		if entry.Type != types.LayerFileTypeRegular {
+			return nil, entry, nil
+		}
+
+		if hdr.Size > limit {
+			return nil, entry, ErrLayerFileTooLarge
+		}
+
+		bz, err := io.ReadAll(io.LimitReader(tr, limit))
+		if err != nil {
+			return nil, nil, fmt.Errorf("ERR_READ_LAYER_FILE: %w", err)
+		}
+
+		return bz, entry, nil
+	}
+}
+
+func newLayerFileEntry(hdr *tar.Header) *types.LayerFileEntry {
+	entry := &types.LayerFileEntry{
+		Path: normaliseLayerPath(hdr.Name),
+		Mode: hdr.FileInfo().Mode().String(),
+		Size: hdr.Size,
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		entry.Type = types.LayerFileTypeRegular
+	case tar.TypeDir:
+		entry.Type = types.LayerFileTypeDir
+	case tar.TypeSymlink:
+		entry.Type = types.LayerFileTypeSymlink
+		entry.LinkTarget = hdr.Linkname
+	case tar.TypeLink:
+		entry.Type = types.LayerFileTypeHardlink
+		entry.LinkTarget = normaliseLayerPath(hdr.Linkname)
+	default:
+		entry.Type = types.LayerFileTypeOther
+	}
+
+	// whiteout files mark deletion of the file with the same name (without the prefix) from the lower layers
+	if base := path.Base(entry.Path); strings.HasPrefix(base, whiteoutPrefix) {
+		entry.Whiteout = true
+	}
+
+	return entry
+}
+
+// normaliseLayerPath converts all the different flavours of tar entry names (./etc, etc/, /etc) to /etc
+func normaliseLayerPath(p string) string {
+	return path.Clean("/" + strings.TrimPrefix(p, "./"))
+}
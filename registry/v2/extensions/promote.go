@@ -0,0 +1,350 @@
+package extensions
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	oci_digest "github.com/opencontainers/go-digest"
+	"github.com/uptrace/bun"
+
+	"github.com/containerish/OpenRegistry/registry/v2"
+	"github.com/containerish/OpenRegistry/registry/v2/layout"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// maxIndexDepth guards against cycles when copying image indexes that refer to other indexes
+const maxIndexDepth = 4
+
+type (
+	PromoteImageRequest struct {
+		// Source is the image to promote, either <username>/<imagename>:<tag> or <username>/<imagename>@<digest>
+		Source string `json:"source"`
+		// Tag is the tag to create in the target repository. Defaults to the source tag
+		Tag string `json:"tag"`
+	}
+
+	PromoteImageResponse struct {
+		Source    string `json:"source"`
+		Namespace string `json:"namespace"`
+		Tag       string `json:"tag"`
+		Digest    string `json:"digest"`
+		// Manifests is the number of manifests copied, i.e, the index itself and all of its children
+		Manifests int `json:"manifests"`
+	}
+)
+
+// PromoteImage copies a manifest (and all the child manifests for an image index) from a tag or digest to a tag in
+// this repository. Layers are stored once per digest for the whole registry, so no blobs are copied or uploaded.
+// POST /v2/ext/repository/<name>/promote
+func (ext *extension) PromoteImage(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	if err := ext.canPushToNamespace(ctx, namespace); err != nil {
+		echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+			"error":   err.Error(),
+			"message": "missing push permissions for the repository",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	var body PromoteImageRequest
+	if err := ctx.Bind(&body); err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "invalid request body",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	sourceNamespace, sourceRef, err := parsePromotionSource(body.Source)
+	if err == nil && body.Tag == "" {
+		body.Tag = sourceRef
+	}
+	if err == nil {
+		err = validateTag(body.Tag)
+	}
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "source must be <username>/<imagename>:<tag> or <username>/<imagename>@<digest>",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	if err = ext.canPullFromNamespace(ctx, sourceNamespace); err != nil {
+		echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+			"error":   err.Error(),
+			"message": "missing pull permissions for the source repository",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	source, err := ext.store.GetManifestByReference(ctx.Request().Context(), sourceNamespace, sourceRef)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":   err.Error(),
+			"message": "source image not found",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	target, err := layout.GetOrCreateRepository(ctx.Request().Context(), ext.store, ext.usersStore, namespace)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error getting the target repository",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	copied, err := ext.promoteManifest(ctx, sourceNamespace, source, target, namespace, body.Tag)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error promoting the image",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	echoErr := ctx.JSON(http.StatusCreated, PromoteImageResponse{
+		Source:    body.Source,
+		Namespace: namespace,
+		Tag:       body.Tag,
+		Digest:    source.Digest,
+		Manifests: copied,
+	})
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+// ListAuditEvents returns the audit history of the repository, newest first
+// GET /v2/ext/repository/<name>/audit?n=100&last=0
+func (ext *extension) ListAuditEvents(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	pageSize, offset, err := parsePaginationParams(ctx)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "invalid pagination params",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	repository, err := ext.store.GetRepositoryByNamespace(ctx.Request().Context(), namespace)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":   err.Error(),
+			"message": "repository not found",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	events, total, err := ext.store.ListAuditEvents(ctx.Request().Context(), repository.ID, pageSize, offset)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error listing audit events",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	echoErr := ctx.JSON(http.StatusOK, echo.Map{
+		"events": events,
+		"total":  total,
+	})
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+// promoteManifest writes the copies and the audit event in a single transaction, so a failed promotion doesn't leave
+// half of an index behind
+func (ext *extension) promoteManifest(
+	ctx echo.Context,
+	sourceNamespace string,
+	source *types.ImageManifest,
+	target *types.ContainerImageRepository,
+	namespace string,
+	tag string,
+) (int, error) {
+	reqCtx := ctx.Request().Context()
+	txn, err := ext.store.NewTxn(reqCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	// only the tag moves, the child manifests of an index are copied by their digest
+	tagged := &types.ImageManifest{RepositoryID: target.ID, Reference: tag, Digest: source.Digest}
+	err = ext.store.AddTagHistory(reqCtx, txn, tagged, registry.GetActorID(ctx), ctx.Request().UserAgent())
+	if err != nil {
+		_ = ext.store.Abort(reqCtx, txn)
+		return 0, err
+	}
+
+	copied, err := ext.copyManifest(reqCtx, txn, sourceNamespace, source, target, tag, 0)
+	if err != nil {
+		_ = ext.store.Abort(reqCtx, txn)
+		return 0, err
+	}
+
+	event := &types.RepositoryAuditEvent{
+		Action:       types.AuditActionPromote,
+		Namespace:    namespace,
+		Reference:    tag,
+		Digest:       source.Digest,
+		ActorID:      registry.GetActorID(ctx),
+		RepositoryID: target.ID,
+		Metadata: map[string]string{
+			"source_namespace": sourceNamespace,
+			"source_reference": source.Reference,
+			"user_agent":       ctx.Request().UserAgent(),
+		},
+	}
+	if err = ext.store.AddAuditEvent(reqCtx, txn, event); err != nil {
+		_ = ext.store.Abort(reqCtx, txn)
+		return 0, err
+	}
+
+	if err = ext.store.Commit(reqCtx, txn); err != nil {
+		return 0, err
+	}
+
+	ext.manifestCache.InvalidateManifestCache(namespace)
+	return copied, nil
+}
+
+// copyManifest copies the manifest to the target repository under the given reference. For image indexes, the child
+// manifests are copied first, referenced by their digest
+func (ext *extension) copyManifest(
+	ctx context.Context,
+	txn *bun.Tx,
+	sourceNamespace string,
+	source *types.ImageManifest,
+	target *types.ContainerImageRepository,
+	reference string,
+	depth int,
+) (int, error) {
+	if depth > maxIndexDepth {
+		return 0, fmt.Errorf("ERR_INDEX_TOO_DEEP: %s", source.Digest)
+	}
+
+	copied := 0
+	for _, child := range source.Manifests {
+		childManifest, err := ext.store.GetManifestByReference(ctx, sourceNamespace, child.Digest.String())
+		if err != nil {
+			return 0, fmt.Errorf("ERR_GET_CHILD_MANIFEST: %s: %w", child.Digest, err)
+		}
+
+		n, err := ext.copyManifest(ctx, txn, sourceNamespace, childManifest, target, child.Digest.String(), depth+1)
+		if err != nil {
+			return 0, err
+		}
+		copied += n
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return 0, err
+	}
+
+	manifest := *source
+	manifest.ID = id
+	manifest.CreatedAt = time.Now()
+	manifest.RepositoryID = target.ID
+	manifest.OwnerID = target.OwnerID
+	manifest.Reference = reference
+	manifest.Repository = nil
+	manifest.User = nil
+
+	if err = ext.store.SetManifest(ctx, txn, &manifest); err != nil {
+		return 0, fmt.Errorf("ERR_SET_MANIFEST: %s: %w", reference, err)
+	}
+
+	return copied + 1, nil
+}
+
+// canPullFromNamespace is the pull counterpart of canPushToNamespace, for repositories other than the one in the URL
+func (ext *extension) canPullFromNamespace(ctx echo.Context, namespace string) error {
+	repository, err := ext.store.GetRepositoryByNamespace(ctx.Request().Context(), namespace)
+	if err != nil {
+		return err
+	}
+
+	if repository.Visibility == types.RepositoryVisibilityPublic {
+		return nil
+	}
+
+	user, ok := ctx.Get(string(types.UserContextKey)).(*types.User)
+	if !ok {
+		return fmt.Errorf("authentication details are missing")
+	}
+
+	if repository.OwnerID == user.ID {
+		return nil
+	}
+
+	permissions := ext.permissionsStore.GetUserPermissionsForNamespace(
+		ctx.Request().Context(),
+		namespace,
+		registry.GetActorID(ctx),
+	)
+	if permissions.IsAdmin || permissions.Pull {
+		return nil
+	}
+
+	return fmt.Errorf("user %s is not allowed to pull from %s", user.Username, namespace)
+}
+
+// parsePromotionSource splits the source into the namespace and the tag or digest
+func parsePromotionSource(source string) (string, string, error) {
+	if namespace, digest, ok := strings.Cut(source, "@"); ok {
+		if _, err := oci_digest.Parse(digest); err != nil {
+			return "", "", fmt.Errorf("ERR_INVALID_DIGEST: %w", err)
+		}
+		if _, _, err := types.SplitNamespace(namespace); err != nil {
+			return "", "", fmt.Errorf("ERR_INVALID_NAMESPACE: %w", err)
+		}
+		return namespace, digest, nil
+	}
+
+	// nested repository names (e.g, org/team/app:rc-42) are allowed, the tag is everything after the last ":"
+	idx := strings.LastIndex(source, ":")
+	if idx < 0 || idx < strings.LastIndex(source, "/") {
+		return "", "", fmt.Errorf("ERR_INVALID_SOURCE: %s", source)
+	}
+
+	if _, _, err := types.SplitNamespace(source[:idx]); err != nil {
+		return "", "", fmt.Errorf("ERR_INVALID_SOURCE: %w", err)
+	}
+
+	return source[:idx], source[idx+1:], nil
+}
+
+func validateTag(tag string) error {
+	if _, err := oci_digest.Parse(tag); err == nil {
+		return fmt.Errorf("ERR_MISSING_TAG: a tag is required when the source is a digest")
+	}
+
+	if !registry.IsValidTag(tag) {
+		return fmt.Errorf("ERR_INVALID_TAG: %s", tag)
+	}
+
+	return nil
+}
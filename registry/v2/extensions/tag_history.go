@@ -0,0 +1,250 @@
+package extensions
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	oci_digest "github.com/opencontainers/go-digest"
+
+	"github.com/containerish/OpenRegistry/registry/v2"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+type (
+	RollbackTagRequest struct {
+		Tag string `json:"tag"`
+		// Digest is the digest to move the tag to. Defaults to the digest the tag pointed to before its last move
+		Digest string `json:"digest"`
+	}
+
+	RollbackTagResponse struct {
+		Tag            string `json:"tag"`
+		Digest         string `json:"digest"`
+		PreviousDigest string `json:"previous_digest"`
+	}
+)
+
+// ListTagHistory returns every move of the tags in the repository, newest first. The tag query param limits the
+// history to a single tag
+// GET /v2/ext/repository/<name>/tags/history?tag=prod&n=100&last=0
+func (ext *extension) ListTagHistory(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	pageSize, offset, err := parsePaginationParams(ctx)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "invalid pagination params",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	repository, err := ext.store.GetRepositoryByNamespace(ctx.Request().Context(), namespace)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":   err.Error(),
+			"message": "repository not found",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	history, total, err := ext.store.ListTagHistory(
+		ctx.Request().Context(),
+		repository.ID,
+		ctx.QueryParam("tag"),
+		pageSize,
+		offset,
+	)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error listing tag history",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	echoErr := ctx.JSON(http.StatusOK, echo.Map{
+		"history": history,
+		"total":   total,
+	})
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+// RollbackTag moves a tag back to a digest from its history. Without a digest, the tag is moved to the digest it
+// pointed to before its last move, so rolling back twice returns the tag to where it started
+// POST /v2/ext/repository/<name>/tags/rollback
+func (ext *extension) RollbackTag(ctx echo.Context) error {
+	ctx.Set(types.HandlerStartTime, time.Now())
+
+	namespace := ctx.Get(string(registry.RegistryNamespace)).(string)
+	if err := ext.canPushToNamespace(ctx, namespace); err != nil {
+		echoErr := ctx.JSON(http.StatusForbidden, echo.Map{
+			"error":   err.Error(),
+			"message": "missing push permissions for the repository",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	var body RollbackTagRequest
+	err := ctx.Bind(&body)
+	if err == nil {
+		err = validateTag(body.Tag)
+	}
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "invalid request body",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	current, err := ext.store.GetManifestByReference(ctx.Request().Context(), namespace, body.Tag)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":   err.Error(),
+			"message": "tag not found",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	digest, err := ext.getRollbackDigest(ctx, current, body.Digest)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusConflict, echo.Map{
+			"error":   err.Error(),
+			"message": "no digest to roll the tag back to",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	target, err := ext.store.GetManifestByReference(ctx.Request().Context(), namespace, digest)
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusNotFound, echo.Map{
+			"error":   err.Error(),
+			"message": "manifest for the digest not found",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	if err = ext.moveTag(ctx, namespace, body.Tag, current, target); err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error":   err.Error(),
+			"message": "error rolling back the tag",
+		})
+		ext.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	echoErr := ctx.JSON(http.StatusOK, RollbackTagResponse{
+		Tag:            body.Tag,
+		Digest:         target.Digest,
+		PreviousDigest: current.Digest,
+	})
+	ext.logger.Log(ctx, nil).Send()
+	return echoErr
+}
+
+func (ext *extension) getRollbackDigest(ctx echo.Context, current *types.ImageManifest, digest string) (string, error) {
+	if digest == "" {
+		history, _, err := ext.store.ListTagHistory(
+			ctx.Request().Context(),
+			current.RepositoryID,
+			current.Reference,
+			1,
+			0,
+		)
+		if err != nil {
+			return "", err
+		}
+
+		if len(history) == 0 || history[0].OldDigest == "" {
+			return "", fmt.Errorf("ERR_NO_PREVIOUS_DIGEST: tag %s has never been moved", current.Reference)
+		}
+		digest = history[0].OldDigest
+	}
+
+	if _, err := oci_digest.Parse(digest); err != nil {
+		return "", fmt.Errorf("ERR_INVALID_DIGEST: %w", err)
+	}
+
+	if digest == current.Digest {
+		return "", fmt.Errorf("ERR_TAG_ALREADY_AT_DIGEST: %s", digest)
+	}
+
+	return digest, nil
+}
+
+// moveTag points the tag to the target manifest and records the move in the tag and the audit history
+func (ext *extension) moveTag(
+	ctx echo.Context,
+	namespace string,
+	tag string,
+	current *types.ImageManifest,
+	target *types.ImageManifest,
+) error {
+	reqCtx := ctx.Request().Context()
+	actorID := registry.GetActorID(ctx)
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	manifest := *target
+	manifest.ID = id
+	manifest.CreatedAt = time.Now()
+	manifest.Reference = tag
+	manifest.Repository = nil
+	manifest.User = nil
+
+	txn, err := ext.store.NewTxn(reqCtx)
+	if err != nil {
+		return err
+	}
+
+	if err = ext.store.AddTagHistory(reqCtx, txn, &manifest, actorID, ctx.Request().UserAgent()); err != nil {
+		_ = ext.store.Abort(reqCtx, txn)
+		return err
+	}
+
+	if err = ext.store.SetManifest(reqCtx, txn, &manifest); err != nil {
+		_ = ext.store.Abort(reqCtx, txn)
+		return err
+	}
+
+	event := &types.RepositoryAuditEvent{
+		Action:       types.AuditActionRollback,
+		Namespace:    namespace,
+		Reference:    tag,
+		Digest:       target.Digest,
+		ActorID:      actorID,
+		RepositoryID: current.RepositoryID,
+		Metadata: map[string]string{
+			"previous_digest": current.Digest,
+			"user_agent":      ctx.Request().UserAgent(),
+		},
+	}
+	if err = ext.store.AddAuditEvent(reqCtx, txn, event); err != nil {
+		_ = ext.store.Abort(reqCtx, txn)
+		return err
+	}
+
+	if err = ext.store.Commit(reqCtx, txn); err != nil {
+		return err
+	}
+
+	ext.manifestCache.InvalidateManifestCache(namespace)
+	return nil
+}
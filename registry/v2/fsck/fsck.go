@@ -0,0 +1,473 @@
+// Package fsck checks that the layers in the database and the blobs in the storage backends agree with each other.
+// It finds layers whose blob is missing or corrupted, blobs that no layer points to, and the manifests that can't be
+// pulled because of them
+package fsck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	oci_digest "github.com/opencontainers/go-digest"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/registry"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// pageSize is the number of layers & manifests read from the database at a time
+const pageSize = 500
+
+// The kinds of problems fsck reports
+const (
+	MissingObject  = "missing_object"
+	SizeMismatch   = "size_mismatch"
+	DigestMismatch = "digest_mismatch"
+	OrphanObject   = "orphan_object"
+	UnknownBackend = "unknown_backend"
+	BrokenManifest = "broken_manifest"
+)
+
+type (
+	Problem struct {
+		Kind    string `json:"kind"`
+		Backend string `json:"backend,omitempty"`
+		Digest  string `json:"digest,omitempty"`
+		DFSLink string `json:"dfsLink,omitempty"`
+		Detail  string `json:"detail,omitempty"`
+		// Repaired is set when --repair fixed the problem, or marked the manifest as broken
+		Repaired bool `json:"repaired"`
+	}
+
+	Options struct {
+		// Backends are the names of the storage backends to look for orphaned blobs in
+		Backends []string
+		// Rehash downloads every blob to verify its digest, instead of only checking that it exists
+		Rehash bool
+		Repair bool
+	}
+
+	Report struct {
+		Problems  []*Problem `json:"problems"`
+		Layers    int        `json:"layers"`
+		Objects   int        `json:"objects"`
+		Manifests int        `json:"manifests"`
+	}
+
+	Checker struct {
+		store registry.RegistryStore
+		dfs   dfs.DFS
+		out   io.Writer
+		// links are the DFS links of the layers by backend, everything else in a backend is orphaned
+		links map[string]map[string]bool
+		// missing are the layers without a blob by backend & digest, orphaned blobs are matched against them
+		missing map[string]map[string]*missingLayer
+		// broken are the digests of the layers that are missing or corrupted
+		broken map[string]bool
+		report *Report
+		opts   Options
+	}
+
+	missingLayer struct {
+		layer   *types.ContainerImageLayer
+		problem *Problem
+	}
+)
+
+func New(store registry.RegistryStore, storage dfs.DFS, out io.Writer, opts Options) *Checker {
+	return &Checker{
+		store:   store,
+		dfs:     storage,
+		out:     out,
+		opts:    opts,
+		links:   make(map[string]map[string]bool),
+		missing: make(map[string]map[string]*missingLayer),
+		broken:  make(map[string]bool),
+		report:  &Report{},
+	}
+}
+
+// Unrepaired is the number of problems that are still there after the check
+func (r *Report) Unrepaired() int {
+	count := 0
+	for _, problem := range r.Problems {
+		if !problem.Repaired {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Run checks all the layers, then the blobs in the backends and finally the manifests
+func (c *Checker) Run(ctx context.Context) (*Report, error) {
+	if err := c.checkLayers(ctx); err != nil {
+		return c.report, err
+	}
+
+	backends := append([]string{}, c.opts.Backends...)
+	sort.Strings(backends)
+	for _, name := range backends {
+		if err := c.checkObjects(ctx, name); err != nil {
+			return c.report, err
+		}
+		c.printMissing(name)
+	}
+
+	// layers on backends that weren't listed
+	for _, name := range sortedKeys(c.missing) {
+		c.printMissing(name)
+	}
+
+	if err := c.checkManifests(ctx); err != nil {
+		return c.report, err
+	}
+
+	return c.report, nil
+}
+
+func (c *Checker) addProblem(problem *Problem) {
+	c.report.Problems = append(c.report.Problems, problem)
+}
+
+func (c *Checker) printProblem(problem *Problem) {
+	line := fmt.Sprintf("%s backend=%s digest=%s link=%s %s", problem.Kind, problem.Backend, problem.Digest,
+		problem.DFSLink, problem.Detail)
+	if problem.Repaired {
+		fmt.Fprintln(c.out, color.GreenString("%s (repaired)", line))
+		return
+	}
+
+	fmt.Fprintln(c.out, color.YellowString(line))
+}
+
+// backend returns the storage backend with the name, for layers without one it's the default backend
+func (c *Checker) backend(name string) (dfs.DFS, bool) {
+	if selector, ok := dfs.As[dfs.Selector](c.dfs); ok {
+		return selector.Backend(name)
+	}
+
+	return c.dfs, name == "" || name == dfs.DefaultBackend
+}
+
+func backendName(layer *types.ContainerImageLayer) string {
+	if layer.Backend == "" {
+		return dfs.DefaultBackend
+	}
+
+	return layer.Backend
+}
+
+// hashBlob downloads the blob and returns its digest, computed with the algorithm of expected
+func hashBlob(ctx context.Context, backend dfs.DFS, link string, expected string) (oci_digest.Digest, int64, error) {
+	algorithm := oci_digest.Canonical
+	if parsed, err := oci_digest.Parse(expected); err == nil {
+		algorithm = parsed.Algorithm()
+	}
+
+	blob, err := backend.Download(ctx, link)
+	if err != nil {
+		return "", 0, err
+	}
+	defer blob.Close()
+
+	digester := algorithm.Digester()
+	size, err := io.Copy(digester.Hash(), blob)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return digester.Digest(), size, nil
+}
+
+func (c *Checker) checkLayers(ctx context.Context) error {
+	afterID := ""
+	for {
+		layers, err := c.store.ListLayers(ctx, afterID, pageSize)
+		if err != nil {
+			return fmt.Errorf("ERR_FSCK_LIST_LAYERS: %w", err)
+		}
+
+		for _, layer := range layers {
+			c.report.Layers++
+			if err = c.checkLayer(ctx, layer); err != nil {
+				return err
+			}
+		}
+
+		if len(layers) < pageSize {
+			return nil
+		}
+		afterID = layers[len(layers)-1].ID
+	}
+}
+
+func (c *Checker) checkLayer(ctx context.Context, layer *types.ContainerImageLayer) error {
+	name := backendName(layer)
+	backend, ok := c.backend(name)
+	if !ok {
+		c.broken[layer.Digest] = true
+		problem := &Problem{Kind: UnknownBackend, Backend: name, Digest: layer.Digest, DFSLink: layer.DFSLink}
+		c.addProblem(problem)
+		c.printProblem(problem)
+		return nil
+	}
+
+	if c.links[name] == nil {
+		c.links[name] = make(map[string]bool)
+	}
+	c.links[name][layer.DFSLink] = true
+
+	metadata, err := backend.Metadata(layer)
+	if err != nil || layer.DFSLink == "" {
+		c.addMissing(name, layer, err)
+		return nil
+	}
+
+	sizeMismatch := layer.Size > 0 && int64(metadata.ContentLength) != layer.Size
+	if !c.opts.Rehash {
+		if sizeMismatch {
+			c.broken[layer.Digest] = true
+			c.addLayerProblem(SizeMismatch, name, layer, fmt.Sprintf("stored=%d", metadata.ContentLength), false)
+		}
+		return nil
+	}
+
+	digest, size, err := hashBlob(ctx, backend, layer.DFSLink, layer.Digest)
+	if err != nil {
+		c.addMissing(name, layer, err)
+		return nil
+	}
+
+	if digest.String() != layer.Digest {
+		c.broken[layer.Digest] = true
+		c.addLayerProblem(DigestMismatch, name, layer, "computed="+digest.String(), false)
+		return nil
+	}
+
+	// the blob itself is fine, only the size in the database is wrong
+	if layer.Size > 0 && size != layer.Size {
+		repaired := false
+		if c.opts.Repair {
+			if err = c.store.UpdateLayerSize(ctx, layer.Digest, size); err != nil {
+				return fmt.Errorf("ERR_FSCK_UPDATE_LAYER_SIZE: %w", err)
+			}
+			repaired = true
+		}
+		c.addLayerProblem(SizeMismatch, name, layer, fmt.Sprintf("recorded=%d stored=%d", layer.Size, size), repaired)
+	}
+
+	return nil
+}
+
+func (c *Checker) addLayerProblem(
+	kind string,
+	backend string,
+	layer *types.ContainerImageLayer,
+	detail string,
+	repaired bool,
+) {
+	problem := &Problem{
+		Kind:     kind,
+		Backend:  backend,
+		Digest:   layer.Digest,
+		DFSLink:  layer.DFSLink,
+		Detail:   detail,
+		Repaired: repaired,
+	}
+	c.addProblem(problem)
+	c.printProblem(problem)
+}
+
+// addMissing records a layer without a blob. It's printed once the orphaned blobs are checked, since one of them might
+// be the blob of the layer
+func (c *Checker) addMissing(backend string, layer *types.ContainerImageLayer, err error) {
+	problem := &Problem{Kind: MissingObject, Backend: backend, Digest: layer.Digest, DFSLink: layer.DFSLink}
+	if err != nil {
+		problem.Detail = err.Error()
+	}
+
+	c.broken[layer.Digest] = true
+	c.addProblem(problem)
+	if c.missing[backend] == nil {
+		c.missing[backend] = make(map[string]*missingLayer)
+	}
+	c.missing[backend][layer.Digest] = &missingLayer{layer: layer, problem: problem}
+}
+
+func (c *Checker) checkObjects(ctx context.Context, name string) error {
+	backend, ok := c.backend(name)
+	if !ok {
+		return fmt.Errorf("ERR_FSCK_UNKNOWN_BACKEND: %s", name)
+	}
+
+	lister, ok := dfs.As[dfs.ObjectLister](backend)
+	if !ok {
+		fmt.Fprintln(c.out, color.YellowString("skipping orphaned blobs in backend %s, it can't list its blobs", name))
+		return nil
+	}
+
+	var repairErr error
+	err := lister.ListObjects(ctx, func(object *types.ObjectMetadata) error {
+		c.report.Objects++
+		if c.links[name][object.DFSLink] {
+			return nil
+		}
+
+		problem := &Problem{
+			Kind:    OrphanObject,
+			Backend: name,
+			DFSLink: object.DFSLink,
+			Detail:  fmt.Sprintf("size=%d", object.ContentLength),
+		}
+		if c.opts.Repair && len(c.missing[name]) > 0 {
+			if repairErr = c.relink(ctx, backend, name, problem); repairErr != nil {
+				return repairErr
+			}
+		}
+
+		c.addProblem(problem)
+		c.printProblem(problem)
+		return nil
+	})
+	if repairErr != nil {
+		return repairErr
+	}
+
+	// the layers were checked already, so a backend that can't be listed doesn't stop the check
+	if err != nil {
+		fmt.Fprintln(c.out, color.RedString("error listing the blobs in backend %s: %s", name, err))
+	}
+
+	return nil
+}
+
+// relink points a layer without a blob to the orphaned blob, if the blob has the digest of the layer. The size of the
+// layer is corrected too, the blob was just hashed
+func (c *Checker) relink(ctx context.Context, backend dfs.DFS, name string, problem *Problem) error {
+	digest, size, err := hashBlob(ctx, backend, problem.DFSLink, "")
+	if err != nil {
+		problem.Detail = fmt.Sprintf("%s error=%s", problem.Detail, err)
+		return nil
+	}
+
+	problem.Digest = digest.String()
+	missing, ok := c.missing[name][digest.String()]
+	if !ok {
+		return nil
+	}
+
+	if err = c.store.UpdateLayerLink(ctx, missing.layer.Digest, missing.layer.Backend, problem.DFSLink); err != nil {
+		return fmt.Errorf("ERR_FSCK_UPDATE_LAYER_LINK: %w", err)
+	}
+
+	if missing.layer.Size != size {
+		if err = c.store.UpdateLayerSize(ctx, missing.layer.Digest, size); err != nil {
+			return fmt.Errorf("ERR_FSCK_UPDATE_LAYER_SIZE: %w", err)
+		}
+	}
+
+	problem.Repaired = true
+	problem.Detail = fmt.Sprintf("%s relinked=%s", problem.Detail, missing.layer.Digest)
+	missing.problem.Repaired = true
+	missing.problem.Detail = "relinked to " + problem.DFSLink
+	delete(c.missing[name], digest.String())
+	delete(c.broken, digest.String())
+	return nil
+}
+
+// printMissing prints the layers of the backend that are still missing their blob, once the orphaned blobs were
+// matched against them
+func (c *Checker) printMissing(name string) {
+	for _, digest := range sortedKeys(c.missing[name]) {
+		c.printProblem(c.missing[name][digest].problem)
+	}
+	delete(c.missing, name)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (c *Checker) checkManifests(ctx context.Context) error {
+	afterID := uuid.Nil
+	for {
+		manifests, err := c.store.ListManifests(ctx, afterID, pageSize)
+		if err != nil {
+			return fmt.Errorf("ERR_FSCK_LIST_MANIFESTS: %w", err)
+		}
+
+		var brokenIDs, healedIDs []uuid.UUID
+		var problems []*Problem
+		for _, manifest := range manifests {
+			c.report.Manifests++
+			problem := c.checkManifest(manifest)
+			if problem != nil {
+				brokenIDs = append(brokenIDs, manifest.ID)
+				problems = append(problems, problem)
+				continue
+			}
+
+			// the manifest was marked by an earlier run, but its blobs are fine now (relinked, pushed again, etc)
+			if !manifest.BrokenAt.IsZero() {
+				healedIDs = append(healedIDs, manifest.ID)
+			}
+		}
+
+		if c.opts.Repair && len(brokenIDs) > 0 {
+			if err = c.store.MarkManifestsBroken(ctx, brokenIDs); err != nil {
+				return fmt.Errorf("ERR_FSCK_MARK_MANIFESTS_BROKEN: %w", err)
+			}
+		}
+
+		if c.opts.Repair && len(healedIDs) > 0 {
+			if err = c.store.ClearManifestsBroken(ctx, healedIDs); err != nil {
+				return fmt.Errorf("ERR_FSCK_CLEAR_MANIFESTS_BROKEN: %w", err)
+			}
+			fmt.Fprintln(c.out, color.GreenString("cleared the broken mark from %d manifests", len(healedIDs)))
+		}
+
+		for _, problem := range problems {
+			problem.Repaired = c.opts.Repair
+			c.addProblem(problem)
+			c.printProblem(problem)
+		}
+
+		if len(manifests) < pageSize {
+			return nil
+		}
+		afterID = manifests[len(manifests)-1].ID
+	}
+}
+
+// checkManifest returns a problem if the manifest references a missing or corrupted blob
+func (c *Checker) checkManifest(manifest *types.ImageManifest) *Problem {
+	digests := make([]string, 0, len(manifest.Layers)+1)
+	if manifest.Config != nil {
+		digests = append(digests, manifest.Config.Digest.String())
+	}
+	for _, layer := range manifest.Layers {
+		digests = append(digests, layer.Digest.String())
+	}
+
+	for _, digest := range digests {
+		if c.broken[digest] {
+			return &Problem{
+				Kind:   BrokenManifest,
+				Digest: manifest.Digest,
+				Detail: fmt.Sprintf("repository=%s reference=%s blob=%s", manifest.RepositoryID, manifest.Reference, digest),
+			}
+		}
+	}
+
+	return nil
+}
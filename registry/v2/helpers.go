@@ -3,13 +3,73 @@ package registry
 import (
 	"context"
 	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/containerish/OpenRegistry/common"
+
+	dfsImpl "github.com/containerish/OpenRegistry/dfs"
+	store_v2 "github.com/containerish/OpenRegistry/store/v1/registry"
+	types_v2 "github.com/containerish/OpenRegistry/store/v1/types"
 )
 
-func (r *registry) getDownloadableURLFromDFSLink(s string) (string, error) {
-	presignedUrl, err := r.dfs.GeneratePresignedURL(context.Background(), s)
+// storageFor returns the storage backend new blobs of the namespace go to, along with the name that's recorded on
+// their layers
+func (r *registry) storageFor(namespace string) (string, dfsImpl.DFS) {
+	return dfsImpl.ForNamespace(r.dfs, namespace)
+}
+
+// getRepositoryLayer returns the layer for the digest, as long as it was pushed to the repository or is used by one of
+// its manifests. Layers are stored once for the whole registry, so a digest alone must not give access to blobs of
+// other repositories
+func getRepositoryLayer(
+	ctx context.Context,
+	store store_v2.RegistryStore,
+	namespace string,
+	digest string,
+) (*types_v2.ContainerImageLayer, error) {
+	ok, err := store.RepositoryHasBlob(ctx, namespace, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("ERR_BLOB_UNKNOWN: %s is not a blob of %s", digest, namespace)
+	}
+
+	return store.GetLayer(ctx, digest)
+}
+
+// getDownloadableURL returns where the layer can be downloaded from. Blobs of storage backends that can't hand out URLs
+// are served by the registry itself
+func (r *registry) getDownloadableURL(namespace string, layer *types_v2.ContainerImageLayer) (string, error) {
+	storage := dfsImpl.ForLayer(r.dfs, layer)
+	if dfsImpl.ProxyDownloads(storage) {
+		return fmt.Sprintf("/v2/%s/blobs/%s", namespace, layer.Digest), nil
+	}
+
+	presignedUrl, err := storage.GeneratePresignedURL(context.Background(), layer.DFSLink)
 	if err != nil {
 		return "", fmt.Errorf("DFS_ERR_GENERATE_PRESIGNED_URL: %w", err)
 	}
 
 	return presignedUrl, nil
 }
+
+// proxyLayer streams the layer from the storage backend to the client
+func (r *registry) proxyLayer(ctx echo.Context, storage dfsImpl.DFS, layer *types_v2.ContainerImageLayer) error {
+	blob, err := dfsImpl.DownloadLayer(ctx.Request().Context(), storage, layer)
+	if err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUnknown, err.Error(), nil)
+		ctx.Response().Header().Del("Content-Length")
+		echoErr := ctx.JSONBlob(http.StatusInternalServerError, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+	defer blob.Close()
+
+	echoErr := ctx.Stream(http.StatusOK, "application/octet-stream", blob)
+	r.logger.Log(ctx, echoErr).Bool("proxied", true).Send()
+	return echoErr
+}
@@ -0,0 +1,254 @@
+package layout
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	oci_digest "github.com/opencontainers/go-digest"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+const (
+	dockerManifestFile     = "manifest.json"
+	dockerRepositoriesFile = "repositories"
+)
+
+type (
+	// dockerArchiveManifest is a single entry from the manifest.json in a `docker save` tarball
+	dockerArchiveManifest struct {
+		Config   string   `json:"Config"`
+		RepoTags []string `json:"RepoTags"`
+		Layers   []string `json:"Layers"`
+	}
+
+	// dockerArchiveReader presents a `docker save` tarball as an OCI image layout. The OCI manifests are generated from
+	// the manifest.json file and the blobs are served from the files in the archive
+	dockerArchiveReader struct {
+		*dirReader
+		index     *img_spec_v1.Index
+		blobs     map[oci_digest.Digest]string
+		manifests map[oci_digest.Digest][]byte
+	}
+)
+
+// NewArchiveReader accepts both OCI image layout tarballs and `docker save` tarballs. Recent versions of Docker write
+// both formats in the same archive, in which case the OCI image layout is preferred. See NewReaderFromTar for maxSize
+func NewArchiveReader(r io.Reader, maxSize int64) (Reader, error) {
+	reader, err := NewReaderFromTar(r, maxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := reader.(*dirReader)
+	if _, err = os.Stat(filepath.Join(dir.root, img_spec_v1.ImageLayoutFile)); err == nil {
+		return dir, nil
+	}
+
+	if _, err = os.Stat(filepath.Join(dir.root, dockerManifestFile)); err != nil {
+		_ = dir.Close()
+		return nil, fmt.Errorf("ERR_UNKNOWN_ARCHIVE_FORMAT: archive has neither %s nor %s",
+			img_spec_v1.ImageLayoutFile, dockerManifestFile)
+	}
+
+	dockerReader := &dockerArchiveReader{
+		dirReader: dir,
+		index:     NewIndex(),
+		blobs:     make(map[oci_digest.Digest]string),
+		manifests: make(map[oci_digest.Digest][]byte),
+	}
+
+	if err = dockerReader.convert(); err != nil {
+		_ = dir.Close()
+		return nil, err
+	}
+
+	return dockerReader, nil
+}
+
+func (r *dockerArchiveReader) Index() (*img_spec_v1.Index, error) {
+	return r.index, nil
+}
+
+func (r *dockerArchiveReader) OpenBlob(digest oci_digest.Digest) (io.ReadCloser, error) {
+	if manifest, ok := r.manifests[digest]; ok {
+		return io.NopCloser(bytes.NewReader(manifest)), nil
+	}
+
+	blobPath, ok := r.blobs[digest]
+	if !ok {
+		return nil, fmt.Errorf("ERR_BLOB_NOT_FOUND: %s", digest)
+	}
+
+	fd, err := os.Open(blobPath)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_OPEN_BLOB: %w", err)
+	}
+
+	return fd, nil
+}
+
+// convert generates an OCI manifest for every image in manifest.json
+func (r *dockerArchiveReader) convert() error {
+	manifestBz, err := os.ReadFile(filepath.Join(r.root, dockerManifestFile))
+	if err != nil {
+		return fmt.Errorf("ERR_READ_DOCKER_MANIFEST: %w", err)
+	}
+
+	var archiveManifests []dockerArchiveManifest
+	if err = json.Unmarshal(manifestBz, &archiveManifests); err != nil {
+		return fmt.Errorf("ERR_PARSE_DOCKER_MANIFEST: %w", err)
+	}
+
+	fallbackTags, err := r.readRepositoriesFile()
+	if err != nil {
+		return err
+	}
+
+	for _, am := range archiveManifests {
+		config, err := r.addBlob(am.Config, img_spec_v1.MediaTypeImageConfig)
+		if err != nil {
+			return err
+		}
+
+		manifest := &types.ImageManifest{
+			SchemaVersion: 2,
+			MediaType:     img_spec_v1.MediaTypeImageManifest,
+			Config:        config,
+			Layers:        make(types.ImageManifestLayers, 0, len(am.Layers)),
+		}
+
+		for _, layerPath := range am.Layers {
+			layer, err := r.addBlob(layerPath, layerMediaType(filepath.Join(r.root, filepath.Clean("/"+layerPath))))
+			if err != nil {
+				return err
+			}
+			manifest.Layers = append(manifest.Layers, layer)
+		}
+
+		// we use the same serialisation as the one used when the manifest is pulled, so that the digest stays the same
+		bz := manifest.ToOCISubject()
+		digest := oci_digest.FromBytes(bz)
+		r.manifests[digest] = bz
+
+		tags := make([]string, 0, len(am.RepoTags))
+		for _, repoTag := range am.RepoTags {
+			tags = append(tags, tagFromRepoTag(repoTag))
+		}
+		if len(tags) == 0 && len(am.Layers) > 0 {
+			// the top layer id is the directory name of the last layer, that's what the repositories file refers to
+			tags = fallbackTags[filepath.Base(filepath.Dir(am.Layers[len(am.Layers)-1]))]
+		}
+
+		desc := img_spec_v1.Descriptor{
+			MediaType: img_spec_v1.MediaTypeImageManifest,
+			Digest:    digest,
+			Size:      int64(len(bz)),
+		}
+
+		// untagged images are pushed by their digest
+		if len(tags) == 0 {
+			r.index.Manifests = append(r.index.Manifests, desc)
+			continue
+		}
+
+		for _, tag := range tags {
+			tagged := desc
+			tagged.Annotations = map[string]string{img_spec_v1.AnnotationRefName: tag}
+			r.index.Manifests = append(r.index.Manifests, tagged)
+		}
+	}
+
+	return nil
+}
+
+// readRepositoriesFile reads the legacy repositories file, which maps repository -> tag -> top layer id. It's only
+// used for images that don't have any RepoTags in manifest.json
+func (r *dockerArchiveReader) readRepositoriesFile() (map[string][]string, error) {
+	tagsByLayerID := make(map[string][]string)
+
+	bz, err := os.ReadFile(filepath.Join(r.root, dockerRepositoriesFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return tagsByLayerID, nil
+		}
+		return nil, fmt.Errorf("ERR_READ_DOCKER_REPOSITORIES: %w", err)
+	}
+
+	var repositories map[string]map[string]string
+	if err = json.Unmarshal(bz, &repositories); err != nil {
+		return nil, fmt.Errorf("ERR_PARSE_DOCKER_REPOSITORIES: %w", err)
+	}
+
+	for _, tags := range repositories {
+		for tag, layerID := range tags {
+			tagsByLayerID[layerID] = append(tagsByLayerID[layerID], tag)
+		}
+	}
+
+	return tagsByLayerID, nil
+}
+
+// addBlob hashes the file at blobPath and registers it as a blob of the layout
+func (r *dockerArchiveReader) addBlob(blobPath, mediaType string) (*img_spec_v1.Descriptor, error) {
+	fullPath := filepath.Join(r.root, filepath.Clean("/"+blobPath))
+	fd, err := os.Open(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_OPEN_BLOB: %s: %w", blobPath, err)
+	}
+	defer fd.Close()
+
+	digester := oci_digest.Canonical.Digester()
+	size, err := io.Copy(digester.Hash(), fd)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_DIGEST_BLOB: %s: %w", blobPath, err)
+	}
+
+	digest := digester.Digest()
+	r.blobs[digest] = fullPath
+
+	return &img_spec_v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest,
+		Size:      size,
+	}, nil
+}
+
+// layerMediaType checks the magic bytes, since `docker save` writes uncompressed layers but other tools that produce
+// the same format write compressed ones
+func layerMediaType(layerPath string) string {
+	fd, err := os.Open(layerPath)
+	if err != nil {
+		return img_spec_v1.MediaTypeImageLayer
+	}
+	defer fd.Close()
+
+	magic := make([]byte, 4)
+	if _, err = io.ReadFull(fd, magic); err != nil {
+		return img_spec_v1.MediaTypeImageLayer
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return img_spec_v1.MediaTypeImageLayerGzip
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return img_spec_v1.MediaTypeImageLayerZstd
+	default:
+		return img_spec_v1.MediaTypeImageLayer
+	}
+}
+
+// tagFromRepoTag returns the tag from a docker reference like docker.io/library/alpine:3.18
+func tagFromRepoTag(repoTag string) string {
+	if idx := strings.LastIndex(repoTag, ":"); idx > strings.LastIndex(repoTag, "/") {
+		return repoTag[idx+1:]
+	}
+
+	return "latest"
+}
@@ -0,0 +1,253 @@
+package layout
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fatih/color"
+	oci_digest "github.com/opencontainers/go-digest"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/registry"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// AnnotationManifestDigest carries the digest the manifest was originally pushed with. OpenRegistry only keeps the
+// parsed manifest around, so the bytes written to the layout (and hence their digest) can differ from what the client
+// pushed. Importing with this annotation (see Importer.WithTrustedDigests) keeps tags and referrers pointing at the
+// same digest as the source registry
+const AnnotationManifestDigest = "sh.openregistry.manifest.digest"
+
+type Exporter struct {
+	store registry.RegistryStore
+	dfs   dfs.DFS
+	out   io.Writer
+}
+
+func NewExporter(store registry.RegistryStore, dfs dfs.DFS, out io.Writer) *Exporter {
+	return &Exporter{
+		store: store,
+		dfs:   dfs,
+		out:   out,
+	}
+}
+
+// Export writes the images to the layout. References can be of the form <namespace>, <namespace>:<tag> or
+// <namespace>@<digest>, where a bare namespace exports all the tags in the repository. Referrers of every exported
+// manifest are exported along with it
+func (e *Exporter) Export(ctx context.Context, w Writer, references []string) (*img_spec_v1.Index, error) {
+	index := NewIndex()
+
+	for _, ref := range references {
+		namespace, tags, err := e.resolveReference(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range tags {
+			manifest, err := e.store.GetManifestByReference(ctx, namespace, tag)
+			if err != nil {
+				return nil, fmt.Errorf("ERR_GET_MANIFEST: %s:%s: %w", namespace, tag, err)
+			}
+
+			desc, err := e.exportManifest(ctx, w, namespace, manifest)
+			if err != nil {
+				return nil, err
+			}
+			if desc == nil {
+				continue
+			}
+
+			if _, err = oci_digest.Parse(tag); err != nil {
+				desc.Annotations[img_spec_v1.AnnotationRefName] = tag
+			}
+			index.Manifests = append(index.Manifests, *desc)
+			fmt.Fprintln(e.out, color.GreenString("exported %s:%s (%s)", namespace, tag, desc.Digest))
+
+			referrers, err := e.exportReferrers(ctx, w, namespace, manifest)
+			if err != nil {
+				return nil, err
+			}
+			index.Manifests = append(index.Manifests, referrers...)
+		}
+	}
+
+	if err := w.Close(index); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+func (e *Exporter) resolveReference(ctx context.Context, ref string) (string, []string, error) {
+	if namespace, digest, ok := strings.Cut(ref, "@"); ok {
+		return namespace, []string{digest}, nil
+	}
+
+	// the tag separator is the last colon after the last slash, anything before that is a part of the namespace
+	if idx := strings.LastIndex(ref, ":"); idx > strings.LastIndex(ref, "/") {
+		return ref[:idx], []string{ref[idx+1:]}, nil
+	}
+
+	allTags, err := e.store.GetImageTags(ctx, ref)
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR_GET_IMAGE_TAGS: %s: %w", ref, err)
+	}
+
+	// manifests pushed by digest (referrers, platform manifests, etc) are exported via the manifests pointing to them
+	tags := make([]string, 0, len(allTags))
+	for _, tag := range allTags {
+		if _, err = oci_digest.Parse(tag); err != nil {
+			tags = append(tags, tag)
+		}
+	}
+
+	if len(tags) == 0 {
+		return "", nil, fmt.Errorf("ERR_NO_TAGS_FOUND: %s", ref)
+	}
+
+	return ref, tags, nil
+}
+
+func (e *Exporter) exportReferrers(
+	ctx context.Context,
+	w Writer,
+	namespace string,
+	subject *types.ImageManifest,
+) ([]img_spec_v1.Descriptor, error) {
+	referrers, err := e.store.GetReferrers(ctx, namespace, subject.Digest, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_GET_REFERRERS: %s@%s: %w", namespace, subject.Digest, err)
+	}
+
+	descriptors := make([]img_spec_v1.Descriptor, 0, len(referrers.Manifests))
+	for _, referrer := range referrers.Manifests {
+		manifest, err := e.store.GetManifestByReference(ctx, namespace, referrer.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("ERR_GET_REFERRER_MANIFEST: %s@%s: %w", namespace, referrer.Digest, err)
+		}
+
+		desc, err := e.exportManifest(ctx, w, namespace, manifest)
+		if err != nil {
+			return nil, err
+		}
+		if desc != nil {
+			descriptors = append(descriptors, *desc)
+		}
+	}
+
+	return descriptors, nil
+}
+
+// exportManifest writes the manifest along with its config and layers. For image indexes, every manifest in the
+// index is exported by its digest first and the index is rewritten to point at the exported manifests
+func (e *Exporter) exportManifest(
+	ctx context.Context,
+	w Writer,
+	namespace string,
+	manifest *types.ImageManifest,
+) (*img_spec_v1.Descriptor, error) {
+	if isImageIndex(manifest.MediaType) {
+		children, err := e.exportIndexManifests(ctx, w, namespace, manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		index := *manifest
+		index.Manifests = children
+		manifest = &index
+	} else {
+		blobs := make([]*img_spec_v1.Descriptor, 0, len(manifest.Layers)+1)
+		if manifest.Config != nil && manifest.Config.Digest != "" {
+			blobs = append(blobs, manifest.Config)
+		}
+		blobs = append(blobs, manifest.Layers...)
+
+		for _, blob := range blobs {
+			if err := e.exportBlob(ctx, w, blob); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	manifestBz := manifest.ToOCISubject()
+	digest := oci_digest.FromBytes(manifestBz)
+	if !w.HasBlob(digest) {
+		if err := w.WriteBlob(digest, int64(len(manifestBz)), bytes.NewReader(manifestBz)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &img_spec_v1.Descriptor{
+		MediaType:    manifest.MediaType,
+		ArtifactType: manifest.ArtifactType,
+		Digest:       digest,
+		Size:         int64(len(manifestBz)),
+		Annotations: map[string]string{
+			AnnotationNamespace:      namespace,
+			AnnotationManifestDigest: manifest.Digest,
+		},
+	}, nil
+}
+
+// exportIndexManifests exports the manifests of an image index and returns the descriptors of the exported manifests,
+// keeping the platform of the original descriptors
+func (e *Exporter) exportIndexManifests(
+	ctx context.Context,
+	w Writer,
+	namespace string,
+	index *types.ImageManifest,
+) ([]img_spec_v1.Descriptor, error) {
+	children := make([]img_spec_v1.Descriptor, 0, len(index.Manifests))
+	for _, child := range index.Manifests {
+		manifest, err := e.store.GetManifestByReference(ctx, namespace, child.Digest.String())
+		if err != nil {
+			return nil, fmt.Errorf("ERR_GET_INDEX_MANIFEST: %s@%s: %w", namespace, child.Digest, err)
+		}
+
+		desc, err := e.exportManifest(ctx, w, namespace, manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range child.Annotations {
+			desc.Annotations[k] = v
+		}
+		desc.Platform = child.Platform
+		children = append(children, *desc)
+	}
+
+	return children, nil
+}
+
+func isImageIndex(mediaType string) bool {
+	return mediaType == img_spec_v1.MediaTypeImageIndex || mediaType == types.MediaTypeDockerManifestList
+}
+
+func (e *Exporter) exportBlob(ctx context.Context, w Writer, desc *img_spec_v1.Descriptor) error {
+	if w.HasBlob(desc.Digest) {
+		return nil
+	}
+
+	layer, err := e.store.GetLayer(ctx, desc.Digest.String())
+	if err != nil {
+		return fmt.Errorf("ERR_GET_LAYER: %s: %w", desc.Digest, err)
+	}
+
+	content, err := dfs.ForLayer(e.dfs, layer).Download(ctx, layer.DFSLink)
+	if err != nil {
+		return fmt.Errorf("ERR_DFS_DOWNLOAD: %s: %w", desc.Digest, err)
+	}
+	defer content.Close()
+
+	size := desc.Size
+	if size == 0 {
+		size = layer.Size
+	}
+
+	return w.WriteBlob(desc.Digest, size, content)
+}
@@ -0,0 +1,360 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	oci_digest "github.com/opencontainers/go-digest"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	registry_v2 "github.com/containerish/OpenRegistry/registry/v2"
+	"github.com/containerish/OpenRegistry/store/v1/registry"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/store/v1/users"
+	core_types "github.com/containerish/OpenRegistry/types"
+)
+
+// importUserAgent is recorded in the tag history when no user agent is set with WithActor
+const importUserAgent = "openregistry-import"
+
+type Importer struct {
+	store      registry.RegistryStore
+	usersStore users.UserStore
+	dfs        dfs.DFS
+	out        io.Writer
+	userAgent  string
+	actorID    uuid.UUID
+	// trustDigests honors AnnotationManifestDigest, see WithTrustedDigests
+	trustDigests bool
+}
+
+// ImportedImage is a single manifest that was written to the registry by an import
+type ImportedImage struct {
+	Namespace string `json:"namespace"`
+	Reference string `json:"reference"`
+	Digest    string `json:"digest"`
+}
+
+func NewImporter(
+	store registry.RegistryStore,
+	usersStore users.UserStore,
+	dfs dfs.DFS,
+	out io.Writer,
+) *Importer {
+	return &Importer{
+		store:      store,
+		usersStore: usersStore,
+		dfs:        dfs,
+		out:        out,
+		userAgent:  importUserAgent,
+	}
+}
+
+// WithActor sets the user and the user agent recorded in the tag history for the imported tags
+func (i *Importer) WithActor(actorID uuid.UUID, userAgent string) *Importer {
+	i.actorID = actorID
+	i.userAgent = userAgent
+	return i
+}
+
+// WithTrustedDigests stores the manifests with the digest from AnnotationManifestDigest instead of the digest of the
+// manifest in the layout. The annotation isn't verified against anything, so it must only be set for layouts from a
+// trusted source (i.e, an export made by the registry operator) and never for layouts uploaded by users
+func (i *Importer) WithTrustedDigests() *Importer {
+	i.trustDigests = true
+	return i
+}
+
+// Import pushes all the manifests in the layout's index.json (and the blobs they refer to) to the registry. If the
+// namespace is empty, the namespace is read from the descriptor annotations instead
+func (i *Importer) Import(ctx context.Context, r Reader, namespace string) ([]*ImportedImage, error) {
+	index, err := r.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	imported := make([]*ImportedImage, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		ns := namespace
+		if ns == "" {
+			ns = desc.Annotations[AnnotationNamespace]
+		}
+		if ns == "" {
+			return nil, fmt.Errorf("ERR_MISSING_NAMESPACE: no namespace found for manifest %s", desc.Digest)
+		}
+
+		image, err := i.ImportManifest(ctx, r, ns, desc)
+		if err != nil {
+			return nil, err
+		}
+		if image == nil {
+			continue
+		}
+
+		imported = append(imported, image)
+		fmt.Fprintln(i.out, color.GreenString("imported %s:%s (%s)", image.Namespace, image.Reference, image.Digest))
+	}
+
+	return imported, nil
+}
+
+// ImportManifest pushes a single manifest from the layout to the repository, tagged with the ref name annotation (or
+// the digest when there isn't one). The repository is created for the namespace owner if it doesn't exist already
+func (i *Importer) ImportManifest(
+	ctx context.Context,
+	r Reader,
+	namespace string,
+	desc img_spec_v1.Descriptor,
+) (*ImportedImage, error) {
+	// the ref name is used as the tag, it's checked before anything is uploaded
+	if tag := desc.Annotations[img_spec_v1.AnnotationRefName]; tag != "" && !registry_v2.IsValidTag(tag) {
+		return nil, fmt.Errorf("ERR_INVALID_TAG: %s: %q isn't a valid tag", desc.Digest, tag)
+	}
+
+	manifestBz, err := ReadBlob(r, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest types.ImageManifest
+	if err = json.Unmarshal(manifestBz, &manifest); err != nil {
+		return nil, fmt.Errorf("ERR_PARSE_MANIFEST: %s: %w", desc.Digest, err)
+	}
+
+	if manifest.MediaType == "" {
+		manifest.MediaType = desc.MediaType
+	}
+
+	if isImageIndex(manifest.MediaType) {
+		if err = i.importIndexManifests(ctx, r, namespace, &manifest); err != nil {
+			return nil, err
+		}
+	} else {
+		blobs := make([]*img_spec_v1.Descriptor, 0, len(manifest.Layers)+1)
+		if manifest.Config != nil && manifest.Config.Digest != "" {
+			blobs = append(blobs, manifest.Config)
+		}
+		blobs = append(blobs, manifest.Layers...)
+
+		for _, blob := range blobs {
+			if err = i.importBlob(ctx, r, namespace, blob); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// layouts from a trusted source were validated when they were pushed to the registry they were exported from,
+	// uploaded layouts have to pass the same checks as a push
+	if !i.trustDigests {
+		if err = registry_v2.ValidateManifest(ctx, i.store, namespace, &manifest); err != nil {
+			return nil, fmt.Errorf("ERR_INVALID_MANIFEST: %s: %w", desc.Digest, err)
+		}
+	}
+
+	repository, err := GetOrCreateRepository(ctx, i.store, i.usersStore, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	layerIDs := make([]string, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		layerIDs = append(layerIDs, layer.Digest.String())
+	}
+
+	id, err := core_types.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	digest := desc.Digest.String()
+	if original := desc.Annotations[AnnotationManifestDigest]; i.trustDigests && original != "" {
+		digest = original
+	}
+
+	reference := desc.Annotations[img_spec_v1.AnnotationRefName]
+	if reference == "" {
+		reference = digest
+	}
+
+	manifest.ID = id
+	manifest.RepositoryID = repository.ID
+	manifest.OwnerID = repository.OwnerID
+	manifest.Digest = digest
+	manifest.Reference = reference
+	if size, sizeErr := i.store.GetImageSizeByLayerIds(ctx, layerIDs); sizeErr == nil {
+		manifest.Size = size
+	}
+
+	txn, err := i.store.NewTxn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = i.store.AddTagHistory(ctx, txn, &manifest, i.actorID, i.userAgent); err != nil {
+		_ = i.store.Abort(ctx, txn)
+		return nil, fmt.Errorf("ERR_ADD_TAG_HISTORY: %s:%s: %w", namespace, reference, err)
+	}
+
+	if err = i.store.SetManifest(ctx, txn, &manifest); err != nil {
+		_ = i.store.Abort(ctx, txn)
+		return nil, fmt.Errorf("ERR_SET_MANIFEST: %s:%s: %w", namespace, reference, err)
+	}
+
+	if err = i.store.Commit(ctx, txn); err != nil {
+		return nil, err
+	}
+
+	return &ImportedImage{
+		Namespace: namespace,
+		Reference: reference,
+		Digest:    digest,
+	}, nil
+}
+
+// importIndexManifests imports the manifests of an image index by their digest and points the index at the digests
+// they were imported with
+func (i *Importer) importIndexManifests(
+	ctx context.Context,
+	r Reader,
+	namespace string,
+	index *types.ImageManifest,
+) error {
+	for idx, child := range index.Manifests {
+		image, err := i.ImportManifest(ctx, r, namespace, child)
+		if err != nil {
+			return fmt.Errorf("ERR_IMPORT_INDEX_MANIFEST: %s@%s: %w", namespace, child.Digest, err)
+		}
+
+		// the annotations are only used to carry the export metadata, they aren't a part of the original index
+		delete(child.Annotations, AnnotationNamespace)
+		delete(child.Annotations, AnnotationManifestDigest)
+		if len(child.Annotations) == 0 {
+			child.Annotations = nil
+		}
+
+		child.Digest = oci_digest.Digest(image.Digest)
+		index.Manifests[idx] = child
+	}
+
+	return nil
+}
+
+// importBlob uploads the blob to the storage backend of the namespace, unless a blob with the same digest already
+// exists in the registry. The blob is read from the layout either way, so that a layout can't link a blob of another
+// repository to the namespace by only knowing its digest. Blobs are streamed and verified on the way, they can be
+// as large as the archive
+func (i *Importer) importBlob(ctx context.Context, r Reader, namespace string, desc *img_spec_v1.Descriptor) error {
+	if desc.Size < 0 {
+		return fmt.Errorf("ERR_INVALID_BLOB_SIZE: %s: %d", desc.Digest, desc.Size)
+	}
+
+	rc, err := r.OpenBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// a blob that's larger than its descriptor says fails the digest check after one byte too many, instead of being
+	// read into memory whole
+	content := io.LimitReader(rc, desc.Size+1)
+
+	var layer *types.ContainerImageLayer
+	if _, err = i.store.GetLayer(ctx, desc.Digest.String()); err != nil {
+		id, idErr := core_types.CreateIdentifier()
+		if idErr != nil {
+			return idErr
+		}
+
+		backend, storage := dfs.ForNamespace(i.dfs, namespace)
+		dfsLink, copyErr := dfs.CopyBlob(
+			ctx,
+			storage,
+			core_types.GetLayerIdentifier(id),
+			desc.Digest.String(),
+			content,
+			int(desc.Size),
+			dfs.ChunkSize(storage),
+		)
+		if copyErr != nil {
+			return fmt.Errorf("ERR_DFS_UPLOAD: %s: %w", desc.Digest, copyErr)
+		}
+
+		layer = &types.ContainerImageLayer{
+			ID:        id,
+			Digest:    desc.Digest.String(),
+			MediaType: desc.MediaType,
+			DFSLink:   dfsLink,
+			Backend:   backend,
+			Size:      desc.Size,
+		}
+	} else if err = copyAndVerify(io.Discard, content, desc.Digest, desc.Size); err != nil {
+		return err
+	}
+
+	txn, err := i.store.NewTxn(ctx)
+	if err != nil {
+		return err
+	}
+
+	if layer != nil {
+		if err = i.store.SetLayer(ctx, txn, layer); err != nil {
+			_ = i.store.Abort(ctx, txn)
+			return fmt.Errorf("ERR_SET_LAYER: %s: %w", desc.Digest, err)
+		}
+	}
+
+	if err = i.store.LinkBlob(ctx, txn, namespace, desc.Digest.String()); err != nil {
+		_ = i.store.Abort(ctx, txn)
+		return fmt.Errorf("ERR_LINK_BLOB: %s: %w", desc.Digest, err)
+	}
+
+	return i.store.Commit(ctx, txn)
+}
+
+// GetOrCreateRepository returns the repository of the namespace, it's created as a private repository of the namespace
+// owner if it doesn't exist. Imports and promotions write to repositories that weren't pushed to before
+func GetOrCreateRepository(
+	ctx context.Context,
+	store registry.RegistryStore,
+	usersStore users.UserStore,
+	namespace string,
+) (*types.ContainerImageRepository, error) {
+	if repository, err := store.GetRepositoryByNamespace(ctx, namespace); err == nil {
+		return repository, nil
+	}
+
+	username, repoName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_INVALID_NAMESPACE: %w", err)
+	}
+
+	user, err := usersStore.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_GET_NAMESPACE_OWNER: %s: %w", username, err)
+	}
+
+	id, err := core_types.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	repository := &types.ContainerImageRepository{
+		CreatedAt:  time.Now(),
+		ID:         id,
+		OwnerID:    user.ID,
+		Name:       repoName,
+		Visibility: types.RepositoryVisibilityPrivate,
+	}
+
+	if err = store.CreateRepository(ctx, repository); err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_REPOSITORY: %s: %w", namespace, err)
+	}
+
+	return repository, nil
+}
@@ -0,0 +1,437 @@
+// Package layout reads and writes container images in the OCI image layout format, i.e, a directory (or a tarball of
+// one) with an oci-layout file, an index.json and the blobs stored under blobs/<algorithm>/<hex>
+// Reference: https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+package layout
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	oci_digest "github.com/opencontainers/go-digest"
+	img_spec "github.com/opencontainers/image-spec/specs-go"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+const (
+	// AnnotationNamespace is set on the index.json descriptors so that a single layout can carry images from
+	// multiple repositories
+	AnnotationNamespace = types.AnnotationNamespace
+
+	blobsDir = "blobs"
+)
+
+// ErrArchiveTooLarge is returned when a tarball extracts to more bytes than allowed
+var ErrArchiveTooLarge = errors.New("ERR_ARCHIVE_TOO_LARGE")
+
+type (
+	// Writer is the destination for an export, either a directory or a tarball
+	Writer interface {
+		// HasBlob reports whether the blob has already been written to the layout
+		HasBlob(digest oci_digest.Digest) bool
+		// WriteBlob writes the blob content to the layout and verifies it against the digest
+		WriteBlob(digest oci_digest.Digest, size int64, content io.Reader) error
+		// Close writes the oci-layout and index.json files and flushes the layout
+		Close(index *img_spec_v1.Index) error
+	}
+
+	// Reader is the source for an import
+	Reader interface {
+		Index() (*img_spec_v1.Index, error)
+		OpenBlob(digest oci_digest.Digest) (io.ReadCloser, error)
+		Close() error
+	}
+
+	dirWriter struct {
+		root string
+	}
+
+	tarWriter struct {
+		fd      *os.File
+		tw      *tar.Writer
+		written map[oci_digest.Digest]struct{}
+	}
+
+	dirReader struct {
+		root string
+		// cleanup is set when the directory is a temporary one, e.g, the extraction of a tarball
+		cleanup bool
+	}
+)
+
+// NewWriter returns a tarball writer if the output ends with .tar and a directory writer otherwise
+func NewWriter(output string) (Writer, error) {
+	if strings.HasSuffix(output, ".tar") {
+		return newTarWriter(output)
+	}
+
+	return newDirWriter(output)
+}
+
+// NewReader opens a layout directory or a layout tarball
+func NewReader(input string) (Reader, error) {
+	stat, err := os.Stat(input)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_OPEN_LAYOUT: %w", err)
+	}
+
+	if stat.IsDir() {
+		return &dirReader{root: input}, nil
+	}
+
+	fd, err := os.Open(input)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_OPEN_LAYOUT: %w", err)
+	}
+	defer fd.Close()
+
+	return NewReaderFromTar(fd, 0)
+}
+
+// NewReaderFromTar extracts the tarball into a temporary directory, since the blobs in an archive can be in any order
+// and we need random access to them. The directory is removed when the reader is closed. Archives that extract to more
+// than maxSize bytes are rejected with ErrArchiveTooLarge, a maxSize of 0 disables the limit
+func NewReaderFromTar(r io.Reader, maxSize int64) (Reader, error) {
+	root, err := os.MkdirTemp("", "openregistry-layout-")
+	if err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_TEMP_DIR: %w", err)
+	}
+
+	if err = extractTar(r, root, maxSize); err != nil {
+		_ = os.RemoveAll(root)
+		return nil, err
+	}
+
+	return &dirReader{root: root, cleanup: true}, nil
+}
+
+// NewPublishedImageReader downloads an image that storage published (see dfs.ImagePublisher) into a temporary
+// directory, e.g, the image DAG with the CID on IPFS. The directory is removed when the reader is closed
+func NewPublishedImageReader(storage dfs.DFS, link string) (Reader, error) {
+	publisher, ok := dfs.As[dfs.ImagePublisher](storage)
+	if !ok {
+		return nil, fmt.Errorf("ERR_OPEN_PUBLISHED_IMAGE: the storage backend doesn't publish images")
+	}
+
+	root, err := os.MkdirTemp("", "openregistry-published-")
+	if err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_TEMP_DIR: %w", err)
+	}
+
+	// DownloadDir creates the directory itself, the temporary one only reserves a unique name for it
+	if err = os.Remove(root); err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_TEMP_DIR: %w", err)
+	}
+
+	if err = publisher.DownloadDir(link, root); err != nil {
+		_ = os.RemoveAll(root)
+		return nil, fmt.Errorf("ERR_OPEN_PUBLISHED_IMAGE: %w", err)
+	}
+
+	return &dirReader{root: root, cleanup: true}, nil
+}
+
+// NewIndex returns an empty OCI image index, ready to be used with Writer.Close
+func NewIndex() *img_spec_v1.Index {
+	return &img_spec_v1.Index{
+		Versioned: img_spec.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType: img_spec_v1.MediaTypeImageIndex,
+		Manifests: make([]img_spec_v1.Descriptor, 0),
+	}
+}
+
+func newDirWriter(root string) (*dirWriter, error) {
+	if err := os.MkdirAll(filepath.Join(root, blobsDir, string(oci_digest.SHA256)), 0o755); err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_LAYOUT_DIR: %w", err)
+	}
+
+	return &dirWriter{root: root}, nil
+}
+
+func (w *dirWriter) HasBlob(digest oci_digest.Digest) bool {
+	_, err := os.Stat(blobPath(w.root, digest))
+	return err == nil
+}
+
+func (w *dirWriter) WriteBlob(digest oci_digest.Digest, size int64, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Join(w.root, blobsDir, digest.Algorithm().String()), 0o755); err != nil {
+		return fmt.Errorf("ERR_CREATE_BLOB_DIR: %w", err)
+	}
+
+	// write to a temp file first so that an interrupted export never leaves a partial blob behind
+	tmpPath := blobPath(w.root, digest) + ".tmp"
+	fd, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("ERR_CREATE_BLOB: %w", err)
+	}
+
+	if err = copyAndVerify(fd, content, digest, size); err != nil {
+		fd.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err = fd.Close(); err != nil {
+		return fmt.Errorf("ERR_CLOSE_BLOB: %w", err)
+	}
+
+	return os.Rename(tmpPath, blobPath(w.root, digest))
+}
+
+func (w *dirWriter) Close(index *img_spec_v1.Index) error {
+	layoutBz, indexBz, err := marshalLayoutFiles(index)
+	if err != nil {
+		return err
+	}
+
+	if err = os.WriteFile(filepath.Join(w.root, img_spec_v1.ImageLayoutFile), layoutBz, 0o644); err != nil {
+		return fmt.Errorf("ERR_WRITE_LAYOUT_FILE: %w", err)
+	}
+
+	if err = os.WriteFile(filepath.Join(w.root, img_spec_v1.ImageIndexFile), indexBz, 0o644); err != nil {
+		return fmt.Errorf("ERR_WRITE_INDEX_FILE: %w", err)
+	}
+
+	return nil
+}
+
+func newTarWriter(output string) (*tarWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(output), 0o755); err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_OUTPUT_DIR: %w", err)
+	}
+
+	fd, err := os.Create(output)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_CREATE_LAYOUT_TAR: %w", err)
+	}
+
+	return &tarWriter{
+		fd:      fd,
+		tw:      tar.NewWriter(fd),
+		written: make(map[oci_digest.Digest]struct{}),
+	}, nil
+}
+
+func (w *tarWriter) HasBlob(digest oci_digest.Digest) bool {
+	_, ok := w.written[digest]
+	return ok
+}
+
+// WriteBlob for tarballs relies on the size being correct, since the tar header has to be written before the content
+func (w *tarWriter) WriteBlob(digest oci_digest.Digest, size int64, content io.Reader) error {
+	hdr := &tar.Header{
+		Name:    filepath.ToSlash(blobPath("", digest)),
+		Mode:    0o644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("ERR_WRITE_TAR_HEADER: %w", err)
+	}
+
+	if err := copyAndVerify(w.tw, content, digest, size); err != nil {
+		return err
+	}
+
+	w.written[digest] = struct{}{}
+	return nil
+}
+
+func (w *tarWriter) Close(index *img_spec_v1.Index) error {
+	layoutBz, indexBz, err := marshalLayoutFiles(index)
+	if err != nil {
+		w.fd.Close()
+		return err
+	}
+
+	for name, content := range map[string][]byte{
+		img_spec_v1.ImageLayoutFile: layoutBz,
+		img_spec_v1.ImageIndexFile:  indexBz,
+	} {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: time.Now()}
+		if err = w.tw.WriteHeader(hdr); err != nil {
+			w.fd.Close()
+			return fmt.Errorf("ERR_WRITE_TAR_HEADER: %w", err)
+		}
+		if _, err = w.tw.Write(content); err != nil {
+			w.fd.Close()
+			return fmt.Errorf("ERR_WRITE_TAR: %w", err)
+		}
+	}
+
+	if err = w.tw.Close(); err != nil {
+		w.fd.Close()
+		return fmt.Errorf("ERR_CLOSE_TAR: %w", err)
+	}
+
+	return w.fd.Close()
+}
+
+func (r *dirReader) Index() (*img_spec_v1.Index, error) {
+	layoutBz, err := os.ReadFile(filepath.Join(r.root, img_spec_v1.ImageLayoutFile))
+	if err != nil {
+		return nil, fmt.Errorf("ERR_READ_LAYOUT_FILE: %w", err)
+	}
+
+	var imageLayout img_spec_v1.ImageLayout
+	if err = json.Unmarshal(layoutBz, &imageLayout); err != nil {
+		return nil, fmt.Errorf("ERR_PARSE_LAYOUT_FILE: %w", err)
+	}
+
+	if imageLayout.Version != img_spec_v1.ImageLayoutVersion {
+		return nil, fmt.Errorf("ERR_UNSUPPORTED_LAYOUT_VERSION: %s", imageLayout.Version)
+	}
+
+	indexBz, err := os.ReadFile(filepath.Join(r.root, img_spec_v1.ImageIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("ERR_READ_INDEX_FILE: %w", err)
+	}
+
+	var index img_spec_v1.Index
+	if err = json.Unmarshal(indexBz, &index); err != nil {
+		return nil, fmt.Errorf("ERR_PARSE_INDEX_FILE: %w", err)
+	}
+
+	return &index, nil
+}
+
+func (r *dirReader) OpenBlob(digest oci_digest.Digest) (io.ReadCloser, error) {
+	if err := digest.Validate(); err != nil {
+		return nil, fmt.Errorf("ERR_INVALID_DIGEST: %w", err)
+	}
+
+	fd, err := os.Open(blobPath(r.root, digest))
+	if err != nil {
+		return nil, fmt.Errorf("ERR_OPEN_BLOB: %w", err)
+	}
+
+	return fd, nil
+}
+
+func (r *dirReader) Close() error {
+	if r.cleanup {
+		return os.RemoveAll(r.root)
+	}
+
+	return nil
+}
+
+// ReadBlob reads the whole blob into memory and verifies its digest
+func ReadBlob(r Reader, digest oci_digest.Digest) ([]byte, error) {
+	rc, err := r.OpenBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	bz, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("ERR_READ_BLOB: %w", err)
+	}
+
+	if actual := digest.Algorithm().FromBytes(bz); actual != digest {
+		return nil, fmt.Errorf("ERR_BLOB_DIGEST_MISMATCH: expected %s, got %s", digest, actual)
+	}
+
+	return bz, nil
+}
+
+func blobPath(root string, digest oci_digest.Digest) string {
+	return filepath.Join(root, blobsDir, digest.Algorithm().String(), digest.Encoded())
+}
+
+func copyAndVerify(dst io.Writer, src io.Reader, digest oci_digest.Digest, size int64) error {
+	verifier := digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(dst, verifier), src)
+	if err != nil {
+		return fmt.Errorf("ERR_WRITE_BLOB: %w", err)
+	}
+
+	if size >= 0 && n != size {
+		return fmt.Errorf("ERR_BLOB_SIZE_MISMATCH: %s: expected %d bytes, got %d", digest, size, n)
+	}
+
+	if !verifier.Verified() {
+		return fmt.Errorf("ERR_BLOB_DIGEST_MISMATCH: %s", digest)
+	}
+
+	return nil
+}
+
+func marshalLayoutFiles(index *img_spec_v1.Index) ([]byte, []byte, error) {
+	layoutBz, err := json.Marshal(img_spec_v1.ImageLayout{Version: img_spec_v1.ImageLayoutVersion})
+	if err != nil {
+		return nil, nil, fmt.Errorf("ERR_MARSHAL_LAYOUT_FILE: %w", err)
+	}
+
+	indexBz, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return nil, nil, fmt.Errorf("ERR_MARSHAL_INDEX_FILE: %w", err)
+	}
+
+	return layoutBz, indexBz, nil
+}
+
+func extractTar(r io.Reader, root string, maxSize int64) error {
+	var extracted int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ERR_READ_TAR: %w", err)
+		}
+
+		// guard against path traversal, i.e, entries like ../../etc/passwd
+		target := filepath.Join(root, filepath.Clean("/"+hdr.Name))
+		if !strings.HasPrefix(target, filepath.Clean(root)+string(os.PathSeparator)) {
+			return fmt.Errorf("ERR_INVALID_TAR_ENTRY: %s", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0o755); err != nil {
+				return fmt.Errorf("ERR_EXTRACT_TAR: %w", err)
+			}
+		case tar.TypeReg:
+			// the header size can't be trusted for the limit, it's only checked to fail early
+			extracted += hdr.Size
+			if maxSize > 0 && extracted > maxSize {
+				return fmt.Errorf("%w: more than %d bytes", ErrArchiveTooLarge, maxSize)
+			}
+
+			if err = os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return fmt.Errorf("ERR_EXTRACT_TAR: %w", err)
+			}
+
+			fd, err := os.Create(target)
+			if err != nil {
+				return fmt.Errorf("ERR_EXTRACT_TAR: %w", err)
+			}
+
+			// nolint:gosec // the entries are content addressed blobs that we verify before using them
+			n, err := io.Copy(fd, io.LimitReader(tr, hdr.Size))
+			fd.Close()
+			if err != nil {
+				return fmt.Errorf("ERR_EXTRACT_TAR: %w", err)
+			}
+			if n != hdr.Size {
+				return fmt.Errorf("ERR_EXTRACT_TAR: %s: expected %d bytes, got %d", hdr.Name, hdr.Size, n)
+			}
+		}
+	}
+}
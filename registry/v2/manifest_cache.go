@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/labstack/echo/v4"
+	oci_digest "github.com/opencontainers/go-digest"
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/containerish/OpenRegistry/config"
+	types_v2 "github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+const (
+	// digestCacheControl is sent for manifests pulled by digest, their content can never change
+	digestCacheControl = "max-age=31536000, immutable"
+
+	defaultManifestCacheSize = 4096
+	defaultManifestCacheTTL  = 30 * time.Second
+)
+
+// ManifestCacheInvalidator drops the cached tag lookups of a repository. Everything that moves tags outside the
+// manifest push & delete handlers (promotion, rollback, imports, conversion) calls it once the move is committed
+type ManifestCacheInvalidator interface {
+	InvalidateManifestCache(namespace string)
+}
+
+// manifestCache maps namespace + reference to the descriptor of the manifest bytes we serve, so that clients polling a
+// tag with HEAD requests don't hit the database every time. Entries expire after the configured TTL and are dropped
+// whenever a tag of the repository moves, see ManifestCacheInvalidator
+type manifestCache struct {
+	lru *expirable.LRU[string, img_spec_v1.Descriptor]
+	ttl int
+}
+
+// newManifestCache creates the cache with the defaults from the YAML config for unset values, since a size of 0 makes
+// the LRU unbounded and a TTL of 0 means entries never expire
+func newManifestCache(cfg *config.ManifestCache) *manifestCache {
+	size, ttl := cfg.Size, cfg.TTL
+	if size <= 0 {
+		size = defaultManifestCacheSize
+	}
+	if ttl <= 0 {
+		ttl = defaultManifestCacheTTL
+	}
+
+	return &manifestCache{
+		lru: expirable.NewLRU[string, img_spec_v1.Descriptor](size, nil, ttl),
+		ttl: int(ttl.Seconds()),
+	}
+}
+
+func manifestCacheKey(namespace, ref string) string {
+	// namespaces can't contain a colon, so the key is never ambiguous even for digest references
+	return namespace + ":" + ref
+}
+
+func (c *manifestCache) Get(namespace, ref string) (img_spec_v1.Descriptor, bool) {
+	return c.lru.Get(manifestCacheKey(namespace, ref))
+}
+
+func (c *manifestCache) Set(namespace, ref string, manifest *types_v2.ImageManifest) img_spec_v1.Descriptor {
+	desc := img_spec_v1.Descriptor{
+		MediaType: manifest.MediaType,
+		Digest:    oci_digest.Digest(manifest.Digest),
+		Size:      int64(len(manifest.ToOCISubject())),
+	}
+
+	c.lru.Add(manifestCacheKey(namespace, ref), desc)
+	return desc
+}
+
+// InvalidateNamespace drops all the cached references of a repository
+func (c *manifestCache) InvalidateNamespace(namespace string) {
+	prefix := namespace + ":"
+	for _, key := range c.lru.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.lru.Remove(key)
+		}
+	}
+}
+
+// Purge drops all the cached references
+func (c *manifestCache) Purge() {
+	c.lru.Purge()
+}
+
+// CacheControl returns the Cache-Control header for a manifest reference. Digest references are immutable, tags are
+// cached for as long as the server side cache keeps them
+func (c *manifestCache) CacheControl(ref string) string {
+	if _, err := oci_digest.Parse(ref); err == nil {
+		return digestCacheControl
+	}
+
+	return fmt.Sprintf("max-age=%d", c.ttl)
+}
+
+// InvalidateManifestCache implements ManifestCacheInvalidator
+func (r *registry) InvalidateManifestCache(namespace string) {
+	r.manifestCache.InvalidateNamespace(namespace)
+}
+
+// setManifestCacheHeaders sets the conditional request and caching headers for a manifest and reports whether the
+// client already has it, in which case the response should be a 304
+func (r *registry) setManifestCacheHeaders(ctx echo.Context, ref string, desc img_spec_v1.Descriptor) bool {
+	etag := fmt.Sprintf("%q", desc.Digest.String())
+	ctx.Response().Header().Set("ETag", etag)
+	ctx.Response().Header().Set("Cache-Control", r.manifestCache.CacheControl(ref))
+	ctx.Response().Header().Set(HeaderDockerContentDigest, desc.Digest.String())
+
+	return etagMatches(ctx.Request().Header.Get("If-None-Match"), desc.Digest.String())
+}
+
+// etagMatches implements the weak comparison used for If-None-Match
+// Reference: https://www.rfc-editor.org/rfc/rfc9110#name-if-none-match
+func etagMatches(ifNoneMatch, digest string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		if strings.Trim(strings.TrimPrefix(tag, "W/"), `"`) == digest {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,212 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	store_v2 "github.com/containerish/OpenRegistry/store/v1/registry"
+	types_v2 "github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// defaultMaxManifestSize is used when the config doesn't set a limit, the distribution spec recommends accepting
+// manifests of at least 4 MiB
+const defaultMaxManifestSize = 4 * 1024 * 1024
+
+// manifestValidationError carries the OCI error code and status that the push should be rejected with
+type manifestValidationError struct {
+	detail  map[string]any
+	code    string
+	message string
+	status  int
+}
+
+func (e *manifestValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.code, e.message)
+}
+
+func invalidManifest(format string, args ...any) error {
+	return &manifestValidationError{
+		code:    RegistryErrorCodeManifestInvalid,
+		status:  http.StatusBadRequest,
+		message: fmt.Sprintf(format, args...),
+	}
+}
+
+func unknownManifestBlob(desc *img_spec_v1.Descriptor, message string) error {
+	return &manifestValidationError{
+		code:    RegistryErrorCodeManifestBlobUnknown,
+		status:  http.StatusBadRequest,
+		message: message,
+		detail:  map[string]any{"digest": desc.Digest.String(), "mediaType": desc.MediaType},
+	}
+}
+
+// tagRegex is the grammar of tags in the distribution spec
+var tagRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// IsValidTag reports whether the tag matches the grammar of tags in the distribution spec
+func IsValidTag(tag string) bool {
+	return tagRegex.MatchString(tag)
+}
+
+// ValidateManifest runs the checks that a pushed manifest goes through before it's stored: the schema of its media
+// type, the blobs or manifests it references and the compression of its layers. Manifests that are written without a
+// push (e.g, imports) go through it as well
+func ValidateManifest(
+	ctx context.Context,
+	store store_v2.RegistryStore,
+	namespace string,
+	manifest *types_v2.ImageManifest,
+) error {
+	if err := validateManifestSchema(manifest); err != nil {
+		return err
+	}
+
+	if err := validateManifestReferences(ctx, store, namespace, manifest); err != nil {
+		return err
+	}
+
+	return validateLayerCompression(manifest)
+}
+
+// IsImageIndex reports whether the media type is an OCI image index or a Docker manifest list
+func IsImageIndex(mediaType string) bool {
+	return mediaType == img_spec_v1.MediaTypeImageIndex || mediaType == types_v2.MediaTypeDockerManifestList
+}
+
+// IsImageManifest reports whether the media type is a single platform OCI or Docker image manifest
+func IsImageManifest(mediaType string) bool {
+	return mediaType == img_spec_v1.MediaTypeImageManifest || mediaType == types_v2.MediaTypeDockerManifest
+}
+
+// validateManifestSchema checks the manifest against the schema of its media type, without looking at the storage
+func validateManifestSchema(manifest *types_v2.ImageManifest) error {
+	if manifest.SchemaVersion != 2 {
+		return invalidManifest("unsupported schemaVersion: %d", manifest.SchemaVersion)
+	}
+
+	switch {
+	case IsImageIndex(manifest.MediaType):
+		if manifest.Config != nil || len(manifest.Layers) != 0 {
+			return invalidManifest("%s must not have a config or layers", manifest.MediaType)
+		}
+
+		for i := range manifest.Manifests {
+			child := &manifest.Manifests[i]
+			if err := validateDescriptor(child, "manifests"); err != nil {
+				return err
+			}
+
+			if !IsImageManifest(child.MediaType) && !IsImageIndex(child.MediaType) {
+				return invalidManifest("manifests[%d] has unsupported media type: %s", i, child.MediaType)
+			}
+		}
+	case IsImageManifest(manifest.MediaType):
+		if len(manifest.Manifests) != 0 {
+			return invalidManifest("%s must not have manifests", manifest.MediaType)
+		}
+
+		if manifest.Config == nil {
+			return invalidManifest("config is required for %s", manifest.MediaType)
+		}
+
+		if err := validateDescriptor(manifest.Config, "config"); err != nil {
+			return err
+		}
+
+		// docker manifests can only reference docker image configs, OCI manifests may carry artifacts
+		if manifest.MediaType == types_v2.MediaTypeDockerManifest &&
+			manifest.Config.MediaType != types_v2.MediaTypeDockerImageConfig {
+			return invalidManifest("unsupported config media type for docker manifest: %s", manifest.Config.MediaType)
+		}
+
+		for _, layer := range manifest.Layers {
+			if err := validateDescriptor(layer, "layers"); err != nil {
+				return err
+			}
+		}
+	default:
+		return invalidManifest("unsupported manifest media type: %s", manifest.MediaType)
+	}
+
+	if manifest.Subject != nil {
+		return validateDescriptor(manifest.Subject, "subject")
+	}
+
+	return nil
+}
+
+func validateDescriptor(desc *img_spec_v1.Descriptor, field string) error {
+	if desc.MediaType == "" {
+		return invalidManifest("%s: mediaType is required", field)
+	}
+
+	if err := desc.Digest.Validate(); err != nil {
+		return invalidManifest("%s: invalid digest %q: %s", field, desc.Digest, err)
+	}
+
+	if desc.Size < 0 {
+		return invalidManifest("%s: invalid size %d for %s", field, desc.Size, desc.Digest)
+	}
+
+	return nil
+}
+
+// isForeignLayer reports whether the layer is hosted outside the registry. Foreign layers are never pushed, so there's
+// nothing in the storage to check them against
+func isForeignLayer(desc *img_spec_v1.Descriptor) bool {
+	//nolint:staticcheck // the non-distributable media types are deprecated but still in use
+	switch desc.MediaType {
+	case types_v2.MediaTypeDockerForeignLayerGzip,
+		img_spec_v1.MediaTypeImageLayerNonDistributable,
+		img_spec_v1.MediaTypeImageLayerNonDistributableGzip,
+		img_spec_v1.MediaTypeImageLayerNonDistributableZstd:
+		return len(desc.URLs) > 0
+	default:
+		return false
+	}
+}
+
+// validateManifestReferences checks that every blob the manifest references was pushed to the repository (or is used
+// by another manifest in it) and that the sizes in the descriptors match the stored blobs. The child manifests of an
+// index must be pushed to the same repository before the index
+func validateManifestReferences(
+	ctx context.Context,
+	store store_v2.RegistryStore,
+	namespace string,
+	manifest *types_v2.ImageManifest,
+) error {
+	if IsImageIndex(manifest.MediaType) {
+		for i := range manifest.Manifests {
+			child := &manifest.Manifests[i]
+			if _, err := store.GetManifestByReference(ctx, namespace, child.Digest.String()); err != nil {
+				return unknownManifestBlob(child, "manifest referenced by the index was not pushed to this repository")
+			}
+		}
+
+		return nil
+	}
+
+	blobs := append([]*img_spec_v1.Descriptor{manifest.Config}, manifest.Layers...)
+	for _, desc := range blobs {
+		if isForeignLayer(desc) {
+			continue
+		}
+
+		layer, err := getRepositoryLayer(ctx, store, namespace, desc.Digest.String())
+		if err != nil {
+			return unknownManifestBlob(desc, "blob referenced by the manifest was not pushed to this repository")
+		}
+
+		if layer.Size != desc.Size {
+			return invalidManifest("size mismatch for %s: descriptor has %d bytes, stored blob has %d bytes",
+				desc.Digest, desc.Size, layer.Size)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+
+	dfsImpl "github.com/containerish/OpenRegistry/dfs"
+	types_v2 "github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// publishImage publishes a pushed image manifest, along with its config and layers, when the storage backend of the
+// namespace publishes images (i.e, IPFS). Image indexes aren't published, and neither are images on encrypted storage,
+// since their blobs would be published encrypted. The manifest is stored at this point, so a failure is logged instead
+// of failing the push and an empty link is returned
+func (r *registry) publishImage(
+	ctx context.Context,
+	namespace string,
+	ref string,
+	manifestBz []byte,
+	manifest *types_v2.ImageManifest,
+) string {
+	_, storage := dfsImpl.ForNamespace(r.dfs, namespace)
+	publisher, ok := dfsImpl.As[dfsImpl.ImagePublisher](storage)
+	if !ok || manifest.Config == nil {
+		return ""
+	}
+
+	if dfsImpl.EncryptsBlobs(storage) {
+		r.logger.Debug().Str("namespace", namespace).Str("reference", ref).Msg("skipped publishing an encrypted image")
+		return ""
+	}
+
+	link, err := r.publish(ctx, publisher, namespace, ref, manifestBz, manifest)
+	if err != nil {
+		r.logger.Info().Err(err).Str("namespace", namespace).Str("reference", ref).Msg("ERR_PUBLISH_IMAGE")
+		return ""
+	}
+
+	return link
+}
+
+func (r *registry) publish(
+	ctx context.Context,
+	publisher dfsImpl.ImagePublisher,
+	namespace string,
+	ref string,
+	manifestBz []byte,
+	manifest *types_v2.ImageManifest,
+) (string, error) {
+	descriptors := append([]*img_spec_v1.Descriptor{manifest.Config}, manifest.Layers...)
+	blobs := make(map[string]string, len(descriptors))
+	for _, desc := range descriptors {
+		layer, err := r.store.GetLayer(ctx, desc.Digest.String())
+		if err != nil {
+			return "", fmt.Errorf("ERR_PUBLISH_IMAGE_GET_LAYER: %s: %w", desc.Digest, err)
+		}
+		blobs[desc.Digest.String()] = layer.DFSLink
+	}
+
+	return publisher.PublishImage(ctx, namespace, ref, manifestBz, blobs)
+}
@@ -4,17 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"path"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	oci_digest "github.com/opencontainers/go-digest"
 	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
@@ -22,6 +23,7 @@ import (
 	"github.com/containerish/OpenRegistry/common"
 	"github.com/containerish/OpenRegistry/config"
 	dfsImpl "github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/registry/v2/conversion"
 	store_v2 "github.com/containerish/OpenRegistry/store/v1/registry"
 	types_v2 "github.com/containerish/OpenRegistry/store/v1/types"
 	"github.com/containerish/OpenRegistry/telemetry"
@@ -49,13 +51,18 @@ func NewRegistry(
 			layerParts:         make(map[string][]s3types.CompletedPart),
 			mu:                 mu,
 		},
-		logger: logger,
-		store:  pgStore,
-		txnMap: map[string]TxnStore{},
+		logger:        logger,
+		store:         pgStore,
+		txnMap:        map[string]TxnStore{},
+		manifestCache: newManifestCache(&config.Registry.ManifestCache),
 	}
 
 	r.b.registry = r
 
+	if config.Registry.Conversion.Enabled {
+		r.converter = conversion.New(pgStore, dfs, logger, &config.Registry.Conversion, r.InvalidateManifestCache)
+	}
+
 	return r
 }
 
@@ -78,24 +85,34 @@ func (r *registry) ManifestExists(ctx echo.Context) error {
 	namespace := ctx.Get(string(RegistryNamespace)).(string)
 	ref := ctx.Param("reference") // ref can be either tag or digest
 
-	manifest, err := r.store.GetManifestByReference(ctx.Request().Context(), namespace, ref)
-	if err != nil {
-		details := echo.Map{
-			"error":     err.Error(),
-			"message":   "manifest not found",
-			"reference": ref,
+	desc, ok := r.manifestCache.Get(namespace, ref)
+	if !ok {
+		manifest, err := r.store.GetManifestByReference(ctx.Request().Context(), namespace, ref)
+		if err != nil {
+			details := echo.Map{
+				"error":     err.Error(),
+				"message":   "manifest not found",
+				"reference": ref,
+			}
+
+			errMsg := common.RegistryErrorResponse(RegistryErrorCodeManifestBlobUnknown, err.Error(), details)
+			r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+			return ctx.NoContent(http.StatusNotFound)
 		}
 
-		errMsg := common.RegistryErrorResponse(RegistryErrorCodeManifestBlobUnknown, err.Error(), details)
-		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
-		return ctx.NoContent(http.StatusNotFound)
+		desc = r.manifestCache.Set(namespace, ref, manifest)
 	}
 
-	ctx.Response().Header().Set("Content-Length", fmt.Sprintf("%d", manifest.Size))
-	ctx.Response().Header().Set("Docker-Content-Digest", manifest.Digest)
-	ctx.Response().Header().Set("Content-Type", img_spec_v1.MediaTypeImageManifest)
+	if r.setManifestCacheHeaders(ctx, ref, desc) {
+		echoErr := ctx.NoContent(http.StatusNotModified)
+		r.logger.Log(ctx, nil).Send()
+		return echoErr
+	}
+
+	ctx.Response().Header().Set("Content-Length", fmt.Sprintf("%d", desc.Size))
+	ctx.Response().Header().Set("Content-Type", desc.MediaType)
 	echoErr := ctx.NoContent(http.StatusOK)
-	r.logger.Log(ctx, nil).Any("manifest", manifest).Send()
+	r.logger.Log(ctx, nil).Any("manifest", desc).Send()
 	// nil is okay here since all the required information has been set above
 	return echoErr
 }
@@ -225,6 +242,13 @@ func (r *registry) PullManifest(ctx echo.Context) error {
 		}
 	}
 
+	// a cached lookup is enough to tell that the client already has the manifest
+	if desc, ok := r.manifestCache.Get(namespace, ref); ok && r.setManifestCacheHeaders(ctx, ref, desc) {
+		echoErr := ctx.NoContent(http.StatusNotModified)
+		r.logger.Log(ctx, nil).Send()
+		return echoErr
+	}
+
 	manifest, err := r.store.GetManifestByReference(ctx.Request().Context(), namespace, ref)
 	if err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeManifestUnknown, "manifest not found", echo.Map{
@@ -237,6 +261,13 @@ func (r *registry) PullManifest(ctx echo.Context) error {
 		return echoErr
 	}
 
+	desc := r.manifestCache.Set(namespace, ref, manifest)
+	if r.setManifestCacheHeaders(ctx, ref, desc) {
+		echoErr := ctx.NoContent(http.StatusNotModified)
+		r.logger.Log(ctx, nil).Send()
+		return echoErr
+	}
+
 	defer func() {
 		err = r.store.IncrementRepositoryPullCounter(ctx.Request().Context(), manifest.RepositoryID)
 		// silently fail
@@ -245,8 +276,16 @@ func (r *registry) PullManifest(ctx echo.Context) error {
 		}
 	}()
 
+	// fsck found missing or corrupted blobs, the pull is likely to fail on one of them
+	if !manifest.BrokenAt.IsZero() {
+		ctx.Response().Header().Set("Warning", fmt.Sprintf(
+			`299 - "manifest %s references blobs that were missing or corrupted at %s"`,
+			manifest.Digest,
+			manifest.BrokenAt.UTC().Format(time.RFC3339),
+		))
+	}
+
 	trimmedMf := manifest.ToOCISubject()
-	ctx.Response().Header().Set("Docker-Content-Digest", manifest.Digest)
 	ctx.Response().Header().Set("Content-Type", manifest.MediaType)
 	ctx.Response().Header().Set("Content-Length", fmt.Sprintf("%d", len(trimmedMf)))
 	echoErr := ctx.JSONBlob(http.StatusOK, trimmedMf)
@@ -260,8 +299,9 @@ func (r *registry) PullManifest(ctx echo.Context) error {
 func (r *registry) PullLayer(ctx echo.Context) error {
 	ctx.Set(types.HandlerStartTime, time.Now())
 
+	namespace := ctx.Get(string(RegistryNamespace)).(string)
 	clientDigest := ctx.Param("digest")
-	layer, err := r.store.GetLayer(ctx.Request().Context(), clientDigest)
+	layer, err := getRepositoryLayer(ctx.Request().Context(), r.store, namespace, clientDigest)
 	if err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUnknown, err.Error(), nil)
 		echoErr := ctx.JSONBlob(http.StatusNotFound, errMsg.Bytes())
@@ -279,7 +319,8 @@ func (r *registry) PullLayer(ctx echo.Context) error {
 		return echoErr
 	}
 
-	size, err := r.dfs.Metadata(layer)
+	storage := dfsImpl.ForLayer(r.dfs, layer)
+	size, err := storage.Metadata(layer)
 	if err != nil {
 		detail := map[string]interface{}{
 			"error":          err.Error(),
@@ -294,9 +335,12 @@ func (r *registry) PullLayer(ctx echo.Context) error {
 
 	ctx.Response().Header().Set("Content-Length", fmt.Sprintf("%d", size.ContentLength))
 	ctx.Response().Header().Set("Docker-Content-Digest", layer.Digest)
-	ctx.Response().Header().Set("status", "307")
+	if dfsImpl.ProxyDownloads(storage) {
+		return r.proxyLayer(ctx, storage, layer)
+	}
 
-	downloadableURL, err := r.getDownloadableURLFromDFSLink(layer.DFSLink)
+	ctx.Response().Header().Set("status", "307")
+	downloadableURL, err := r.getDownloadableURL(namespace, layer)
 	if err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUnknown, err.Error(), nil)
 		echoErr := ctx.JSONBlob(http.StatusInternalServerError, errMsg.Bytes())
@@ -314,6 +358,7 @@ func (r *registry) PullLayer(ctx echo.Context) error {
 func (r *registry) MonolithicUpload(ctx echo.Context) error {
 	ctx.Set(types.HandlerStartTime, time.Now())
 
+	namespace := ctx.Get(string(RegistryNamespace)).(string)
 	imageDigest := ctx.QueryParam("digest")
 	buf := &bytes.Buffer{}
 	if _, err := io.Copy(buf, ctx.Request().Body); err != nil {
@@ -352,7 +397,8 @@ func (r *registry) MonolithicUpload(ctx echo.Context) error {
 		return echoErr
 	}
 
-	dfsLink, err := r.dfs.Upload(ctx.Request().Context(), types.GetLayerIdentifier(uuid), imageDigest, buf.Bytes())
+	backend, storage := r.storageFor(namespace)
+	dfsLink, err := storage.Upload(ctx.Request().Context(), types.GetLayerIdentifier(uuid), imageDigest, buf.Bytes())
 	if err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUploadInvalid, err.Error(), nil)
 		echoErr := ctx.JSONBlob(http.StatusRequestedRangeNotSatisfiable, errMsg.Bytes())
@@ -364,6 +410,7 @@ func (r *registry) MonolithicUpload(ctx echo.Context) error {
 		MediaType: ctx.Request().Header.Get("content-type"),
 		Digest:    imageDigest,
 		DFSLink:   dfsLink,
+		Backend:   backend,
 		ID:        uuid,
 		Size:      int64(buf.Len()),
 		CreatedAt: time.Now(),
@@ -385,6 +432,13 @@ func (r *registry) MonolithicUpload(ctx echo.Context) error {
 		return echoErr
 	}
 
+	if err = r.store.LinkBlob(ctx.Request().Context(), txnOp, namespace, layerV2.Digest); err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUploadInvalid, err.Error(), nil)
+		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
 	if err = r.store.Commit(ctx.Request().Context(), txnOp); err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUploadInvalid, err.Error(), nil)
 		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
@@ -392,7 +446,7 @@ func (r *registry) MonolithicUpload(ctx echo.Context) error {
 		return echoErr
 	}
 
-	downloadableURL, err := r.getDownloadableURLFromDFSLink(dfsLink)
+	downloadableURL, err := r.getDownloadableURL(namespace, layerV2)
 	if err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUnknown, err.Error(), nil)
 		echoErr := ctx.JSONBlob(http.StatusInternalServerError, errMsg.Bytes())
@@ -440,7 +494,8 @@ func (r *registry) StartUpload(ctx echo.Context) error {
 		return echoErr
 	}
 
-	uploadId, err := r.dfs.CreateMultipartUpload(types.GetLayerIdentifier(layerIdentifier))
+	_, storage := r.storageFor(namespace)
+	uploadId, err := storage.CreateMultipartUpload(types.GetLayerIdentifier(layerIdentifier))
 	if err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUploadUnknown, err.Error(), nil)
 		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
@@ -473,7 +528,7 @@ func (r *registry) StartUpload(ctx echo.Context) error {
 	ctx.Response().Header().Set("Location", locationHeader)
 	ctx.Response().Header().Set("Content-Length", "0")
 	ctx.Response().Header().Set("Docker-Upload-UUID", uploadTrackingID)
-	ctx.Response().Header().Set("OCI-Chunk-Min-Length", fmt.Sprintf("%d", r.dfs.Config().MinChunkSize))
+	ctx.Response().Header().Set("OCI-Chunk-Min-Length", fmt.Sprintf("%d", storage.Config().MinChunkSize))
 	ctx.Response().Header().Set("Range", "0-0")
 	echoErr := ctx.NoContent(http.StatusAccepted)
 	r.logger.Log(ctx, echoErr).Send()
@@ -489,7 +544,8 @@ func (r *registry) UploadProgress(ctx echo.Context) error {
 	layerkey := types.GetLayerIdentifierFromTrakcingID(uuid)
 	uploadID := types.GetUploadIDFromTrakcingID(uuid)
 
-	metadata, err := r.dfs.GetUploadProgress(types.GetLayerIdentifier(layerkey), uploadID)
+	_, storage := r.storageFor(namespace)
+	metadata, err := storage.GetUploadProgress(types.GetLayerIdentifier(layerkey), uploadID)
 	if err != nil {
 		locationHeader := fmt.Sprintf("/v2/%s/blobs/uploads/%s", namespace, uuid)
 		ctx.Response().Header().Set("Location", locationHeader)
@@ -513,6 +569,7 @@ func (r *registry) MonolithicPut(ctx echo.Context) error {
 	ctx.Set(types.HandlerStartTime, time.Now())
 
 	digest := ctx.QueryParam("digest")
+	namespace := ctx.Get(string(RegistryNamespace)).(string)
 	identifier := ctx.Param("uuid")
 	layerKey := types.GetLayerIdentifierFromTrakcingID(identifier)
 	uploadID := types.GetUploadIDFromTrakcingID(identifier)
@@ -527,7 +584,23 @@ func (r *registry) MonolithicPut(ctx echo.Context) error {
 	defer ctx.Request().Body.Close()
 	ourHash := oci_digest.FromBytes(buf.Bytes())
 
-	dfsLink, err := r.dfs.Upload(
+	if ourHash.String() != digest {
+		details := map[string]interface{}{
+			"clientDigest":   digest,
+			"computedDigest": ourHash.String(),
+		}
+		errMsg := common.RegistryErrorResponse(
+			RegistryErrorCodeDigestInvalid,
+			"client digest does not meet computed digest",
+			details,
+		)
+		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
+	backend, storage := r.storageFor(namespace)
+	dfsLink, err := storage.Upload(
 		ctx.Request().Context(),
 		types.GetLayerIdentifier(layerKey),
 		ourHash.String(),
@@ -555,9 +628,10 @@ func (r *registry) MonolithicPut(ctx echo.Context) error {
 	layer := &types_v2.ContainerImageLayer{
 		CreatedAt: time.Now(),
 		ID:        layerKey,
-		Digest:    digest,
+		Digest:    ourHash.String(),
 		MediaType: ctx.Request().Header.Get("content-type"),
 		DFSLink:   dfsLink,
+		Backend:   backend,
 		Size:      int64(buf.Len()),
 	}
 
@@ -570,6 +644,15 @@ func (r *registry) MonolithicPut(ctx echo.Context) error {
 		return echoErr
 	}
 
+	if err = r.store.LinkBlob(ctx.Request().Context(), txnOp.txn, namespace, layer.Digest); err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
+			"error_detail": "link blob issues",
+		})
+		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
 	if err = r.store.Commit(ctx.Request().Context(), txnOp.txn); err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
 			"error_detail": "commitment issue",
@@ -579,7 +662,7 @@ func (r *registry) MonolithicPut(ctx echo.Context) error {
 		return echoErr
 	}
 
-	downlaodableURL, err := r.getDownloadableURLFromDFSLink(dfsLink)
+	downlaodableURL, err := r.getDownloadableURL(namespace, layer)
 	if err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUnknown, err.Error(), nil)
 		echoErr := ctx.JSONBlob(http.StatusInternalServerError, errMsg.Bytes())
@@ -624,9 +707,10 @@ func (r *registry) CompleteUpload(ctx echo.Context) error {
 	defer ctx.Request().Body.Close()
 	checksum := oci_digest.FromBytes(buf.Bytes())
 
+	backend, storage := r.storageFor(namespace)
 	if buf.Len() > 0 {
 		r.b.blobCounter[uploadID]++
-		part, err := r.dfs.UploadPart(
+		part, err := storage.UploadPart(
 			ctx.Request().Context(),
 			uploadID,
 			types.GetLayerIdentifier(layerKey),
@@ -648,7 +732,7 @@ func (r *registry) CompleteUpload(ctx echo.Context) error {
 		r.mu.Unlock()
 	}
 
-	dfsLink, err := r.dfs.CompleteMultipartUpload(
+	dfsLink, err := storage.CompleteMultipartUpload(
 		ctx.Request().Context(),
 		uploadID,
 		types.GetLayerIdentifier(layerKey),
@@ -665,7 +749,7 @@ func (r *registry) CompleteUpload(ctx echo.Context) error {
 			"error":  err.Error(),
 		})
 
-		_ = r.dfs.AbortMultipartUpload(ctx.Request().Context(), types.GetLayerIdentifier(layerKey), uploadID)
+		_ = storage.AbortMultipartUpload(ctx.Request().Context(), types.GetLayerIdentifier(layerKey), uploadID)
 		echoErr := ctx.JSONBlob(http.StatusRequestedRangeNotSatisfiable, errMsg.Bytes())
 		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
 		return echoErr
@@ -687,6 +771,7 @@ func (r *registry) CompleteUpload(ctx echo.Context) error {
 		MediaType: ctx.Request().Header.Get("content-type"),
 		Digest:    digest,
 		DFSLink:   dfsLink,
+		Backend:   backend,
 		ID:        layerKey,
 		Size:      int64(r.b.layerLengthCounter[uploadID]),
 		CreatedAt: time.Now(),
@@ -701,6 +786,15 @@ func (r *registry) CompleteUpload(ctx echo.Context) error {
 		return echoErr
 	}
 
+	if err := r.store.LinkBlob(ctx.Request().Context(), txnOp.txn, namespace, layer.Digest); err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
+			"error_detail": "link blob issues",
+		})
+		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
 	if err := r.store.Commit(ctx.Request().Context(), txnOp.txn); err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
 			"error_detail": "commitment issue",
@@ -710,9 +804,10 @@ func (r *registry) CompleteUpload(ctx echo.Context) error {
 		return echoErr
 	}
 
-	locationHeader := fmt.Sprintf("/v2/%s/blobs/%s", namespace, checksum.String())
+	// the backend verified the blob against the digest, checksum is only the digest of the last chunk
+	locationHeader := fmt.Sprintf("/v2/%s/blobs/%s", namespace, layer.Digest)
 	ctx.Response().Header().Set("Content-Length", "0")
-	ctx.Response().Header().Set("Docker-Content-Digest", checksum.String())
+	ctx.Response().Header().Set("Docker-Content-Digest", layer.Digest)
 	ctx.Response().Header().Set("Location", locationHeader)
 	echoErr := ctx.NoContent(http.StatusCreated)
 	r.logger.Log(ctx, echoErr).Send()
@@ -752,9 +847,96 @@ func (r *registry) PushManifest(ctx echo.Context) error {
 		return echoErr
 	}
 
+	maxManifestSize := r.config.Registry.MaxManifestSize
+	if maxManifestSize <= 0 {
+		maxManifestSize = defaultMaxManifestSize
+	}
+	buf := &bytes.Buffer{}
+	// read one byte past the limit to tell a manifest that's exactly at the limit from one that's over it
+	_, err = io.Copy(buf, io.LimitReader(ctx.Request().Body, maxManifestSize+1))
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{
+			"error":   err.Error(),
+			"message": "failed in push manifest while io Copy",
+		})
+	}
+	defer ctx.Request().Body.Close()
+
+	if int64(buf.Len()) > maxManifestSize {
+		errMsg := common.RegistryErrorResponse(
+			RegistryErrorCodeManifestInvalid,
+			fmt.Sprintf("manifest exceeds the maximum size of %d bytes", maxManifestSize),
+			nil,
+		)
+		echoErr := ctx.JSONBlob(http.StatusRequestEntityTooLarge, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
+	digest := oci_digest.FromBytes(buf.Bytes())
+
+	uuid, err := types.NewUUID()
+	if err != nil {
+		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+			"cause": "error creating random id for config",
+		})
+		r.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	manifest := types_v2.ImageManifest{
+		CreatedAt: time.Now(),
+		ID:        uuid,
+		OwnerID:   user.ID,
+		Digest:    digest.String(),
+		Reference: ref,
+		Size:      0,
+	}
+
+	if err = json.Unmarshal(buf.Bytes(), &manifest); err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeManifestInvalid, err.Error(), nil)
+		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
+	if manifest.MediaType == "" {
+		// mediaType is optional in the manifest body, clients always send it as the Content-Type
+		manifest.MediaType = img_spec_v1.MediaTypeImageManifest
+		if contentType := ctx.Request().Header.Get(echo.HeaderContentType); IsImageIndex(contentType) {
+			manifest.MediaType = contentType
+		}
+	}
+
+	err = ValidateManifest(ctx.Request().Context(), r.store, namespace, &manifest)
+	var validationErr *manifestValidationError
+	if errors.As(err, &validationErr) {
+		errMsg := common.RegistryErrorResponse(validationErr.code, validationErr.message, validationErr.detail)
+		echoErr := ctx.JSONBlob(validationErr.status, errMsg.Bytes())
+		r.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	if err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeManifestInvalid, err.Error(), nil)
+		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
+		r.logger.Log(ctx, err).Send()
+		return echoErr
+	}
+
+	// the repository is only created once the manifest is known to be valid
 	repository := r.GetRepositoryFromCtx(ctx)
 	repositoryExists := r.store.RepositoryExists(ctx.Request().Context(), namespace)
 	if repository == nil || !repositoryExists {
+		_, repositoryName, nsErr := types_v2.SplitNamespace(namespace)
+		if nsErr != nil {
+			errMsg := common.RegistryErrorResponse(RegistryErrorCodeNameInvalid, nsErr.Error(), nil)
+			echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
+			r.logger.Log(ctx, nsErr).Send()
+			return echoErr
+		}
+
 		repositoryID, idErr := types.NewUUID()
 		if idErr != nil {
 			errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, idErr.Error(), echo.Map{
@@ -770,7 +952,7 @@ func (r *registry) PushManifest(ctx echo.Context) error {
 			CreatedAt:  time.Now(),
 			OwnerID:    user.ID,
 			ID:         repositoryID,
-			Name:       strings.Split(namespace, "/")[1],
+			Name:       repositoryName,
 			Visibility: types_v2.RepositoryVisibilityPrivate,
 		}
 
@@ -790,48 +972,7 @@ func (r *registry) PushManifest(ctx echo.Context) error {
 		}
 	}
 
-	buf := &bytes.Buffer{}
-	_, err = io.Copy(buf, ctx.Request().Body)
-	if err != nil {
-		return ctx.JSON(http.StatusBadRequest, echo.Map{
-			"error":   err.Error(),
-			"message": "failed in push manifest while io Copy",
-		})
-	}
-	defer ctx.Request().Body.Close()
-
-	digest := oci_digest.FromBytes(buf.Bytes())
-
-	uuid, err := types.NewUUID()
-	if err != nil {
-		echoErr := ctx.JSON(http.StatusInternalServerError, echo.Map{
-			"error": err.Error(),
-			"cause": "error creating random id for config",
-		})
-		r.logger.Log(ctx, err).Send()
-		return echoErr
-	}
-
-	manifest := types_v2.ImageManifest{
-		CreatedAt:    time.Now(),
-		ID:           uuid,
-		RepositoryID: repository.ID,
-		OwnerID:      user.ID,
-		Digest:       digest.String(),
-		Reference:    ref,
-		Size:         0,
-	}
-
-	if err = json.Unmarshal(buf.Bytes(), &manifest); err != nil {
-		errMsg := common.RegistryErrorResponse(RegistryErrorCodeBlobUnknown, err.Error(), nil)
-		echoErr := ctx.JSONBlob(http.StatusBadRequest, errMsg.Bytes())
-		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
-		return echoErr
-	}
-
-	if manifest.MediaType == "" {
-		manifest.MediaType = img_spec_v1.MediaTypeImageManifest
-	}
+	manifest.RepositoryID = repository.ID
 
 	var layerIDs []string
 
@@ -855,6 +996,17 @@ func (r *registry) PushManifest(ctx echo.Context) error {
 		return echoErr
 	}
 
+	err = r.store.AddTagHistory(ctx.Request().Context(), txnOp, &manifest, GetActorID(ctx), ctx.Request().UserAgent())
+	if err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
+			"reason": "ERR_ADD_TAG_HISTORY",
+		})
+		_ = r.store.Abort(ctx.Request().Context(), txnOp)
+		echoErr := ctx.JSONBlob(http.StatusInternalServerError, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
 	if err = r.store.SetManifest(ctx.Request().Context(), txnOp, &manifest); err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
 			"message": "invalid input provided",
@@ -865,6 +1017,16 @@ func (r *registry) PushManifest(ctx echo.Context) error {
 		return echoErr
 	}
 
+	if err = r.setLayerCompression(ctx.Request().Context(), txnOp, &manifest); err != nil {
+		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
+			"reason": "ERR_SET_LAYER_COMPRESSION",
+		})
+		_ = r.store.Abort(ctx.Request().Context(), txnOp)
+		echoErr := ctx.JSONBlob(http.StatusInternalServerError, errMsg.Bytes())
+		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
+		return echoErr
+	}
+
 	if err = r.store.Commit(ctx.Request().Context(), txnOp); err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeUnknown, err.Error(), echo.Map{
 			"reason": "ERR_PG_COMMIT_TXN",
@@ -875,7 +1037,12 @@ func (r *registry) PushManifest(ctx echo.Context) error {
 		return echoErr
 	}
 
+	r.manifestCache.InvalidateNamespace(namespace)
+	r.enqueueLayerConversion(namespace, ref, &manifest)
 	r.setPushManifestHaeders(ctx, namespace, ref, digest.String(), &manifest)
+	if rootCID := r.publishImage(ctx.Request().Context(), namespace, ref, buf.Bytes(), &manifest); rootCID != "" {
+		ctx.Response().Header().Set(HeaderIPFSRootCID, rootCID)
+	}
 	echoErr := ctx.NoContent(http.StatusCreated)
 	r.logger.Log(ctx, echoErr).Send()
 	return echoErr
@@ -902,13 +1069,9 @@ func (r *registry) setPushManifestHaeders(
 func (r *registry) PushLayer(ctx echo.Context) error {
 	ctx.Set(types.HandlerStartTime, time.Now())
 
-	elem := strings.Split(ctx.Request().URL.Path, "/")
-	elem = elem[1:]
-	if elem[len(elem)-1] == "" {
-		elem = elem[:len(elem)-1]
-	}
+	namespace := ctx.Get(string(RegistryNamespace)).(string)
 	// Must have a path of form /v2/{name}/blobs/{upload,sha256:}
-	if len(elem) < 4 {
+	if _, _, err := types_v2.SplitNamespace(namespace); err != nil {
 		errMsg := common.RegistryErrorResponse(RegistryErrorCodeNameInvalid, "blobs must be attached to a repo", nil)
 		echoErr := ctx.JSONBlob(http.StatusNotFound, errMsg.Bytes())
 		r.logger.Log(ctx, fmt.Errorf("%s", errMsg)).Send()
@@ -925,8 +1088,7 @@ func (r *registry) PushLayer(ctx echo.Context) error {
 		return echoErr
 	}
 
-	p := path.Join(elem[1 : len(elem)-2]...)
-	locationHeader := fmt.Sprintf("/v2/%s/blobs/uploads/%s", p, uuid)
+	locationHeader := fmt.Sprintf("/v2/%s/blobs/uploads/%s", namespace, uuid)
 	ctx.Response().Header().Set("Location", locationHeader)
 	ctx.Response().Header().Set("Docker-Upload-UUID", uuid)
 	ctx.Response().Header().Set("Range", "0-0")
@@ -964,6 +1126,8 @@ func (r *registry) DeleteTagOrManifest(ctx echo.Context) error {
 		return echoErr
 	}
 
+	// the reference is deleted from every repository that has it, not just this namespace
+	r.manifestCache.Purge()
 	echoErr := ctx.NoContent(http.StatusAccepted)
 	r.logger.Log(ctx, echoErr).Send()
 	return echoErr
@@ -1041,6 +1205,22 @@ func (r *registry) GetUserFromCtx(ctx echo.Context) (*types_v2.User, error) {
 	return user, nil
 }
 
+// GetActorID returns the id of the user making the request. For requests made on behalf of an organization, the
+// permissions middleware replaces the user in the context with the organization, but the permissions still belong
+// to the actual user
+func GetActorID(ctx echo.Context) uuid.UUID {
+	permissions, ok := ctx.Get(string(types_v2.UserPermissionsContextKey)).(*types_v2.Permissions)
+	if ok && permissions.UserID != uuid.Nil {
+		return permissions.UserID
+	}
+
+	if user, ok := ctx.Get(string(types_v2.UserContextKey)).(*types_v2.User); ok {
+		return user.ID
+	}
+
+	return uuid.Nil
+}
+
 func (r *registry) GetRepositoryFromCtx(ctx echo.Context) *types_v2.ContainerImageRepository {
 	if repository, ok := ctx.Get(string(types_v2.UserRepositoryContextKey)).(*types_v2.ContainerImageRepository); ok {
 		return repository
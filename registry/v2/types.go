@@ -10,6 +10,7 @@ import (
 
 	"github.com/containerish/OpenRegistry/config"
 	dfsImpl "github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/registry/v2/conversion"
 	store_v2 "github.com/containerish/OpenRegistry/store/v1/registry"
 	"github.com/containerish/OpenRegistry/telemetry"
 )
@@ -65,6 +66,9 @@ type RegistryError struct {
 const (
 	HeaderDockerContentDigest          = "Docker-Content-Digest"
 	HeaderDockerDistributionApiVersion = "Docker-Distribution-API-Version"
+	// HeaderIPFSRootCID is set on manifest pushes to storage that publishes images to IPFS, it's the CID of the DAG
+	// the image was published as
+	HeaderIPFSRootCID = "OpenRegistry-IPFS-Root-CID"
 )
 
 // // OCI - Distribution Spec compliant Error Codes
@@ -90,14 +94,17 @@ const (
 
 type (
 	registry struct {
-		b      blobs
-		config *config.OpenRegistryConfig
-		logger telemetry.Logger
-		store  store_v2.RegistryStore
-		dfs    dfsImpl.DFS
-		txnMap map[string]TxnStore
-		mu     *sync.RWMutex
-		debug  bool
+		b         blobs
+		config    *config.OpenRegistryConfig
+		logger    telemetry.Logger
+		store     store_v2.RegistryStore
+		dfs       dfsImpl.DFS
+		converter conversion.Converter
+		// manifestCache is used for conditional manifest requests
+		manifestCache *manifestCache
+		txnMap        map[string]TxnStore
+		mu            *sync.RWMutex
+		debug         bool
 	}
 
 	TxnStore struct {
@@ -119,6 +126,8 @@ type (
 )
 
 type Registry interface {
+	ManifestCacheInvalidator
+
 	UploadProgress(ctx echo.Context) error
 
 	// GET /v2/<name>/blobs/<digest>
@@ -3,7 +3,9 @@ package router
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strings"
 
 	"github.com/containerish/OpenRegistry/common"
 	"github.com/containerish/OpenRegistry/registry/v2"
@@ -24,13 +26,19 @@ func registryNamespaceValidator(logger telemetry.Logger) echo.MiddlewareFunc {
 				return handler(ctx)
 			}
 
+			// nested repository names reach here with their slashes escaped, see nestedNamespaceRewriter
+			imageName, err := url.PathUnescape(ctx.Param("imagename"))
+			if err == nil {
+				setParamValue(ctx, "imagename", imageName)
+			}
+
 			namespace := ctx.Param("username") + "/" + ctx.Param("imagename")
-			if namespace != "/" && !nsRegex.MatchString(namespace) {
+			if namespace != "/" && (err != nil || !nsRegex.MatchString(namespace)) {
 				registryErr := common.RegistryErrorResponse(
 					registry.RegistryErrorCodeNameInvalid,
 					"invalid user namespace",
 					echo.Map{
-						"error": "the required format for namespace is <username>/<imagename>",
+						"error": "the required format for namespace is <username>/<imagename>[/<imagename>...]",
 					},
 				)
 				echoErr := ctx.JSONBlob(http.StatusBadRequest, registryErr.Bytes())
@@ -44,6 +52,57 @@ func registryNamespaceValidator(logger telemetry.Logger) echo.MiddlewareFunc {
 	}
 }
 
+// nestedNamespaceRewriter runs before the router. The routes only have two params for the repository namespace
+// (username & imagename), so for names with more than two components, e.g, org/team/service/image, the slashes after
+// the username are escaped. The router then matches "team%2Fservice%2Fimage" as the imagename param, which is
+// unescaped again by registryNamespaceValidator. The first component is always the owning user or organization.
+func nestedNamespaceRewriter() echo.MiddlewareFunc {
+	// Reference: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#endpoints
+	ociPath := regexp.MustCompile(
+		`^(/v2/)(.+)(/(?:manifests/[^/]+|blobs/uploads/[^/]*|blobs/monolithic/upload/[^/]+|blobs/[^/]+|` +
+			`tags/list|referrers/[^/]+))$`,
+	)
+	extPath := regexp.MustCompile(
+		`^(/v2/ext/repository/)(.+)(/(?:layers/[^/]+/files?|diff|import(?:/ipfs/[^/]+)?|promote|audit|` +
+			`tags/history|tags/rollback))$`,
+	)
+
+	return func(handler echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			if !strings.HasPrefix(req.URL.Path, V2+"/") {
+				return handler(ctx)
+			}
+
+			parts := extPath.FindStringSubmatch(req.URL.Path)
+			if parts == nil {
+				parts = ociPath.FindStringSubmatch(req.URL.Path)
+			}
+
+			if parts != nil {
+				username, imageName, _ := strings.Cut(parts[2], "/")
+				if strings.Contains(imageName, "/") {
+					req.URL.RawPath = parts[1] + username + "/" + strings.ReplaceAll(imageName, "/", "%2F") + parts[3]
+				}
+			}
+
+			return handler(ctx)
+		}
+	}
+}
+
+// setParamValue replaces the value of a single path param
+func setParamValue(ctx echo.Context, name, value string) {
+	values := ctx.ParamValues()
+	for i, paramName := range ctx.ParamNames() {
+		if paramName == name && i < len(values) {
+			values[i] = value
+		}
+	}
+
+	ctx.SetParamValues(values...)
+}
+
 func registryReferenceOrTagValidator(logger telemetry.Logger) echo.MiddlewareFunc {
 	// Reference: https://github.com/opencontainers/distribution-spec/blob/main/spec.md#pulling-manifests
 	refRegex := regexp.MustCompile(`[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}`)
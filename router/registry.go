@@ -107,3 +107,16 @@ func RegisterExtensionsRoutes(
 	group.Add(http.MethodPost, RepositoryFavorites, ext.AddRepositoryToFavorites, middlewares...)
 	group.Add(http.MethodDelete, RepositoryFavorites, ext.RemoveRepositoryFromFavorites, middlewares...)
 }
+
+// RegisterRepositoryExtensionsRoutes registers the extension APIs that operate on a single repository
+func RegisterRepositoryExtensionsRoutes(group *echo.Group, ext extensions.Extenion) {
+	group.Add(http.MethodGet, LayerFiles, ext.ListLayerFiles)
+	group.Add(http.MethodGet, LayerFile, ext.GetLayerFile)
+	group.Add(http.MethodGet, ImageDiff, ext.ImageDiff)
+	group.Add(http.MethodPost, ImportImageArchive, ext.ImportImageArchive)
+	group.Add(http.MethodPost, ImportPublishedImage, ext.ImportPublishedImage)
+	group.Add(http.MethodPost, PromoteImage, ext.PromoteImage)
+	group.Add(http.MethodGet, AuditEvents, ext.ListAuditEvents)
+	group.Add(http.MethodGet, TagHistory, ext.ListTagHistory)
+	group.Add(http.MethodPost, RollbackTag, ext.RollbackTag)
+}
@@ -17,6 +17,12 @@ const (
 	Webauthn = Auth + "/webauthn"
 
 	GitHub = "/github"
+
+	// Admin endpoints are only allowed for the users listed in registry.admins
+	Admin = "/admin"
+
+	// StorageReplication shows the replication lag of the secondary storage backends
+	StorageReplication = "/storage/replication"
 	//Beta endpoint refers to the experimental code and features under observation
 	// not to be released or exposed to public
 	Beta = "/beta"
@@ -75,4 +81,27 @@ const (
 	ChangeRepositoryVisibility = Ext + "/repository/visibility"
 	CreateRepository           = Ext + "/repository/create"
 	RepositoryFavorites        = Ext + "/repository/favorites"
+
+	// RepositoryExt is the prefix for extension APIs scoped to a single repository
+	RepositoryExt = Ext + "/repository" + Namespace
+
+	// LayerFiles lists the files inside a layer and LayerFile returns the contents of a single file from it
+	LayerFiles = "/layers/:digest/files"
+	LayerFile  = "/layers/:digest/file"
+
+	// ImageDiff compares two tags or digests of a repository
+	ImageDiff = "/diff"
+
+	// ImportImageArchive accepts a docker save or OCI image layout tarball and ImportPublishedImage pulls an image that
+	// was published to IPFS by its CID
+	ImportImageArchive   = "/import"
+	ImportPublishedImage = "/import/ipfs/:cid"
+
+	// PromoteImage copies a tag or digest from another repository and AuditEvents lists the changes made to a repository
+	PromoteImage = "/promote"
+	AuditEvents  = "/audit"
+
+	// TagHistory lists every move of the tags in a repository and RollbackTag moves a tag back to an older digest
+	TagHistory  = "/tags/history"
+	RollbackTag = "/tags/rollback"
 )
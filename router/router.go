@@ -9,6 +9,8 @@ import (
 	"github.com/containerish/OpenRegistry/auth"
 	auth_server "github.com/containerish/OpenRegistry/auth/server"
 	"github.com/containerish/OpenRegistry/config"
+	"github.com/containerish/OpenRegistry/dfs"
+	"github.com/containerish/OpenRegistry/dfs/replication"
 	"github.com/containerish/OpenRegistry/orgmode"
 	"github.com/containerish/OpenRegistry/registry/v2"
 	"github.com/containerish/OpenRegistry/registry/v2/extensions"
@@ -37,8 +39,10 @@ func Register(
 	registryStore registry_store.RegistryStore,
 	usersStore users_store.UserStore,
 	automationStore automation.BuildAutomationStore,
+	storageBackend dfs.DFS,
 ) *echo.Echo {
 	e := setDefaultEchoOptions(cfg.WebAppConfig, healthCheckApi)
+	e.Pre(nestedNamespaceRewriter())
 
 	baseAPIRouter := e.Group("/api")
 	githubRouter := e.Group("/github")
@@ -48,7 +52,9 @@ func Register(
 	ociRouter := e.Group(V2, registryNamespaceValidator(logger), authApi.BasicAuth(), authApi.JWT())
 	userApiRouter := baseAPIRouter.Group("/users", authApi.JWTRest())
 	nsRouter := ociRouter.Group(Namespace, authApi.RepositoryPermissionsMiddleware())
+	repoExtRouter := ociRouter.Group(RepositoryExt, authApi.RepositoryPermissionsMiddleware())
 	authGithubRouter := authRouter.Group(GitHub)
+	adminRouter := baseAPIRouter.Group(Admin, authApi.JWTRest(), authApi.AdminOnly())
 
 	ociRouter.Add(http.MethodGet, Root, registryApi.ApiVersion)
 	e.Add(http.MethodGet, TokenAuth, authApi.Token, authApi.RepositoryPermissionsMiddleware())
@@ -59,9 +65,21 @@ func Register(
 	RegisterNSRoutes(nsRouter, registryApi, registryStore, logger)
 	RegisterAuthRoutes(authRouter, authApi)
 	RegisterExtensionsRoutes(ociRouter, registryApi, extensionsApi)
+	RegisterRepositoryExtensionsRoutes(repoExtRouter, extensionsApi)
 	RegisterWebauthnRoutes(webauthnRouter, webauthnApi)
 	RegisterOrgModeRoutes(orgModeRouter, orgModeApi)
 
+	// storage backends without URLs of their own serve blobs from the registry, the URLs are signed by the backend
+	if blobServer, ok := dfs.As[dfs.BlobServer](storageBackend); ok {
+		e.Add(http.MethodGet, dfs.BlobServerPath+"/*", blobServer.ServeBlob)
+		e.Add(http.MethodHead, dfs.BlobServerPath+"/*", blobServer.ServeBlob)
+	}
+
+	// the replication lag of the secondary storage backends
+	if status, ok := dfs.As[replication.ReplicationStatus](storageBackend); ok {
+		adminRouter.Add(http.MethodGet, StorageReplication, status.ReplicationStatus)
+	}
+
 	if cfg.Integrations.GetGithubConfig() != nil && cfg.Integrations.GetGithubConfig().Enabled {
 		RegisterGitHubRoutes(
 			githubRouter,
@@ -0,0 +1,46 @@
+package dfsmigration
+
+import (
+	"context"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/uptrace/bun"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+// defaultBackend is dfs.DefaultBackend, the name of the backend configured directly in the dfs section
+const defaultBackend = "default"
+
+type store struct {
+	logger telemetry.Logger
+	db     *bun.DB
+}
+
+// MigrationStore keeps the progress of storage migrations and cuts layers over to the backend they were copied to
+type MigrationStore interface {
+	// GetMigration returns the progress of the migration from source to target, it's created if it doesn't exist yet
+	GetMigration(ctx context.Context, source, target string) (*types.StorageMigration, error)
+	// Lock takes (or extends) the lock on the migration for the lease, owner identifies the process that holds it. It
+	// returns false if another process holds the lock
+	Lock(ctx context.Context, migration *types.StorageMigration, owner string, lease time.Duration) (bool, error)
+	Unlock(ctx context.Context, migration *types.StorageMigration) error
+	// ListLayersOnBackend returns up to limit layers on the backend with an ID greater than afterID, ordered by ID.
+	// Layers without a backend are on the default backend
+	ListLayersOnBackend(ctx context.Context, backend, afterID string, limit int) ([]*types.ContainerImageLayer, error)
+	// CutOver points the layer to the copy of its blob on the target backend and records the progress of the
+	// migration, in the same transaction. It returns false if the layer was changed since it was listed, the layer is
+	// left as it is then
+	CutOver(ctx context.Context, migration *types.StorageMigration, layer *types.ContainerImageLayer, link string) (
+		bool,
+		error,
+	)
+	SaveProgress(ctx context.Context, migration *types.StorageMigration) error
+}
+
+func New(db *bun.DB, logger telemetry.Logger) MigrationStore {
+	color.Green("Service - MigrationStore - connection to database successful")
+	return &store{db: db, logger: logger}
+}
@@ -0,0 +1,227 @@
+package dfsmigration
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+
+	v1 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// GetMigration implements MigrationStore.
+func (s *store) GetMigration(ctx context.Context, source, target string) (*types.StorageMigration, error) {
+	logEvent := s.logger.Debug().Str("method", "GetMigration").Str("source", source).Str("target", target)
+
+	migration := &types.StorageMigration{Source: source, Target: target}
+	_, err := s.db.NewInsert().Model(migration).On("CONFLICT (source, target) DO NOTHING").Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	if err = s.db.NewSelect().Model(migration).WherePK().Scan(ctx); err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return migration, nil
+}
+
+// Lock implements MigrationStore.
+func (s *store) Lock(
+	ctx context.Context,
+	migration *types.StorageMigration,
+	owner string,
+	lease time.Duration,
+) (bool, error) {
+	logEvent := s.logger.Debug().Str("method", "Lock").Str("source", migration.Source).Str("target", migration.Target)
+
+	// the lock is only taken if it's free or expired, or if it's ours already and is being extended
+	now := time.Now()
+	lockedUntil := now.Add(lease)
+	result, err := s.
+		db.
+		NewUpdate().
+		Model(&types.StorageMigration{}).
+		Set("locked_until = ?", lockedUntil).
+		Set("locked_by = ?", owner).
+		Where("source = ?", migration.Source).
+		Where("target = ?", migration.Target).
+		WhereGroup(" AND ", func(q *bun.UpdateQuery) *bun.UpdateQuery {
+			return q.Where("locked_until IS NULL").WhereOr("locked_until < ?", now).WhereOr("locked_by = ?", owner)
+		}).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return false, v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		logEvent.Err(err).Send()
+		return false, v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	if rowsAffected == 0 {
+		logEvent.Bool("locked", false).Send()
+		return false, nil
+	}
+
+	migration.LockedUntil = lockedUntil
+	migration.LockedBy = owner
+	logEvent.Bool("success", true).Send()
+	return true, nil
+}
+
+// Unlock implements MigrationStore.
+func (s *store) Unlock(ctx context.Context, migration *types.StorageMigration) error {
+	logEvent := s.logger.Debug().Str("method", "Unlock").Str("source", migration.Source).Str("target", migration.Target)
+
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(&types.StorageMigration{}).
+		Set("locked_until = NULL").
+		Set("locked_by = NULL").
+		Where("source = ?", migration.Source).
+		Where("target = ?", migration.Target).
+		Where("locked_by = ?", migration.LockedBy).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	migration.LockedUntil = time.Time{}
+	migration.LockedBy = ""
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// ListLayersOnBackend implements MigrationStore.
+func (s *store) ListLayersOnBackend(
+	ctx context.Context,
+	backend string,
+	afterID string,
+	limit int,
+) ([]*types.ContainerImageLayer, error) {
+	logEvent := s.logger.Debug().Str("method", "ListLayersOnBackend").Str("backend", backend).Str("after_id", afterID)
+
+	var layers []*types.ContainerImageLayer
+	q := s.db.NewSelect().Model(&layers).Where("dfs_link IS NOT NULL").Order("id ASC").Limit(limit)
+	if backend == defaultBackend {
+		q = q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("backend IS NULL").WhereOr("backend = ''").WhereOr("backend = ?", backend)
+		})
+	} else {
+		q = q.Where("backend = ?", backend)
+	}
+
+	if afterID != "" {
+		q = q.Where("id > ?", afterID)
+	}
+
+	if err := q.Scan(ctx); err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return layers, nil
+}
+
+// CutOver implements MigrationStore.
+func (s *store) CutOver(
+	ctx context.Context,
+	migration *types.StorageMigration,
+	layer *types.ContainerImageLayer,
+	link string,
+) (bool, error) {
+	logEvent := s.logger.Debug().Str("method", "CutOver").Str("digest", layer.Digest).Str("target", migration.Target)
+
+	cutOver := false
+	err := s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// the layer is only cut over if it still points to the blob that was copied
+		q := tx.
+			NewUpdate().
+			Model(&types.ContainerImageLayer{}).
+			Set("dfs_link = ?", link).
+			Set("backend = ?", migration.Target).
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", layer.ID).
+			Where("dfs_link = ?", layer.DFSLink)
+		if layer.Backend == "" {
+			q = q.WhereGroup(" AND ", func(q *bun.UpdateQuery) *bun.UpdateQuery {
+				return q.Where("backend IS NULL").WhereOr("backend = ''")
+			})
+		} else {
+			q = q.Where("backend = ?", layer.Backend)
+		}
+
+		result, err := q.Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		progress := tx.
+			NewUpdate().
+			Model(&types.StorageMigration{}).
+			Set("cursor = ?", layer.ID).
+			Set("updated_at = ?", time.Now()).
+			Where("source = ?", migration.Source).
+			Where("target = ?", migration.Target)
+		if rowsAffected == 1 {
+			cutOver = true
+			progress = progress.
+				Set("migrated = migrated + 1").
+				Set("migrated_bytes = migrated_bytes + ?", layer.Size)
+		}
+
+		_, err = progress.Exec(ctx)
+		return err
+	})
+	if err != nil {
+		logEvent.Err(err).Send()
+		return false, v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	migration.Cursor = layer.ID
+	if cutOver {
+		migration.Migrated++
+		migration.MigratedBytes += layer.Size
+	}
+
+	logEvent.Bool("cut_over", cutOver).Bool("success", true).Send()
+	return cutOver, nil
+}
+
+// SaveProgress implements MigrationStore.
+func (s *store) SaveProgress(ctx context.Context, migration *types.StorageMigration) error {
+	logEvent := s.logger.Debug().
+		Str("method", "SaveProgress").
+		Str("source", migration.Source).
+		Str("target", migration.Target)
+
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(migration).
+		Column("cursor", "failed", "last_error", "completed_at", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
@@ -0,0 +1,31 @@
+package encryption
+
+import (
+	"context"
+
+	"github.com/fatih/color"
+	"github.com/uptrace/bun"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+type store struct {
+	logger telemetry.Logger
+	db     *bun.DB
+}
+
+// KeyStore keeps the wrapped data keys of encrypted blobs
+type KeyStore interface {
+	SetBlobKey(ctx context.Context, key *types.BlobKey) error
+	// GetBlobKey returns nil without an error if the blob has no key, i.e, it was stored before encryption was enabled
+	GetBlobKey(ctx context.Context, link string) (*types.BlobKey, error)
+	// ListBlobKeysToRewrap returns up to limit keys that aren't wrapped by the master key with keyID
+	ListBlobKeysToRewrap(ctx context.Context, keyID string, limit int) ([]*types.BlobKey, error)
+	UpdateWrappedKey(ctx context.Context, link string, keyID string, wrappedKey []byte) error
+}
+
+func New(db *bun.DB, logger telemetry.Logger) KeyStore {
+	color.Green("Service - KeyStore - connection to database successful")
+	return &store{db: db, logger: logger}
+}
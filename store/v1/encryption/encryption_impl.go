@@ -0,0 +1,98 @@
+package encryption
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	v1 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// SetBlobKey implements KeyStore.
+func (s *store) SetBlobKey(ctx context.Context, key *types.BlobKey) error {
+	logEvent := s.logger.Debug().Str("method", "SetBlobKey").Str("link", key.Link)
+
+	_, err := s.
+		db.
+		NewInsert().
+		Model(key).
+		On("CONFLICT (link) DO UPDATE").
+		Set("key_id = EXCLUDED.key_id").
+		Set("wrapped_key = EXCLUDED.wrapped_key").
+		Set("size = EXCLUDED.size").
+		Set("parts = EXCLUDED.parts").
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// GetBlobKey implements KeyStore.
+func (s *store) GetBlobKey(ctx context.Context, link string) (*types.BlobKey, error) {
+	logEvent := s.logger.Debug().Str("method", "GetBlobKey").Str("link", link)
+
+	var key types.BlobKey
+	err := s.db.NewSelect().Model(&key).Where("link = ?", link).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		logEvent.Bool("found", false).Send()
+		return nil, nil
+	}
+
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return &key, nil
+}
+
+// ListBlobKeysToRewrap implements KeyStore.
+func (s *store) ListBlobKeysToRewrap(ctx context.Context, keyID string, limit int) ([]*types.BlobKey, error) {
+	logEvent := s.logger.Debug().Str("method", "ListBlobKeysToRewrap").Str("key_id", keyID)
+
+	var keys []*types.BlobKey
+	err := s.
+		db.
+		NewSelect().
+		Model(&keys).
+		Where("key_id != ?", keyID).
+		Order("link ASC").
+		Limit(limit).
+		Scan(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return keys, nil
+}
+
+// UpdateWrappedKey implements KeyStore.
+func (s *store) UpdateWrappedKey(ctx context.Context, link string, keyID string, wrappedKey []byte) error {
+	logEvent := s.logger.Debug().Str("method", "UpdateWrappedKey").Str("link", link).Str("key_id", keyID)
+
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(&types.BlobKey{}).
+		Set("key_id = ?", keyID).
+		Set("wrapped_key = ?", wrappedKey).
+		Set("updated_at = ?", time.Now()).
+		Where("link = ?", link).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
@@ -1,6 +1,25 @@
 package v1
 
+import "strings"
+
 const (
 	ErrDuplicateConstraintUsername = "username_key"
 	ErrDuplicateConstraintEmail    = "email_key"
 )
+
+// IsDuplicateConstraintError reports whether err violates one of the ErrDuplicateConstraint* unique constraints.
+// Postgres names the constraint in the error while SQLite reports the column, e.g,
+// "UNIQUE constraint failed: users.username"
+func IsDuplicateConstraintError(err error, constraint string) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	if strings.Contains(msg, constraint) {
+		return true
+	}
+
+	column := strings.TrimSuffix(constraint, "_key")
+	return strings.Contains(msg, "UNIQUE constraint failed") && strings.Contains(msg, "."+column)
+}
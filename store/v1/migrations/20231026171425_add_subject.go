@@ -12,13 +12,7 @@ func init() {
 	up := func(ctx context.Context, db *bun.DB) error {
 		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 			color.Green("Running up migration ✅")
-			_, err := tx.
-				NewAddColumn().
-				Model(&types.ImageManifest{}).
-				ColumnExpr("subject jsonb").
-				IfNotExists().
-				Exec(ctx)
-			return err
+			return addColumn(ctx, tx, &types.ImageManifest{}, "subject "+jsonColumnType(tx))
 		})
 	}
 
@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/fatih/color"
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	up := func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			color.Green("Running up migration ✅")
+			for _, column := range []string{"compression varchar", "toc_digest varchar"} {
+				if err := addColumn(ctx, tx, &types.ContainerImageLayer{}, column); err != nil {
+					return err
+				}
+			}
+
+			return addColumn(ctx, tx, &types.ImageManifest{}, "manifests "+jsonColumnType(tx))
+		})
+	}
+
+	down := func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			color.Yellow("Running down migration ⚠️")
+
+			for _, column := range []string{"compression", "toc_digest"} {
+				_, err := tx.
+					NewDropColumn().
+					Model(&types.ContainerImageLayer{}).
+					ColumnExpr(column).
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+			}
+
+			_, err := tx.
+				NewDropColumn().
+				Model(&types.ImageManifest{}).
+				ColumnExpr("manifests").
+				Exec(ctx)
+			return err
+		})
+	}
+
+	Migrations.MustRegister(up, down)
+}
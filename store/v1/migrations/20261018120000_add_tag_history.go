@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/fatih/color"
+	"github.com/uptrace/bun"
+)
+
+func init() {
+	up := func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			color.Green("Running up migration ✅")
+			_, err := tx.
+				NewCreateTable().
+				Model(&types.TagHistory{}).
+				IfNotExists().
+				Exec(ctx)
+			return err
+		})
+	}
+
+	down := func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			color.Yellow("Running down migration ⚠️")
+
+			_, err := tx.
+				NewDropTable().
+				Model(&types.TagHistory{}).
+				IfExists().
+				Exec(ctx)
+			return err
+		})
+	}
+
+	Migrations.MustRegister(up, down)
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
 	"github.com/uptrace/bun/migrate"
 )
 
@@ -52,3 +53,37 @@ func NewMigrator(db *bun.DB) *migrate.Migrator {
 		migrate.WithMarkAppliedOnSuccess(true),
 	)
 }
+
+// addColumn adds a column to the model's table unless it exists already. SQLite doesn't support
+// "ADD COLUMN IF NOT EXISTS", so the table's columns are looked up first
+func addColumn(ctx context.Context, tx bun.Tx, model any, column string) error {
+	q := tx.NewAddColumn().Model(model).ColumnExpr(column)
+	if tx.Dialect().Name() != dialect.SQLite {
+		_, err := q.IfNotExists().Exec(ctx)
+		return err
+	}
+
+	name, _, _ := strings.Cut(column, " ")
+	var exists bool
+	err := tx.
+		NewSelect().
+		TableExpr("pragma_table_info(?)", q.GetTableName()).
+		ColumnExpr("count(*) > 0").
+		Where("name = ?", name).
+		Scan(ctx, &exists)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = q.Exec(ctx)
+	return err
+}
+
+// jsonColumnType is the column type for JSON documents, SQLite stores them as text
+func jsonColumnType(db bun.IDB) string {
+	if db.Dialect().Name() == dialect.SQLite {
+		return "text"
+	}
+
+	return "jsonb"
+}
@@ -3,7 +3,6 @@ package permissions
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	v1 "github.com/containerish/OpenRegistry/store/v1"
 	"github.com/containerish/OpenRegistry/store/v1/types"
@@ -124,11 +123,10 @@ func (p *permissionStore) GetUserPermissionsForNamespace(
 ) *types.Permissions {
 	perms := types.Permissions{}
 
-	nsParts := strings.Split(ns, "/")
-	if len(nsParts) != 2 {
+	orgName, _, err := types.SplitNamespace(ns)
+	if err != nil {
 		return &perms
 	}
-	orgName := nsParts[0]
 
 	q := p.
 		db.
@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	v1 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// AddAuditEvent implements registry.RegistryStore.
+func (s *registryStore) AddAuditEvent(ctx context.Context, txn *bun.Tx, event *types.RepositoryAuditEvent) error {
+	logEvent := s.logger.Debug().Str("method", "AddAuditEvent").Str("action", event.Action)
+
+	if _, err := txn.NewInsert().Model(event).Exec(ctx); err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// ListAuditEvents implements registry.RegistryStore.
+func (s *registryStore) ListAuditEvents(
+	ctx context.Context,
+	repositoryID uuid.UUID,
+	pageSize int,
+	offset int,
+) ([]*types.RepositoryAuditEvent, int, error) {
+	logEvent := s.logger.Debug().Str("method", "ListAuditEvents").Str("repository_id", repositoryID.String())
+
+	var events []*types.RepositoryAuditEvent
+	total, err := s.
+		db.
+		NewSelect().
+		Model(&events).
+		Where("repository_id = ?", repositoryID).
+		Order("created_at DESC").
+		Limit(pageSize).
+		Offset(offset).
+		ScanAndCount(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, 0, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return events, total, nil
+}
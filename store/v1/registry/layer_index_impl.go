@@ -0,0 +1,36 @@
+package registry
+
+import (
+	"context"
+
+	v1 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// GetLayerFileIndex implements registry.RegistryStore.
+func (s *registryStore) GetLayerFileIndex(ctx context.Context, digest string) (*types.LayerFileIndex, error) {
+	logEvent := s.logger.Debug().Str("method", "GetLayerFileIndex").Str("digest", digest)
+
+	index := &types.LayerFileIndex{Digest: digest}
+	if err := s.db.NewSelect().Model(index).WherePK().Scan(ctx); err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return index, nil
+}
+
+// SetLayerFileIndex implements registry.RegistryStore.
+func (s *registryStore) SetLayerFileIndex(ctx context.Context, index *types.LayerFileIndex) error {
+	logEvent := s.logger.Debug().Str("method", "SetLayerFileIndex").Str("digest", index.Digest)
+
+	_, err := s.db.NewInsert().Model(index).On("conflict (digest) do nothing").Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
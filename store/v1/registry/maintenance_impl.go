@@ -0,0 +1,149 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+
+	v1 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// ListLayers implements registry.RegistryStore.
+func (s *registryStore) ListLayers(
+	ctx context.Context,
+	afterID string,
+	limit int,
+) ([]*types.ContainerImageLayer, error) {
+	logEvent := s.logger.Debug().Str("method", "ListLayers").Str("after_id", afterID)
+
+	var layers []*types.ContainerImageLayer
+	q := s.db.NewSelect().Model(&layers).Order("id ASC").Limit(limit)
+	if afterID != "" {
+		q = q.Where("id > ?", afterID)
+	}
+
+	if err := q.Scan(ctx); err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return layers, nil
+}
+
+// UpdateLayerLink implements registry.RegistryStore.
+func (s *registryStore) UpdateLayerLink(ctx context.Context, digest string, backend string, dfsLink string) error {
+	logEvent := s.logger.Debug().Str("method", "UpdateLayerLink").Str("digest", digest).Str("dfs_link", dfsLink)
+
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(&types.ContainerImageLayer{}).
+		Set("dfs_link = ?", dfsLink).
+		Set("backend = ?", backend).
+		Set("updated_at = ?", time.Now()).
+		Where("digest = ?", digest).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// UpdateLayerSize implements registry.RegistryStore.
+func (s *registryStore) UpdateLayerSize(ctx context.Context, digest string, size int64) error {
+	logEvent := s.logger.Debug().Str("method", "UpdateLayerSize").Str("digest", digest).Int64("size", size)
+
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(&types.ContainerImageLayer{}).
+		Set("size = ?", size).
+		Set("updated_at = ?", time.Now()).
+		Where("digest = ?", digest).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// ListManifests implements registry.RegistryStore.
+func (s *registryStore) ListManifests(
+	ctx context.Context,
+	afterID uuid.UUID,
+	limit int,
+) ([]*types.ImageManifest, error) {
+	logEvent := s.logger.Debug().Str("method", "ListManifests").Str("after_id", afterID.String())
+
+	var manifests []*types.ImageManifest
+	q := s.db.NewSelect().Model(&manifests).Order("id ASC").Limit(limit)
+	if afterID != uuid.Nil {
+		q = q.Where("id > ?", afterID)
+	}
+
+	if err := q.Scan(ctx); err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return manifests, nil
+}
+
+// MarkManifestsBroken implements registry.RegistryStore.
+func (s *registryStore) MarkManifestsBroken(ctx context.Context, ids []uuid.UUID) error {
+	logEvent := s.logger.Debug().Str("method", "MarkManifestsBroken").Int("manifests", len(ids))
+	if len(ids) == 0 {
+		logEvent.Bool("success", true).Send()
+		return nil
+	}
+
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(&types.ImageManifest{}).
+		Set("broken_at = ?", time.Now()).
+		Where("id IN (?)", bun.In(ids)).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// ClearManifestsBroken implements registry.RegistryStore.
+func (s *registryStore) ClearManifestsBroken(ctx context.Context, ids []uuid.UUID) error {
+	logEvent := s.logger.Debug().Str("method", "ClearManifestsBroken").Int("manifests", len(ids))
+	if len(ids) == 0 {
+		logEvent.Bool("success", true).Send()
+		return nil
+	}
+
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(&types.ImageManifest{}).
+		Set("broken_at = NULL").
+		Where("id IN (?)", bun.In(ids)).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
@@ -4,7 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"strings"
+	"reflect"
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,6 +13,7 @@ import (
 	img_spec "github.com/opencontainers/image-spec/specs-go"
 	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
 	"github.com/uptrace/bun/dialect/feature"
 
 	v1 "github.com/containerish/OpenRegistry/store/v1"
@@ -20,11 +22,14 @@ import (
 
 func (s *registryStore) RepositoryExists(ctx context.Context, namespace string) bool {
 	logEvent := s.logger.Debug().Str("method", "RepositoryExists").Str("name", namespace)
-	nsParts := strings.Split(namespace, "/")
-	username, repoName := nsParts[0], nsParts[1]
+	username, repoName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return false
+	}
 
 	repository := &types.ContainerImageRepository{}
-	err := s.
+	err = s.
 		db.
 		NewSelect().
 		Model(repository).
@@ -77,14 +82,13 @@ func (s *registryStore) GetRepositoryByNamespace(
 	namespace string,
 ) (*types.ContainerImageRepository, error) {
 	logEvent := s.logger.Debug().Str("namespace", namespace)
-	nsParts := strings.Split(namespace, "/")
-	if len(nsParts) != 2 {
-		return nil, fmt.Errorf("GetRepositoryByNamespace: invalid namespace format")
+	username, repoName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("GetRepositoryByNamespace: %w", err)
 	}
 
-	username, repoName := nsParts[0], nsParts[1]
 	repository := &types.ContainerImageRepository{}
-	err := s.
+	err = s.
 		db.
 		NewSelect().
 		Model(repository).
@@ -207,12 +211,20 @@ func (s *registryStore) GetCatalog(
 ) ([]string, error) {
 	var catalog []types.ContainerImageRepository
 
-	repositoryName := strings.Split(namespace, "/")[1]
-	err := s.
+	username, repositoryName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.
 		db.
 		NewSelect().
 		Model(&catalog).
 		Relation("ImageManifests").
+		Relation("User", func(sq *bun.SelectQuery) *bun.SelectQuery {
+			return sq.Column("username")
+		}).
+		Where("username = ?", username).
 		Where("name = ? and visibility = ?", repositoryName, types.RepositoryVisibilityPublic).
 		Scan(ctx)
 	if err != nil {
@@ -283,11 +295,8 @@ func (s *registryStore) GetUserRepositories(
 // GetCatalogCount implements registry.RegistryStore.
 func (s *registryStore) GetCatalogCount(ctx context.Context, namespace string) (int64, error) {
 	logEvent := s.logger.Debug().Str("method", "GetCatalogCount").Str("namespace", namespace)
-	parts := strings.Split(namespace, "/")
-	repositoryName := ""
-	if len(parts) == 2 {
-		repositoryName = parts[1]
-	}
+	// the namespace is optional, the repository name is empty when it's missing or only has the username
+	_, repositoryName, _ := types.SplitNamespace(namespace)
 
 	stmnt := s.
 		db.
@@ -320,11 +329,8 @@ func (s *registryStore) GetCatalogDetail(
 ) ([]*types.ContainerImageRepository, error) {
 	logEvent := s.logger.Debug().Str("method", "GetCatalogDetail").Str("namespace", namespace)
 	var repositoryList []*types.ContainerImageRepository
-	parts := strings.Split(namespace, "/")
-	repositoryName := ""
-	if len(parts) == 2 {
-		repositoryName = parts[1]
-	}
+	// the namespace is optional, the repository name is empty when it's missing or only has the username
+	_, repositoryName, _ := types.SplitNamespace(namespace)
 
 	stmnt := s.
 		db.
@@ -383,13 +389,23 @@ func (s *registryStore) GetImageTags(ctx context.Context, namespace string) ([]s
 	logEvent := s.logger.Debug().Str("methid", "GetImageTags").Str("namespace", namespace)
 	var manifests []*types.ImageManifest
 
-	err := s.
+	username, repositoryName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, err
+	}
+
+	err = s.
 		db.
 		NewSelect().
 		Model(&manifests).
 		Relation("Repository").
+		Relation("User", func(sq *bun.SelectQuery) *bun.SelectQuery {
+			return sq.Column("username")
+		}).
 		Column("reference").
-		Where("name = ?", strings.Split(namespace, "/")[1]).
+		Where("username = ?", username).
+		Where("name = ?", repositoryName).
 		Scan(ctx)
 	if err != nil {
 		logEvent.Err(err).Send()
@@ -400,6 +416,10 @@ func (s *registryStore) GetImageTags(ctx context.Context, namespace string) ([]s
 
 	tags := make([]string, 0)
 	for _, manifest := range manifests {
+		// manifests referenced by their digest (index children, previous targets of a tag, etc) aren't tags
+		if _, err = oci_digest.Parse(manifest.Reference); err == nil {
+			continue
+		}
 		tags = append(tags, manifest.Reference)
 	}
 
@@ -441,8 +461,11 @@ func (s *registryStore) GetManifestByReference(
 ) (*types.ImageManifest, error) {
 	logEvent := s.logger.Debug().Str("method", "GetManifestByReference").Str("whereClause", "reference")
 
-	nsParts := strings.Split(namespace, "/")
-	username, repoName := nsParts[0], nsParts[1]
+	username, repoName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, err
+	}
 
 	var manifest types.ImageManifest
 	q := s.
@@ -475,6 +498,88 @@ func (s *registryStore) GetManifestByReference(
 	return &manifest, nil
 }
 
+// IsBlobReferenced implements registry.RegistryStore.
+func (s *registryStore) IsBlobReferenced(ctx context.Context, namespace string, digest string) (bool, error) {
+	logEvent := s.logger.Debug().Str("method", "IsBlobReferenced").Str("digest", digest)
+
+	username, repoName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return false, err
+	}
+
+	// layers is jsonb on Postgres and text on SQLite, the digest is matched against the text of the column so that the
+	// query works with both. Digests have a fixed length per algorithm, a substring match can't hit a different blob
+	exists, err := s.
+		db.
+		NewSelect().
+		Model((*types.ImageManifest)(nil)).
+		Relation("Repository", func(sq *bun.SelectQuery) *bun.SelectQuery {
+			return sq.Column("name")
+		}).
+		Relation("User", func(sq *bun.SelectQuery) *bun.SelectQuery {
+			return sq.Column("username")
+		}).
+		Where("username = ?", username).
+		Where("name = ?", repoName).
+		WhereGroup(" AND ", func(sq *bun.SelectQuery) *bun.SelectQuery {
+			return sq.
+				Where("config_digest = ?", digest).
+				WhereOr("CAST(layers AS TEXT) LIKE ?", "%"+digest+"%")
+		}).
+		Exists(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return false, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("referenced", exists).Send()
+	return exists, nil
+}
+
+// LinkBlob implements registry.RegistryStore.
+func (s *registryStore) LinkBlob(ctx context.Context, txn *bun.Tx, namespace string, digest string) error {
+	logEvent := s.logger.Debug().Str("method", "LinkBlob").Str("namespace", namespace).Str("digest", digest)
+
+	_, err := txn.
+		NewInsert().
+		Model(&types.RepositoryBlob{Namespace: namespace, Digest: digest}).
+		On("conflict (namespace, digest) do nothing").
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// RepositoryHasBlob implements registry.RegistryStore.
+func (s *registryStore) RepositoryHasBlob(ctx context.Context, namespace string, digest string) (bool, error) {
+	logEvent := s.logger.Debug().Str("method", "RepositoryHasBlob").Str("namespace", namespace).Str("digest", digest)
+
+	linked, err := s.
+		db.
+		NewSelect().
+		Model((*types.RepositoryBlob)(nil)).
+		Where("namespace = ?", namespace).
+		Where("digest = ?", digest).
+		Exists(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return false, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	// blobs pushed before uploads were linked to repositories are only known through the manifests using them
+	if !linked {
+		return s.IsBlobReferenced(ctx, namespace, digest)
+	}
+
+	logEvent.Bool("linked", linked).Send()
+	return linked, nil
+}
+
 // GetRepoDetail implements registry.RegistryStore.
 func (s *registryStore) GetRepoDetail(
 	ctx context.Context,
@@ -485,16 +590,26 @@ func (s *registryStore) GetRepoDetail(
 	logEvent := s.logger.Debug().Str("method", "GetRepoDetail")
 	var repoDetail types.ContainerImageRepository
 
-	repositoryName := strings.Split(namespace, "/")[1]
-	err := s.
+	username, repositoryName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, err
+	}
+
+	err = s.
 		db.
 		NewSelect().
 		Model(&repoDetail).
 		Relation("ImageManifests").
+		Relation("User", func(sq *bun.SelectQuery) *bun.SelectQuery {
+			return sq.Column("username")
+		}).
+		Where("username = ?", username).
 		Where("name = ?", repositoryName).
 		Limit(pageSize).
 		Offset(offset).
-		Order("created_at DESC").
+		// users have a created_at column too
+		Order("r.created_at DESC").
 		Scan(ctx)
 	if err != nil {
 		logEvent.Err(err).Send()
@@ -543,6 +658,32 @@ func (s *registryStore) SetLayer(ctx context.Context, txn *bun.Tx, l *types.Cont
 	return nil
 }
 
+// SetLayerCompression implements registry.RegistryStore.
+func (s *registryStore) SetLayerCompression(
+	ctx context.Context,
+	txn *bun.Tx,
+	digest string,
+	compression string,
+	tocDigest string,
+) error {
+	logEvent := s.logger.Debug().Str("method", "SetLayerCompression").Str("digest", digest)
+
+	_, err := txn.
+		NewUpdate().
+		Model(&types.ContainerImageLayer{}).
+		Set("compression = ?", compression).
+		Set("toc_digest = ?", tocDigest).
+		Where("digest = ?", digest).
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
 // SetManifest implements registry.RegistryStore.
 func (s *registryStore) SetManifest(ctx context.Context, txn *bun.Tx, im *types.ImageManifest) error {
 	logEvent := s.logger.Debug().Str("method", "SetManifest")
@@ -551,12 +692,23 @@ func (s *registryStore) SetManifest(ctx context.Context, txn *bun.Tx, im *types.
 	}
 
 	if s.db.HasFeature(feature.InsertOnConflict) {
-		_, err := txn.
+		q := txn.
 			NewInsert().
 			Model(im).
 			On("conflict (reference,repository_id) do update").
-			Set("updated_at = ?", time.Now()).
-			Exec(ctx)
+			Set("updated_at = ?", time.Now())
+
+		// pushing an existing tag again should point the tag to the new manifest, so everything except the identity
+		// of the row is replaced
+		for _, field := range s.db.Table(reflect.TypeOf(im).Elem()).DataFields {
+			switch field.Name {
+			case "created_at", "updated_at", "reference", "repository_id":
+				continue
+			}
+			q.Set("? = EXCLUDED.?", bun.Ident(field.Name), bun.Ident(field.Name))
+		}
+
+		_, err := q.Exec(ctx)
 		if err != nil {
 			logEvent.Err(err).Send()
 			return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
@@ -613,13 +765,11 @@ func (s *registryStore) GetReferrers(
 		},
 		MediaType: img_spec_v1.MediaTypeImageIndex,
 	}
-	nsParts := strings.Split(namespace, "/")
-	if len(nsParts) != 2 {
-		return imgIndex, fmt.Errorf("GetReferrers: invalid namespace format")
+	username, repoName, err := types.SplitNamespace(namespace)
+	if err != nil {
+		return imgIndex, fmt.Errorf("GetReferrers: %w", err)
 	}
 
-	username, repoName := nsParts[0], nsParts[1]
-
 	q := s.
 		db.
 		NewSelect().
@@ -717,72 +867,83 @@ func (s *registryStore) IncrementRepositoryPullCounter(ctx context.Context, repo
 }
 
 func (s *registryStore) AddRepositoryToFavorites(ctx context.Context, repoID uuid.UUID, userID uuid.UUID) error {
-	user := types.User{}
-
-	q := s.
-		db.
-		NewUpdate().
-		Model(&user).
-		Set("favorite_repositories = array_append(favorite_repositories, ?)", repoID).
-		Where("id = ?", userID).
-		Where("NOT (? = ANY(favorite_repositories))", repoID)
+	return s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		user, err := s.getFavoriteRepositoriesForUpdate(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
 
-	result, err := q.Exec(ctx)
-	if err != nil {
-		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
-	}
+		if slices.Contains(user.FavoriteRepositories, repoID) {
+			return v1.WrapDatabaseError(
+				fmt.Errorf("repository is already in favorites list"),
+				v1.DatabaseOperationUpdate,
+			)
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
-	}
+		user.FavoriteRepositories = append(user.FavoriteRepositories, repoID)
+		return s.updateFavoriteRepositories(ctx, tx, user, repoID, "favorite_count = favorite_count + 1")
+	})
+}
 
-	if rowsAffected == 1 {
-		repo := types.ContainerImageRepository{
-			ID: repoID,
+func (s *registryStore) RemoveRepositoryFromFavorites(ctx context.Context, repoID uuid.UUID, userID uuid.UUID) error {
+	return s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		user, err := s.getFavoriteRepositoriesForUpdate(ctx, tx, userID)
+		if err != nil {
+			return err
 		}
 
-		_, err = s.db.NewUpdate().Model(&repo).WherePK().Set("favorite_count = favorite_count + 1").Exec(ctx)
-		if err != nil {
-			return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+		idx := slices.Index(user.FavoriteRepositories, repoID)
+		if idx == -1 {
+			return v1.WrapDatabaseError(
+				fmt.Errorf("repository is not in favorites list"),
+				v1.DatabaseOperationUpdate,
+			)
 		}
 
-		return nil
+		user.FavoriteRepositories = slices.Delete(user.FavoriteRepositories, idx, idx+1)
+		return s.updateFavoriteRepositories(ctx, tx, user, repoID, "favorite_count = favorite_count - 1")
+	})
+}
+
+// getFavoriteRepositoriesForUpdate reads the user's favorites so that they can be changed in Go, SQLite has no array
+// functions like array_append/array_remove
+func (s *registryStore) getFavoriteRepositoriesForUpdate(
+	ctx context.Context,
+	tx bun.Tx,
+	userID uuid.UUID,
+) (*types.User, error) {
+	user := &types.User{ID: userID}
+	q := tx.NewSelect().Model(user).Column("id", "favorite_repositories").WherePK()
+
+	// SQLite locks the whole database for writes, row locks are only needed with Postgres
+	if s.db.Dialect().Name() == dialect.PG {
+		q = q.For("UPDATE")
 	}
 
-	return v1.WrapDatabaseError(fmt.Errorf("repository is already in favorites list"), v1.DatabaseOperationUpdate)
-}
+	if err := q.Scan(ctx); err != nil {
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
 
-func (s *registryStore) RemoveRepositoryFromFavorites(ctx context.Context, repoID uuid.UUID, userID uuid.UUID) error {
-	user := types.User{}
-	q := s.
-		db.
-		NewUpdate().
-		Model(&user).
-		Set("favorite_repositories = array_remove(favorite_repositories, ?)", repoID).
-		Where("id = ?", userID).
-		Where("? = ANY(favorite_repositories)", repoID)
+	return user, nil
+}
 
-	result, err := q.Exec(ctx)
+func (s *registryStore) updateFavoriteRepositories(
+	ctx context.Context,
+	tx bun.Tx,
+	user *types.User,
+	repoID uuid.UUID,
+	favoriteCountExpr string,
+) error {
+	_, err := tx.NewUpdate().Model(user).Column("favorite_repositories").WherePK().Exec(ctx)
 	if err != nil {
 		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	if err == nil && rowsAffected == 1 {
-		repo := types.ContainerImageRepository{
-			ID: repoID,
-		}
-
-		_, err = s.db.NewUpdate().Model(&repo).WherePK().Set("favorite_count = favorite_count - 1").Exec(ctx)
-		if err != nil {
-			return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
-		}
-		return nil
+	repo := &types.ContainerImageRepository{ID: repoID}
+	_, err = tx.NewUpdate().Model(repo).WherePK().Set(favoriteCountExpr).Exec(ctx)
+	if err != nil {
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
 	}
 
-	return v1.WrapDatabaseError(
-		fmt.Errorf("repository is not in favorites list"),
-		v1.DatabaseOperationUpdate,
-	)
+	return nil
 }
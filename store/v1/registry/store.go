@@ -31,10 +31,18 @@ func New(
 
 type RegistryBaseStore interface {
 	SetLayer(ctx context.Context, txn *bun.Tx, l *types.ContainerImageLayer) error
+	SetLayerCompression(ctx context.Context, txn *bun.Tx, digest string, compression string, tocDigest string) error
 	GetLayer(ctx context.Context, digest string) (*types.ContainerImageLayer, error)
 	SetManifest(ctx context.Context, txn *bun.Tx, im *types.ImageManifest) error
 	GetManifest(ctx context.Context, ref string) (*types.ImageManifest, error)
 	GetManifestByReference(ctx context.Context, namespace string, ref string) (*types.ImageManifest, error)
+	// IsBlobReferenced reports whether a manifest in the repository uses the blob as its config or as a layer
+	IsBlobReferenced(ctx context.Context, namespace string, digest string) (bool, error)
+	// LinkBlob records that the blob was uploaded to the repository. It must be called in the same transaction as
+	// SetLayer
+	LinkBlob(ctx context.Context, txn *bun.Tx, namespace string, digest string) error
+	// RepositoryHasBlob reports whether the blob was uploaded to the repository or is used by one of its manifests
+	RepositoryHasBlob(ctx context.Context, namespace string, digest string) (bool, error)
 	GetReferrers(
 		ctx context.Context,
 		ns string,
@@ -87,4 +95,40 @@ type RegistryStore interface {
 	IncrementRepositoryPullCounter(ctx context.Context, repoID uuid.UUID) error
 	AddRepositoryToFavorites(ctx context.Context, repoID uuid.UUID, userID uuid.UUID) error
 	RemoveRepositoryFromFavorites(ctx context.Context, repoID uuid.UUID, userID uuid.UUID) error
+
+	GetLayerFileIndex(ctx context.Context, digest string) (*types.LayerFileIndex, error)
+	SetLayerFileIndex(ctx context.Context, index *types.LayerFileIndex) error
+
+	AddAuditEvent(ctx context.Context, txn *bun.Tx, event *types.RepositoryAuditEvent) error
+	ListAuditEvents(
+		ctx context.Context,
+		repositoryID uuid.UUID,
+		pageSize int,
+		offset int,
+	) ([]*types.RepositoryAuditEvent, int, error)
+
+	// AddTagHistory records the move of a tag to the digest of the manifest. It must be called before the manifest is
+	// written with SetManifest, in the same transaction
+	AddTagHistory(ctx context.Context, txn *bun.Tx, im *types.ImageManifest, userID uuid.UUID, userAgent string) error
+	ListTagHistory(
+		ctx context.Context,
+		repositoryID uuid.UUID,
+		tag string,
+		pageSize int,
+		offset int,
+	) ([]*types.TagHistory, int, error)
+
+	// ListLayers returns up to limit layers with an id after afterID, ordered by id. Pass an empty afterID to start
+	// from the first layer
+	ListLayers(ctx context.Context, afterID string, limit int) ([]*types.ContainerImageLayer, error)
+	UpdateLayerLink(ctx context.Context, digest string, backend string, dfsLink string) error
+	UpdateLayerSize(ctx context.Context, digest string, size int64) error
+	// ListManifests returns up to limit manifests with an id after afterID, ordered by id. Pass uuid.Nil to start
+	// from the first manifest
+	ListManifests(ctx context.Context, afterID uuid.UUID, limit int) ([]*types.ImageManifest, error)
+	// MarkManifestsBroken records that blobs of the manifests are missing or corrupted. Pushing a manifest again
+	// clears the mark
+	MarkManifestsBroken(ctx context.Context, ids []uuid.UUID) error
+	// ClearManifestsBroken removes the mark set by MarkManifestsBroken
+	ClearManifestsBroken(ctx context.Context, ids []uuid.UUID) error
 }
@@ -0,0 +1,118 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	oci_digest "github.com/opencontainers/go-digest"
+	"github.com/uptrace/bun"
+
+	v1 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// AddTagHistory implements registry.RegistryStore.
+func (s *registryStore) AddTagHistory(
+	ctx context.Context,
+	txn *bun.Tx,
+	im *types.ImageManifest,
+	userID uuid.UUID,
+	userAgent string,
+) error {
+	logEvent := s.logger.Debug().Str("method", "AddTagHistory").Str("reference", im.Reference)
+
+	// manifests pushed by digest don't move any tags
+	if _, err := oci_digest.Parse(im.Reference); err == nil {
+		logEvent.Bool("success", true).Send()
+		return nil
+	}
+
+	var current types.ImageManifest
+	err := txn.
+		NewSelect().
+		Model(&current).
+		Where("repository_id = ?", im.RepositoryID).
+		Where("reference = ?", im.Reference).
+		Scan(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	found := err == nil
+	if found && current.Digest == im.Digest {
+		logEvent.Bool("success", true).Send()
+		return nil
+	}
+
+	history := &types.TagHistory{
+		Tag:          im.Reference,
+		NewDigest:    im.Digest,
+		UserAgent:    userAgent,
+		UserID:       userID,
+		RepositoryID: im.RepositoryID,
+	}
+
+	if found {
+		history.OldDigest = current.Digest
+
+		// the tag row is about to be replaced, so the previous manifest is kept by its digest. This keeps it pullable
+		// and makes it possible to roll the tag back to it
+		previous := current
+		previous.ID = uuid.New()
+		previous.Reference = current.Digest
+		_, err = txn.
+			NewInsert().
+			Model(&previous).
+			On("conflict (reference,repository_id) do nothing").
+			Exec(ctx)
+		if err != nil {
+			logEvent.Err(err).Send()
+			return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+		}
+	}
+
+	if _, err = txn.NewInsert().Model(history).Exec(ctx); err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// ListTagHistory implements registry.RegistryStore.
+func (s *registryStore) ListTagHistory(
+	ctx context.Context,
+	repositoryID uuid.UUID,
+	tag string,
+	pageSize int,
+	offset int,
+) ([]*types.TagHistory, int, error) {
+	logEvent := s.logger.Debug().Str("method", "ListTagHistory").Str("repository_id", repositoryID.String())
+
+	var history []*types.TagHistory
+	q := s.
+		db.
+		NewSelect().
+		Model(&history).
+		Where("repository_id = ?", repositoryID).
+		Order("created_at DESC").
+		Limit(pageSize).
+		Offset(offset)
+
+	if tag != "" {
+		q.Where("tag = ?", tag)
+	}
+
+	total, err := q.ScanAndCount(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, 0, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return history, total, nil
+}
@@ -0,0 +1,37 @@
+package replication
+
+import (
+	"context"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/uptrace/bun"
+
+	"github.com/containerish/OpenRegistry/store/v1/types"
+	"github.com/containerish/OpenRegistry/telemetry"
+)
+
+type store struct {
+	logger telemetry.Logger
+	db     *bun.DB
+}
+
+// ReplicationStore is the persisted queue of blobs that have to be copied to the secondary storage backends
+type ReplicationStore interface {
+	// Enqueue adds the tasks to the queue. A task for a key & target that's already queued or replicated is reset, so
+	// that a blob that's pushed again is copied again
+	Enqueue(ctx context.Context, tasks ...*types.ReplicationTask) error
+	// Claim returns up to limit tasks for the target that are due. The claimed tasks aren't handed out again until the
+	// lease is over, so several registry instances can work on the same queue
+	Claim(ctx context.Context, target string, limit int, lease time.Duration) ([]*types.ReplicationTask, error)
+	MarkReplicated(ctx context.Context, task *types.ReplicationTask, replicaLink string) error
+	MarkFailed(ctx context.Context, task *types.ReplicationTask, taskErr error, retryAt time.Time) error
+	// GetReplicaLink returns where the target stored the blob, it's empty if the blob wasn't replicated yet
+	GetReplicaLink(ctx context.Context, key string, target string) (string, error)
+	GetReplicationLag(ctx context.Context) ([]*types.ReplicationLag, error)
+}
+
+func New(db *bun.DB, logger telemetry.Logger) ReplicationStore {
+	color.Green("Service - ReplicationStore - connection to database successful")
+	return &store{db: db, logger: logger}
+}
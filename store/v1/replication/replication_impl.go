@@ -0,0 +1,202 @@
+package replication
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+
+	v1 "github.com/containerish/OpenRegistry/store/v1"
+	"github.com/containerish/OpenRegistry/store/v1/types"
+)
+
+// Enqueue implements ReplicationStore.
+func (s *store) Enqueue(ctx context.Context, tasks ...*types.ReplicationTask) error {
+	logEvent := s.logger.Debug().Str("method", "Enqueue").Int("tasks", len(tasks))
+	if len(tasks) == 0 {
+		logEvent.Bool("success", true).Send()
+		return nil
+	}
+
+	_, err := s.
+		db.
+		NewInsert().
+		Model(&tasks).
+		On("CONFLICT (key, target) DO UPDATE").
+		Set("digest = EXCLUDED.digest").
+		Set("created_at = EXCLUDED.created_at").
+		Set("next_attempt_at = EXCLUDED.next_attempt_at").
+		Set("completed_at = NULL").
+		Set("replica_link = NULL").
+		Set("last_error = NULL").
+		Set("attempts = 0").
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationWrite)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// Claim implements ReplicationStore.
+func (s *store) Claim(
+	ctx context.Context,
+	target string,
+	limit int,
+	lease time.Duration,
+) ([]*types.ReplicationTask, error) {
+	logEvent := s.logger.Debug().Str("method", "Claim").Str("target", target)
+
+	var tasks []*types.ReplicationTask
+	err := s.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		now := time.Now()
+		q := tx.
+			NewSelect().
+			Model(&tasks).
+			Where("target = ?", target).
+			Where("completed_at IS NULL").
+			Where("next_attempt_at <= ?", now).
+			Order("next_attempt_at ASC").
+			Limit(limit)
+
+		// SQLite locks the whole database for writes, row locks are only needed with Postgres
+		if s.db.Dialect().Name() == dialect.PG {
+			q = q.For("UPDATE SKIP LOCKED")
+		}
+
+		if err := q.Scan(ctx); err != nil {
+			return err
+		}
+
+		if len(tasks) == 0 {
+			return nil
+		}
+
+		ids := make([]any, 0, len(tasks))
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+
+		_, err := tx.
+			NewUpdate().
+			Model(&types.ReplicationTask{}).
+			Set("next_attempt_at = ?", now.Add(lease)).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		return err
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Int("claimed", len(tasks)).Bool("success", true).Send()
+	return tasks, nil
+}
+
+// MarkReplicated implements ReplicationStore.
+func (s *store) MarkReplicated(ctx context.Context, task *types.ReplicationTask, replicaLink string) error {
+	logEvent := s.logger.Debug().Str("method", "MarkReplicated").Str("key", task.Key).Str("target", task.Target)
+
+	task.CompletedAt = time.Now()
+	task.ReplicaLink = replicaLink
+	task.LastError = ""
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(task).
+		Column("completed_at", "replica_link", "last_error", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// MarkFailed implements ReplicationStore.
+func (s *store) MarkFailed(ctx context.Context, task *types.ReplicationTask, taskErr error, retryAt time.Time) error {
+	logEvent := s.logger.Debug().Str("method", "MarkFailed").Str("key", task.Key).Str("target", task.Target)
+
+	task.Attempts++
+	task.LastError = taskErr.Error()
+	task.NextAttemptAt = retryAt
+	_, err := s.
+		db.
+		NewUpdate().
+		Model(task).
+		Column("attempts", "last_error", "next_attempt_at", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return v1.WrapDatabaseError(err, v1.DatabaseOperationUpdate)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return nil
+}
+
+// GetReplicaLink implements ReplicationStore.
+func (s *store) GetReplicaLink(ctx context.Context, key string, target string) (string, error) {
+	logEvent := s.logger.Debug().Str("method", "GetReplicaLink").Str("key", key).Str("target", target)
+
+	var task types.ReplicationTask
+	err := s.
+		db.
+		NewSelect().
+		Model(&task).
+		Column("replica_link").
+		Where("key = ?", key).
+		Where("target = ?", target).
+		Where("completed_at IS NOT NULL").
+		Scan(ctx)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return "", v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	logEvent.Bool("success", true).Send()
+	return task.ReplicaLink, nil
+}
+
+// GetReplicationLag implements ReplicationStore.
+func (s *store) GetReplicationLag(ctx context.Context) ([]*types.ReplicationLag, error) {
+	logEvent := s.logger.Debug().Str("method", "GetReplicationLag")
+
+	var lag []*types.ReplicationLag
+	err := s.
+		db.
+		NewSelect().
+		Model((*types.ReplicationTask)(nil)).
+		Column("target").
+		ColumnExpr("SUM(CASE WHEN completed_at IS NULL THEN 1 ELSE 0 END) AS pending").
+		ColumnExpr("SUM(CASE WHEN completed_at IS NULL AND attempts > 0 THEN 1 ELSE 0 END) AS failing").
+		ColumnExpr("SUM(CASE WHEN completed_at IS NOT NULL THEN 1 ELSE 0 END) AS replicated").
+		ColumnExpr("MIN(CASE WHEN completed_at IS NULL THEN created_at END) AS oldest_pending_at").
+		ColumnExpr("MAX(completed_at) AS last_replicated_at").
+		Group("target").
+		Order("target").
+		Scan(ctx, &lag)
+	if err != nil {
+		logEvent.Err(err).Send()
+		return nil, v1.WrapDatabaseError(err, v1.DatabaseOperationRead)
+	}
+
+	for _, l := range lag {
+		if !l.OldestPendingAt.IsZero() {
+			l.LagSeconds = time.Since(l.OldestPendingAt).Seconds()
+		}
+	}
+
+	logEvent.Bool("success", true).Send()
+	return lag, nil
+}
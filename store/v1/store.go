@@ -3,6 +3,7 @@ package v1
 import (
 	"context"
 	"database/sql"
+	"net/url"
 	"os"
 
 	"github.com/containerish/OpenRegistry/config"
@@ -28,7 +29,7 @@ func New(cfg config.Store, env config.Environment) *bun.DB {
 		sqlDB = sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(cfg.Endpoint())))
 	case config.StoreKindSQLite:
 		dialect = sqlitedialect.New()
-		sqliteDB, err := sql.Open(sqliteshim.ShimName, "file::memory:?cache=shared")
+		sqliteDB, err := sql.Open(sqliteshim.ShimName, sqliteDSN(cfg.Path))
 		if err != nil {
 			color.Red("error opening connection for SQLite: %s", err)
 			os.Exit(1101)
@@ -58,6 +59,32 @@ func New(cfg config.Store, env config.Environment) *bun.DB {
 	return bunWrappedDB
 }
 
+// sqliteDSN returns the connection string for the SQLite database at path, or a shared in-memory database when path
+// is empty. File backed databases use WAL so that readers don't block the writer, wait for locks instead of failing
+// with SQLITE_BUSY and take the write lock when a transaction begins, since upgrading a read transaction to a write
+// one can't wait for the lock
+func sqliteDSN(path string) string {
+	if path == "" {
+		return "file::memory:?cache=shared"
+	}
+
+	params := url.Values{}
+	params.Set("_txlock", "immediate")
+	// sqliteshim picks mattn/go-sqlite3 ("sqlite3") when Cgo is enabled and modernc.org/sqlite ("sqlite") otherwise,
+	// they name the pragma parameters differently
+	if sqliteshim.DriverName() == "sqlite3" {
+		params.Set("_journal_mode", "WAL")
+		params.Set("_busy_timeout", "5000")
+		params.Set("_foreign_keys", "on")
+	} else {
+		params.Add("_pragma", "journal_mode(WAL)")
+		params.Add("_pragma", "busy_timeout(5000)")
+		params.Add("_pragma", "foreign_keys(on)")
+	}
+
+	return "file:" + path + "?" + params.Encode()
+}
+
 type DBPinger struct {
 	DB *bun.DB
 }
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+const (
+	// AuditActionPromote is recorded when a manifest is copied to a tag by the promotion API
+	AuditActionPromote = "promote"
+	// AuditActionRollback is recorded when a tag is rolled back to a digest it pointed to before
+	AuditActionRollback = "rollback"
+)
+
+type (
+	// RepositoryAuditEvent records a change made to a repository through the extension APIs. The event is written in
+	// the same transaction as the change itself
+	RepositoryAuditEvent struct {
+		bun.BaseModel `bun:"table:repository_audit_events,alias:rae" json:"-"`
+
+		CreatedAt    time.Time         `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+		Metadata     map[string]string `bun:"metadata" json:"metadata,omitempty"`
+		Action       string            `bun:"action,notnull" json:"action"`
+		Namespace    string            `bun:"namespace,notnull" json:"namespace"`
+		Reference    string            `bun:"reference" json:"reference"`
+		Digest       string            `bun:"digest" json:"digest"`
+		ActorID      uuid.UUID         `bun:"actor_id,type:uuid" json:"actor_id"`
+		RepositoryID uuid.UUID         `bun:"repository_id,type:uuid,notnull" json:"repository_id"`
+		ID           uuid.UUID         `bun:"id,pk,type:uuid" json:"id"`
+	}
+)
+
+var _ bun.BeforeAppendModelHook = (*RepositoryAuditEvent)(nil)
+var _ bun.AfterCreateTableHook = (*RepositoryAuditEvent)(nil)
+
+func (e *RepositoryAuditEvent) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if _, ok := query.(*bun.InsertQuery); ok {
+		e.CreatedAt = time.Now()
+		if e.ID == uuid.Nil {
+			e.ID = uuid.New()
+		}
+	}
+
+	return nil
+}
+
+func (e *RepositoryAuditEvent) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery) error {
+	_, err := query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(e).
+		Index("repository_audit_events_repository_id_idx").
+		Column("repository_id", "created_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	color.Yellow(`Create index in table "repository_audit_events" on column "repository_id" succeeded ✔︎`)
+	return nil
+}
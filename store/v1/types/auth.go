@@ -2,7 +2,6 @@ package types
 
 import (
 	"bytes"
-	"strings"
 )
 
 type (
@@ -57,9 +56,8 @@ func (cl OCITokenPermissonClaimList) HasPullAccess(name string) bool {
 
 func (cl OCITokenPermissonClaimList) MatchUsername(name string) bool {
 	for _, claim := range cl {
-		nameParts := strings.Split(claim.Name, "/")
-		if len(nameParts) == 2 {
-			if ok := bytes.EqualFold([]byte(nameParts[0]), []byte(name)); ok {
+		if owner, _, err := SplitNamespace(claim.Name); err == nil {
+			if ok := bytes.EqualFold([]byte(owner), []byte(name)); ok {
 				return ok
 			}
 		}
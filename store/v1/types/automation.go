@@ -30,7 +30,7 @@ type (
 
 		UpdatedAt            time.Time                 `bun:"updated_at" json:"updated_at,omitempty" validate:"-"`
 		CreatedAt            time.Time                 `bun:"created_at" json:"created_at,omitempty" validate:"-"`
-		EnvironmentVariables map[string]string         `bun:"environment_variables,type:jsonb" json:"environment_variables"`
+		EnvironmentVariables map[string]string         `bun:"environment_variables" json:"environment_variables"`
 		Repository           *ContainerImageRepository `bun:"rel:belongs-to,join:repository_id=id" json:"-"`
 		Name                 string                    `bun:"name" json:"name"`
 		ProductionBranch     string                    `bun:"production_branch" json:"production_branch"`
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	img_spec_v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	LayerCompressionNone        = "none"
+	LayerCompressionGzip        = "gzip"
+	LayerCompressionZstd        = "zstd"
+	LayerCompressionEStargz     = "estargz"
+	LayerCompressionZstdChunked = "zstd:chunked"
+	LayerCompressionMixed       = "mixed"
+
+	// eStargz layers are regular gzip layers with a table of contents appended to them, they are only distinguishable
+	// from gzip layers by these annotations
+	// Reference: https://github.com/containerd/stargz-snapshotter/blob/main/docs/estargz.md
+	AnnotationEStargzTOCDigest        = "containerd.io/snapshot/stargz/toc.digest"
+	AnnotationEStargzUncompressedSize = "io.containers.estargz.uncompressed-size"
+
+	// zstd:chunked layers are the zstd equivalent of eStargz
+	AnnotationZstdChunkedManifestChecksum = "io.github.containers.zstd-chunked.manifest-checksum"
+	AnnotationZstdChunkedManifestPosition = "io.github.containers.zstd-chunked.manifest-position"
+
+	MediaTypeDockerLayerGzip        = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeDockerForeignLayerGzip = "application/vnd.docker.image.rootfs.foreign.diff.tar.gzip"
+	MediaTypeDockerManifest         = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeDockerImageConfig      = "application/vnd.docker.container.image.v1+json"
+
+	ociLayerMediaTypePrefix = "application/vnd.oci.image.layer."
+)
+
+// LayerCompression returns the compression used for a layer, based on its media type and annotations. An empty string
+// is returned for descriptors that aren't container image layers (e.g, artifacts). Image layers with an unknown
+// compression return an error
+func LayerCompression(desc *img_spec_v1.Descriptor) (string, error) {
+	var compression string
+
+	//nolint:staticcheck // the non-distributable media types are deprecated but still in use
+	switch desc.MediaType {
+	case img_spec_v1.MediaTypeImageLayer, img_spec_v1.MediaTypeImageLayerNonDistributable:
+		compression = LayerCompressionNone
+	case img_spec_v1.MediaTypeImageLayerGzip, img_spec_v1.MediaTypeImageLayerNonDistributableGzip,
+		MediaTypeDockerLayerGzip, MediaTypeDockerForeignLayerGzip:
+		compression = LayerCompressionGzip
+	case img_spec_v1.MediaTypeImageLayerZstd, img_spec_v1.MediaTypeImageLayerNonDistributableZstd:
+		compression = LayerCompressionZstd
+	default:
+		if strings.HasPrefix(desc.MediaType, ociLayerMediaTypePrefix) {
+			return "", fmt.Errorf("unsupported layer media type: %s", desc.MediaType)
+		}
+		return "", nil
+	}
+
+	if compression == LayerCompressionGzip && desc.Annotations[AnnotationEStargzTOCDigest] != "" {
+		return LayerCompressionEStargz, nil
+	}
+
+	if compression == LayerCompressionZstd && desc.Annotations[AnnotationZstdChunkedManifestChecksum] != "" {
+		return LayerCompressionZstdChunked, nil
+	}
+
+	return compression, nil
+}
+
+// LayerTOCDigest returns the digest of the table of contents for eStargz and zstd:chunked layers
+func LayerTOCDigest(desc *img_spec_v1.Descriptor) string {
+	if tocDigest := desc.Annotations[AnnotationEStargzTOCDigest]; tocDigest != "" {
+		return tocDigest
+	}
+
+	return desc.Annotations[AnnotationZstdChunkedManifestChecksum]
+}
+
+// LayerCompression returns the compression used by all the layers of the manifest, or "mixed" if the layers use
+// different compression formats
+func (m *ImageManifest) LayerCompression() string {
+	seen := make(map[string]struct{})
+	for _, layer := range m.Layers {
+		if compression, err := LayerCompression(layer); err == nil && compression != "" {
+			seen[compression] = struct{}{}
+		}
+	}
+
+	compressions := make([]string, 0, len(seen))
+	for compression := range seen {
+		compressions = append(compressions, compression)
+	}
+	sort.Strings(compressions)
+
+	switch len(compressions) {
+	case 0:
+		return ""
+	case 1:
+		return compressions[0]
+	default:
+		return LayerCompressionMixed
+	}
+}
+
+// SetManifestsCompression sets the Compression field for all the manifests. Image indexes get the compression of the
+// child manifests that are part of the list
+func SetManifestsCompression(manifests []*ImageManifest) {
+	byDigest := make(map[string]string, len(manifests))
+	for _, m := range manifests {
+		m.Compression = m.LayerCompression()
+		if m.Compression != "" {
+			byDigest[m.Digest] = m.Compression
+		}
+	}
+
+	for _, m := range manifests {
+		if len(m.Manifests) == 0 {
+			continue
+		}
+
+		seen := make(map[string]struct{})
+		for _, child := range m.Manifests {
+			if compression, ok := byDigest[child.Digest.String()]; ok {
+				seen[compression] = struct{}{}
+			}
+		}
+
+		compressions := make([]string, 0, len(seen))
+		for compression := range seen {
+			compressions = append(compressions, compression)
+		}
+		sort.Strings(compressions)
+		m.Compression = strings.Join(compressions, ",")
+	}
+}
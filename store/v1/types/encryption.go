@@ -0,0 +1,38 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// BlobKey is the data key of an encrypted blob. The data key is only stored wrapped (encrypted) by a master key, KeyID
+// identifies which one
+type BlobKey struct {
+	bun.BaseModel `bun:"table:blob_keys,alias:bk" json:"-"`
+
+	CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero" json:"updated_at"`
+	// Link is the DFS link of the encrypted blob
+	Link       string `bun:"link,pk" json:"link"`
+	KeyID      string `bun:"key_id,notnull" json:"key_id"`
+	WrappedKey []byte `bun:"wrapped_key,notnull" json:"-"`
+	// Size is the size of the plaintext, the stored blob is larger
+	Size int64 `bun:"size,notnull" json:"size"`
+	// Parts is the number of separately encrypted parts the blob was uploaded in
+	Parts int32 `bun:"parts,notnull" json:"parts"`
+}
+
+var _ bun.BeforeAppendModelHook = (*BlobKey)(nil)
+
+func (bk *BlobKey) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		bk.CreatedAt = time.Now()
+	case *bun.UpdateQuery:
+		bk.UpdatedAt = time.Now()
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	LayerFileTypeRegular  = "file"
+	LayerFileTypeDir      = "dir"
+	LayerFileTypeSymlink  = "symlink"
+	LayerFileTypeHardlink = "hardlink"
+	LayerFileTypeOther    = "other"
+)
+
+type (
+	// LayerFileIndex is the computed file listing of a single layer. Since layers are content addressed, the index
+	// only ever needs to be generated once per digest
+	LayerFileIndex struct {
+		bun.BaseModel `bun:"table:layer_file_indexes,alias:lfi" json:"-"`
+
+		CreatedAt   time.Time         `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+		Digest      string            `bun:"digest,pk" json:"digest"`
+		Compression string            `bun:"compression" json:"compression"`
+		Entries     []*LayerFileEntry `bun:"entries" json:"entries"`
+		TotalSize   int64             `bun:"total_size" json:"total_size"`
+		TotalFiles  int               `bun:"total_files" json:"total_files"`
+	}
+
+	LayerFileEntry struct {
+		Path       string `json:"path"`
+		Type       string `json:"type"`
+		Mode       string `json:"mode"`
+		LinkTarget string `json:"link_target,omitempty"`
+		Size       int64  `json:"size"`
+		// Whiteout is set for the OCI whiteout entries, i.e, files deleted by this layer
+		Whiteout bool `json:"whiteout,omitempty"`
+	}
+)
+
+var _ bun.BeforeAppendModelHook = (*LayerFileIndex)(nil)
+
+func (idx *LayerFileIndex) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if _, ok := query.(*bun.InsertQuery); ok {
+		idx.CreatedAt = time.Now()
+	}
+
+	return nil
+}
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AnnotationNamespace carries the namespace of an image on the descriptors of an OCI image layout index, so that a
+// single layout can carry images from multiple repositories
+const AnnotationNamespace = "sh.openregistry.image.namespace"
+
+// SplitNamespace splits a repository namespace into the owning user (or organization) and the repository name. The
+// repository name can have any number of path components, e.g, org/team/service/image -> org, team/service/image
+func SplitNamespace(namespace string) (string, string, error) {
+	owner, name, ok := strings.Cut(namespace, "/")
+	if !ok || owner == "" || name == "" || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+		return "", "", fmt.Errorf("invalid namespace %q, expected <username>/<imagename>", namespace)
+	}
+
+	return owner, name, nil
+}
@@ -44,6 +44,7 @@ func (p *Permissions) AfterCreateTable(ctx context.Context, query *bun.CreateTab
 	_, err := query.
 		DB().
 		NewCreateIndex().
+		Conn(query.GetConn()).
 		IfNotExists().
 		Model(p).
 		Index("org_id_user_id_idx").
@@ -59,7 +60,7 @@ func (p *Permissions) AfterCreateTable(ctx context.Context, query *bun.CreateTab
 }
 
 func (p *Permissions) AfterDropTable(ctx context.Context, query *bun.DropTableQuery) error {
-	_, err := query.DB().NewDropIndex().IfExists().Model(p).Index("org_id_user_id_idx").Exec(ctx)
+	_, err := query.DB().NewDropIndex().Conn(query.GetConn()).IfExists().Model(p).Index("org_id_user_id_idx").Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -37,6 +37,8 @@ type (
 		Visibility        RepositoryVisibility `json:"visibility_mode"`
 	}
 
+	// ImageManifest's JSON columns (annotations, layers and manifests) don't set a column type, bun picks jsonb on
+	// Postgres and text on SQLite
 	ImageManifest struct {
 		bun.BaseModel `bun:"table:image_manifests,alias:m" json:"-"`
 
@@ -46,17 +48,23 @@ type (
 		User          *User                     `bun:"rel:belongs-to,join:owner_id=id" json:"-"`
 		Subject       *img_spec_v1.Descriptor   `bun:"embed:subject_" json:"subject,omitempty"`
 		Config        *img_spec_v1.Descriptor   `bun:"embed:config_" json:"config"`
-		Annotations   map[string]string         `bun:"annotations,type:jsonb" json:"annotations,omitempty"`
+		Annotations   map[string]string         `bun:"annotations" json:"annotations,omitempty"`
 		Digest        string                    `bun:"digest,notnull" json:"digest"`
 		MediaType     string                    `bun:"media_type,notnull" json:"mediaType"`
 		ArtifactType  string                    `bun:"artifact_type" json:"artifactType,omitempty"`
 		Reference     string                    `bun:"reference,notnull" json:"reference"`
-		Layers        ImageManifestLayers       `bun:"layers,type:jsonb" json:"layers"`
+		Layers        ImageManifestLayers       `bun:"layers" json:"layers"`
 		SchemaVersion int                       `bun:"schema_version,notnull" json:"schemaVersion"`
 		Size          int64                     `bun:"size,notnull" json:"size"`
 		RepositoryID  uuid.UUID                 `bun:"repository_id,type:uuid" json:"repositoryId"`
 		ID            uuid.UUID                 `bun:"id,pk,type:uuid" json:"id"`
 		OwnerID       uuid.UUID                 `bun:"owner_id,type:uuid" json:"ownerId"`
+		// Manifests is only set for image indexes
+		Manifests []img_spec_v1.Descriptor `bun:"manifests" json:"manifests,omitempty"`
+		// Compression is derived from the layers and isn't stored in the database
+		Compression string `bun:"-" json:"compression,omitempty"`
+		// BrokenAt is set by fsck when a blob that the manifest references is missing or corrupted
+		BrokenAt time.Time `bun:"broken_at,nullzero" json:"brokenAt,omitempty"`
 	}
 
 	Platform struct {
@@ -85,6 +93,12 @@ type (
 		MediaType string    `bun:"media_type,notnull" json:"mediaType"`
 		DFSLink   string    `bun:"dfs_link" json:"dfsLink"`
 		Size      int64     `bun:"size,default:0" json:"size"`
+		// Compression and TOCDigest are recorded when a manifest referencing the layer is pushed
+		Compression string `bun:"compression" json:"compression,omitempty"`
+		TOCDigest   string `bun:"toc_digest" json:"tocDigest,omitempty"`
+		// Backend is the name of the storage backend that DFSLink points into. Layers pushed before backends were named
+		// don't have one, they are on the default backend
+		Backend string `bun:"backend" json:"backend,omitempty"`
 	}
 
 	ContainerImageRepository struct {
@@ -100,7 +114,7 @@ type (
 		Name           string               `bun:"name,notnull" json:"name"`
 		ImageManifests []*ImageManifest     `bun:"rel:has-many,join:id=repository_id" json:"image_manifests,omitempty"`
 		Builds         []*RepositoryBuild   `bun:"rel:has-many,join:id=repository_id" json:"-"`
-		ID             uuid.UUID            `bun:"id,pk,type:uuid,default:gen_random_uuid()" json:"id"`
+		ID             uuid.UUID            `bun:"id,pk,type:uuid,default:(gen_random_uuid())" json:"id"`
 		OwnerID        uuid.UUID            `bun:"owner_id,type:uuid" json:"owner_id"`
 		PullCount      uint64               `bun:"pull_count" json:"pull_count"`
 		FavoriteCount  uint64               `bun:"favorite_count" json:"favorite_count"`
@@ -131,9 +145,15 @@ func (l *ImageManifestLayers) Scan(input any) error {
 		return nil
 	}
 
-	bz, ok := input.([]byte)
-	if !ok {
-		return fmt.Errorf("Scan: expected []byte, got %T", input)
+	// Postgres returns jsonb as bytes while SQLite returns the text it was stored as
+	var bz []byte
+	switch v := input.(type) {
+	case []byte:
+		bz = v
+	case string:
+		bz = []byte(v)
+	default:
+		return fmt.Errorf("Scan: expected []byte or string, got %T", input)
 	}
 
 	if err := json.Unmarshal(bz, l); err != nil {
@@ -151,12 +171,17 @@ func (m *ImageManifest) ToOCISubject() []byte {
 	}
 
 	manifest := map[string]any{
-		"config":        m.Config,
 		"mediaType":     m.MediaType,
-		"layers":        m.Layers,
 		"schemaVersion": m.SchemaVersion,
 	}
 
+	if m.MediaType == img_spec_v1.MediaTypeImageIndex || m.MediaType == MediaTypeDockerManifestList {
+		manifest["manifests"] = m.Manifests
+	} else {
+		manifest["config"] = m.Config
+		manifest["layers"] = m.Layers
+	}
+
 	if m.ArtifactType != "" {
 		manifest["artifactType"] = m.ArtifactType
 	}
@@ -232,7 +257,15 @@ var _ bun.AfterCreateTableHook = (*ContainerImageLayer)(nil)
 var _ bun.AfterCreateTableHook = (*ContainerImageRepository)(nil)
 
 func (cir *ContainerImageRepository) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery) error {
-	_, err := query.DB().NewCreateIndex().IfNotExists().Model(cir).Index("name_idx").Column("name").Exec(ctx)
+	_, err := query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(cir).
+		Index("name_idx").
+		Column("name").
+		Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -242,7 +275,15 @@ func (cir *ContainerImageRepository) AfterCreateTable(ctx context.Context, query
 }
 
 func (l *ContainerImageLayer) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery) error {
-	_, err := query.DB().NewCreateIndex().IfNotExists().Model(l).Index("digest_idx").Column("digest").Exec(ctx)
+	_, err := query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(l).
+		Index("digest_idx").
+		Column("digest").
+		Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -252,12 +293,28 @@ func (l *ContainerImageLayer) AfterCreateTable(ctx context.Context, query *bun.C
 }
 
 func (imf *ImageManifest) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery) error {
-	_, err := query.DB().NewCreateIndex().IfNotExists().Model(imf).Index("digest_idx").Column("digest").Exec(ctx)
+	_, err := query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(imf).
+		Index("digest_idx").
+		Column("digest").
+		Exec(ctx)
 	if err != nil {
 		return err
 	}
 	color.Yellow(`Create index in table "image_manifests" on column "digest" succeeded ✔︎`)
-	_, err = query.DB().NewCreateIndex().IfNotExists().Model(imf).Index("reference_idx").Column("reference").Exec(ctx)
+	_, err = query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(imf).
+		Index("reference_idx").
+		Column("reference").
+		Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -272,12 +329,12 @@ var _ bun.AfterDropTableHook = (*ContainerImageLayer)(nil)
 var _ bun.AfterDropTableHook = (*ContainerImageRepository)(nil)
 
 func (imf *ImageManifest) AfterDropTable(ctx context.Context, query *bun.DropTableQuery) error {
-	_, err := query.DB().NewDropIndex().IfExists().Model(imf).Index("digest_idx").Exec(ctx)
+	_, err := query.DB().NewDropIndex().Conn(query.GetConn()).IfExists().Model(imf).Index("digest_idx").Exec(ctx)
 	if err != nil {
 		return err
 	}
 	color.Yellow(`Drop index in table "image_manifests" on column "digest" succeeded ✔︎`)
-	_, err = query.DB().NewDropIndex().IfExists().Model(imf).Index("reference_idx").Exec(ctx)
+	_, err = query.DB().NewDropIndex().Conn(query.GetConn()).IfExists().Model(imf).Index("reference_idx").Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -286,7 +343,7 @@ func (imf *ImageManifest) AfterDropTable(ctx context.Context, query *bun.DropTab
 }
 
 func (cir *ContainerImageRepository) AfterDropTable(ctx context.Context, query *bun.DropTableQuery) error {
-	_, err := query.DB().NewDropIndex().IfExists().Model(cir).Index("name_idx").Exec(ctx)
+	_, err := query.DB().NewDropIndex().Conn(query.GetConn()).IfExists().Model(cir).Index("name_idx").Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -295,7 +352,7 @@ func (cir *ContainerImageRepository) AfterDropTable(ctx context.Context, query *
 }
 
 func (l *ContainerImageLayer) AfterDropTable(ctx context.Context, query *bun.DropTableQuery) error {
-	_, err := query.DB().NewDropIndex().IfExists().Model(l).Index("digest_idx").Exec(ctx)
+	_, err := query.DB().NewDropIndex().Conn(query.GetConn()).IfExists().Model(l).Index("digest_idx").Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,94 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type (
+	// ReplicationTask is the copy of a blob from the primary storage backend to a secondary. Completed tasks are kept,
+	// ReplicaLink is where the secondary stored the blob, which isn't always the same as the key on the primary
+	ReplicationTask struct {
+		bun.BaseModel `bun:"table:replication_tasks,alias:rt" json:"-"`
+
+		CreatedAt     time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+		UpdatedAt     time.Time `bun:"updated_at,nullzero" json:"updated_at"`
+		NextAttemptAt time.Time `bun:"next_attempt_at,notnull,default:current_timestamp" json:"next_attempt_at"`
+		CompletedAt   time.Time `bun:"completed_at,nullzero" json:"completed_at,omitempty"`
+		// Key is the DFS link of the blob on the primary
+		Key         string    `bun:"key,notnull" json:"key"`
+		Digest      string    `bun:"digest,notnull" json:"digest"`
+		Target      string    `bun:"target,notnull" json:"target"`
+		ReplicaLink string    `bun:"replica_link" json:"replica_link,omitempty"`
+		LastError   string    `bun:"last_error" json:"last_error,omitempty"`
+		Attempts    int       `bun:"attempts,notnull,default:0" json:"attempts"`
+		ID          uuid.UUID `bun:"id,pk,type:uuid" json:"id"`
+	}
+
+	// ReplicationLag summarises the replication queue of a secondary storage backend
+	ReplicationLag struct {
+		OldestPendingAt  time.Time `bun:"oldest_pending_at" json:"oldest_pending_at,omitempty"`
+		LastReplicatedAt time.Time `bun:"last_replicated_at" json:"last_replicated_at,omitempty"`
+		Target           string    `bun:"target" json:"target"`
+		// LagSeconds is the age of the oldest blob that's waiting to be replicated
+		LagSeconds float64 `bun:"-" json:"lag_seconds"`
+		Pending    int     `bun:"pending" json:"pending"`
+		Failing    int     `bun:"failing" json:"failing"`
+		Replicated int     `bun:"replicated" json:"replicated"`
+	}
+)
+
+var _ bun.BeforeAppendModelHook = (*ReplicationTask)(nil)
+var _ bun.AfterCreateTableHook = (*ReplicationTask)(nil)
+
+func (rt *ReplicationTask) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		rt.CreatedAt = time.Now()
+		rt.NextAttemptAt = rt.CreatedAt
+		if rt.ID == uuid.Nil {
+			rt.ID = uuid.New()
+		}
+	case *bun.UpdateQuery:
+		rt.UpdatedAt = time.Now()
+	}
+
+	return nil
+}
+
+func (rt *ReplicationTask) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery) error {
+	_, err := query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Unique().
+		Model(rt).
+		Index("replication_tasks_key_target_idx").
+		Column("key", "target").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(rt).
+		Index("replication_tasks_pending_idx").
+		Column("target", "next_attempt_at").
+		Where("completed_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	color.Yellow(`Create indexes in table "replication_tasks" succeeded ✔︎`)
+	return nil
+}
@@ -0,0 +1,31 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	// RepositoryBlob records that a blob was uploaded to a repository. Layers are stored once for the whole registry,
+	// so this is what limits a manifest to the blobs that were pushed to its own repository. The namespace is used
+	// instead of the repository id since blobs are pushed before the manifest creates the repository
+	RepositoryBlob struct {
+		bun.BaseModel `bun:"table:repository_blobs,alias:rb" json:"-"`
+
+		CreatedAt time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+		Namespace string    `bun:"namespace,pk" json:"namespace"`
+		Digest    string    `bun:"digest,pk" json:"digest"`
+	}
+)
+
+var _ bun.BeforeAppendModelHook = (*RepositoryBlob)(nil)
+
+func (rb *RepositoryBlob) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if _, ok := query.(*bun.InsertQuery); ok {
+		rb.CreatedAt = time.Now()
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// StorageMigration is the progress of copying the blobs of one storage backend to another. Layers are migrated in the
+// order of their IDs, Cursor is the ID of the last layer that was cut over or failed
+type StorageMigration struct {
+	bun.BaseModel `bun:"table:storage_migrations,alias:sm" json:"-"`
+
+	CreatedAt   time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt   time.Time `bun:"updated_at,nullzero" json:"updated_at"`
+	CompletedAt time.Time `bun:"completed_at,nullzero" json:"completed_at,omitempty"`
+	// LockedUntil & LockedBy keep other registry instances from running the same migration
+	LockedUntil   time.Time `bun:"locked_until,nullzero" json:"-"`
+	LockedBy      string    `bun:"locked_by" json:"-"`
+	Source        string    `bun:"source,pk" json:"source"`
+	Target        string    `bun:"target,pk" json:"target"`
+	Cursor        string    `bun:"cursor" json:"cursor,omitempty"`
+	LastError     string    `bun:"last_error" json:"last_error,omitempty"`
+	MigratedBytes int64     `bun:"migrated_bytes,notnull,default:0" json:"migrated_bytes"`
+	Migrated      int       `bun:"migrated,notnull,default:0" json:"migrated"`
+	// Failed is the number of layers that failed to copy since the last time the migration went through all layers
+	Failed int `bun:"failed,notnull,default:0" json:"failed"`
+}
+
+var _ bun.BeforeAppendModelHook = (*StorageMigration)(nil)
+
+func (sm *StorageMigration) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	switch query.(type) {
+	case *bun.InsertQuery:
+		sm.CreatedAt = time.Now()
+	case *bun.UpdateQuery:
+		sm.UpdatedAt = time.Now()
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package types
+
+import (
+	"context"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type (
+	// TagHistory is a single move of a tag from one digest to another. OldDigest is empty when the tag was created
+	TagHistory struct {
+		bun.BaseModel `bun:"table:tag_history,alias:th" json:"-"`
+
+		CreatedAt    time.Time `bun:"created_at,notnull,default:current_timestamp" json:"created_at"`
+		Tag          string    `bun:"tag,notnull" json:"tag"`
+		OldDigest    string    `bun:"old_digest" json:"old_digest,omitempty"`
+		NewDigest    string    `bun:"new_digest,notnull" json:"new_digest"`
+		UserAgent    string    `bun:"user_agent" json:"user_agent,omitempty"`
+		UserID       uuid.UUID `bun:"user_id,type:uuid" json:"user_id"`
+		RepositoryID uuid.UUID `bun:"repository_id,type:uuid,notnull" json:"repository_id"`
+		ID           uuid.UUID `bun:"id,pk,type:uuid" json:"id"`
+	}
+)
+
+var _ bun.BeforeAppendModelHook = (*TagHistory)(nil)
+var _ bun.AfterCreateTableHook = (*TagHistory)(nil)
+
+func (th *TagHistory) BeforeAppendModel(ctx context.Context, query bun.Query) error {
+	if _, ok := query.(*bun.InsertQuery); ok {
+		th.CreatedAt = time.Now()
+		if th.ID == uuid.Nil {
+			th.ID = uuid.New()
+		}
+	}
+
+	return nil
+}
+
+func (th *TagHistory) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery) error {
+	_, err := query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(th).
+		Index("tag_history_repository_id_tag_idx").
+		Column("repository_id", "tag", "created_at").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	color.Yellow(`Create index in table "tag_history" on column "repository_id" succeeded ✔︎`)
+	return nil
+}
@@ -2,6 +2,8 @@ package types
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -58,12 +60,12 @@ type (
 		Permissions         []*Permissions              `bun:"rel:has-many,join:id=user_id" json:"-"`
 		Repositories        []*ContainerImageRepository `bun:"rel:has-many,join:id=owner_id" json:"-"`
 		// nolint:lll
-		FavoriteRepositories []uuid.UUID `bun:"favorite_repositories,type:uuid[],default:'{}'" json:"favorite_repositories"`
-		ID                   uuid.UUID   `bun:"id,type:uuid,pk" json:"id,omitempty" validate:"-"`
-		IsActive             bool        `bun:"is_active" json:"is_active,omitempty" validate:"-"`
-		WebauthnConnected    bool        `bun:"webauthn_connected" json:"webauthn_connected"`
-		GithubConnected      bool        `bun:"github_connected" json:"github_connected"`
-		IsOrgOwner           bool        `bun:"is_org_owner" json:"is_org_owner,omitempty"`
+		FavoriteRepositories UUIDArray `bun:"favorite_repositories,type:uuid[],default:'{}'" json:"favorite_repositories"`
+		ID                   uuid.UUID `bun:"id,type:uuid,pk" json:"id,omitempty" validate:"-"`
+		IsActive             bool      `bun:"is_active" json:"is_active,omitempty" validate:"-"`
+		WebauthnConnected    bool      `bun:"webauthn_connected" json:"webauthn_connected"`
+		GithubConnected      bool      `bun:"github_connected" json:"github_connected"`
+		IsOrgOwner           bool      `bun:"is_org_owner" json:"is_org_owner,omitempty"`
 	}
 
 	// type here is string so that we can use it with echo.Context & std context.Context
@@ -78,6 +80,9 @@ type (
 		OwnerID      uuid.UUID `bun:"owner_id,type:uuid" json:"-"`
 	}
 
+	// UUIDArray is a native uuid[] on Postgres. SQLite has no arrays, so it stores the same "{id1,id2}" literal as text
+	UUIDArray []uuid.UUID
+
 	Identities   map[string]*UserIdentity
 	UserIdentity struct {
 		ID             string `json:"id"`
@@ -184,14 +189,33 @@ func (u *User) BeforeAppendModel(ctx context.Context, query bun.Query) error {
 	return nil
 }
 
+// AfterCreateTable creates the indexes and system users. Like every table hook, it runs its queries on the query's
+// connection, so that they are part of a migration's transaction. SQLite only allows a single writer and queries on
+// another connection would wait for the migration to finish
 func (u *User) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery) error {
-	_, err := query.DB().NewCreateIndex().IfNotExists().Model(u).Index("email_idx").Column("email").Exec(ctx)
+	_, err := query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(u).
+		Index("email_idx").
+		Column("email").
+		Exec(ctx)
 	if err != nil {
 		return err
 	}
 	color.Yellow(`Create index in table "users" on column "email" succeeded ✔︎`)
 
-	_, err = query.DB().NewCreateIndex().IfNotExists().Model(u).Index("username_idx").Column("username").Exec(ctx)
+	_, err = query.
+		DB().
+		NewCreateIndex().
+		Conn(query.GetConn()).
+		IfNotExists().
+		Model(u).
+		Index("username_idx").
+		Column("username").
+		Exec(ctx)
 	if err != nil {
 		return err
 	}
@@ -206,8 +230,8 @@ func (u *User) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery
 		IsOrgOwner: true,
 	}
 
-	_, err = query.DB().NewInsert().Model(ipfsUser).Exec(ctx)
-	if err != nil && !strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+	_, err = query.DB().NewInsert().Conn(query.GetConn()).Model(ipfsUser).Exec(ctx)
+	if err != nil && !isUniqueViolation(err) {
 		return err
 	}
 
@@ -216,16 +240,72 @@ func (u *User) AfterCreateTable(ctx context.Context, query *bun.CreateTableQuery
 }
 
 func (u *User) AfterDropTable(ctx context.Context, query *bun.DropTableQuery) error {
-	_, err := query.DB().NewDropIndex().IfExists().Model(u).Index("email_idx").Exec(ctx)
+	_, err := query.DB().NewDropIndex().Conn(query.GetConn()).IfExists().Model(u).Index("email_idx").Exec(ctx)
 	if err != nil {
 		return err
 	}
 	color.Yellow(`Drop index in table "users" on column "email" succeeded ✔︎`)
 
-	_, err = query.DB().NewDropIndex().IfExists().Model(u).Index("username_idx").Exec(ctx)
+	_, err = query.DB().NewDropIndex().Conn(query.GetConn()).IfExists().Model(u).Index("username_idx").Exec(ctx)
 	if err != nil {
 		return err
 	}
 	color.Yellow(`Drop index in table "users" on column "username" succeeded ✔︎`)
 	return nil
 }
+
+// isUniqueViolation reports whether err is a unique constraint violation from either Postgres or SQLite
+func isUniqueViolation(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "duplicate key value violates unique constraint") ||
+		strings.Contains(msg, "UNIQUE constraint failed")
+}
+
+var _ driver.Valuer = (*UUIDArray)(nil)
+
+var _ sql.Scanner = (*UUIDArray)(nil)
+
+// Value is only used by SQLite, the Postgres dialect appends uuid[] columns as native arrays
+func (a UUIDArray) Value() (driver.Value, error) {
+	ids := make([]string, 0, len(a))
+	for _, id := range a {
+		ids = append(ids, id.String())
+	}
+
+	return "{" + strings.Join(ids, ",") + "}", nil
+}
+
+// Scan is only used by SQLite, the Postgres dialect scans uuid[] columns as native arrays
+func (a *UUIDArray) Scan(input any) error {
+	var literal string
+	switch v := input.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		literal = string(v)
+	case string:
+		literal = v
+	default:
+		return fmt.Errorf("Scan: expected []byte or string, got %T", input)
+	}
+
+	literal = strings.TrimSuffix(strings.TrimPrefix(literal, "{"), "}")
+	if literal == "" {
+		*a = UUIDArray{}
+		return nil
+	}
+
+	parts := strings.Split(literal, ",")
+	ids := make(UUIDArray, 0, len(parts))
+	for _, part := range parts {
+		id, err := uuid.Parse(strings.Trim(part, `"`))
+		if err != nil {
+			return fmt.Errorf("ERR_SCAN_UUID_ARRAY: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	*a = ids
+	return nil
+}
@@ -13,7 +13,7 @@ type WebauthnSession struct {
 	bun.BaseModel `bun:"table:webauthn_session" json:"-"`
 
 	Expires          time.Time                            `bun:"expires" json:"expires"`
-	Extensions       protocol.AuthenticationExtensions    `bun:"extensions,type:jsonb" json:"extensions"`
+	Extensions       protocol.AuthenticationExtensions    `bun:"extensions" json:"extensions"`
 	User             *User                                `bun:"rel:belongs-to,join:user_id=id"`
 	Challege         string                               `bun:"challenge" json:"challenge"`
 	UserVerification protocol.UserVerificationRequirement `bun:"user_verification" json:"user_verification"`
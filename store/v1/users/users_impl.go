@@ -270,8 +270,9 @@ func (us *userStore) Search(ctx context.Context, query string) ([]*types.User, e
 			return sq.Where("user_type = ?", types.UserTypeRegular.String()) // only search for users (skip orgs and system users
 		}).
 		WhereGroup(" AND ", func(sq *bun.SelectQuery) *bun.SelectQuery {
-			return sq.WhereOr("username ilike ?", b.String()).
-				WhereOr("email ilike ?", b.String())
+			// ILIKE is Postgres only, lowering both sides is case-insensitive with SQLite as well
+			return sq.WhereOr("LOWER(username) LIKE LOWER(?)", b.String()).
+				WhereOr("LOWER(email) LIKE LOWER(?)", b.String())
 		}).
 		ExcludeColumn("password").
 		ExcludeColumn("updated_at").